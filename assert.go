@@ -0,0 +1,153 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// kRegressionExitCode is the exit code when an --assert is violated or a
+// function regressed over the --baseline, so scripts can tell a regression
+// from a failed conversion.
+const kRegressionExitCode = 3
+
+const assertHelp = "Fails with exit code 3 unless the cumulative weight of the functions matching a regular " +
+	"expression satisfies a bound, e.g. 'objc_msgSend <= 5%' or 'malloc < 200000000'. Percentages are " +
+	"of the total weight, numbers are in the unit of the default sample type. Can be repeated."
+
+var assertionExpr = regexp.MustCompile(`^(.*?)\s*(<=|>=|<|>)\s*([^<>=]+)$`)
+
+// assertion is a bound on the cumulative weight of the functions matching
+// symbol.
+type assertion struct {
+	expr      string
+	symbol    *regexp.Regexp
+	op        string
+	bound     float64
+	isPercent bool
+}
+
+func parseAssertion(expr string) (assertion, error) {
+	match := assertionExpr.FindStringSubmatch(strings.TrimSpace(expr))
+	if match == nil || match[1] == "" {
+		return assertion{}, fmt.Errorf("invalid assertion %q, expected e.g. 'symbolRegex <= 5%%'", expr)
+	}
+	symbol, err := regexp.Compile(match[1])
+	if err != nil {
+		return assertion{}, fmt.Errorf("invalid assertion %q: %v", expr, err)
+	}
+	a := assertion{expr: expr, symbol: symbol, op: match[2]}
+	value := strings.TrimSpace(match[3])
+	if strings.HasSuffix(value, "%") {
+		a.isPercent = true
+		value = strings.TrimSpace(strings.TrimSuffix(value, "%"))
+	}
+	if a.bound, err = strconv.ParseFloat(value, 64); err != nil {
+		return assertion{}, fmt.Errorf("invalid bound in assertion %q: %v", expr, err)
+	}
+	return a, nil
+}
+
+func (a assertion) holds(value float64) bool {
+	switch a.op {
+	case "<=":
+		return value <= a.bound
+	case "<":
+		return value < a.bound
+	case ">=":
+		return value >= a.bound
+	default:
+		return value > a.bound
+	}
+}
+
+// assertions are the --assert flags, in the order they were given.
+type assertions []assertion
+
+func (as *assertions) String() string {
+	exprs := make([]string, len(*as))
+	for i, a := range *as {
+		exprs[i] = a.expr
+	}
+	return strings.Join(exprs, ", ")
+}
+
+func (as *assertions) Set(expr string) error {
+	a, err := parseAssertion(expr)
+	if err != nil {
+		return err
+	}
+	*as = append(*as, a)
+	return nil
+}
+
+// check evaluates the assertions against the default sample type of the
+// converted profile and returns a message for each violated one. A sample
+// counts once toward an assertion if any of its functions match, so
+// recursion doesn't inflate the weight.
+func (as assertions) check(prof *profile.Profile) []string {
+	if len(as) == 0 {
+		return nil
+	}
+	index := 0
+	for i, sampleType := range prof.SampleType {
+		if sampleType.Type == prof.DefaultSampleType {
+			index = i
+		}
+	}
+	var total int64
+	matched := make([]int64, len(as))
+	for _, sample := range prof.Sample {
+		value := sample.Value[index]
+		total += value
+		for i, a := range as {
+			if sampleMatches(sample, a.symbol) {
+				matched[i] += value
+			}
+		}
+	}
+	var violations []string
+	for i, a := range as {
+		value := float64(matched[i])
+		actual := strconv.FormatInt(matched[i], 10)
+		if a.isPercent {
+			value = 0
+			if total != 0 {
+				value = 100 * float64(matched[i]) / float64(total)
+			}
+			actual = fmt.Sprintf("%.2f%%", value)
+		}
+		if !a.holds(value) {
+			violations = append(violations, fmt.Sprintf("assertion %q failed: the weight is %s", a.expr, actual))
+		}
+	}
+	return violations
+}
+
+func sampleMatches(sample *profile.Sample, symbol *regexp.Regexp) bool {
+	for _, loc := range sample.Location {
+		for _, line := range loc.Line {
+			if symbol.MatchString(line.Function.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
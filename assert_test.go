@@ -0,0 +1,84 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+func TestParseAssertion(t *testing.T) {
+	for _, test := range []struct {
+		expr      string
+		symbol    string
+		op        string
+		bound     float64
+		isPercent bool
+	}{
+		{"foo <= 5%", "foo", "<=", 5, true},
+		{"foo<1000", "foo", "<", 1000, false},
+		{"^a|b >= 0.5 %", "^a|b", ">=", 0.5, true},
+		{"x<y > 2", "x<y", ">", 2, false},
+	} {
+		a, err := parseAssertion(test.expr)
+		if err != nil {
+			t.Errorf("parseAssertion(%q) failed: %v", test.expr, err)
+			continue
+		}
+		if a.symbol.String() != test.symbol || a.op != test.op || a.bound != test.bound || a.isPercent != test.isPercent {
+			t.Errorf("parseAssertion(%q) = %q %s %v (percent %v), want %q %s %v (percent %v)", test.expr,
+				a.symbol, a.op, a.bound, a.isPercent, test.symbol, test.op, test.bound, test.isPercent)
+		}
+	}
+	for _, expr := range []string{"foo", "<= 5%", "foo <= five", "( <= 5"} {
+		if _, err := parseAssertion(expr); err == nil {
+			t.Errorf("parseAssertion(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCheckAssertions(t *testing.T) {
+	function := func(id uint64, name string) *profile.Location {
+		return &profile.Location{ID: id, Line: []profile.Line{{Function: &profile.Function{ID: id, Name: name}}}}
+	}
+	main, foo, bar := function(1, "main"), function(2, "foo"), function(3, "bar")
+	prof := &profile.Profile{
+		SampleType:        []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		DefaultSampleType: "cpu",
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{foo, main}, Value: []int64{60}},
+			{Location: []*profile.Location{bar, foo, foo, main}, Value: []int64{30}},
+			{Location: []*profile.Location{main}, Value: []int64{10}},
+		},
+	}
+	var as assertions
+	for _, expr := range []string{"foo <= 90%", "foo < 90%", "^bar$ > 30", "main >= 100"} {
+		if err := as.Set(expr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	violations := as.check(prof)
+	if len(violations) != 2 {
+		t.Fatalf("check() = %q, want 2 violations", violations)
+	}
+	if !strings.Contains(violations[0], "foo < 90%") || !strings.Contains(violations[0], "90.00%") {
+		t.Errorf("violations[0] = %q, want the failed foo assertion with its share", violations[0])
+	}
+	if !strings.Contains(violations[1], "^bar$ > 30") || !strings.Contains(violations[1], "30") {
+		t.Errorf("violations[1] = %q, want the failed bar assertion with its weight", violations[1])
+	}
+}
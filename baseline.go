@@ -0,0 +1,95 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// parseTolerance parses a tolerance like "10%" into a fraction.
+func parseTolerance(value string) (float64, error) {
+	trimmed := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "%"))
+	percent, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || percent < 0 {
+		return 0, fmt.Errorf("invalid tolerance %q, expected a percentage like 10%%", value)
+	}
+	return percent / 100, nil
+}
+
+// readBaseline reads the pprof profile at path.
+func readBaseline(path string) (*profile.Profile, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the baseline: %v", err)
+	}
+	defer in.Close()
+	baseline, err := profile.Parse(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the baseline %s: %v", path, err)
+	}
+	return baseline, nil
+}
+
+// regressionsOf returns the functions whose self weight grew by more than
+// tolerance over their self weight in base, from the largest growth. The
+// functions missing from base regress if their self weight exceeds tolerance
+// of the total weight of base, so new functions aren't reported for every
+// sample they got.
+func regressionsOf(base, changed *profile.Profile, tolerance float64) []functionChange {
+	baseWeights := weightsOf(base).self
+	var total int64
+	for _, weight := range baseWeights {
+		total += weight
+	}
+	var regressions []functionChange
+	for _, c := range changesOf(baseWeights, weightsOf(changed).self) {
+		if c.delta() <= 0 {
+			break
+		}
+		limit := float64(c.base) * tolerance
+		if c.base == 0 {
+			limit = float64(total) * tolerance
+		}
+		if float64(c.delta()) > limit {
+			regressions = append(regressions, c)
+		}
+	}
+	return regressions
+}
+
+// writeRegressionReport writes the regressions ranked from the largest
+// growth, with their growth relative to the baseline.
+func writeRegressionReport(w io.Writer, regressions []functionChange, tolerance float64, format func(int64) string) error {
+	if _, err := fmt.Fprintf(w, "Functions whose self weight regressed by more than %g%% over the baseline:\n", tolerance*100); err != nil {
+		return err
+	}
+	if len(regressions) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for i, c := range regressions {
+		growth := "new"
+		if c.base != 0 {
+			growth = fmt.Sprintf("+%.1f%%", 100*float64(c.delta())/float64(c.base))
+		}
+		fmt.Fprintf(w, "  %d. %s\t%s +%s (%s -> %s)\n", i+1, c.name, growth, format(c.delta()), format(c.base), format(c.changed))
+	}
+	return nil
+}
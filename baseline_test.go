@@ -0,0 +1,81 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
+
+// selfProfile returns a profile in nanoseconds with a sample per function of
+// its self weight.
+func selfProfile(weights map[string]int64) *profile.Profile {
+	prof := &profile.Profile{
+		SampleType:        []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		DefaultSampleType: "cpu",
+	}
+	id := uint64(0)
+	for name, weight := range weights {
+		id++
+		loc := &profile.Location{ID: id, Line: []profile.Line{{Function: &profile.Function{ID: id, Name: name}}}}
+		prof.Sample = append(prof.Sample, &profile.Sample{Location: []*profile.Location{loc}, Value: []int64{weight}})
+	}
+	return prof
+}
+
+func TestParseTolerance(t *testing.T) {
+	for value, expected := range map[string]float64{"10%": 0.1, "0%": 0, " 2.5 % ": 0.025, "50": 0.5} {
+		if tolerance, err := parseTolerance(value); err != nil || tolerance != expected {
+			t.Errorf("parseTolerance(%q) = %v, %v, want %v", value, tolerance, err, expected)
+		}
+	}
+	for _, value := range []string{"", "ten%", "-5%"} {
+		if _, err := parseTolerance(value); err == nil {
+			t.Errorf("parseTolerance(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestRegressionsOf(t *testing.T) {
+	base := selfProfile(map[string]int64{"steady": 1000, "slower": 1000, "faster": 1000, "noisy": 1000})
+	changed := selfProfile(map[string]int64{"steady": 1000, "slower": 1500, "faster": 500, "noisy": 1050,
+		"new": 500, "tiny": 100})
+	regressions := regressionsOf(base, changed, 0.1)
+	expected := []functionChange{{"new", 0, 500}, {"slower", 1000, 1500}}
+	if !reflect.DeepEqual(regressions, expected) {
+		t.Errorf("regressionsOf() = %v, want %v", regressions, expected)
+	}
+	if regressions := regressionsOf(base, changed, 1); len(regressions) != 0 {
+		t.Errorf("regressionsOf() with 100%% tolerance = %v, want none", regressions)
+	}
+
+	var report bytes.Buffer
+	if err := writeRegressionReport(&report, regressions, 0.1, weightFormatter(changed)); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"regressed by more than 10% over the baseline",
+		"  1. new\tnew +500ns (0s -> 500ns)",
+		"  2. slower\t+50.0% +500ns (1µs -> 1.5µs)",
+	} {
+		if !strings.Contains(report.String(), line) {
+			t.Errorf("report %q lacks %q", report.String(), line)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+const (
+	kClipboardPath    string = "path"
+	kClipboardContent string = "content"
+)
+
+var clipboardTargets = []string{kClipboardPath, kClipboardContent}
+
+// The commands reading and writing the macOS pasteboard, replaced in tests.
+var (
+	pasteCommand = []string{"pbpaste", "-Prefer", "txt"}
+	copyCommand  = []string{"pbcopy"}
+)
+
+// clipboardEnv makes pbpaste and pbcopy use UTF-8. Without a UTF-8 locale,
+// e.g. when run from a script, they replace the non-ASCII characters of
+// symbol names.
+func clipboardEnv() []string {
+	return append(os.Environ(), "LANG=en_US.UTF-8", "LC_ALL=en_US.UTF-8")
+}
+
+// readClipboard returns the text on the pasteboard.
+func readClipboard() ([]byte, error) {
+	cmd := exec.Command(pasteCommand[0], pasteCommand[1:]...)
+	cmd.Env = clipboardEnv()
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, clipboardError(err, &stderr)
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, fmt.Errorf("The clipboard is empty, use Edit > Deep Copy in Instruments first")
+	}
+	return out, nil
+}
+
+// writeClipboard puts data on the pasteboard.
+func writeClipboard(data []byte) error {
+	cmd := exec.Command(copyCommand[0], copyCommand[1:]...)
+	cmd.Env = clipboardEnv()
+	cmd.Stdin = bytes.NewReader(data)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return clipboardError(err, &stderr)
+	}
+	return nil
+}
+
+func clipboardError(err error, stderr *bytes.Buffer) error {
+	if execErr, ok := err.(*exec.Error); ok {
+		return fmt.Errorf("Could not run %s, the clipboard flags require macOS: %v", execErr.Name, err)
+	}
+	return fmt.Errorf("Clipboard access failed: %v\n%s", err, stderr.String())
+}
+
+// copyOutput puts the absolute path of the written output, or its content,
+// on the pasteboard.
+func copyOutput(target, path string) error {
+	var data []byte
+	switch target {
+	case kClipboardPath:
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		data = []byte(abs)
+	case kClipboardContent:
+		var err error
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return err
+		}
+	}
+	return writeClipboard(data)
+}
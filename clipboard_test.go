@@ -0,0 +1,100 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeClipboard replaces pbpaste and pbcopy with shell commands on a file
+// in dir, which also records the locale they ran with.
+func fakeClipboard(t *testing.T, dir string) (clipboard string) {
+	clipboard = filepath.Join(dir, "clipboard")
+	oldPaste, oldCopy := pasteCommand, copyCommand
+	pasteCommand = []string{"sh", "-c", `echo "$LC_ALL" > "$0.locale"; cat "$0"`, clipboard}
+	copyCommand = []string{"sh", "-c", `cat > "$0"`, clipboard}
+	t.Cleanup(func() {
+		pasteCommand, copyCommand = oldPaste, oldCopy
+	})
+	return clipboard
+}
+
+func TestReadClipboard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clipboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clipboard := fakeClipboard(t, dir)
+	content := "Weight\tSelf Weight\t\tSymbol Name\n1.0 ms  100%\t1.0 ms\t \tüber::naïve()\n"
+	if err := ioutil.WriteFile(clipboard, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := readClipboard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != content {
+		t.Errorf("readClipboard() = %q, want %q", data, content)
+	}
+	locale, _ := ioutil.ReadFile(clipboard + ".locale")
+	if strings.TrimSpace(string(locale)) != "en_US.UTF-8" {
+		t.Errorf("pbpaste ran with LC_ALL=%q, want en_US.UTF-8", locale)
+	}
+
+	if err := ioutil.WriteFile(clipboard, []byte("\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readClipboard(); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("readClipboard() of an empty clipboard = %v, want an error", err)
+	}
+}
+
+func TestCopyOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "clipboard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	clipboard := fakeClipboard(t, dir)
+	output := filepath.Join(dir, "profile.folded")
+	if err := ioutil.WriteFile(output, []byte("main;foo 10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for target, expected := range map[string]string{
+		kClipboardPath:    output,
+		kClipboardContent: "main;foo 10\n",
+	} {
+		if err := copyOutput(target, output); err != nil {
+			t.Fatal(err)
+		}
+		if data, _ := ioutil.ReadFile(clipboard); string(data) != expected {
+			t.Errorf("copyOutput(%q) copied %q, want %q", target, data, expected)
+		}
+	}
+}
+
+func TestClipboardWithoutPbpaste(t *testing.T) {
+	oldPaste := pasteCommand
+	pasteCommand = []string{"instrumentsToPprof-missing-pbpaste"}
+	defer func() { pasteCommand = oldPaste }()
+	if _, err := readClipboard(); err == nil || !strings.Contains(err.Error(), "require macOS") {
+		t.Errorf("readClipboard() = %v, want an error explaining that macOS is required", err)
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/instrumentsToPprof/internal/parsers"
+	"github.com/google/pprof/profile"
+)
+
+// kDiffBaseLabel marks the samples of the base of a diff, as pprof's
+// -diff_base does.
+const kDiffBaseLabel = "pprof::base"
+
+const diffHelp = `usage %[1]s diff [options] base-file new-file
+Converts both inputs and prints the functions whose self and cumulative weights grew or shrank
+the most from base-file to new-file. --output writes the difference as a pprof profile, with the
+samples of base-file negated.
+Flags:
+`
+
+// runDiff runs the diff command with its arguments, writing the report to w.
+func runDiff(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	format := fs.String("format", kAuto, "Format of both inputs. "+formatHelp())
+	output := fs.String("output", "", "Output file of the pprof profile of the difference, none if empty.")
+	force := fs.Bool("force", false, "Overwrites the output file if it exists.")
+	top := fs.Int("top", 10, "Number of functions listed in each section of the report.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), diffHelp, os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("diff takes 2 inputs, got %d", fs.NArg())
+	}
+	parserFn, err := parserForFormat(*format, nil)
+	if err != nil {
+		return err
+	}
+	opts := internal.NewConvertOptions(internal.IncludeIDs(false))
+	base, err := convertForDiff(fs.Arg(0), parserFn, opts)
+	if err != nil {
+		return err
+	}
+	changed, err := convertForDiff(fs.Arg(1), parserFn, opts)
+	if err != nil {
+		return err
+	}
+	if err := writeDiffReport(w, base, changed, *top); err != nil {
+		return err
+	}
+	if *output == "" {
+		return nil
+	}
+	diff, err := diffAgainstBase(changed, base)
+	if err != nil {
+		return fmt.Errorf("Failed to compute the difference: %v", err)
+	}
+	return writeOutput(*output, diff, *force)
+}
+
+// convertForDiff converts the input file to a pprof profile. The options
+// should leave out the ids of processes and threads, since they change
+// between runs.
+func convertForDiff(path string, parserFn parsers.MakeParserFn, opts internal.ConvertOptions) (*profile.Profile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	parser, err := parserFn(file)
+	if err != nil {
+		return nil, err
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+	// Offsets into functions change between builds, they would split the
+	// functions of the two inputs apart.
+	internal.StripSymbolOffsets(timeProfile, true)
+	return internal.ConvertToPprof(timeProfile, opts), nil
+}
+
+// diffAgainstBase returns changed minus base, with the negated samples of
+// base labeled like pprof -diff_base does, so pprof shows percentages of
+// the base.
+func diffAgainstBase(changed, base *profile.Profile) (*profile.Profile, error) {
+	base = base.Copy()
+	for _, sample := range base.Sample {
+		if sample.Label == nil {
+			sample.Label = make(map[string][]string)
+		}
+		sample.Label[kDiffBaseLabel] = []string{"true"}
+	}
+	base.Scale(-1)
+	return mergeProfiles(changed, base)
+}
+
+// writeBaseDiff converts both inputs and writes the profile of before minus
+// after to output, with after as the base like pprof -diff_base=after
+// before. Savings of an optimization measured in after are positive, and
+// regressions negative.
+func writeBaseDiff(before, after string, parserFn parsers.MakeParserFn, opts internal.ConvertOptions,
+	output string, force bool) error {
+	changed, err := convertForDiff(before, parserFn, opts)
+	if err != nil {
+		return err
+	}
+	base, err := convertForDiff(after, parserFn, opts)
+	if err != nil {
+		return err
+	}
+	diff, err := diffAgainstBase(changed, base)
+	if err != nil {
+		return fmt.Errorf("Failed to compute the difference: %v", err)
+	}
+	return writeOutput(output, diff, force)
+}
+
+// functionWeights are the self and cumulative weights of the functions of a
+// profile, by name, of its default sample type.
+type functionWeights struct {
+	self, cum map[string]int64
+}
+
+// defaultSampleIndex returns the index of the default sample type of prof,
+// which is the last one if it isn't set, like in pprof.
+func defaultSampleIndex(prof *profile.Profile) int {
+	for i, sampleType := range prof.SampleType {
+		if sampleType.Type == prof.DefaultSampleType {
+			return i
+		}
+	}
+	return len(prof.SampleType) - 1
+}
+
+func weightsOf(prof *profile.Profile) functionWeights {
+	index := defaultSampleIndex(prof)
+	weights := functionWeights{self: make(map[string]int64), cum: make(map[string]int64)}
+	for _, sample := range prof.Sample {
+		value := sample.Value[index]
+		// Recursive functions count once for the cumulative weight.
+		seen := make(map[string]bool)
+		for i, loc := range sample.Location {
+			for j, line := range loc.Line {
+				name := line.Function.Name
+				if i == 0 && j == 0 {
+					weights.self[name] += value
+				}
+				if !seen[name] {
+					seen[name] = true
+					weights.cum[name] += value
+				}
+			}
+		}
+	}
+	return weights
+}
+
+// functionChange is the change of a weight of a function between profiles.
+type functionChange struct {
+	name          string
+	base, changed int64
+}
+
+func (c functionChange) delta() int64 {
+	return c.changed - c.base
+}
+
+// changesOf returns the changed weights of the functions, from the largest
+// growth to the largest shrinkage.
+func changesOf(base, changed map[string]int64) []functionChange {
+	var changes []functionChange
+	for name, weight := range changed {
+		if weight != base[name] {
+			changes = append(changes, functionChange{name, base[name], weight})
+		}
+	}
+	for name, weight := range base {
+		if _, ok := changed[name]; !ok && weight != 0 {
+			changes = append(changes, functionChange{name, weight, 0})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].delta() != changes[j].delta() {
+			return changes[i].delta() > changes[j].delta()
+		}
+		return changes[i].name < changes[j].name
+	})
+	return changes
+}
+
+// writeDiffReport writes the top functions growing and shrinking by self and
+// cumulative weight from base to changed.
+func writeDiffReport(w io.Writer, base, changed *profile.Profile, top int) error {
+	format := weightFormatter(changed)
+	baseWeights, changedWeights := weightsOf(base), weightsOf(changed)
+	for _, weight := range []struct {
+		name          string
+		base, changed map[string]int64
+	}{
+		{"self", baseWeights.self, changedWeights.self},
+		{"cumulative", baseWeights.cum, changedWeights.cum},
+	} {
+		changes := changesOf(weight.base, weight.changed)
+		var growing, shrinking []functionChange
+		for i := 0; i < len(changes) && changes[i].delta() > 0 && len(growing) < top; i++ {
+			growing = append(growing, changes[i])
+		}
+		for i := len(changes) - 1; i >= 0 && changes[i].delta() < 0 && len(shrinking) < top; i-- {
+			shrinking = append(shrinking, changes[i])
+		}
+		for _, section := range []struct {
+			title   string
+			changes []functionChange
+		}{
+			{"growing", growing},
+			{"shrinking", shrinking},
+		} {
+			if _, err := fmt.Fprintf(w, "Top %s functions by %s weight:\n", section.title, weight.name); err != nil {
+				return err
+			}
+			if len(section.changes) == 0 {
+				fmt.Fprintln(w, "  none")
+			}
+			for _, c := range section.changes {
+				sign := "+"
+				if c.delta() < 0 {
+					sign = ""
+				}
+				fmt.Fprintf(w, "  %s%s\t%s (%s -> %s)\n", sign, format(c.delta()), c.name, format(c.base), format(c.changed))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	return nil
+}
+
+// weightFormatter returns a function formatting weights in the unit of the
+// default sample type of prof, or the last one if it isn't set.
+func weightFormatter(prof *profile.Profile) func(int64) string {
+	unit := ""
+	if index := defaultSampleIndex(prof); index >= 0 {
+		unit = prof.SampleType[index].Unit
+	}
+	return func(value int64) string {
+		if unit == "nanoseconds" {
+			return time.Duration(value).String()
+		}
+		return fmt.Sprintf("%d %s", value, unit)
+	}
+}
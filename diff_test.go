@@ -0,0 +1,192 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/pprof/profile"
+)
+
+const (
+	diffBase = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+		"10.0 s  100%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"10.0 s  100%\t4.0 s\t \t  main\n" +
+		"6.0 s  60%\t6.0 s\t \t   parse\n"
+	// diffChanged is a later run, with other ids, whose parse got slower and
+	// calls a new function.
+	diffChanged = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"12.0 s  100%\t0 s\t \tMain Process (456)\n" +
+		"12.0 s  100%\t0 s\t \t Thread 1  0x2ee7\n" +
+		"12.0 s  100%\t3.0 s\t \t  main\n" +
+		"9.0 s  75%\t7.0 s\t \t   parse\n" +
+		"2.0 s  17%\t2.0 s\t \t    tokenize\n"
+)
+
+func TestDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base, changed := filepath.Join(dir, "base.txt"), filepath.Join(dir, "changed.txt")
+	if err := ioutil.WriteFile(base, []byte(diffBase), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(changed, []byte(diffChanged), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "diff.pb.gz")
+	var report strings.Builder
+	if err := runDiff([]string{"--output=" + output, base, changed}, &report); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"Top growing functions by self weight:\n  +2s\ttokenize (0s -> 2s)\n  +1s\tparse (6s -> 7s)\n",
+		"Top shrinking functions by self weight:\n  -1s\tmain (4s -> 3s)\n",
+		"  +3s\tparse (6s -> 9s)\n",
+		"  +2s\tThread 1 (10s -> 12s)\n",
+	} {
+		if !strings.Contains(report.String(), expected) {
+			t.Errorf("Expected the report to contain %q, got\n%s", expected, report.String())
+		}
+	}
+
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	diff, err := profile.Parse(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total, baseTotal int64
+	for _, sample := range diff.Sample {
+		total += sample.Value[0]
+		if sample.Label[kDiffBaseLabel] != nil {
+			baseTotal -= sample.Value[0]
+		}
+	}
+	if total != 2_000_000_000 {
+		t.Errorf("Expected the difference to add up to 2s, got %dns", total)
+	}
+	// The negated base is labeled, so pprof can tell it apart like with -diff_base.
+	if baseTotal != 10_000_000_000 {
+		t.Errorf("Expected the labeled base to add up to 10s, got %dns", baseTotal)
+	}
+}
+
+func TestDiffStripsSymbolOffsets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	base, changed := filepath.Join(dir, "base.txt"), filepath.Join(dir, "changed.txt")
+	// The offsets into parse changed with the build.
+	if err := ioutil.WriteFile(base, []byte(strings.Replace(diffBase, "parse", "parse + 12", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(changed, []byte(strings.Replace(diffChanged, "parse", "parse + 40", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var report strings.Builder
+	if err := runDiff([]string{base, changed}, &report); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "  +1s\tparse (6s -> 7s)\n"; !strings.Contains(report.String(), expected) {
+		t.Errorf("Expected the report to contain %q, got\n%s", expected, report.String())
+	}
+}
+
+func TestDiffNeedsTwoInputs(t *testing.T) {
+	var report strings.Builder
+	if err := runDiff([]string{"base.txt"}, &report); err == nil {
+		t.Error("Expected an error for a single input")
+	}
+}
+
+func TestWriteBaseDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	before, after := filepath.Join(dir, "before.txt"), filepath.Join(dir, "after.txt")
+	if err := ioutil.WriteFile(before, []byte(diffChanged), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(after, []byte(diffBase), 0644); err != nil {
+		t.Fatal(err)
+	}
+	parserFn, err := parserForFormat(kAuto, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "diff.pb.gz")
+	opts := internal.NewConvertOptions(internal.ExcludeProcessFrames(true), internal.IncludeIDs(false))
+	if err := writeBaseDiff(before, after, parserFn, opts, output, false); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	diff, err := profile.Parse(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total, base int64
+	for _, sample := range diff.Sample {
+		if sample.Location[len(sample.Location)-1].Line[0].Function.Name != "Thread 1" {
+			t.Errorf("Expected the process frames to be excluded, got %v", sample.Location)
+		}
+		total += sample.Value[0]
+		if sample.Label[kDiffBaseLabel] != nil {
+			if sample.Value[0] > 0 {
+				t.Errorf("Expected the samples of the base to be negated, got %v", sample)
+			}
+			base -= sample.Value[0]
+		}
+	}
+	// The optimization saved 2s of the 10s that remain.
+	if total != 2_000_000_000 || base != 10_000_000_000 {
+		t.Errorf("Expected a 2s difference over a 10s base, got %dns over %dns", total, base)
+	}
+}
+
+func TestWeightsOfDefaultsToTheLastSampleType(t *testing.T) {
+	main := &profile.Function{ID: 1, Name: "main"}
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "samples", Unit: "count"}, {Type: "cpu", Unit: "nanoseconds"}},
+		Function:   []*profile.Function{main},
+		Location:   []*profile.Location{{ID: 1, Line: []profile.Line{{Function: main}}}},
+	}
+	prof.Sample = []*profile.Sample{{Location: prof.Location, Value: []int64{1, 5}}}
+	if self := weightsOf(prof).self["main"]; self != 5 {
+		t.Errorf("Expected the cpu weight 5 of main without a default sample type, got %d", self)
+	}
+	if got := weightFormatter(prof)(5); got != "5ns" {
+		t.Errorf("Expected the weight in nanoseconds, got %s", got)
+	}
+}
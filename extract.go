@@ -0,0 +1,125 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+
+	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/instrumentsToPprof/internal/ir"
+)
+
+const extractHelp = `usage %[1]s extract [options] [input-file]
+Parses the input, or stdin, and writes the processes and threads selected by --pid and --thread
+as an IR document or folded stacks, for scripts working on a part of a capture.
+Flags:
+`
+
+var extractFormats = []string{kIR, kCollapsedOutput}
+
+// runExtract runs the extract command with its arguments, writing to stdout
+// unless --output is given.
+func runExtract(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("extract", flag.ContinueOnError)
+	format := fs.String("format", kAuto, "Format of the input. "+formatHelp())
+	pid := fs.Uint64("pid", 0, "Extracts the process with this pid, or all processes if 0.")
+	thread := fs.String("thread", "", "Extracts the threads whose name matches this regular expression, or all if empty.")
+	output := fs.String("output", "", "Output file, stdout if empty.")
+	outputFormat := fs.String("output-format", kIR, "Format of the output: ir, or collapsed for folded stacks.")
+	force := fs.Bool("force", false, "Overwrites the output file if it exists.")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), extractHelp, os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("extract takes at most 1 input, got %d", fs.NArg())
+	}
+	if !contains(extractFormats, *outputFormat) {
+		return unknownValueError("output-format", *outputFormat, extractFormats)
+	}
+	threadExpr, err := regexp.Compile(*thread)
+	if err != nil {
+		return fmt.Errorf("Invalid --thread: %v", err)
+	}
+	parserFn, err := parserForFormat(*format, nil)
+	if err != nil {
+		return err
+	}
+	input := stdin
+	if path := fs.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+	parser, err := parserFn(input)
+	if err != nil {
+		return err
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		return fmt.Errorf("Failed to parse the input: %v", err)
+	}
+	if err := selectScope(timeProfile, *pid, threadExpr); err != nil {
+		return err
+	}
+	write := func(w io.Writer) error {
+		if *outputFormat == kCollapsedOutput {
+			return internal.WriteCollapsed(w, internal.ConvertToPprof(timeProfile, internal.NewConvertOptions()))
+		}
+		return ir.Write(w, timeProfile)
+	}
+	if *output == "" {
+		return write(stdout)
+	}
+	return writeFile(*output, *force, write)
+}
+
+// selectScope keeps the threads matching thread of the process with pid,
+// or of every process if pid is 0. It fails if nothing matches, rather than
+// leaving an empty profile for the scripts to puzzle over.
+func selectScope(p *internal.TimeProfile, pid uint64, thread *regexp.Regexp) error {
+	var processes []*internal.Process
+	for _, proc := range p.Processes {
+		if pid != 0 && proc.Pid != pid {
+			continue
+		}
+		var threads []*internal.Thread
+		for _, th := range proc.Threads {
+			if thread.MatchString(th.Name) {
+				threads = append(threads, th)
+			}
+		}
+		if len(threads) > 0 {
+			proc.Threads = threads
+			processes = append(processes, proc)
+		}
+	}
+	if len(processes) == 0 {
+		return fmt.Errorf("No thread matches --pid=%d --thread=%q", pid, thread)
+	}
+	p.Processes = processes
+	return nil
+}
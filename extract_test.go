@@ -0,0 +1,85 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal/ir"
+)
+
+// extractInput is a deep copy of two processes, which are separated by an
+// empty line.
+const extractInput = "Weight\tSelf Weight\t\tSymbol Name\n" +
+	"10.0 s  100%\t0 s\t \tApp (123)\n" +
+	"6.0 s  60%\t0 s\t \t Main Thread  0x1\n" +
+	"6.0 s  60%\t6.0 s\t \t  draw\n" +
+	"4.0 s  40%\t0 s\t \t Worker  0x2\n" +
+	"4.0 s  40%\t4.0 s\t \t  decode\n" +
+	"\n" +
+	"5.0 s  100%\t0 s\t \tDaemon (456)\n" +
+	"5.0 s  100%\t0 s\t \t Main Thread  0x3\n" +
+	"5.0 s  100%\t5.0 s\t \t  poll\n"
+
+func TestExtract(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--output-format=collapsed", "--pid=123"},
+			"App [pid: 123];Main Thread [tid: 0x1];draw 6000000000\nApp [pid: 123];Worker [tid: 0x2];decode 4000000000\n"},
+		{[]string{"--output-format=collapsed", "--thread=^Main"},
+			"App [pid: 123];Main Thread [tid: 0x1];draw 6000000000\nDaemon [pid: 456];Main Thread [tid: 0x3];poll 5000000000\n"},
+		{[]string{"--output-format=collapsed", "--pid=456", "--thread=Main.*"},
+			"Daemon [pid: 456];Main Thread [tid: 0x3];poll 5000000000\n"},
+	} {
+		var out strings.Builder
+		if err := runExtract(test.args, strings.NewReader(extractInput), &out); err != nil {
+			t.Fatalf("%v: %v", test.args, err)
+		}
+		if out.String() != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.args, test.expected, out.String())
+		}
+	}
+}
+
+func TestExtractIR(t *testing.T) {
+	var out strings.Builder
+	if err := runExtract([]string{"--thread=Worker"}, strings.NewReader(extractInput), &out); err != nil {
+		t.Fatal(err)
+	}
+	p, err := ir.Read(strings.NewReader(out.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Processes) != 1 || len(p.Processes[0].Threads) != 1 || p.Processes[0].Threads[0].Name != "Worker" {
+		t.Errorf("Expected only the Worker thread, got %v", p.Processes)
+	}
+}
+
+func TestExtractNothingMatches(t *testing.T) {
+	for _, args := range [][]string{
+		{"--pid=789"},
+		{"--thread=Render"},
+		{"--output-format=pprof"},
+		{"--thread=("},
+	} {
+		var out strings.Builder
+		if err := runExtract(args, strings.NewReader(extractInput), &out); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/instrumentsToPprof/internal/parsers"
+	"github.com/google/instrumentsToPprof/internal/parsers/xctrace"
+)
+
+// suggest returns the candidate closest to value, if it is close enough to
+// be a misspelling of it, or "".
+func suggest(value string, candidates []string) string {
+	best, bestDistance := "", len(value)/3+2
+	for _, candidate := range candidates {
+		if d := editDistance(strings.ToLower(value), candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// unknownValueError explains that value isn't one of candidates, suggesting
+// the closest one.
+func unknownValueError(flagName, value string, candidates []string) error {
+	msg := fmt.Sprintf("Unknown --%s '%s'", flagName, value)
+	if s := suggest(value, candidates); s != "" {
+		msg += fmt.Sprintf(", did you mean '%s'?", s)
+	}
+	return fmt.Errorf("%s Expected one of %s", msg, strings.Join(candidates, ", "))
+}
+
+// formatFlags are the options of a format, registered on the command line.
+type formatFlags struct {
+	set *flag.FlagSet
+	// configure makes the parser factory with the parsed options.
+	configure func() (parsers.MakeParserFn, error)
+}
+
+// formatOptions are the options of the formats having some, by format name.
+type formatOptions map[string]formatFlags
+
+// registerFormatFlags registers the options of every format on fs.
+func registerFormatFlags(fs *flag.FlagSet) formatOptions {
+	options := make(formatOptions)
+	for _, f := range parsers.Formats() {
+		if f.Flags == nil {
+			continue
+		}
+		set := flag.NewFlagSet(f.Name, flag.ContinueOnError)
+		configure := f.Flags(set)
+		set.VisitAll(func(option *flag.Flag) {
+			fs.Var(option.Value, option.Name, option.Usage)
+		})
+		options[f.Name] = formatFlags{set: set, configure: configure}
+	}
+	return options
+}
+
+// formatOf returns the format having the option, or "" for general flags.
+func (options formatOptions) formatOf(flagName string) string {
+	for format, flags := range options {
+		if flags.set.Lookup(flagName) != nil {
+			return format
+		}
+	}
+	return ""
+}
+
+// values returns the values of the options of the format, in the order of
+// their names. Any format can be detected with --format=auto, so it has the
+// options of all formats.
+func (options formatOptions) values(format string) []string {
+	var values []string
+	for _, f := range parsers.FormatNames() {
+		if flags, ok := options[f]; ok && (f == format || format == kAuto) {
+			flags.set.VisitAll(func(option *flag.Flag) {
+				values = append(values, option.Value.String())
+			})
+		}
+	}
+	return values
+}
+
+// printDefaults prints the general flags of fs, followed by the options of
+// each format.
+func (options formatOptions) printDefaults(fs *flag.FlagSet) {
+	general := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	general.SetOutput(fs.Output())
+	fs.VisitAll(func(f *flag.Flag) {
+		if options.formatOf(f.Name) == "" {
+			general.Var(f.Value, f.Name, f.Usage)
+			general.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	general.PrintDefaults()
+	for _, f := range parsers.Formats() {
+		if flags, ok := options[f.Name]; ok {
+			fmt.Fprintf(fs.Output(), "Options of --format=%s:\n", f.Name)
+			flags.set.SetOutput(fs.Output())
+			flags.set.PrintDefaults()
+		}
+	}
+}
+
+// formatHelp describes the values of --format.
+func formatHelp() string {
+	help := "The format of the input. Use,\n" +
+		"--format=auto for detecting the format from the input. This is the default.\n"
+	for _, f := range parsers.Formats() {
+		help += fmt.Sprintf("--format=%s for %s\n", f.Name, f.Help)
+	}
+	return help + `
+Sample copying is a new feature and may have issues. File an issue on github in that case, with
+the archive written by --report-bundle.
+`
+}
+
+// parserForFormat returns the parser factory of the format, configured by
+// its options if they were registered.
+func parserForFormat(format string, options formatOptions) (parsers.MakeParserFn, error) {
+	if format == kAuto {
+		// Check the options of every format up front, as any can be detected.
+		for _, f := range parsers.Formats() {
+			if _, err := options.configure(f); err != nil {
+				return nil, err
+			}
+		}
+		return parsers.MakeDetectingParser(options.configure), nil
+	}
+	f, ok := parsers.LookupFormat(format)
+	if !ok {
+		return nil, unknownValueError("format", format, append([]string{kAuto}, parsers.FormatNames()...))
+	}
+	makeParser, err := options.configure(f)
+	if err != nil {
+		return nil, err
+	}
+	if f.Archive {
+		return makeParser, nil
+	}
+	return parsers.MakeDecompressingParser(makeParser), nil
+}
+
+// configure returns the parser factory of the format with its options.
+func (options formatOptions) configure(f parsers.Format) (parsers.MakeParserFn, error) {
+	if flags, ok := options[f.Name]; ok {
+		return flags.configure()
+	}
+	return f.Make, nil
+}
+
+// outputFormats are the values of --output-format.
+var outputFormats = []string{kPprofOutput, kCollapsedOutput, kSpeedscopeOutput, kHTMLOutput, kTreeOutput}
+
+// symbolOffsetModes are the values of --symbol-offsets.
+var symbolOffsetModes = []string{kKeepOffsets, kMergeOffsets, kAddressOffsets}
+
+// checkCapabilities returns an error for the flags that need something the
+// input's format doesn't record, so they fail before a long parse.
+func checkCapabilities(fs *flag.FlagSet, c internal.Capabilities) error {
+	value := func(name string) string {
+		return fs.Lookup(name).Value.String()
+	}
+	var problems []string
+	if !c.Timestamps {
+		if value("between") != "" {
+			problems = append(problems, "--between needs the times of the samples, which the input's format doesn't record.")
+		}
+		if value("split-by-core-type") == "true" {
+			problems = append(problems, "--split-by-core-type needs the samples over time, which the input's format "+
+				"doesn't record. Use an xctrace export of a recording on Apple Silicon.")
+		}
+	}
+	if resolution, _ := time.ParseDuration(value("weight-resolution")); resolution > 0 && !c.HasUnit("nanoseconds") {
+		problems = append(problems, "--weight-resolution needs weights in nanoseconds, which the input's format doesn't have.")
+	}
+	if weight, _ := time.ParseDuration(value("min-weight")); weight > 0 && !c.HasUnit("nanoseconds") {
+		problems = append(problems, "--min-weight needs weights in nanoseconds, which the input's format doesn't have. "+
+			"Use --min-percent instead.")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// validateFlags checks the flags and their combinations before anything is
+// parsed, returning every problem found.
+func validateFlags(fs *flag.FlagSet, options formatOptions) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	value := func(name string) string {
+		return fs.Lookup(name).Value.String()
+	}
+	var problems []string
+	format := value("format")
+	if _, err := parserForFormat(format, options); err != nil {
+		problems = append(problems, err.Error())
+	}
+	for name := range explicit {
+		if optionFormat := options.formatOf(name); optionFormat != "" && optionFormat != format && format != kAuto {
+			problems = append(problems, fmt.Sprintf("--%s only applies to --format=%s, not --format=%s", name, optionFormat, format))
+		}
+	}
+	if table := value("instrument-table"); !contains(xctrace.TableNames(), table) {
+		problems = append(problems, unknownValueError("instrument-table", table, xctrace.TableNames()).Error())
+	}
+	if run, _ := strconv.Atoi(value("run")); run < 1 {
+		problems = append(problems, fmt.Sprintf("--run %s must be at least 1, the first run of a .trace bundle", value("run")))
+	}
+	if between := value("between"); between != "" && !strings.Contains(between, ",") {
+		problems = append(problems, fmt.Sprintf("Invalid --between %s, expected startSymbol,endSymbol", between))
+	}
+	if resolution, _ := time.ParseDuration(value("weight-resolution")); resolution < 0 {
+		problems = append(problems, "--weight-resolution must not be negative")
+	}
+	if weight, _ := time.ParseDuration(value("min-weight")); weight < 0 {
+		problems = append(problems, "--min-weight must not be negative")
+	}
+	if percent, _ := strconv.ParseFloat(value("min-percent"), 64); percent < 0 || percent > 100 {
+		problems = append(problems, fmt.Sprintf("--min-percent %s must be between 0 and 100", value("min-percent")))
+	}
+	if explicit["min-weight"] && explicit["min-percent"] {
+		problems = append(problems, "Give either --min-weight or --min-percent, not both")
+	}
+	for _, name := range []string{"include-threads", "exclude-threads"} {
+		if _, err := regexp.Compile(value(name)); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid --%s: %v", name, err))
+		}
+	}
+	for _, name := range []string{"drop-frames", "keep-frames"} {
+		// pprof matches the expressions against whole function names.
+		if _, err := regexp.Compile("^(" + value(name) + ")$"); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid --%s: %v", name, err))
+		}
+	}
+	if value("drop-frames") == "" {
+		for _, name := range []string{"keep-frames", "prune-frames"} {
+			if explicit[name] {
+				problems = append(problems, fmt.Sprintf("--%s only applies with --drop-frames", name))
+			}
+		}
+	}
+	if output := value("output-format"); explicit["prune-frames"] && output != kPprofOutput && output != kCollapsedOutput {
+		problems = append(problems, fmt.Sprintf("--prune-frames only applies to the pprof and collapsed outputs, "+
+			"not --output-format=%s. Fold the frames with --frame-rules instead", output))
+	}
+	for _, name := range []string{"include-process", "exclude-process"} {
+		if _, err := internal.ParseProcessFilter(value(name)); err != nil {
+			problems = append(problems, fmt.Sprintf("Invalid --%s: %v", name, err))
+		}
+	}
+	for _, label := range splitList(value("labels")) {
+		if !contains(internal.SampleLabels, label) {
+			problems = append(problems, unknownValueError("labels", label, internal.SampleLabels).Error())
+		}
+	}
+	if mode := value("symbol-offsets"); !contains(symbolOffsetModes, mode) {
+		problems = append(problems, unknownValueError("symbol-offsets", mode, symbolOffsetModes).Error())
+	}
+	if output := value("output-format"); !contains(outputFormats, output) {
+		problems = append(problems, unknownValueError("output-format", output, outputFormats).Error())
+	}
+	if _, err := parseTolerance(value("tolerance")); err != nil {
+		problems = append(problems, fmt.Sprintf("Invalid --tolerance: %v", err))
+	}
+	if value("baseline") == "" {
+		for _, name := range []string{"tolerance", "baseline-warn"} {
+			if explicit[name] {
+				problems = append(problems, fmt.Sprintf("--%s only applies with --baseline", name))
+			}
+		}
+	}
+	if value("diff") == "true" {
+		if fs.NArg() != 2 {
+			problems = append(problems, fmt.Sprintf("--diff takes 2 inputs, before and after, got %d", fs.NArg()))
+		}
+		if output := value("output-format"); output != kPprofOutput {
+			problems = append(problems, fmt.Sprintf("--diff writes a pprof profile, not --output-format=%s", output))
+		}
+	}
+	// A tree without --output is printed to stdout, leaving no file to copy.
+	writesFile := value("output-format") != kTreeOutput || explicit["output"]
+	if target := value("to-clipboard"); target != "" && !contains(clipboardTargets, target) {
+		problems = append(problems, unknownValueError("to-clipboard", target, clipboardTargets).Error())
+	} else if target != "" && !writesFile {
+		problems = append(problems, fmt.Sprintf("--output-format=%s prints to stdout, so --to-clipboard=%s "+
+			"needs an --output file to copy", kTreeOutput, target))
+	}
+	if value("from-clipboard") == "true" && fs.NArg() > 0 {
+		problems = append(problems, fmt.Sprintf("--from-clipboard reads the input from the clipboard, not %s. "+
+			"Drop one of them.", fs.Arg(0)))
+	}
+	// The output is written last, after e.g. --store appended the profile,
+	// so an existing output must fail before anything is converted.
+	if output := value("output"); writesFile && value("force") != "true" {
+		if _, err := os.Stat(output); err == nil {
+			problems = append(problems, fmt.Sprintf(
+				"%s already exists, use --force to overwrite it or --output to choose another file", output))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, element := range list {
+		if element == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/instrumentsToPprof/internal/parsers"
+	"github.com/google/instrumentsToPprof/internal/parsers/xctrace"
+)
+
+func makeValidatedFlags() (*flag.FlagSet, formatOptions) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("format", kInstrumentsDeepCopy, "")
+	options := registerFormatFlags(fs)
+	fs.String("instrument-table", xctrace.DefaultTable, "")
+	fs.Int("run", 1, "")
+	fs.String("between", "", "")
+	fs.Duration("weight-resolution", 0, "")
+	fs.Bool("split-by-core-type", false, "")
+	fs.Bool("exclude-process-from-stack", false, "")
+	fs.String("output", "profile.pb.gz", "")
+	fs.Bool("force", false, "")
+	fs.String("output-format", kPprofOutput, "")
+	fs.Var(&internal.ProcessAnnotationMap{}, "pidTag", "")
+	fs.String("baseline", "", "")
+	fs.String("tolerance", "10%", "")
+	fs.Bool("baseline-warn", false, "")
+	fs.Bool("from-clipboard", false, "")
+	fs.String("to-clipboard", "", "")
+	fs.Bool("diff", false, "")
+	fs.String("include-threads", "", "")
+	fs.String("exclude-threads", "", "")
+	fs.String("include-process", "", "")
+	fs.String("exclude-process", "", "")
+	fs.String("drop-frames", "", "")
+	fs.String("keep-frames", "", "")
+	fs.Bool("prune-frames", false, "")
+	fs.Duration("min-weight", 0, "")
+	fs.Float64("min-percent", 0, "")
+	fs.String("symbol-offsets", kMergeOffsets, "")
+	fs.String("labels", strings.Join(internal.SampleLabels, ","), "")
+	return fs, options
+}
+
+func TestValidateFlags(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected []string
+	}{
+		{args: []string{}},
+		{args: []string{"--format=sample"}},
+		{args: []string{"--format=smaple"}, expected: []string{"did you mean 'sample'?"}},
+		{args: []string{"--format=Speedscope"}, expected: []string{"did you mean 'speedscope'?"}},
+		{args: []string{"--format=pprof"}, expected: []string{"Unknown --format 'pprof' Expected one of"}},
+		{args: []string{"--deep-copy-indent=-1"}, expected: []string{"must not be negative"}},
+		{args: []string{"--format=auto", "--deep-copy-indent=2"}},
+		{args: []string{"--format=auto", "--bounded-weights=lower-bound"}, expected: []string{"expected upper-bound or zero"}},
+		{args: []string{"--format=sample", "--deep-copy-indent=2"},
+			expected: []string{"--deep-copy-indent only applies to --format=instruments"}},
+		{args: []string{"--bounded-weights=lower-bound"}, expected: []string{"expected upper-bound or zero"}},
+		{args: []string{"--instrument-table=cpu-profiel"}, expected: []string{"did you mean 'cpu-profile'?"}},
+		{args: []string{"--run=0"}, expected: []string{"--run 0 must be at least 1"}},
+		{args: []string{"--between=start"}, expected: []string{"Invalid --between start"}},
+		{args: []string{"--weight-resolution=-1ms"}, expected: []string{"must not be negative"}},
+		{args: []string{"--pidTag=1:tag", "--exclude-process-from-stack"}},
+		{args: []string{"--output-format=folded"}, expected: []string{"Unknown --output-format 'folded'"}},
+		{args: []string{"--baseline=old.pb.gz", "--tolerance=5%", "--baseline-warn"}},
+		{args: []string{"--baseline=old.pb.gz", "--tolerance=lots"}, expected: []string{"Invalid --tolerance"}},
+		{args: []string{"--tolerance=5%"}, expected: []string{"--tolerance only applies with --baseline"}},
+		{args: []string{"--from-clipboard", "--to-clipboard=path"}},
+		{args: []string{"--to-clipboard=file"}, expected: []string{"Unknown --to-clipboard 'file'"}},
+		{args: []string{"--to-clipboard=content", "--output-format=tree"}, expected: []string{"needs an --output file"}},
+		{args: []string{"--to-clipboard=content", "--output-format=tree", "--output=tree.txt"}},
+		{args: []string{"--from-clipboard", "input.txt"}, expected: []string{"not input.txt"}},
+		{args: []string{"--diff", "before.txt", "after.txt"}},
+		{args: []string{"--diff", "before.txt"}, expected: []string{"--diff takes 2 inputs"}},
+		{args: []string{"--diff", "--output-format=tree", "a", "b"}, expected: []string{"not --output-format=tree"}},
+		{args: []string{"--include-threads=main", "--exclude-threads=GC|JIT"}},
+		{args: []string{"--exclude-threads=(GC"}, expected: []string{"Invalid --exclude-threads"}},
+		{args: []string{"--include-process=Renderer", "--exclude-process=123"}},
+		{args: []string{"--include-process=[Renderer"}, expected: []string{"Invalid --include-process"}},
+		{args: []string{"--drop-frames=mach_msg.*", "--keep-frames=mach_msg", "--prune-frames"}},
+		{args: []string{"--drop-frames=mach_msg("}, expected: []string{"Invalid --drop-frames"}},
+		{args: []string{"--drop-frames=bar", "--prune-frames", "--output-format=tree"},
+			expected: []string{"not --output-format=tree"}},
+		{args: []string{"--prune-frames"}, expected: []string{"--prune-frames only applies with --drop-frames"}},
+		{args: []string{"--min-weight=5ms"}},
+		{args: []string{"--min-percent=0.1"}},
+		{args: []string{"--min-weight=-5ms"}, expected: []string{"--min-weight must not be negative"}},
+		{args: []string{"--min-percent=120"}, expected: []string{"--min-percent 120 must be between 0 and 100"}},
+		{args: []string{"--min-weight=5ms", "--min-percent=0.1"}, expected: []string{"either --min-weight or --min-percent"}},
+		{args: []string{"--symbol-offsets=address"}},
+		{args: []string{"--labels="}},
+		{args: []string{"--labels=pid, thread_name"}},
+		{args: []string{"--labels=pid,thread"}, expected: []string{"Unknown --labels 'thread' Expected one of pid"}},
+		{args: []string{"--symbol-offsets=merged"}, expected: []string{"did you mean 'merge'?"}},
+		{args: []string{"--format=smaple", "--run=0"}, expected: []string{"'sample'", "--run 0"}},
+	} {
+		fs, options := makeValidatedFlags()
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		err := validateFlags(fs, options)
+		if len(test.expected) == 0 {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", test.args, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%v: expected an error", test.args)
+			continue
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("%v: expected %q in %q", test.args, expected, err)
+			}
+		}
+	}
+}
+
+func TestValidateFlagsExistingOutput(t *testing.T) {
+	existing, err := ioutil.TempFile("", "profile*.pb.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing.Close()
+	defer os.Remove(existing.Name())
+	for _, test := range []struct {
+		args  []string
+		fails bool
+	}{
+		{args: []string{"--output=" + existing.Name()}, fails: true},
+		{args: []string{"--output=" + existing.Name(), "--store=st"}, fails: true},
+		{args: []string{"--output=" + existing.Name(), "--output-format=tree"}, fails: true},
+		{args: []string{"--output=" + existing.Name(), "--force"}},
+		{args: []string{"--output=" + existing.Name() + ".new"}},
+	} {
+		fs, options := makeValidatedFlags()
+		fs.String("store", "", "")
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		err := validateFlags(fs, options)
+		if test.fails && (err == nil || !strings.Contains(err.Error(), "already exists")) {
+			t.Errorf("%v: expected an error for the existing output, got %v", test.args, err)
+		}
+		if !test.fails && err != nil {
+			t.Errorf("%v: unexpected error %v", test.args, err)
+		}
+	}
+}
+
+func TestCheckCapabilities(t *testing.T) {
+	for _, test := range []struct {
+		args         []string
+		capabilities internal.Capabilities
+		expected     []string
+	}{
+		{args: []string{"--between=a,b"}, capabilities: internal.AllCapabilities},
+		{args: []string{"--between=a,b", "--split-by-core-type"}, capabilities: internal.Capabilities{},
+			expected: []string{"--between needs the times", "--split-by-core-type needs"}},
+		{args: []string{"--weight-resolution=1ms"},
+			capabilities: internal.Capabilities{Units: []internal.ValueType{internal.CPUValueType}}},
+		{args: []string{"--weight-resolution=1ms"},
+			capabilities: internal.Capabilities{Units: []internal.ValueType{{Type: "space", Unit: "bytes"}}},
+			expected:     []string{"--weight-resolution needs weights in nanoseconds"}},
+		{args: []string{"--min-weight=5ms"},
+			capabilities: internal.Capabilities{Units: []internal.ValueType{{Type: "space", Unit: "bytes"}}},
+			expected:     []string{"--min-weight needs weights in nanoseconds"}},
+	} {
+		fs, _ := makeValidatedFlags()
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		err := checkCapabilities(fs, test.capabilities)
+		if len(test.expected) == 0 {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", test.args, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("%v: expected an error", test.args)
+			continue
+		}
+		for _, expected := range test.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("%v: expected %q in %q", test.args, expected, err)
+			}
+		}
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	for value, expected := range map[string]string{
+		"instrument": kInstrumentsDeepCopy,
+		"crash":      kCrash,
+		"spindumps":  kSpindump,
+		"fsusage":    kFsUsage,
+		"json":       "",
+	} {
+		if got := suggest(value, parsers.FormatNames()); got != expected {
+			t.Errorf("suggest(%q) = %q, expected %q", value, got, expected)
+		}
+	}
+}
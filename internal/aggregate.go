@@ -0,0 +1,116 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ReportsValueType is the value type of aggregated reports.
+var ReportsValueType = ValueType{Type: "reports", Unit: "count"}
+
+type reportAggregator struct {
+	processes map[string]*Process
+	threads   map[string]*Thread
+	result    *TimeProfile
+}
+
+// threadKey identifies a thread across reports by its name and labels, so
+// e.g. crashed and non-crashed main threads are kept apart.
+func threadKey(proc *Process, th *Thread) string {
+	keys := make([]string, 0, len(th.Labels))
+	for key := range th.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	key := proc.Name + "\x00" + th.Name
+	for _, k := range keys {
+		key += fmt.Sprintf("\x00%s=%s", k, th.Labels[k])
+	}
+	return key
+}
+
+func (a *reportAggregator) getThread(proc *Process, th *Thread) *Thread {
+	process, ok := a.processes[proc.Name]
+	if !ok {
+		process = &Process{
+			Name:    proc.Name,
+			Threads: make([]*Thread, 0),
+		}
+		a.processes[proc.Name] = process
+		a.result.Processes = append(a.result.Processes, process)
+	}
+	key := threadKey(proc, th)
+	thread, ok := a.threads[key]
+	if !ok {
+		thread = &Thread{
+			Name:   th.Name,
+			Frames: make([]*Frame, 0),
+			Labels: th.Labels,
+		}
+		a.threads[key] = thread
+		process.Threads = append(process.Threads, thread)
+	}
+	return thread
+}
+
+func stackOf(frame *Frame) []string {
+	stack := make([]string, 0, frame.Depth)
+	for f := frame; f != nil; f = f.Parent {
+		stack = append(stack, f.SymbolName)
+	}
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return stack
+}
+
+func (a *reportAggregator) addFrame(seen map[string]bool, proc *Process, th *Thread, frame *Frame) {
+	if frame.SelfWeightNs != 0 {
+		stack := stackOf(frame)
+		key := threadKey(proc, th) + "\x00" + strings.Join(stack, "\x00")
+		if !seen[key] {
+			seen[key] = true
+			a.getThread(proc, th).AddStack(stack, 1)
+		}
+	}
+	for _, child := range frame.Children {
+		a.addFrame(seen, proc, th, child)
+	}
+}
+
+// AggregateReports combines the profiles of many reports, e.g. crash reports,
+// into one profile where the weight of a stack is the number of reports that
+// contain it. Processes are matched by name since pids differ between reports.
+func AggregateReports(reports []*TimeProfile) *TimeProfile {
+	a := &reportAggregator{
+		processes: make(map[string]*Process),
+		threads:   make(map[string]*Thread),
+		result:    &TimeProfile{ValueType: ReportsValueType},
+	}
+	for _, report := range reports {
+		seen := make(map[string]bool)
+		for _, proc := range report.Processes {
+			for _, th := range proc.Threads {
+				for _, frame := range th.Frames {
+					a.addFrame(seen, proc, th, frame)
+				}
+			}
+		}
+	}
+	return a.result
+}
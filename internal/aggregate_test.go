@@ -0,0 +1,58 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestAggregateReports(t *testing.T) {
+	first := MakeDeepCopy()
+	second := MakeDeepCopy()
+	second.Processes[0].Pid = 456
+	// The same stack twice in one report only counts once.
+	second.Processes[0].Threads[0].Frames[0].Children[0].SelfWeightNs = 10
+	second.Processes[0].Threads = append(second.Processes[0].Threads,
+		MakeDeepCopy().Processes[0].Threads[0])
+
+	got := AggregateReports([]*TimeProfile{first, second})
+	expected := &TimeProfile{
+		Processes: []*Process{
+			{
+				Name: "proc",
+				Threads: []*Thread{
+					{
+						Name: "thread1",
+						Frames: []*Frame{
+							{
+								SymbolName: "first_frame",
+								Depth:      1,
+								Children: []*Frame{
+									{
+										SymbolName:   "sub_frame",
+										Depth:        2,
+										SelfWeightNs: 2,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	TimeProfileEquals(t, got, expected)
+	if got.GetValueType() != ReportsValueType {
+		t.Errorf("Expected value type %v, was %v", ReportsValueType, got.GetValueType())
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Labels with the attributes of the device a report was taken on, so
+// profiles can be sliced by OS and architecture.
+const (
+	OSVersionLabel   = "os_version"
+	ArchLabel        = "arch"
+	DeviceModelLabel = "device_model"
+)
+
+// attributeHeaders are the header fields of crash, sample and spindump
+// reports with attributes of the device, by label.
+var attributeHeaders = map[string]string{
+	"OS Version":     OSVersionLabel,
+	"Code Type":      ArchLabel,
+	"Architecture":   ArchLabel,
+	"Hardware Model": DeviceModelLabel,
+	"Hardware model": DeviceModelLabel,
+}
+
+// ParseAttribute returns the label and value of a header line with an
+// attribute of the device, e.g. "OS Version: macOS 11.2.3 (20D91)", and false
+// for other lines.
+func ParseAttribute(line string) (label string, value string, ok bool) {
+	parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	label, ok = attributeHeaders[parts[0]]
+	value = strings.TrimSpace(parts[1])
+	return label, value, ok && value != ""
+}
+
+// archComments returns a profile comment for every architecture the threads
+// of the profile ran as, noting processes translated by Rosetta.
+func archComments(p *TimeProfile) []string {
+	archs := make(map[string]bool)
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			if arch, ok := th.Labels[ArchLabel]; ok {
+				archs[arch] = true
+			}
+		}
+	}
+	comments := make([]string, 0, len(archs))
+	for arch := range archs {
+		comment := fmt.Sprintf("arch: %s", arch)
+		if strings.Contains(arch, "Translated") {
+			comment += ", run under Rosetta"
+		}
+		comments = append(comments, comment)
+	}
+	sort.Strings(comments)
+	return comments
+}
+
+// SetAttributes adds the attributes of the device to the labels of every
+// thread of the process.
+func SetAttributes(proc *Process, attributes map[string]string) {
+	if len(attributes) == 0 {
+		return
+	}
+	for _, th := range proc.Threads {
+		if th.Labels == nil {
+			th.Labels = make(map[string]string)
+		}
+		for label, value := range attributes {
+			th.Labels[label] = value
+		}
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAttribute(t *testing.T) {
+	for line, expected := range map[string][2]string{
+		"OS Version:            macOS 11.2.3 (20D91)": {OSVersionLabel, "macOS 11.2.3 (20D91)"},
+		"Code Type:             ARM-64 (Native)":      {ArchLabel, "ARM-64 (Native)"},
+		"Architecture:     arm64e":                    {ArchLabel, "arm64e"},
+		"Hardware model:   MacBookPro17,1":            {DeviceModelLabel, "MacBookPro17,1"},
+	} {
+		label, value, ok := ParseAttribute(line)
+		if !ok || label != expected[0] || value != expected[1] {
+			t.Errorf("ParseAttribute(%q) = %s, %s, %v, expected %v", line, label, value, ok, expected)
+		}
+	}
+	for _, line := range []string{"Process:         Sandwich [1234]", "OS Version:", "Thread 0 Crashed"} {
+		if _, _, ok := ParseAttribute(line); ok {
+			t.Errorf("Expected %q not to be an attribute", line)
+		}
+	}
+}
+
+func TestSetAttributes(t *testing.T) {
+	proc := &Process{Name: "proc", Threads: []*Thread{
+		{Name: "main", Labels: map[string]string{"crashed": "true"}},
+		{Name: "worker"},
+	}}
+	SetAttributes(proc, map[string]string{OSVersionLabel: "macOS 11.2.3 (20D91)"})
+	for _, th := range proc.Threads {
+		if th.Labels[OSVersionLabel] != "macOS 11.2.3 (20D91)" {
+			t.Errorf("Expected os_version label on %s, got %v", th.Name, th.Labels)
+		}
+	}
+	if proc.Threads[0].Labels["crashed"] != "true" {
+		t.Errorf("Expected the crashed label to be kept, got %v", proc.Threads[0].Labels)
+	}
+}
+
+func TestArchComments(t *testing.T) {
+	p := &TimeProfile{Processes: []*Process{
+		{Name: "native", Threads: []*Thread{{Name: "main", Labels: map[string]string{ArchLabel: "ARM-64 (Native)"}}}},
+		{Name: "rosetta", Threads: []*Thread{
+			{Name: "main", Labels: map[string]string{ArchLabel: "X86-64 (Translated)"}},
+			{Name: "worker", Labels: map[string]string{ArchLabel: "X86-64 (Translated)"}},
+		}},
+	}}
+	got := TimeProfileToPprof(p, false, false, true, NoAnnotations)
+	expected := []string{"arch: ARM-64 (Native)", "arch: X86-64 (Translated), run under Rosetta"}
+	if !reflect.DeepEqual(got.Comments, expected) {
+		t.Errorf("Expected comments %v, got %v", expected, got.Comments)
+	}
+}
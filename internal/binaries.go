@@ -0,0 +1,65 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"path"
+)
+
+// BinaryFilter keeps or hides frames by the binary their code is in. Patterns
+// are globs matched against the file name of the binary, e.g. "libsystem*".
+type BinaryFilter struct {
+	// Only keeps the frames of the matching binaries, if not empty.
+	Only []string
+	// Hide hides the frames of the matching binaries.
+	Hide []string
+}
+
+func matchesAny(patterns []string, binary string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, path.Base(binary)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the patterns are valid globs.
+func (b BinaryFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, b.Only...), b.Hide...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Invalid binary pattern '%s': %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (b BinaryFilter) keep(binary string) bool {
+	if len(b.Only) > 0 && !matchesAny(b.Only, binary) {
+		return false
+	}
+	return !matchesAny(b.Hide, binary)
+}
+
+// FilterBinaries folds the frames of filtered binaries into their callers, so
+// e.g. the time spent in system libraries is attributed to the application
+// code calling them. Frames of inputs that don't record binaries are kept, as
+// are the outermost frames of each thread.
+func FilterBinaries(p *TimeProfile, filter BinaryFilter) {
+	rewriteFrames(p, func(f *Frame, parent *Frame) (string, bool) {
+		return f.SymbolName, f.Binary != "" && !filter.keep(f.Binary)
+	})
+}
@@ -0,0 +1,64 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+// setBinaries sets the binary of the frames by name.
+func setBinaries(p *TimeProfile, binaries map[string]string) {
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		f.Binary = binaries[f.SymbolName]
+	})
+}
+
+func TestFilterBinaries(t *testing.T) {
+	binaries := map[string]string{
+		"start":        "libdyld.dylib",
+		"main":         "MyApp",
+		"malloc":       "libsystem_malloc.dylib",
+		"memcpy":       "libsystem_platform.dylib",
+		"render":       "/Applications/MyApp.app/Contents/MacOS/MyApp",
+		"CFRunLoopRun": "CoreFoundation",
+	}
+	stacks := [][]string{
+		{"start", "main", "malloc"},
+		{"start", "main", "CFRunLoopRun", "render", "memcpy"},
+		{"start", "unknown"},
+	}
+
+	got := makeStacks(stacks...)
+	setBinaries(got, binaries)
+	FilterBinaries(got, BinaryFilter{Hide: []string{"libsystem*"}})
+	TimeProfileEquals(t, got, makeStacks(
+		[]string{"start", "main"},
+		[]string{"start", "main", "CFRunLoopRun", "render"},
+		[]string{"start", "unknown"},
+	))
+
+	got = makeStacks(stacks...)
+	setBinaries(got, binaries)
+	FilterBinaries(got, BinaryFilter{Only: []string{"MyApp"}})
+	TimeProfileEquals(t, got, makeStacks(
+		[]string{"start", "main"},
+		[]string{"start", "main", "render"},
+		[]string{"start", "unknown"},
+	))
+}
+
+func TestInvalidBinaryPattern(t *testing.T) {
+	if err := (BinaryFilter{Hide: []string{"lib["}}).Validate(); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
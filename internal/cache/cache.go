@@ -0,0 +1,291 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cache stores parsed profiles on disk, keyed by a hash of the input
+// and the parser options, so repeated conversions of the same input with
+// different flags skip parsing.
+//
+// Profiles are stored with gob. Frames are flattened into a list in which
+// parents come before their children, and symbol and binary names are
+// interned in a string table, since big exports repeat them many times.
+package cache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// version is part of every key, so entries of older versions of the format or
+// of the parsers are not used.
+const version = "4"
+
+type frame struct {
+	// Parent is the index of the parent frame, or -1.
+	Parent int
+	// Name and Binary are indexes into the string table.
+	Name          int
+	Binary        int
+	Depth         int
+	Position      internal.Position
+	SelfWeightNs  int64
+	TotalWeightNs int64
+	ExtraWeights  []int64
+	Labels        map[string]string
+	NumLabels     map[string]int64
+}
+
+type timedSample struct {
+	Time int64
+	// Frame is the index of the sample's innermost frame.
+	Frame    int
+	Weight   int64
+	CoreType string
+}
+
+type thread struct {
+	Name     string
+	Tid      uint64
+	Labels   map[string]string
+	Position internal.Position
+	// Frames of the thread, parents first.
+	Frames   []frame
+	Timeline []timedSample
+}
+
+type process struct {
+	Name     string
+	Pid      uint64
+	Position internal.Position
+	Threads  []thread
+}
+
+type profile struct {
+	Strings         []string
+	ValueType       internal.ValueType
+	ExtraValueTypes []internal.ValueType
+	Comments        []string
+	Processes       []process
+	// Capabilities of the parser of the input, which isn't made again for
+	// cached inputs.
+	Capabilities internal.Capabilities
+}
+
+// Key returns the cache key of an input parsed with the given options, e.g.
+// the format and parser flags.
+func Key(input []byte, options ...string) string {
+	h := NewHasher(options...)
+	h.Write(input)
+	return h.Key()
+}
+
+// Hasher computes the cache key of an input written to it, so big inputs
+// can be hashed while they are streamed instead of being read into memory.
+type Hasher struct {
+	h hash.Hash
+}
+
+// NewHasher returns a Hasher of an input parsed with the given options.
+func NewHasher(options ...string) *Hasher {
+	h := sha256.New()
+	for _, option := range append([]string{version}, options...) {
+		// Options are length prefixed so they can't run into each other.
+		fmt.Fprintf(h, "%d:%s", len(option), option)
+	}
+	return &Hasher{h: h}
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	return h.h.Write(p)
+}
+
+// Key returns the cache key of the input written so far.
+func (h *Hasher) Key() string {
+	return hex.EncodeToString(h.h.Sum(nil))
+}
+
+func path(dir string, key string) string {
+	return filepath.Join(dir, key+".gob")
+}
+
+// Load returns the cached profile of key and the capabilities of its parser,
+// or a nil profile if there is none.
+func Load(dir string, key string) (*internal.TimeProfile, internal.Capabilities, error) {
+	data, err := ioutil.ReadFile(path(dir, key))
+	if os.IsNotExist(err) {
+		return nil, internal.Capabilities{}, nil
+	}
+	if err != nil {
+		return nil, internal.Capabilities{}, err
+	}
+	var cached profile
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cached); err != nil {
+		return nil, internal.Capabilities{}, err
+	}
+	return cached.timeProfile(), cached.Capabilities, nil
+}
+
+// Store caches the profile parsed by a parser with the capabilities under
+// key.
+func Store(dir string, key string, p *internal.TimeProfile, capabilities internal.Capabilities) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	cached := flatten(p)
+	cached.Capabilities = capabilities
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cached); err != nil {
+		return err
+	}
+	// Write through a temporary file, so concurrent conversions never read
+	// a partial entry.
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path(dir, key))
+}
+
+// stringTable interns strings.
+type stringTable struct {
+	strings []string
+	indexes map[string]int
+}
+
+func (t *stringTable) intern(s string) int {
+	if i, ok := t.indexes[s]; ok {
+		return i
+	}
+	t.indexes[s] = len(t.strings)
+	t.strings = append(t.strings, s)
+	return len(t.strings) - 1
+}
+
+func flatten(p *internal.TimeProfile) profile {
+	table := &stringTable{indexes: make(map[string]int)}
+	cached := profile{ValueType: p.ValueType, ExtraValueTypes: p.ExtraValueTypes, Comments: p.Comments}
+	for _, proc := range p.Processes {
+		cachedProc := process{Name: proc.Name, Pid: proc.Pid, Position: proc.Position}
+		for _, th := range proc.Threads {
+			cachedThread := thread{Name: th.Name, Tid: th.Tid, Labels: th.Labels, Position: th.Position}
+			indexes := make(map[*internal.Frame]int)
+			var add func(f *internal.Frame, parent int)
+			add = func(f *internal.Frame, parent int) {
+				indexes[f] = len(cachedThread.Frames)
+				cachedThread.Frames = append(cachedThread.Frames, frame{
+					Parent:        parent,
+					Name:          table.intern(f.SymbolName),
+					Binary:        table.intern(f.Binary),
+					Depth:         f.Depth,
+					Position:      f.Position,
+					SelfWeightNs:  f.SelfWeightNs,
+					TotalWeightNs: f.TotalWeightNs,
+					ExtraWeights:  f.ExtraWeights,
+					Labels:        f.Labels,
+					NumLabels:     f.NumLabels,
+				})
+				index := indexes[f]
+				for _, child := range f.Children {
+					add(child, index)
+				}
+			}
+			for _, f := range th.Frames {
+				add(f, -1)
+			}
+			for _, sample := range th.Timeline {
+				cachedThread.Timeline = append(cachedThread.Timeline, timedSample{
+					Time:     sample.Time,
+					Frame:    indexes[sample.Frame],
+					Weight:   sample.Weight,
+					CoreType: sample.CoreType,
+				})
+			}
+			cachedProc.Threads = append(cachedProc.Threads, cachedThread)
+		}
+		cached.Processes = append(cached.Processes, cachedProc)
+	}
+	cached.Strings = table.strings
+	return cached
+}
+
+func (cached profile) timeProfile() *internal.TimeProfile {
+	p := &internal.TimeProfile{ValueType: cached.ValueType, ExtraValueTypes: cached.ExtraValueTypes, Comments: cached.Comments}
+	for _, cachedProc := range cached.Processes {
+		proc := &internal.Process{
+			Name:     cachedProc.Name,
+			Pid:      cachedProc.Pid,
+			Position: cachedProc.Position,
+			Threads:  make([]*internal.Thread, 0, len(cachedProc.Threads)),
+		}
+		for _, cachedThread := range cachedProc.Threads {
+			th := &internal.Thread{
+				Name:     cachedThread.Name,
+				Tid:      cachedThread.Tid,
+				Labels:   cachedThread.Labels,
+				Position: cachedThread.Position,
+				Frames:   make([]*internal.Frame, 0),
+			}
+			frames := make([]*internal.Frame, len(cachedThread.Frames))
+			for i, cachedFrame := range cachedThread.Frames {
+				f := &internal.Frame{
+					Children:      make([]*internal.Frame, 0),
+					SymbolName:    cached.Strings[cachedFrame.Name],
+					Binary:        cached.Strings[cachedFrame.Binary],
+					Depth:         cachedFrame.Depth,
+					Position:      cachedFrame.Position,
+					SelfWeightNs:  cachedFrame.SelfWeightNs,
+					TotalWeightNs: cachedFrame.TotalWeightNs,
+					ExtraWeights:  cachedFrame.ExtraWeights,
+					Labels:        cachedFrame.Labels,
+					NumLabels:     cachedFrame.NumLabels,
+				}
+				if cachedFrame.Parent < 0 {
+					th.Frames = append(th.Frames, f)
+				} else {
+					f.Parent = frames[cachedFrame.Parent]
+					f.Parent.Children = append(f.Parent.Children, f)
+				}
+				frames[i] = f
+			}
+			for _, sample := range cachedThread.Timeline {
+				th.Timeline = append(th.Timeline, internal.TimedSample{
+					Time:     sample.Time,
+					Frame:    frames[sample.Frame],
+					Weight:   sample.Weight,
+					CoreType: sample.CoreType,
+				})
+			}
+			proc.Threads = append(proc.Threads, th)
+		}
+		p.Processes = append(p.Processes, proc)
+	}
+	return p
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cache
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+func TestStoreAndLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	th := &internal.Thread{Name: "main", Tid: 1, Labels: map[string]string{"queue": "main"}}
+	th.AddTimedStack(1, []string{"start", "main", "eat"}, 10)
+	th.AddTimedStack(2, []string{"start", "main", "sleep"}, 5)
+	th.Timeline[1].CoreType = "E"
+	th.Frames[0].Binary = "dyld"
+	expected := &internal.TimeProfile{
+		Processes:       []*internal.Process{{Name: "Sandwich", Pid: 1234, Threads: []*internal.Thread{th}}},
+		ValueType:       internal.RunningValueType,
+		ExtraValueTypes: []internal.ValueType{internal.BlockedValueType},
+	}
+
+	key := Key([]byte("input"), "instruments")
+	if got, _, err := Load(dir, key); got != nil || err != nil {
+		t.Fatalf("Expected a cache miss, got %v, %v", got, err)
+	}
+	capabilities := internal.Capabilities{Timestamps: true, Units: []internal.ValueType{internal.RunningValueType}}
+	if err := Store(dir, key, expected, capabilities); err != nil {
+		t.Fatal(err)
+	}
+	got, gotCapabilities, err := Load(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotCapabilities, capabilities) {
+		t.Errorf("Expected capabilities %+v, got %+v", capabilities, gotCapabilities)
+	}
+	internal.TimeProfileEquals(t, got, expected)
+	if got.ValueType != expected.ValueType || len(got.ExtraValueTypes) != 1 {
+		t.Errorf("Unexpected value types %v %v", got.ValueType, got.ExtraValueTypes)
+	}
+	gotThread := got.Processes[0].Threads[0]
+	if gotThread.Labels["queue"] != "main" || gotThread.Frames[0].Binary != "dyld" {
+		t.Errorf("Unexpected thread %v", gotThread)
+	}
+	sleep := gotThread.Timeline[1].Frame
+	if sleep.SymbolName != "sleep" || sleep.Parent.SymbolName != "main" || gotThread.Timeline[1].CoreType != "E" {
+		t.Errorf("Unexpected timeline sample %v", gotThread.Timeline[1])
+	}
+}
+
+func TestKeyDependsOnOptions(t *testing.T) {
+	if Key([]byte("input"), "sample") == Key([]byte("input"), "instruments") {
+		t.Error("Expected different keys for different formats")
+	}
+	if Key([]byte("input"), "ab", "c") == Key([]byte("input"), "a", "bc") {
+		t.Error("Expected options not to run into each other")
+	}
+}
+
+func TestHasherMatchesKey(t *testing.T) {
+	h := NewHasher("instruments")
+	io.Copy(h, strings.NewReader("in"))
+	io.Copy(h, strings.NewReader("put"))
+	if h.Key() != Key([]byte("input"), "instruments") {
+		t.Error("Expected the key of a streamed input to match the key of its bytes")
+	}
+}
@@ -0,0 +1,46 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+// Capabilities describe what the inputs of a format record, so the
+// conversion can enable the features that depend on it per format instead
+// of guessing from the parsed profile.
+type Capabilities struct {
+	// Timestamps is set if the threads have a Timeline, as SelectBetween and
+	// SplitByCoreType need.
+	Timestamps bool
+	// ThreadIDs is set if the threads have their tids. Without them, the
+	// tids of the threads are 0 or made up.
+	ThreadIDs bool
+	// MultiProcess is set if an input can hold several processes.
+	MultiProcess bool
+	// Units are the value types the weights can have. Types named after the
+	// input, like the columns of vmmap, are left empty.
+	Units []ValueType
+}
+
+// AllCapabilities are the capabilities of inputs whose format doesn't limit
+// them, like the intermediate representation.
+var AllCapabilities = Capabilities{Timestamps: true, ThreadIDs: true, MultiProcess: true}
+
+// HasUnit returns whether the weights can be in the unit.
+func (c Capabilities) HasUnit(unit string) bool {
+	for _, valueType := range c.Units {
+		if valueType.Unit == unit {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+// ChromiumComponentRules are the component rules for Chromium and the engines
+// it embeds, in the format of ParseComponentRules.
+const ChromiumComponentRules = `# Rendering engine.
+blink::                         Blink
+WTF::                           Blink
+# JavaScript engine.
+v8::                            V8
+Builtins_                       V8
+cppgc::                         V8
+# Compositor and graphics.
+cc::                            Compositor
+viz::                           Viz
+gpu::                           GPU
+gl::                            GPU
+skia::                          Skia
+Sk                              Skia
+# Browser.
+content::                       Content
+chrome::                        Chrome
+views::                         Views
+ui::                            UI
+# Infrastructure.
+base::                          Base
+mojo::                          Mojo
+IPC::                           Mojo
+net::                           Network
+network::                       Network
+partition_alloc::               PartitionAlloc
+`
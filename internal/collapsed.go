@@ -0,0 +1,65 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/google/pprof/profile"
+)
+
+// WriteCollapsed writes the samples of a converted profile in the folded
+// stack format of flamegraph.pl, one line per stack with its frames from the
+// root separated by semicolons and the weight of the default sample type,
+// e.g. "proc [pid: 1];main;foo 1000". Writing the converted profile keeps the
+// process and thread frames as the flags of the conversion chose them.
+func WriteCollapsed(w io.Writer, prof *profile.Profile) error {
+	index := 0
+	for i, sampleType := range prof.SampleType {
+		if sampleType.Type == prof.DefaultSampleType {
+			index = i
+		}
+	}
+	weights := make(map[string]int64)
+	for _, sample := range prof.Sample {
+		var frames []string
+		// Locations start at the leaf, their lines at the innermost inlined
+		// function.
+		for i := len(sample.Location) - 1; i >= 0; i-- {
+			lines := sample.Location[i].Line
+			for j := len(lines) - 1; j >= 0; j-- {
+				// Semicolons would split the frame.
+				frames = append(frames, strings.Replace(lines[j].Function.Name, ";", ":", -1))
+			}
+		}
+		if len(frames) > 0 && sample.Value[index] != 0 {
+			weights[strings.Join(frames, ";")] += sample.Value[index]
+		}
+	}
+	stacks := make([]string, 0, len(weights))
+	for stack := range weights {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	out := bufio.NewWriter(w)
+	for _, stack := range stacks {
+		fmt.Fprintf(out, "%s %d\n", stack, weights[stack])
+	}
+	return out.Flush()
+}
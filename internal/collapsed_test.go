@@ -0,0 +1,40 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteCollapsed(t *testing.T) {
+	p := MakeDeepCopy()
+	p.Processes[0].Threads[0].Frames[0].Children[0].SymbolName = "operator;()"
+	for _, test := range []struct {
+		opts     ConvertOptions
+		expected string
+	}{
+		{NewConvertOptions(), "proc [pid: 123];thread1 [tid: 0x1];first_frame;operator:() 1\n"},
+		{NewConvertOptions(ExcludeProcessFrames(true), ExcludeThreadFrames(true)), "first_frame;operator:() 1\n"},
+	} {
+		var out strings.Builder
+		if err := WriteCollapsed(&out, ConvertToPprof(p, test.opts)); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != test.expected {
+			t.Errorf("Expected %q with %+v, got %q", test.expected, test.opts, out.String())
+		}
+	}
+}
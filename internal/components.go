@@ -0,0 +1,102 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// ComponentLabel is the label holding the component of a sample's innermost
+// frame that matches a component rule.
+const ComponentLabel = "component"
+
+type componentRule struct {
+	prefix    string
+	component string
+}
+
+// ComponentRules map symbol prefixes to the components owning them.
+type ComponentRules struct {
+	// rules sorted by decreasing prefix length, so the most specific prefix
+	// matches first.
+	rules []componentRule
+}
+
+// ParseComponentRules parses a rules file. Each line is a symbol prefix
+// followed by the name of its component, separated by whitespace, e.g.
+//
+//	blink::  Blink
+//
+// Empty lines and lines starting with '#' are ignored.
+func ParseComponentRules(r io.Reader) (c ComponentRules, err error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return c, fmt.Errorf("Component rule on line %d has no component: %s", line, text)
+		}
+		c.rules = append(c.rules, componentRule{
+			prefix:    fields[0],
+			component: strings.Join(fields[1:], " "),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return c, err
+	}
+	sort.SliceStable(c.rules, func(i, j int) bool {
+		return len(c.rules[i].prefix) > len(c.rules[j].prefix)
+	})
+	return c, nil
+}
+
+// componentOf returns the component of a symbol, or "" if no rule matches.
+func (c ComponentRules) componentOf(symbol string) string {
+	for _, rule := range c.rules {
+		if strings.HasPrefix(symbol, rule.prefix) {
+			return rule.component
+		}
+	}
+	return ""
+}
+
+// AddComponentLabels labels every frame with the component of the innermost
+// frame of its stack that matches a rule, so pprof tag reports can break the
+// profile down by component. Stacks matching no rule are left unlabeled.
+func AddComponentLabels(p *TimeProfile, rules ComponentRules) {
+	components := make(map[*Frame]string)
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		component := rules.componentOf(f.SymbolName)
+		if component == "" && f.Parent != nil {
+			component = components[f.Parent]
+		}
+		components[f] = component
+		if component != "" {
+			if f.Labels == nil {
+				f.Labels = make(map[string]string)
+			}
+			f.Labels[ComponentLabel] = component
+		}
+	})
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestComponentLabels(t *testing.T) {
+	rules, err := ParseComponentRules(strings.NewReader(`
+# Comment
+blink::        Blink
+blink::paint:: Blink Paint
+v8::           V8
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := makeStacks(
+		[]string{"main", "blink::Document::UpdateStyle", "v8::Function::Call"},
+		[]string{"main", "blink::paint::PaintLayer", "memcpy"},
+		[]string{"main", "idle"},
+	)
+	AddComponentLabels(p, rules)
+	labels := make(map[string]string)
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		labels[f.SymbolName] = f.Labels[ComponentLabel]
+	})
+	expected := map[string]string{
+		"main":                         "",
+		"blink::Document::UpdateStyle": "Blink",
+		"v8::Function::Call":           "V8",
+		"blink::paint::PaintLayer":     "Blink Paint",
+		"memcpy":                       "Blink Paint",
+		"idle":                         "",
+	}
+	for name, component := range expected {
+		if labels[name] != component {
+			t.Errorf("%s had component '%s', expected '%s'", name, labels[name], component)
+		}
+	}
+}
+
+func TestInvalidComponentRules(t *testing.T) {
+	if _, err := ParseComponentRules(strings.NewReader("blink::\n")); err == nil {
+		t.Error("Expected an error for a rule without a component")
+	}
+}
+
+func TestChromiumComponentRules(t *testing.T) {
+	if _, err := ParseComponentRules(strings.NewReader(ChromiumComponentRules)); err != nil {
+		t.Error(err)
+	}
+}
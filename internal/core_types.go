@@ -0,0 +1,50 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "errors"
+
+// SplitByCoreType adds two sample values with the part of the weight that
+// ran on performance and on efficiency cores, "cpu_p" and "cpu_e", in the
+// unit of the profile. Samples of unknown core type only count towards the
+// total. The profile must have a timeline recording core types, like the
+// exports of xctrace on Apple Silicon.
+func SplitByCoreType(p *TimeProfile) error {
+	found := false
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			for _, sample := range th.Timeline {
+				found = found || sample.CoreType != ""
+			}
+		}
+	}
+	if !found {
+		return errors.New("Splitting by core type requires an input recording the core of each sample, e.g. xctrace on Apple Silicon.")
+	}
+	unit := p.GetValueType().Unit
+	index := map[string]int{"P": len(p.ExtraValueTypes), "E": len(p.ExtraValueTypes) + 1}
+	p.ExtraValueTypes = append(p.ExtraValueTypes,
+		ValueType{Type: "cpu_p", Unit: unit}, ValueType{Type: "cpu_e", Unit: unit})
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			for _, sample := range th.Timeline {
+				if i, ok := index[sample.CoreType]; ok {
+					sample.Frame.addExtraWeight(i, sample.Weight)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestSplitByCoreType(t *testing.T) {
+	th := &Thread{Name: "thread", Tid: 1}
+	th.AddTimedStack(0, []string{"main", "foo"}, 10)
+	th.Timeline[0].CoreType = "P"
+	th.AddTimedStack(1, []string{"main", "foo"}, 20)
+	th.Timeline[1].CoreType = "E"
+	th.AddTimedStack(2, []string{"main"}, 5)
+	p := &TimeProfile{Processes: []*Process{{Name: "proc", Pid: 1, Threads: []*Thread{th}}}}
+
+	if err := SplitByCoreType(p); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.ExtraValueTypes) != 2 || p.ExtraValueTypes[0].Type != "cpu_p" || p.ExtraValueTypes[1].Type != "cpu_e" {
+		t.Fatalf("Unexpected value types %v", p.ExtraValueTypes)
+	}
+	foo := th.Frames[0].Children[0]
+	if foo.SelfWeightNs != 30 || foo.ExtraWeights[0] != 10 || foo.ExtraWeights[1] != 20 {
+		t.Errorf("Unexpected weights of foo %d %v", foo.SelfWeightNs, foo.ExtraWeights)
+	}
+	if main := th.Frames[0]; len(main.ExtraWeights) != 0 {
+		t.Errorf("Expected no core weights for samples of unknown core, got %v", main.ExtraWeights)
+	}
+}
+
+func TestSplitByCoreTypeRequiresCores(t *testing.T) {
+	if err := SplitByCoreType(makeStacks([]string{"main"})); err == nil {
+		t.Error("Expected an error for a profile without core types")
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "fmt"
+
+// CumulativeValueType is the weight of a frame and its callees.
+var CumulativeValueType = ValueType{Type: "cumulative", Unit: "nanoseconds"}
+
+// AddCumulativeWeights adds a cumulative sample value holding the total
+// weight of each frame, including its callees, to the sample of the frame.
+// The total shown by the input is used if it has one, so comparing the flat
+// cumulative value of a frame with its cum cpu value in pprof reveals rows
+// missing from a truncated copy. It requires a profile in nanoseconds.
+func AddCumulativeWeights(p *TimeProfile) error {
+	if unit := p.GetValueType().Unit; unit != "nanoseconds" {
+		return fmt.Errorf("Cumulative weights need a profile in nanoseconds, the weights are in %s", unit)
+	}
+	index := len(p.ExtraValueTypes)
+	p.ExtraValueTypes = append(p.ExtraValueTypes, CumulativeValueType)
+	var add func(f *Frame) int64
+	add = func(f *Frame) int64 {
+		total := f.SelfWeightNs
+		for _, child := range f.Children {
+			total += add(child)
+		}
+		if f.TotalWeightNs != 0 {
+			total = f.TotalWeightNs
+		}
+		f.addExtraWeight(index, total)
+		return total
+	}
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			for _, f := range th.Frames {
+				add(f)
+			}
+		}
+	}
+	return nil
+}
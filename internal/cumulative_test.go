@@ -0,0 +1,56 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAddCumulativeWeights(t *testing.T) {
+	th := &Thread{Name: "main"}
+	th.AddStack([]string{"main", "foo"}, 3)
+	th.AddStack([]string{"main", "bar", "baz"}, 2)
+	th.AddStack([]string{"main"}, 1)
+	// The input shows a larger total for bar than its callees add up to.
+	bar := th.Frames[0].Children[1]
+	bar.TotalWeightNs = 4
+	p := &TimeProfile{Processes: []*Process{{Name: "p", Threads: []*Thread{th}}}}
+
+	if err := AddCumulativeWeights(p); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p.ExtraValueTypes, []ValueType{CumulativeValueType}) {
+		t.Errorf("Expected a cumulative value type, got %v", p.ExtraValueTypes)
+	}
+	expected := map[*Frame]int64{
+		th.Frames[0]:             8,
+		th.Frames[0].Children[0]: 3,
+		bar:                      4,
+		bar.Children[0]:          2,
+	}
+	for f, total := range expected {
+		if len(f.ExtraWeights) != 1 || f.ExtraWeights[0] != total {
+			t.Errorf("Expected cumulative weight %d for %s, got %v", total, f.SymbolName, f.ExtraWeights)
+		}
+	}
+}
+
+func TestAddCumulativeWeightsRequiresNanoseconds(t *testing.T) {
+	p := &TimeProfile{ValueType: ValueType{Type: "space", Unit: "bytes"}}
+	if err := AddCumulativeWeights(p); err == nil {
+		t.Error("Expected an error for a profile in bytes")
+	}
+}
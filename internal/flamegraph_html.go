@@ -0,0 +1,217 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+)
+
+// flameNode is a frame of the flame graph with its cumulative weight. The
+// short JSON names keep the embedded tree small.
+type flameNode struct {
+	Name     string       `json:"n"`
+	Value    int64        `json:"v"`
+	Children []*flameNode `json:"c,omitempty"`
+}
+
+// add appends child if it has any weight.
+func (n *flameNode) add(child *flameNode) {
+	if child.Value > 0 {
+		n.Children = append(n.Children, child)
+		n.Value += child.Value
+	}
+}
+
+func flameNodeOf(frame *Frame) *flameNode {
+	node := &flameNode{Name: frame.SymbolName, Value: frame.SelfWeightNs}
+	for _, child := range frame.Children {
+		node.add(flameNodeOf(child))
+	}
+	sortFlameNodes(node.Children)
+	return node
+}
+
+// sortFlameNodes orders siblings by name, like flamegraph.pl, so the same
+// stacks line up between graphs.
+func sortFlameNodes(nodes []*flameNode) {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+}
+
+// flameTreeOf returns the call tree of the profile under a root frame, with
+// the process and thread frames the options keep.
+func flameTreeOf(p *TimeProfile, opts ConvertOptions) *flameNode {
+	root := &flameNode{Name: opts.RootFrameName}
+	if root.Name == "" {
+		root.Name = "all"
+	}
+	for _, proc := range p.Processes {
+		procNode := &flameNode{Name: fmt.Sprintf("%s [pid: %d]", proc.Name, proc.Pid)}
+		for _, th := range proc.Threads {
+			thNode := &flameNode{Name: fmt.Sprintf("%s [tid: 0x%x]", th.Name, th.Tid)}
+			for _, frame := range th.Frames {
+				thNode.add(flameNodeOf(frame))
+			}
+			sortFlameNodes(thNode.Children)
+			if opts.OmitSingleThreads && len(proc.Threads) == 1 {
+				for _, child := range thNode.Children {
+					procNode.add(child)
+				}
+			} else {
+				procNode.add(thNode)
+			}
+		}
+		if opts.OmitSingleProcess && len(p.Processes) == 1 {
+			for _, child := range procNode.Children {
+				root.add(child)
+			}
+		} else {
+			root.add(procNode)
+		}
+	}
+	return root
+}
+
+// WriteFlameGraphHTML writes the profile as a standalone HTML page with an
+// interactive flame graph, for viewing without pprof. The page embeds its
+// script and the call tree, so it works offline. Clicking a frame zooms into
+// it, and the search box highlights the frames matching a regular
+// expression. The root frame is named by the options.
+func WriteFlameGraphHTML(w io.Writer, p *TimeProfile, opts ConvertOptions) error {
+	valueType := p.GetValueType()
+	return flameGraphTemplate.Execute(w, struct {
+		Title string
+		Type  string
+		Unit  string
+		Root  *flameNode
+	}{
+		Title: "Flame graph",
+		Type:  valueType.Type,
+		Unit:  valueType.Unit,
+		Root:  flameTreeOf(p, opts),
+	})
+}
+
+// flameGraphTemplate is kept in the source rather than embedded, since the
+// module supports Go versions without go:embed.
+var flameGraphTemplate = template.Must(template.New("flamegraph").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font: 12px sans-serif; margin: 8px; }
+#bar { margin-bottom: 8px; }
+#details { margin-left: 16px; }
+#chart { position: relative; width: 100%; }
+.frame { position: absolute; height: 17px; line-height: 17px; box-sizing: border-box;
+  border: 1px solid #fff; overflow: hidden; white-space: nowrap; text-overflow: ellipsis;
+  padding: 0 2px; cursor: pointer; }
+.frame.match { background: #e070f0 !important; }
+</style>
+</head>
+<body>
+<div id="bar">
+<input id="search" placeholder="Search (regular expression)" size="40">
+<button id="reset">Reset zoom</button>
+<span id="details"></span>
+</div>
+<div id="chart"></div>
+<script>
+"use strict";
+const root = {{.Root}};
+const valueType = {{.Type}};
+const unit = {{.Unit}};
+const rowHeight = 18;
+const chart = document.getElementById("chart");
+const details = document.getElementById("details");
+let zoomed = root;
+let search = null;
+
+function format(value) {
+  if (unit === "nanoseconds") {
+    const scales = [[1e9, "s"], [1e6, "ms"], [1e3, "µs"]];
+    for (const [scale, suffix] of scales) {
+      if (value >= scale) return (value / scale).toFixed(2) + " " + suffix;
+    }
+    return value + " ns";
+  }
+  return value + " " + unit;
+}
+
+function color(name) {
+  let hash = 0;
+  for (let i = 0; i < name.length; i++) hash = (hash * 31 + name.charCodeAt(i)) | 0;
+  const h = Math.abs(hash);
+  return "rgb(" + (205 + h % 50) + "," + (80 + (h >> 8) % 150) + "," + (40 + (h >> 16) % 50) + ")";
+}
+
+function describe(node) {
+  const share = 100 * node.v / root.v;
+  return node.n + " (" + format(node.v) + ", " + share.toFixed(2) + "% of " + valueType + ")";
+}
+
+function draw(node, depth, x, width) {
+  if (width * chart.clientWidth < 1) return depth;
+  const div = document.createElement("div");
+  div.className = "frame";
+  if (search && search.test(node.n)) div.className += " match";
+  div.style.left = (100 * x) + "%";
+  div.style.width = (100 * width) + "%";
+  div.style.top = (depth * rowHeight) + "px";
+  div.style.background = color(node.n);
+  div.textContent = node.n;
+  div.title = describe(node);
+  div.onclick = () => { zoomed = node; render(); };
+  div.onmouseover = () => { details.textContent = describe(node); };
+  chart.appendChild(div);
+  let maxDepth = depth + 1;
+  let childX = x;
+  for (const child of node.c || []) {
+    const childWidth = width * child.v / node.v;
+    maxDepth = Math.max(maxDepth, draw(child, depth + 1, childX, childWidth));
+    childX += childWidth;
+  }
+  return maxDepth;
+}
+
+function render() {
+  chart.textContent = "";
+  if (!zoomed.v) {
+    chart.textContent = "The profile has no samples.";
+    return;
+  }
+  chart.style.height = (draw(zoomed, 0, 0, 1) * rowHeight) + "px";
+}
+
+document.getElementById("reset").onclick = () => { zoomed = root; render(); };
+document.getElementById("search").oninput = (event) => {
+  try {
+    search = event.target.value ? new RegExp(event.target.value) : null;
+  } catch (e) {
+    return;
+  }
+  render();
+};
+window.onresize = render;
+render();
+</script>
+</body>
+</html>
+`))
@@ -0,0 +1,62 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFlameTreeOf(t *testing.T) {
+	p := &TimeProfile{}
+	th := &Thread{Name: "main", Tid: 1}
+	th.AddStack([]string{"start", "run", "parse"}, 6)
+	th.AddStack([]string{"start", "run"}, 3)
+	th.AddStack([]string{"start", "idle"}, 1)
+	th.AddStack([]string{"start", "empty"}, 0)
+	p.Processes = []*Process{{Name: "app", Pid: 7, Threads: []*Thread{th}}}
+
+	expected := `{"n":"all","v":10,"c":[{"n":"app [pid: 7]","v":10,"c":[{"n":"main [tid: 0x1]","v":10,` +
+		`"c":[{"n":"start","v":10,"c":[{"n":"idle","v":1},{"n":"run","v":9,"c":[{"n":"parse","v":6}]}]}]}]}]}`
+	if tree, _ := json.Marshal(flameTreeOf(p, ConvertOptions{})); string(tree) != expected {
+		t.Errorf("Expected %s, got %s", expected, tree)
+	}
+
+	tree := flameTreeOf(p, NewConvertOptions(RootFrameName("capture"), OmitSingleThreads(true), OmitSingleProcess(true)))
+	if tree.Name != "capture" || len(tree.Children) != 1 || tree.Children[0].Name != "start" {
+		t.Errorf("Expected start under capture without process and thread frames, got %+v", tree)
+	}
+}
+
+func TestWriteFlameGraphHTML(t *testing.T) {
+	p := &TimeProfile{}
+	th := &Thread{Name: "main", Tid: 1}
+	th.AddStack([]string{"start", "</script><b>"}, 5)
+	p.Processes = []*Process{{Name: "app", Pid: 7, Threads: []*Thread{th}}}
+	var out strings.Builder
+	if err := WriteFlameGraphHTML(&out, p, ConvertOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	html := out.String()
+	if strings.Contains(html, "</script><b>") {
+		t.Errorf("The symbol name isn't escaped in the script: %s", html)
+	}
+	for _, expected := range []string{`const unit = "nanoseconds"`, `"n":"start"`, `"v":5`} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected %q in the page", expected)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// FrameAction is what a frame rule does to the frames it matches.
+type FrameAction string
+
+const (
+	// FoldAction folds the frame into its parent, see rewriteFrames.
+	FoldAction FrameAction = "fold"
+	// RenameAction replaces the frame's name, expanding $1 style references
+	// to the groups of the pattern.
+	RenameAction FrameAction = "rename"
+	// DropAction removes the frame and all its descendants with their weight.
+	DropAction FrameAction = "drop"
+)
+
+type frameRule struct {
+	action      FrameAction
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// FrameRules are fold, rename and drop operations on frames matched by a
+// regular expression on their name.
+type FrameRules struct {
+	rules []frameRule
+}
+
+// ParseFrameRules parses a rules file. Each line is an action, a regular
+// expression matched against the frame names, and for renames the new name,
+// separated by whitespace, e.g.
+//
+//	fold    ^std::__1::
+//	rename  ^(\w+)\.cold\.\d+$  $1
+//	drop    ^mach_msg_trap$
+//
+// Spaces in a pattern are written as \s. The first rule matching a frame is
+// applied. Empty lines and lines starting with '#' are ignored.
+func ParseFrameRules(r io.Reader) (f FrameRules, err error) {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return f, fmt.Errorf("Frame rule on line %d has no pattern: %s", line, text)
+		}
+		rule := frameRule{action: FrameAction(fields[0])}
+		switch rule.action {
+		case FoldAction, DropAction:
+			if len(fields) != 2 {
+				return f, fmt.Errorf("Frame rule on line %d has too many fields: %s", line, text)
+			}
+		case RenameAction:
+			if len(fields) < 3 {
+				return f, fmt.Errorf("Rename rule on line %d has no new name: %s", line, text)
+			}
+			rule.replacement = strings.Join(fields[2:], " ")
+		default:
+			return f, fmt.Errorf("Unknown action '%s' on line %d, expected fold, rename or drop", fields[0], line)
+		}
+		if rule.pattern, err = regexp.Compile(fields[1]); err != nil {
+			return f, fmt.Errorf("Invalid pattern on line %d: %v", line, err)
+		}
+		f.rules = append(f.rules, rule)
+	}
+	return f, scanner.Err()
+}
+
+// match returns the first rule matching name, or nil.
+func (f FrameRules) match(name string) *frameRule {
+	for i := range f.rules {
+		if f.rules[i].pattern.MatchString(name) {
+			return &f.rules[i]
+		}
+	}
+	return nil
+}
+
+// ApplyFrameRules applies the rules to every frame of the profile.
+func ApplyFrameRules(p *TimeProfile, rules FrameRules) {
+	dropped := make(map[*Frame]bool)
+	rewriteFrames(p, func(f *Frame, parent *Frame) (string, bool) {
+		rule := rules.match(f.SymbolName)
+		if rule == nil {
+			return f.SymbolName, false
+		}
+		switch rule.action {
+		case FoldAction:
+			return f.SymbolName, true
+		case RenameAction:
+			return rule.pattern.ReplaceAllString(f.SymbolName, rule.replacement), false
+		case DropAction:
+			dropped[f] = true
+		}
+		return f.SymbolName, false
+	})
+	if len(dropped) == 0 {
+		return
+	}
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			th.Frames = dropFrames(th.Frames, dropped)
+		}
+	}
+}
+
+func dropFrames(frames []*Frame, dropped map[*Frame]bool) []*Frame {
+	result := make([]*Frame, 0, len(frames))
+	for _, f := range frames {
+		if dropped[f] {
+			continue
+		}
+		f.Children = dropFrames(f.Children, dropped)
+		result = append(result, f)
+	}
+	return result
+}
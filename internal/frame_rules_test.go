@@ -0,0 +1,59 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyFrameRules(t *testing.T) {
+	rules, err := ParseFrameRules(strings.NewReader(`
+# Comment
+drop    ^mach_msg_trap$
+fold    ^std::__1::
+rename  ^(\w+)\.cold\.\d+$  $1 (cold)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := makeStacks(
+		[]string{"main", "run", "std::__1::function::operator()", "work.cold.1"},
+		[]string{"main", "run", "work"},
+		[]string{"main", "wait", "mach_msg_trap", "ipc"},
+	)
+	ApplyFrameRules(got, rules)
+	expected := makeStacks(
+		[]string{"main", "run", "work (cold)"},
+		[]string{"main", "run", "work"},
+	)
+	// The frame above the dropped subtree is kept without weight.
+	expected.Processes[0].Threads[0].AddStack([]string{"main", "wait"}, 0)
+	TimeProfileEquals(t, got, expected)
+}
+
+func TestInvalidFrameRules(t *testing.T) {
+	for _, rules := range []string{
+		"fold\n",
+		"squash ^foo$\n",
+		"rename ^foo$\n",
+		"drop ^foo$ bar\n",
+		"fold ^(foo$\n",
+	} {
+		if _, err := ParseFrameRules(strings.NewReader(rules)); err == nil {
+			t.Errorf("Expected an error parsing %q", rules)
+		}
+	}
+}
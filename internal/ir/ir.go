@@ -0,0 +1,318 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ir reads and writes TimeProfiles as versioned JSON documents, the
+// intermediate representation other tools can persist and produce.
+//
+// The schema of version 4 is,
+//
+//	{
+//	  "version": 4,
+//	  "valueType": {"type": "cpu", "unit": "nanoseconds"},
+//	  "extraValueTypes": [{"type": "blocked", "unit": "nanoseconds"}],
+//	  "comments": ["Filtered by Instruments"],
+//	  "processes": [{
+//	    "name": "Sandwich", "pid": 1234,
+//	    "threads": [{
+//	      "name": "Main Thread", "tid": 5960, "labels": {"crashed": "false"},
+//	      "frames": [{
+//	        "name": "main", "binary": "Sandwich", "offset": 10, "selfWeight": 0, "totalWeight": 5,
+//	        "extraWeights": [0],
+//	        "labels": {}, "numLabels": {},
+//	        "children": [...]
+//	      }]
+//	    }]
+//	  }]
+//	}
+//
+// Only "version" and "processes" are required, as well as the names of
+// processes, threads and frames. Unknown fields are rejected.
+package ir
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// Version is the version of the schema written by Write.
+const Version = 4
+
+// migrations upgrade a document of version i to version i+1. Documents are
+// migrated as generic JSON, before they are decoded with the current schema.
+var migrations = map[int]func(doc map[string]interface{}) error{
+	// Version 2 added the optional binary of frames.
+	1: func(doc map[string]interface{}) error { return nil },
+	// Version 3 added the optional offset of frames.
+	2: func(doc map[string]interface{}) error { return nil },
+	// Version 4 added the optional total weight of frames and the comments
+	// of profiles.
+	3: func(doc map[string]interface{}) error { return nil },
+}
+
+type valueType struct {
+	Type string `json:"type"`
+	Unit string `json:"unit"`
+}
+
+type frame struct {
+	Name         string            `json:"name"`
+	Binary       string            `json:"binary,omitempty"`
+	Offset       uint64            `json:"offset,omitempty"`
+	SelfWeight   int64             `json:"selfWeight"`
+	TotalWeight  int64             `json:"totalWeight,omitempty"`
+	ExtraWeights []int64           `json:"extraWeights,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	NumLabels    map[string]int64  `json:"numLabels,omitempty"`
+	Children     []*frame          `json:"children,omitempty"`
+}
+
+type thread struct {
+	Name   string            `json:"name"`
+	Tid    uint64            `json:"tid"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Frames []*frame          `json:"frames"`
+}
+
+type process struct {
+	Name    string    `json:"name"`
+	Pid     uint64    `json:"pid"`
+	Threads []*thread `json:"threads"`
+}
+
+type document struct {
+	Version         int          `json:"version"`
+	ValueType       *valueType   `json:"valueType,omitempty"`
+	ExtraValueTypes []*valueType `json:"extraValueTypes,omitempty"`
+	Comments        []string     `json:"comments,omitempty"`
+	Processes       []*process   `json:"processes"`
+}
+
+func fromFrame(f *internal.Frame) *frame {
+	result := &frame{
+		Name:         f.SymbolName,
+		Binary:       f.Binary,
+		Offset:       f.Offset,
+		SelfWeight:   f.SelfWeightNs,
+		TotalWeight:  f.TotalWeightNs,
+		ExtraWeights: f.ExtraWeights,
+		Labels:       f.Labels,
+		NumLabels:    f.NumLabels,
+	}
+	for _, child := range f.Children {
+		result.Children = append(result.Children, fromFrame(child))
+	}
+	return result
+}
+
+// Write writes the profile as a document of the current version.
+func Write(w io.Writer, p *internal.TimeProfile) error {
+	vt := p.GetValueType()
+	doc := &document{
+		Version:   Version,
+		ValueType: &valueType{Type: vt.Type, Unit: vt.Unit},
+		Comments:  p.Comments,
+		Processes: make([]*process, 0, len(p.Processes)),
+	}
+	for _, extra := range p.ExtraValueTypes {
+		doc.ExtraValueTypes = append(doc.ExtraValueTypes, &valueType{Type: extra.Type, Unit: extra.Unit})
+	}
+	for _, proc := range p.Processes {
+		pr := &process{Name: proc.Name, Pid: proc.Pid, Threads: make([]*thread, 0, len(proc.Threads))}
+		for _, th := range proc.Threads {
+			t := &thread{Name: th.Name, Tid: th.Tid, Labels: th.Labels, Frames: make([]*frame, 0, len(th.Frames))}
+			for _, f := range th.Frames {
+				t.Frames = append(t.Frames, fromFrame(f))
+			}
+			pr.Threads = append(pr.Threads, t)
+		}
+		doc.Processes = append(doc.Processes, pr)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
+
+// migrate upgrades a generic document to the current version.
+func migrate(content []byte) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, fmt.Errorf("Could not decode IR: %v", err)
+	}
+	number, ok := doc["version"].(float64)
+	if !ok {
+		return nil, errors.New("IR has no version")
+	}
+	version := int(number)
+	if version > Version {
+		return nil, fmt.Errorf("IR version %d is newer than the supported version %d, update instrumentsToPprof", version, Version)
+	}
+	if version < 1 {
+		return nil, fmt.Errorf("Invalid IR version %d", version)
+	}
+	if version == Version {
+		return content, nil
+	}
+	for ; version < Version; version++ {
+		migration, ok := migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("No migration from IR version %d", version)
+		}
+		if err := migration(doc); err != nil {
+			return nil, fmt.Errorf("Could not migrate IR from version %d: %v", version, err)
+		}
+	}
+	doc["version"] = Version
+	return json.Marshal(doc)
+}
+
+func (f *frame) validate(path string) error {
+	if f.Name == "" {
+		return fmt.Errorf("Frame without name in %s", path)
+	}
+	for _, child := range f.Children {
+		if err := child.validate(path + ";" + f.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validate checks the constraints of the schema that decoding doesn't.
+func (d *document) validate() error {
+	if d.Processes == nil {
+		return errors.New("IR has no processes")
+	}
+	for _, vt := range append([]*valueType{d.ValueType}, d.ExtraValueTypes...) {
+		if vt != nil && (vt.Type == "" || vt.Unit == "") {
+			return fmt.Errorf("IR value type %v needs a type and a unit", *vt)
+		}
+	}
+	for _, proc := range d.Processes {
+		if proc.Name == "" {
+			return fmt.Errorf("Process %d has no name", proc.Pid)
+		}
+		for _, th := range proc.Threads {
+			if th.Name == "" {
+				return fmt.Errorf("Thread %d of %s has no name", th.Tid, proc.Name)
+			}
+			for _, f := range th.Frames {
+				if err := f.validate(proc.Name + ";" + th.Name); err != nil {
+					return err
+				}
+				if err := f.checkExtraWeights(len(d.ExtraValueTypes)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (f *frame) checkExtraWeights(count int) error {
+	if len(f.ExtraWeights) > count {
+		return fmt.Errorf("Frame %s has %d extra weights but there are %d extra value types",
+			f.Name, len(f.ExtraWeights), count)
+	}
+	for _, child := range f.Children {
+		if err := child.checkExtraWeights(count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *frame) toFrame(parent *internal.Frame, depth int) *internal.Frame {
+	result := &internal.Frame{
+		Parent:        parent,
+		Children:      make([]*internal.Frame, 0, len(f.Children)),
+		SelfWeightNs:  f.SelfWeight,
+		TotalWeightNs: f.TotalWeight,
+		SymbolName:    f.Name,
+		Binary:        f.Binary,
+		Offset:        f.Offset,
+		Depth:         depth,
+		Labels:        f.Labels,
+		NumLabels:     f.NumLabels,
+		ExtraWeights:  f.ExtraWeights,
+	}
+	for _, child := range f.Children {
+		result.Children = append(result.Children, child.toFrame(result, depth+1))
+	}
+	return result
+}
+
+// Read reads a document, migrating it from older versions.
+func Read(r io.Reader) (*internal.TimeProfile, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if content, err = migrate(content); err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	var doc document
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("IR does not match the schema of version %d: %v", Version, err)
+	}
+	if err := doc.validate(); err != nil {
+		return nil, err
+	}
+	p := &internal.TimeProfile{Comments: doc.Comments, Processes: make([]*internal.Process, 0, len(doc.Processes))}
+	if doc.ValueType != nil {
+		p.ValueType = internal.ValueType{Type: doc.ValueType.Type, Unit: doc.ValueType.Unit}
+	}
+	for _, extra := range doc.ExtraValueTypes {
+		p.ExtraValueTypes = append(p.ExtraValueTypes, internal.ValueType{Type: extra.Type, Unit: extra.Unit})
+	}
+	for _, proc := range doc.Processes {
+		pr := &internal.Process{Name: proc.Name, Pid: proc.Pid, Threads: make([]*internal.Thread, 0, len(proc.Threads))}
+		for _, th := range proc.Threads {
+			t := &internal.Thread{Name: th.Name, Tid: th.Tid, Labels: th.Labels, Frames: make([]*internal.Frame, 0, len(th.Frames))}
+			for _, f := range th.Frames {
+				t.Frames = append(t.Frames, f.toFrame(nil, 1))
+			}
+			pr.Threads = append(pr.Threads, t)
+		}
+		p.Processes = append(p.Processes, pr)
+	}
+	return p, nil
+}
+
+// IRParser parses IR documents.
+type IRParser struct {
+	content []byte
+}
+
+func MakeIRParser(file io.Reader) (p IRParser, err error) {
+	p.content, err = ioutil.ReadAll(file)
+	return p, err
+}
+
+// Capabilities of IR documents aren't limited by the format, since any
+// profile can be written as IR.
+func (i IRParser) Capabilities() internal.Capabilities {
+	return internal.AllCapabilities
+}
+
+func (i IRParser) ParseProfile() (*internal.TimeProfile, error) {
+	return Read(bytes.NewReader(i.content))
+}
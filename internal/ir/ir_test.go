@@ -0,0 +1,113 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ir
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+func TestRoundTrip(t *testing.T) {
+	thread := &internal.Thread{Name: "Main Thread", Tid: 5960, Labels: map[string]string{"crashed": "true"}}
+	thread.AddStack([]string{"main", "eat"}, 3)
+	thread.AddStack([]string{"main", "cook"}, 2)
+	main := thread.Frames[0]
+	main.TotalWeightNs = 5
+	main.Children[0].TotalWeightNs = 3
+	main.Children[1].TotalWeightNs = 2
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{{Name: "Sandwich", Pid: 1234, Threads: []*internal.Thread{thread}}},
+		ValueType: internal.ValueType{Type: "samples", Unit: "count"},
+		Comments:  []string{"Filtered by Instruments"},
+	}
+	var buf bytes.Buffer
+	if err := Write(&buf, expected); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	internal.TimeProfileEquals(t, got, expected)
+	if got.ValueType != expected.ValueType {
+		t.Errorf("Expected value type %v, got %v", expected.ValueType, got.ValueType)
+	}
+	if got.Processes[0].Threads[0].Labels["crashed"] != "true" {
+		t.Errorf("Thread labels were lost: %v", got.Processes[0].Threads[0].Labels)
+	}
+	if !reflect.DeepEqual(got.Comments, expected.Comments) {
+		t.Errorf("Expected comments %v, got %v", expected.Comments, got.Comments)
+	}
+	gotMain := got.Processes[0].Threads[0].Frames[0]
+	gotFrames := append([]*internal.Frame{gotMain}, gotMain.Children...)
+	for i, f := range append([]*internal.Frame{main}, main.Children...) {
+		if gotFrames[i].TotalWeightNs != f.TotalWeightNs {
+			t.Errorf("Expected the total weight %d of %s, got %d", f.TotalWeightNs, f.SymbolName, gotFrames[i].TotalWeightNs)
+		}
+	}
+}
+
+func TestInvalidDocuments(t *testing.T) {
+	cases := map[string]string{
+		"no version":     `{"processes": []}`,
+		"newer version":  `{"version": 99, "processes": []}`,
+		"unknown field":  `{"version": 2, "processes": [], "samples": []}`,
+		"no processes":   `{"version": 2}`,
+		"unnamed frame":  `{"version": 1, "processes": [{"name": "p", "threads": [{"name": "t", "frames": [{"selfWeight": 1}]}]}]}`,
+		"extra weights":  `{"version": 1, "processes": [{"name": "p", "threads": [{"name": "t", "frames": [{"name": "f", "extraWeights": [1]}]}]}]}`,
+		"wrong type":     `{"version": 1, "processes": [{"name": "p", "pid": "one"}]}`,
+		"untyped values": `{"version": 1, "valueType": {"type": "cpu"}, "processes": []}`,
+	}
+	for name, doc := range cases {
+		if _, err := Read(strings.NewReader(doc)); err == nil {
+			t.Errorf("%s: expected an error reading %s", name, doc)
+		}
+	}
+}
+
+func TestMigrateVersion1(t *testing.T) {
+	const doc = `{"version": 1, "processes": [{"name": "p", "threads": [{"name": "t", "frames": [{"name": "f", "selfWeight": 1}]}]}]}`
+	got, err := Read(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := got.Processes[0].Threads[0].Frames[0]; f.SymbolName != "f" || f.Binary != "" {
+		t.Errorf("Unexpected frame %v", f)
+	}
+}
+
+func TestOffsetRoundTrip(t *testing.T) {
+	const doc = `{"version": 2, "processes": [{"name": "p", "threads": [{"name": "t", "frames": [{"name": "f", "selfWeight": 1}]}]}]}`
+	p, err := Read(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Processes[0].Threads[0].Frames[0].Offset = 10
+	var buf bytes.Buffer
+	if err := Write(&buf, p); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := got.Processes[0].Threads[0].Frames[0]; f.Offset != 10 {
+		t.Errorf("Expected offset 10, got %v", f)
+	}
+}
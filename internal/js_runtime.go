@@ -0,0 +1,65 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "regexp"
+
+// RuntimeLabel is the label holding the runtime a sample ran in, "js" for
+// samples below a JavaScript engine's interpreter or JIT frames.
+const RuntimeLabel = "runtime"
+
+var (
+	// jsInterpreterRe matches the interpreter frames of V8 and
+	// JavaScriptCore, e.g. "Builtins_InterpreterEntryTrampoline" or
+	// "llint_op_call".
+	jsInterpreterRe = regexp.MustCompile(
+		`^(?:Builtins_Interpreter\w*|llint_\w*|JSC::LLInt::.*|JSC::Interpreter::.*)$`)
+	// jsFrameRe matches the other frames running JavaScript: the entry
+	// trampolines, builtins and the JIT tiers.
+	jsFrameRe = regexp.MustCompile(
+		`^(?:Builtins_\w*|v8::internal::Execution::.*|vmEntryTo(?:JavaScript|Native)\w*|` +
+			`js_trampoline_\w*|JSC::(?:JIT|DFG|FTL|Baseline)\w*::.*|operation\w+)$`)
+)
+
+func isJSFrame(name string) bool {
+	return jsInterpreterRe.MatchString(name) || jsFrameRe.MatchString(name)
+}
+
+// AddJSRuntimeLabels labels the samples whose stack contains a V8 or
+// JavaScriptCore interpreter or JIT frame with runtime=js, so profiles of apps
+// embedding a JavaScript engine can be split into JavaScript and native time.
+func AddJSRuntimeLabels(p *TimeProfile) {
+	inJS := make(map[*Frame]bool)
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		inJS[f] = isJSFrame(f.SymbolName) || (f.Parent != nil && inJS[f.Parent])
+		if inJS[f] {
+			if f.Labels == nil {
+				f.Labels = make(map[string]string)
+			}
+			f.Labels[RuntimeLabel] = "js"
+		}
+	})
+}
+
+// FoldJSInterpreterFrames folds runs of consecutive interpreter frames into
+// the outermost one. Every JavaScript call adds interpreter frames, which
+// bury the native frames under noise.
+func FoldJSInterpreterFrames(p *TimeProfile) {
+	rewriteFrames(p, func(f *Frame, parent *Frame) (string, bool) {
+		fold := parent != nil && jsInterpreterRe.MatchString(f.SymbolName) &&
+			jsInterpreterRe.MatchString(parent.SymbolName)
+		return f.SymbolName, fold
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestJSRuntimeLabels(t *testing.T) {
+	p := makeStacks(
+		[]string{"main", "Builtins_JSEntry", "Builtins_InterpreterEntryTrampoline", "malloc"},
+		[]string{"main", "vmEntryToJavaScript", "llint_op_call"},
+		[]string{"main", "free"},
+	)
+	AddJSRuntimeLabels(p)
+	labels := make(map[string]string)
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		labels[f.SymbolName] = f.Labels[RuntimeLabel]
+	})
+	expected := map[string]string{
+		"main":                                "",
+		"Builtins_JSEntry":                    "js",
+		"Builtins_InterpreterEntryTrampoline": "js",
+		"malloc":                              "js",
+		"llint_op_call":                       "js",
+		"free":                                "",
+	}
+	for name, runtime := range expected {
+		if labels[name] != runtime {
+			t.Errorf("%s had runtime '%s', expected '%s'", name, labels[name], runtime)
+		}
+	}
+}
+
+func TestFoldJSInterpreterFrames(t *testing.T) {
+	got := makeStacks(
+		[]string{
+			"main",
+			"Builtins_InterpreterEntryTrampoline",
+			"Builtins_InterpreterEntryTrampoline",
+			"Builtins_InterpreterEntryTrampoline",
+			"Builtins_CallFunction",
+			"Builtins_InterpreterEntryTrampoline",
+			"malloc",
+		},
+		[]string{"main", "llint_entry", "llint_op_call"},
+	)
+	FoldJSInterpreterFrames(got)
+	expected := makeStacks(
+		[]string{
+			"main",
+			"Builtins_InterpreterEntryTrampoline",
+			"Builtins_CallFunction",
+			"Builtins_InterpreterEntryTrampoline",
+			"malloc",
+		},
+		[]string{"main", "llint_entry"},
+	)
+	TimeProfileEquals(t, got, expected)
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "regexp"
+
+// RowLabel is the numeric label holding the input line of a sample's frame.
+const RowLabel = "row"
+
+// RootFrameLabel is the label holding the outermost application frame of a
+// sample's stack.
+const RootFrameLabel = "root_frame"
+
+// walkFrames calls fn for every frame of the profile, parents before children.
+func walkFrames(p *TimeProfile, fn func(proc *Process, th *Thread, f *Frame)) {
+	var walk func(proc *Process, th *Thread, f *Frame)
+	walk = func(proc *Process, th *Thread, f *Frame) {
+		fn(proc, th, f)
+		for _, child := range f.Children {
+			walk(proc, th, child)
+		}
+	}
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			for _, f := range th.Frames {
+				walk(proc, th, f)
+			}
+		}
+	}
+}
+
+func (f *Frame) setNumLabel(key string, value int64) {
+	if f.NumLabels == nil {
+		f.NumLabels = make(map[string]int64)
+	}
+	f.NumLabels[key] = value
+}
+
+// AddRowLabels labels every frame with the input line it was parsed from, so
+// samples can be matched against the source table when debugging conversions.
+// Frames without a position are left unlabeled.
+func AddRowLabels(p *TimeProfile) {
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		if f.Position.IsValid() {
+			f.setNumLabel(RowLabel, int64(f.Position.Line))
+		}
+	})
+}
+
+// threadEntryRe matches the frames the system starts threads with, which are
+// not the entry point of the application's code.
+var threadEntryRe = regexp.MustCompile(
+	`^(?:thread_start|_pthread_start|start_wqthread|_pthread_wqthread|__NSThread__start__)(?: \+ \d+)?(?: \(in .*\))?$`)
+
+// AddRootFrameLabels labels every frame with the outermost frame of its stack
+// that is not a process or thread bootstrap frame, e.g. the run loop or the
+// function a worker thread runs, so pprof can group samples by entry point.
+func AddRootFrameLabels(p *TimeProfile) {
+	roots := make(map[*Frame]string)
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		if f.Parent != nil && roots[f.Parent] != "" {
+			roots[f] = roots[f.Parent]
+		} else if !dyldStartRe.MatchString(f.SymbolName) && !threadEntryRe.MatchString(f.SymbolName) {
+			roots[f] = f.SymbolName
+		}
+		if roots[f] != "" {
+			if f.Labels == nil {
+				f.Labels = make(map[string]string)
+			}
+			f.Labels[RootFrameLabel] = roots[f]
+		}
+	})
+}
@@ -0,0 +1,63 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+// PruneBelow folds the frames whose weight with their callees is below
+// minWeight into their callers, which get their weight, so long captures
+// keep their totals without thousands of tiny subtrees. The outermost frames
+// of each thread are kept. Samples of the timeline move to the nearest
+// remaining caller of their frame.
+func PruneBelow(p *TimeProfile, minWeight int64) {
+	totals := make(map[*Frame]int64)
+	var total func(f *Frame) int64
+	total = func(f *Frame) int64 {
+		weight := f.SelfWeightNs
+		for _, child := range f.Children {
+			weight += total(child)
+		}
+		totals[f] = weight
+		return weight
+	}
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			for _, f := range th.Frames {
+				total(f)
+			}
+		}
+	}
+	folded := make(map[*Frame]bool)
+	rewriteFrames(p, func(f *Frame, parent *Frame) (string, bool) {
+		if parent != nil && totals[f] < minWeight {
+			folded[f] = true
+			return f.SymbolName, true
+		}
+		return f.SymbolName, false
+	})
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			for i := range th.Timeline {
+				for folded[th.Timeline[i].Frame] {
+					th.Timeline[i].Frame = th.Timeline[i].Frame.Parent
+				}
+			}
+		}
+	}
+}
+
+// MinPercentWeight returns the weight that is the given percentage of the
+// profile's total weight.
+func MinPercentWeight(p *TimeProfile, percent float64) int64 {
+	return int64(float64(p.totals()[0]) * percent / 100)
+}
@@ -0,0 +1,48 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestPruneBelow(t *testing.T) {
+	got := makeStacks(
+		[]string{"main", "big", "leaf"},
+		[]string{"main", "small", "tiny"},
+		[]string{"other"},
+	)
+	th := got.Processes[0].Threads[0]
+	th.Frames[0].Children[0].Children[0].SelfWeightNs = 10
+	th.Timeline = []TimedSample{{Time: 1, Frame: th.Frames[0].Children[1].Children[0], Weight: 1}}
+	PruneBelow(got, 2)
+
+	expected := makeStacks([]string{"main", "big", "leaf"}, []string{"other"})
+	expected.Processes[0].Threads[0].Frames[0].Children[0].Children[0].SelfWeightNs = 10
+	expected.Processes[0].Threads[0].Frames[0].SelfWeightNs = 1
+	TimeProfileEquals(t, got, expected)
+	if frame := th.Timeline[0].Frame; frame != th.Frames[0] {
+		t.Errorf("Expected the timeline sample moved to main, got %v", frame)
+	}
+	if totals := got.totals(); totals[0] != 12 {
+		t.Errorf("Expected the total weight kept, got %d", totals[0])
+	}
+}
+
+func TestMinPercentWeight(t *testing.T) {
+	p := makeStacks([]string{"main", "a"}, []string{"main", "b"})
+	p.Processes[0].Threads[0].Frames[0].Children[0].SelfWeightNs = 999
+	if got := MinPercentWeight(p, 10); got != 100 {
+		t.Errorf("Expected 10%% of 1000, got %d", got)
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "regexp"
+
+// frameRewriter returns the new name of a frame and whether it should be
+// folded into its parent. parent is the frame's parent after rewriting, or nil
+// for the outermost frames of a thread.
+type frameRewriter func(f *Frame, parent *Frame) (name string, fold bool)
+
+// rewriteFrames renames and folds the frames of every thread. A folded frame
+// gives its self weight to its parent and its children become children of the
+// parent. Siblings that end up with the same name are merged.
+func rewriteFrames(p *TimeProfile, rewrite frameRewriter) {
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			if len(th.Frames) == 0 {
+				continue
+			}
+			th.Frames = rewriteChildren(nil, th.Frames, th.Frames[0].Depth, rewrite)
+		}
+	}
+}
+
+func rewriteChildren(parent *Frame, frames []*Frame, depth int, rewrite frameRewriter) []*Frame {
+	result := make([]*Frame, 0, len(frames))
+	for _, f := range frames {
+		name, fold := rewrite(f, parent)
+		f.SymbolName = name
+		if fold && parent != nil {
+			parent.addWeights(f)
+			result = append(result, rewriteChildren(parent, f.Children, depth, rewrite)...)
+			continue
+		}
+		f.Parent = parent
+		f.Depth = depth
+		f.Children = rewriteChildren(f, f.Children, depth+1, rewrite)
+		result = append(result, f)
+	}
+	return mergeSiblings(result)
+}
+
+// siblingKey identifies the frames mergeSiblings merges. Frames at
+// different offsets of a function stay apart.
+type siblingKey struct {
+	name   string
+	offset uint64
+}
+
+// mergeSiblings merges frames with the same name and offset, keeping the
+// first one.
+func mergeSiblings(frames []*Frame) []*Frame {
+	byName := make(map[siblingKey]*Frame, len(frames))
+	result := make([]*Frame, 0, len(frames))
+	merged := false
+	for _, f := range frames {
+		key := siblingKey{f.SymbolName, f.Offset}
+		first, ok := byName[key]
+		if !ok {
+			byName[key] = f
+			result = append(result, f)
+			continue
+		}
+		first.addWeights(f)
+		for _, child := range f.Children {
+			child.Parent = first
+		}
+		first.Children = append(first.Children, f.Children...)
+		merged = true
+	}
+	if merged {
+		for _, f := range result {
+			f.Children = mergeSiblings(f.Children)
+		}
+	}
+	return result
+}
+
+// CanonicalStartFrame is the name given to the dyld bootstrap frames.
+const CanonicalStartFrame = "start"
+
+// dyldStartRe matches the names of the frames dyld uses to start a process,
+// which differ between macOS versions, e.g. "_dyld_start",
+// "dyldbootstrap::start(...)" or "start (in /usr/lib/dyld)".
+var dyldStartRe = regexp.MustCompile(
+	`^(?:_dyld_start|start|dyldbootstrap::start\(.*\)|dyld\d*::start\(.*\)|dyld::_main\(.*\)|(?:.*/)?dyld)` +
+		`(?: \+ \d+)?(?: \(in (?:.*/)?(?:dyld|libdyld\.dylib)\))?$`)
+
+// CanonicalizeStartFrames renames the dyld bootstrap frames at the start of
+// each stack to "start", folding chains of them into one frame, so that the
+// stacks of different processes line up when profiles are merged.
+func CanonicalizeStartFrames(p *TimeProfile) {
+	rewriteFrames(p, func(f *Frame, parent *Frame) (string, bool) {
+		// Only the outermost frames are bootstrap frames, a "start" deeper in
+		// the stack is application code.
+		if parent != nil && parent.SymbolName != CanonicalStartFrame {
+			return f.SymbolName, false
+		}
+		if !dyldStartRe.MatchString(f.SymbolName) {
+			return f.SymbolName, false
+		}
+		return CanonicalStartFrame, parent != nil
+	})
+}
+
+var (
+	// swiftPrefixRes match the parts of generated Swift symbols in front of
+	// the function they were generated for, e.g.
+	// "partial apply for closure #1 in ContentView.body.getter".
+	swiftPrefixRes = []*regexp.Regexp{
+		regexp.MustCompile(`^partial apply (?:forwarder )?for `),
+		regexp.MustCompile(`^\(\d+\) (?:suspend|await) resume partial function for `),
+		regexp.MustCompile(`^(?:implicit )?closure #\d+ (?:\(.*?\) )?in `),
+		regexp.MustCompile(`^(?:dispatch thunk of|protocol witness for|specialized|merged|@objc) `),
+	}
+	// swiftConformanceRe matches the suffix of protocol witnesses.
+	swiftConformanceRe = regexp.MustCompile(` in conformance .*$`)
+	// swiftThunkRe matches thunks that don't name the function they call.
+	swiftThunkRe = regexp.MustCompile(`^(?:reabstraction thunk(?: helper)?|thunk) (?:for|from) `)
+)
+
+// normalizeSwiftSymbol returns the function a generated Swift symbol belongs
+// to, and whether the symbol is a thunk without a function of its own.
+func normalizeSwiftSymbol(name string) (string, bool) {
+	for {
+		stripped := name
+		for _, re := range swiftPrefixRes {
+			stripped = re.ReplaceAllString(stripped, "")
+		}
+		if stripped == name {
+			break
+		}
+		name = swiftConformanceRe.ReplaceAllString(stripped, "")
+	}
+	return name, swiftThunkRe.MatchString(name)
+}
+
+// NormalizeSwiftSymbols folds the closures, thunks and async partial functions
+// the Swift compiler generates into the function they belong to, so e.g.
+// "closure #1 in ContentView.body.getter" is shown as part of
+// "ContentView.body.getter".
+func NormalizeSwiftSymbols(p *TimeProfile) {
+	rewriteFrames(p, func(f *Frame, parent *Frame) (string, bool) {
+		name, thunk := normalizeSwiftSymbol(f.SymbolName)
+		if parent == nil {
+			return name, false
+		}
+		return name, thunk || parent.SymbolName == name
+	})
+}
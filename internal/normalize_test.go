@@ -0,0 +1,72 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+// makeStacks builds a single thread profile from stacks given from the
+// outermost frame, each with a self weight of 1 on its last frame.
+func makeStacks(stacks ...[]string) *TimeProfile {
+	thread := &Thread{Name: "thread", Tid: 1}
+	for _, stack := range stacks {
+		thread.AddStack(stack, 1)
+	}
+	return &TimeProfile{
+		Processes: []*Process{{Name: "proc", Pid: 1, Threads: []*Thread{thread}}},
+	}
+}
+
+func TestCanonicalizeStartFrames(t *testing.T) {
+	got := makeStacks(
+		[]string{"_dyld_start", "dyldbootstrap::start(dyld3::MachOLoaded const*)", "main", "foo"},
+		[]string{"start", "main", "foo"},
+		[]string{"start (in /System/Volumes/Preboot/Cryptexes/OS/usr/lib/dyld)", "main", "start"},
+	)
+	CanonicalizeStartFrames(got)
+	expected := makeStacks(
+		[]string{"start", "main", "foo"},
+		[]string{"start", "main", "foo"},
+		[]string{"start", "main", "start"},
+	)
+	TimeProfileEquals(t, got, expected)
+	foo := got.Processes[0].Threads[0].Frames[0].Children[0].Children[0]
+	if foo.Parent.SymbolName != "main" || foo.Parent.Parent.SymbolName != "start" {
+		t.Errorf("Parents were not fixed up: %v", foo)
+	}
+}
+
+func TestNormalizeSwiftSymbols(t *testing.T) {
+	got := makeStacks(
+		[]string{
+			"main",
+			"ContentView.body.getter",
+			"closure #1 in ContentView.body.getter",
+			"partial apply for closure #2 (Swift.Int) -> () in closure #1 in ContentView.body.getter",
+			"thunk for @escaping @callee_guaranteed () -> ()",
+			"Model.load()",
+			"(1) suspend resume partial function for Model.load()",
+		},
+		[]string{
+			"main",
+			"protocol witness for View.body.getter in conformance ContentView",
+		},
+	)
+	NormalizeSwiftSymbols(got)
+	expected := makeStacks(
+		[]string{"main", "ContentView.body.getter", "Model.load()"},
+		[]string{"main", "View.body.getter"},
+	)
+	TimeProfileEquals(t, got, expected)
+}
@@ -0,0 +1,61 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// symbolOffsetRe matches the instruction offset at the end of a frame name,
+// e.g. "foo + 123" or "start  (in libdyld.dylib) + 1  [0x7fff2037a6f1]",
+// including the address sample prints after it.
+var symbolOffsetRe = regexp.MustCompile(`^(.*?)\s+\+\s+(0x[0-9a-fA-F]+|\d+)(?:\s+\[0x[0-9a-fA-F]+\])?\s*$`)
+
+// splitSymbolOffset returns the name without its instruction offset and the
+// offset, or ok false if the name has no offset.
+func splitSymbolOffset(name string) (symbol string, offset uint64, ok bool) {
+	matches := symbolOffsetRe.FindStringSubmatch(name)
+	if matches == nil {
+		return name, 0, false
+	}
+	offset, err := strconv.ParseUint(matches[2], 0, 64)
+	if err != nil {
+		return name, 0, false
+	}
+	return strings.TrimSpace(matches[1]), offset, true
+}
+
+// StripSymbolOffsets removes the instruction offsets like " + 123" from the
+// frame names. With merge, the frames of a caller that only differed by
+// their offsets are merged, so a function shows up once per stack. Otherwise
+// the offsets are kept in the frames' Offset and the frames stay apart,
+// becoming locations of the same function at different addresses in pprof.
+func StripSymbolOffsets(p *TimeProfile, merge bool) {
+	if merge {
+		rewriteFrames(p, func(f *Frame, _ *Frame) (string, bool) {
+			name, _, _ := splitSymbolOffset(f.SymbolName)
+			return name, false
+		})
+		return
+	}
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		if name, offset, ok := splitSymbolOffset(f.SymbolName); ok {
+			f.SymbolName = name
+			f.Offset = offset
+		}
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestSplitSymbolOffset(t *testing.T) {
+	for _, test := range []struct {
+		name, symbol string
+		offset       uint64
+		ok           bool
+	}{
+		{name: "foo + 123", symbol: "foo", offset: 123, ok: true},
+		{name: "foo + 0x1f", symbol: "foo", offset: 31, ok: true},
+		{name: "start  (in libdyld.dylib) + 1  [0x7fff2037a6f1]", symbol: "start  (in libdyld.dylib)", offset: 1, ok: true},
+		{name: "operator+(int, int)", symbol: "operator+(int, int)"},
+		{name: "foo", symbol: "foo"},
+	} {
+		symbol, offset, ok := splitSymbolOffset(test.name)
+		if symbol != test.symbol || offset != test.offset || ok != test.ok {
+			t.Errorf("splitSymbolOffset(%q) = %q, %d, %v, expected %q, %d, %v",
+				test.name, symbol, offset, ok, test.symbol, test.offset, test.ok)
+		}
+	}
+}
+
+func TestStripSymbolOffsets(t *testing.T) {
+	stacks := [][]string{{"main + 1", "foo + 10"}, {"main + 1", "foo + 20"}, {"main + 1", "foo + 10"}}
+
+	merged := makeStacks(stacks...)
+	StripSymbolOffsets(merged, true)
+	main := merged.Processes[0].Threads[0].Frames[0]
+	if main.SymbolName != "main" || len(main.Children) != 1 {
+		t.Fatalf("Expected one foo under main, got %v", main.Children)
+	}
+	if foo := main.Children[0]; foo.SymbolName != "foo" || foo.SelfWeightNs != 3 || foo.Offset != 0 {
+		t.Errorf("Expected foo to have all the weight, got %v", foo)
+	}
+
+	apart := makeStacks(stacks...)
+	StripSymbolOffsets(apart, false)
+	main = apart.Processes[0].Threads[0].Frames[0]
+	if main.SymbolName != "main" || main.Offset != 1 || len(main.Children) != 2 {
+		t.Fatalf("Expected two foos under main, got %v", main.Children)
+	}
+	for i, expected := range []uint64{10, 20} {
+		if foo := main.Children[i]; foo.SymbolName != "foo" || foo.Offset != expected {
+			t.Errorf("Expected foo at offset %d, got %v", expected, foo)
+		}
+	}
+
+	prof := ConvertToPprof(apart, NewConvertOptions())
+	if err := prof.CheckValid(); err != nil {
+		t.Fatal(err)
+	}
+	addresses := make(map[uint64]bool)
+	functions := make(map[uint64]bool)
+	for _, loc := range prof.Location {
+		if loc.Line[0].Function.Name == "foo" {
+			addresses[loc.Address] = true
+			functions[loc.Line[0].Function.ID] = true
+		}
+	}
+	if len(addresses) != 2 || !addresses[10] || !addresses[20] || len(functions) != 1 {
+		t.Errorf("Expected one function foo at addresses 10 and 20, got %v", prof.Location)
+	}
+}
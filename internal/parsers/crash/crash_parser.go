@@ -0,0 +1,297 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package crash parses the thread backtraces of Apple crash reports.
+package crash
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// CrashedLabel is the label set to "true" on the samples of the crashed thread.
+const CrashedLabel = internal.CrashedLabel
+
+// StackValueType is the value type of crash profiles, each thread
+// contributes a single stack.
+var StackValueType = internal.ValueType{Type: "stacks", Unit: "count"}
+
+// CrashParser parses both the text (.crash) and JSON (.ips) crash reports.
+type CrashParser struct {
+	content []byte
+}
+
+func MakeCrashParser(file io.Reader) (p CrashParser, err error) {
+	p.content, err = ioutil.ReadAll(file)
+	return p, err
+}
+
+// Capabilities of crash reports: a stack per thread of the crashed process.
+func (c CrashParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		// Only .ips reports have the ids of the threads.
+		ThreadIDs: c.isIps(),
+		Units:     []internal.ValueType{StackValueType},
+	}
+}
+
+// isIps reports whether the input is a JSON .ips report rather than a text
+// .crash report.
+func (c CrashParser) isIps() bool {
+	return bytes.HasPrefix(bytes.TrimSpace(c.content), []byte("{"))
+}
+
+func (c CrashParser) ParseProfile() (p *internal.TimeProfile, err error) {
+	var process *internal.Process
+	if c.isIps() {
+		process, err = parseIps(c.content)
+	} else {
+		process, err = parseCrash(string(c.content))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &internal.TimeProfile{
+		Processes: []*internal.Process{process},
+		ValueType: StackValueType,
+	}, nil
+}
+
+// backtraceFrame is a frame of a thread's backtrace.
+type backtraceFrame struct {
+	symbol string
+	binary string
+	// position of the backtrace line, invalid if unknown.
+	position internal.Position
+}
+
+// newThread builds a thread whose only stack is the given backtrace, ordered
+// from the innermost frame.
+func newThread(name string, tid uint64, crashed bool, backtrace []backtraceFrame) *internal.Thread {
+	thread := &internal.Thread{
+		Name:   name,
+		Tid:    tid,
+		Frames: make([]*internal.Frame, 0),
+		Labels: map[string]string{CrashedLabel: strconv.FormatBool(crashed)},
+	}
+	var parent *internal.Frame
+	for i := len(backtrace) - 1; i >= 0; i-- {
+		frame := &internal.Frame{
+			Parent:     parent,
+			Children:   make([]*internal.Frame, 0),
+			SymbolName: backtrace[i].symbol,
+			Binary:     backtrace[i].binary,
+			Depth:      len(backtrace) - i,
+			Position:   backtrace[i].position,
+		}
+		if parent == nil {
+			thread.Frames = append(thread.Frames, frame)
+		} else {
+			parent.Children = append(parent.Children, frame)
+		}
+		parent = frame
+	}
+	if parent != nil {
+		parent.SelfWeightNs = 1
+	}
+	return thread
+}
+
+var (
+	processRe      = regexp.MustCompile(`^Process:\s+(.*)\s\[(\d+)\]`)
+	threadNameRe   = regexp.MustCompile(`^Thread (\d+) name:\s*(.*)$`)
+	threadHeaderRe = regexp.MustCompile(`^Thread (\d+)( Crashed)?::?\s*(.*)$`)
+	// Frame lines look like,
+	// 0   libsystem_kernel.dylib        	0x00007fff2032d92e __pthread_kill + 10
+	crashFrameRe = regexp.MustCompile(`^\d+\s+(.*?)\s+0x[0-9a-fA-F]+\s+(.*)$`)
+)
+
+func parseCrash(content string) (*internal.Process, error) {
+	process := &internal.Process{
+		Threads: make([]*internal.Thread, 0),
+	}
+	threadNames := make(map[string]string)
+	attributes := make(map[string]string)
+	var name, number string
+	var crashed bool
+	var backtrace []backtraceFrame
+	var threadPosition internal.Position
+	inThread := false
+	endThread := func() {
+		if inThread {
+			if name == "" {
+				name = threadNames[number]
+			}
+			threadName := fmt.Sprintf("Thread %s", number)
+			if name != "" {
+				threadName = fmt.Sprintf("%s %s", threadName, name)
+			}
+			thread := newThread(threadName, 0, crashed, backtrace)
+			thread.Position = threadPosition
+			process.Threads = append(process.Threads, thread)
+		}
+		inThread = false
+		backtrace = nil
+	}
+	var offset int64
+	for i, line := range strings.Split(content, "\n") {
+		position := internal.Position{Line: i + 1, Offset: offset}
+		offset += int64(len(line)) + 1
+		line = strings.TrimSpace(line)
+		if matches := processRe.FindStringSubmatch(line); matches != nil && process.Name == "" {
+			process.Name = matches[1]
+			process.Position = position
+			pid, err := strconv.ParseUint(matches[2], 10, 64)
+			if err == nil {
+				process.Pid = pid
+			}
+			continue
+		}
+		if matches := threadNameRe.FindStringSubmatch(line); matches != nil {
+			threadNames[matches[1]] = matches[2]
+			continue
+		}
+		if label, value, ok := internal.ParseAttribute(line); ok && !inThread {
+			attributes[label] = value
+			continue
+		}
+		if matches := threadHeaderRe.FindStringSubmatch(line); matches != nil {
+			endThread()
+			inThread = true
+			threadPosition = position
+			number = matches[1]
+			crashed = matches[2] != ""
+			name = matches[3]
+			continue
+		}
+		if !inThread {
+			if line != "" {
+				internal.Reject(position, line, "not an attribute or thread backtrace")
+			}
+			continue
+		}
+		if line == "" {
+			endThread()
+			continue
+		}
+		matches := crashFrameRe.FindStringSubmatch(line)
+		if matches == nil {
+			return nil, fmt.Errorf("Could not parse backtrace line: %s", line)
+		}
+		backtrace = append(backtrace, backtraceFrame{symbol: matches[2], binary: matches[1], position: position})
+	}
+	endThread()
+	if len(process.Threads) == 0 {
+		return nil, errors.New("No thread backtraces found in crash report.")
+	}
+	internal.SetAttributes(process, attributes)
+	return process, nil
+}
+
+type ipsFrame struct {
+	ImageOffset    uint64 `json:"imageOffset"`
+	ImageIndex     int    `json:"imageIndex"`
+	Symbol         string `json:"symbol"`
+	SymbolLocation uint64 `json:"symbolLocation"`
+}
+
+type ipsThread struct {
+	ID        uint64     `json:"id"`
+	Name      string     `json:"name"`
+	Queue     string     `json:"queue"`
+	Triggered bool       `json:"triggered"`
+	Frames    []ipsFrame `json:"frames"`
+}
+
+type ipsImage struct {
+	Name string `json:"name"`
+}
+
+// ipsBody is the second JSON document of an .ips report, following the
+// single line header.
+type ipsBody struct {
+	// OSVersion is in the header, e.g. "macOS 12.0 (21A344)".
+	OSVersion  string      `json:"os_version"`
+	CPUType    string      `json:"cpuType"`
+	ModelCode  string      `json:"modelCode"`
+	Translated bool        `json:"translated"`
+	Pid        uint64      `json:"pid"`
+	ProcName   string      `json:"procName"`
+	Threads    []ipsThread `json:"threads"`
+	UsedImages []ipsImage  `json:"usedImages"`
+}
+
+func parseIps(content []byte) (*internal.Process, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	var body ipsBody
+	// Skip the header, which doesn't have any threads.
+	for len(body.Threads) == 0 {
+		if err := decoder.Decode(&body); err != nil {
+			if err == io.EOF {
+				return nil, errors.New("No thread backtraces found in crash report.")
+			}
+			return nil, fmt.Errorf("Could not decode .ips crash report: %v", err)
+		}
+	}
+	process := &internal.Process{
+		Name:    body.ProcName,
+		Pid:     body.Pid,
+		Threads: make([]*internal.Thread, 0),
+	}
+	for i, th := range body.Threads {
+		name := fmt.Sprintf("Thread %d", i)
+		if th.Name != "" {
+			name = fmt.Sprintf("%s %s", name, th.Name)
+		} else if th.Queue != "" {
+			name = fmt.Sprintf("%s Dispatch queue: %s", name, th.Queue)
+		}
+		backtrace := make([]backtraceFrame, 0, len(th.Frames))
+		for _, f := range th.Frames {
+			image := "???"
+			if f.ImageIndex >= 0 && f.ImageIndex < len(body.UsedImages) {
+				image = body.UsedImages[f.ImageIndex].Name
+			}
+			symbol := fmt.Sprintf("0x%x (in %s)", f.ImageOffset, image)
+			if f.Symbol != "" {
+				symbol = fmt.Sprintf("%s + %d", f.Symbol, f.SymbolLocation)
+			}
+			backtrace = append(backtrace, backtraceFrame{symbol: symbol, binary: image})
+		}
+		process.Threads = append(process.Threads, newThread(name, th.ID, th.Triggered, backtrace))
+	}
+	attributes := make(map[string]string)
+	for label, value := range map[string]string{
+		internal.OSVersionLabel:   body.OSVersion,
+		internal.ArchLabel:        body.CPUType,
+		internal.DeviceModelLabel: body.ModelCode,
+	} {
+		if value != "" {
+			attributes[label] = value
+		}
+	}
+	if body.Translated {
+		attributes[internal.TranslatedLabel] = "true"
+	}
+	internal.SetAttributes(process, attributes)
+	return process, nil
+}
@@ -0,0 +1,234 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crash
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const (
+	validCrash = `Process:               Sandwich [1234]
+Path:                  /Applications/Sandwich.app/Contents/MacOS/Sandwich
+Code Type:             X86-64 (Native)
+
+Crashed Thread:        0  Dispatch queue: com.apple.main-thread
+
+Exception Type:        EXC_CRASH (SIGABRT)
+
+Thread 0 Crashed:: Dispatch queue: com.apple.main-thread
+0   libsystem_kernel.dylib        	0x00007fff2032d92e __pthread_kill + 10
+1   Sandwich                      	0x000000010a3c1f3e makeSandwich + 30
+2   libdyld.dylib                 	0x00007fff20378621 start + 1
+
+Thread 1:
+0   libsystem_pthread.dylib       	0x00007fff2035c458 start_wqthread + 0
+
+Thread 0 crashed with X86 Thread State (64-bit):
+  rax: 0x0000000000000000  rbx: 0x000000010b4c2e00
+`
+	validIps = `{"app_name":"Sandwich","bug_type":"309"}
+{
+  "pid" : 1234,
+  "procName" : "Sandwich",
+  "threads" : [
+    {"triggered": true, "id": 5960, "queue": "com.apple.main-thread", "frames": [
+      {"imageOffset": 100, "symbol": "__pthread_kill", "symbolLocation": 10, "imageIndex": 0},
+      {"imageOffset": 7998, "imageIndex": 1}
+    ]},
+    {"id": 5961, "name": "Worker", "frames": [
+      {"imageOffset": 200, "symbol": "start_wqthread", "symbolLocation": 0, "imageIndex": 0}
+    ]}
+  ],
+  "usedImages" : [{"name": "libsystem_kernel.dylib"}, {"name": "Sandwich"}]
+}`
+)
+
+func parse(t *testing.T, input string) *internal.TimeProfile {
+	t.Helper()
+	parser, err := MakeCrashParser(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return timeProfile
+}
+
+func TestCrashParsing(t *testing.T) {
+	timeProfile := parse(t, validCrash)
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{
+			{
+				Name: "Sandwich",
+				Pid:  1234,
+				Threads: []*internal.Thread{
+					{
+						Name: "Thread 0 Dispatch queue: com.apple.main-thread",
+						Frames: []*internal.Frame{
+							{
+								SymbolName: "start + 1",
+								Depth:      1,
+								Children: []*internal.Frame{
+									{
+										SymbolName: "makeSandwich + 30",
+										Depth:      2,
+										Children: []*internal.Frame{
+											{
+												SymbolName:   "__pthread_kill + 10",
+												Depth:        3,
+												SelfWeightNs: 1,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+					{
+						Name: "Thread 1",
+						Frames: []*internal.Frame{
+							{
+								SymbolName:   "start_wqthread + 0",
+								Depth:        1,
+								SelfWeightNs: 1,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	internal.TimeProfileEquals(t, timeProfile, expected)
+	threads := timeProfile.Processes[0].Threads
+	if threads[0].Labels[CrashedLabel] != "true" || threads[1].Labels[CrashedLabel] != "false" {
+		t.Errorf("Only thread 0 should be labeled crashed: %v, %v", threads[0].Labels, threads[1].Labels)
+	}
+}
+
+func TestIpsParsing(t *testing.T) {
+	timeProfile := parse(t, validIps)
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{
+			{
+				Name: "Sandwich",
+				Pid:  1234,
+				Threads: []*internal.Thread{
+					{
+						Name: "Thread 0 Dispatch queue: com.apple.main-thread",
+						Tid:  5960,
+						Frames: []*internal.Frame{
+							{
+								SymbolName: "0x1f3e (in Sandwich)",
+								Depth:      1,
+								Children: []*internal.Frame{
+									{
+										SymbolName:   "__pthread_kill + 10",
+										Depth:        2,
+										SelfWeightNs: 1,
+									},
+								},
+							},
+						},
+					},
+					{
+						Name: "Thread 1 Worker",
+						Tid:  5961,
+						Frames: []*internal.Frame{
+							{
+								SymbolName:   "start_wqthread + 0",
+								Depth:        1,
+								SelfWeightNs: 1,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	internal.TimeProfileEquals(t, timeProfile, expected)
+	if timeProfile.Processes[0].Threads[0].Labels[CrashedLabel] != "true" {
+		t.Errorf("Triggered thread should be labeled crashed")
+	}
+}
+
+func TestCrashBinaries(t *testing.T) {
+	for report, binary := range map[string]string{
+		validCrash: "libsystem_pthread.dylib",
+		validIps:   "libsystem_kernel.dylib",
+	} {
+		parser, err := MakeCrashParser(strings.NewReader(report))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parser.ParseProfile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The outermost frame of the worker thread is start_wqthread.
+		worker := got.Processes[0].Threads[1].Frames[0]
+		if worker.Binary != binary {
+			t.Errorf("Expected %s in %s, got %s", worker.SymbolName, binary, worker.Binary)
+		}
+	}
+}
+
+func TestCrashAttributes(t *testing.T) {
+	parser, err := MakeCrashParser(strings.NewReader(validCrash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, th := range got.Processes[0].Threads {
+		if arch := th.Labels[internal.ArchLabel]; arch != "X86-64 (Native)" {
+			t.Errorf("Expected arch label X86-64 (Native) on %s, got %v", th.Name, th.Labels)
+		}
+	}
+}
+
+func TestCrashThreadIDs(t *testing.T) {
+	for input, want := range map[string]bool{validCrash: false, validIps: true} {
+		parser, err := MakeCrashParser(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := parser.Capabilities().ThreadIDs; got != want {
+			t.Errorf("Expected ThreadIDs %v for %.20q, got %v", want, input, got)
+		}
+	}
+}
+
+func TestCrashRejects(t *testing.T) {
+	var rejects strings.Builder
+	internal.SetRejectOutput(&rejects)
+	defer internal.SetRejectOutput(nil)
+	parse(t, validCrash)
+	var lines []string
+	for _, reject := range strings.Split(strings.TrimSuffix(rejects.String(), "\n"), "\n") {
+		lines = append(lines, strings.SplitN(reject, "\t", 2)[0])
+	}
+	// The unused header lines and the thread state, but none of the
+	// backtraces.
+	if got := strings.Join(lines, ","); got != "2,5,7,17,18" {
+		t.Errorf("Expected the lines 2,5,7,17,18 to be rejected, got %s in %q", got, rejects.String())
+	}
+}
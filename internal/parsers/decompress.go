@@ -0,0 +1,90 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parsers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zipMagic  = []byte("PK\x03\x04")
+)
+
+// Decompress returns the content of gzip and zip compressed input, detected by
+// their magic bytes, and other input as it is. A zip archive must contain a
+// single file.
+func Decompress(file io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(file)
+	magic, _ := buffered.Peek(len(zipMagic))
+	if bytes.HasPrefix(magic, gzipMagic) {
+		return gzip.NewReader(buffered)
+	}
+	if bytes.HasPrefix(magic, zipMagic) {
+		return unzip(buffered)
+	}
+	return buffered, nil
+}
+
+func unzip(file io.Reader) (io.Reader, error) {
+	// zip needs random access to the archive.
+	content, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("Could not read zip archive: %v", err)
+	}
+	var files []*zip.File
+	for _, f := range archive.File {
+		// Skip directories and the resource forks the macOS archiver adds.
+		if f.FileInfo().IsDir() || strings.HasPrefix(f.Name, "__MACOSX/") {
+			continue
+		}
+		files = append(files, f)
+	}
+	if len(files) == 0 {
+		return nil, errors.New("zip archive has no files")
+	}
+	if len(files) > 1 {
+		names := make([]string, len(files))
+		for i, f := range files {
+			names[i] = f.Name
+		}
+		return nil, fmt.Errorf("zip archive has several files, extract the one to convert: %s", strings.Join(names, ", "))
+	}
+	return files[0].Open()
+}
+
+// MakeDecompressingParser returns a parser factory that decompresses the input
+// before passing it to makeParser.
+func MakeDecompressingParser(makeParser func(io.Reader) (Parser, error)) func(io.Reader) (Parser, error) {
+	return func(file io.Reader) (Parser, error) {
+		input, err := Decompress(file)
+		if err != nil {
+			return nil, err
+		}
+		return makeParser(input)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parsers
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const content = "Weight\tSelf Weight\t\tSymbol Name\n"
+
+func gzipped(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(content))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipped(t *testing.T, names ...string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	inputs := map[string][]byte{
+		"plain": []byte(content),
+		"gzip":  gzipped(t),
+		"zip":   zipped(t, "deep_copy.txt", "__MACOSX/._deep_copy.txt"),
+	}
+	for name, input := range inputs {
+		r, err := Decompress(bytes.NewReader(input))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		} else if string(got) != content {
+			t.Errorf("%s: expected %q, got %q", name, content, got)
+		}
+	}
+}
+
+func TestDecompressZipWithSeveralFiles(t *testing.T) {
+	_, err := Decompress(bytes.NewReader(zipped(t, "a.txt", "b.txt")))
+	if err == nil || !strings.Contains(err.Error(), "a.txt, b.txt") {
+		t.Errorf("Expected an error naming the files, got %v", err)
+	}
+}
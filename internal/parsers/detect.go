@@ -0,0 +1,102 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parsers
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// sniffLength is how much of the decompressed input is sniffed.
+const sniffLength = 64 * 1024
+
+// Sniff returns the first registered format recognizing the start of a
+// decompressed input. Archives can hold inputs of other formats, so they are
+// recognized first.
+func Sniff(head []byte) (Format, bool) {
+	for _, archive := range []bool{true, false} {
+		for _, f := range formats {
+			if f.Archive == archive && f.Sniff != nil && f.Sniff(head) {
+				return f, true
+			}
+		}
+	}
+	return Format{}, false
+}
+
+// recorder records what is read through it until stopped, so an input that
+// was partly read can be replayed from the start.
+type recorder struct {
+	r        io.Reader
+	recorded bytes.Buffer
+	stopped  bool
+}
+
+func (r *recorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if !r.stopped {
+		r.recorded.Write(p[:n])
+	}
+	return n, err
+}
+
+// stop stops recording and drops what was recorded.
+func (r *recorder) stop() {
+	r.stopped = true
+	r.recorded = bytes.Buffer{}
+}
+
+// replay returns the input from the start and stops recording.
+func (r *recorder) replay() io.Reader {
+	r.stopped = true
+	return io.MultiReader(bytes.NewReader(r.recorded.Bytes()), r.r)
+}
+
+// MakeDetectingParser returns a parser factory that detects the format of the
+// input, which may be compressed, and parses it with the parser factory
+// makeParser returns for the format. Only the start of the input is sniffed,
+// the rest is streamed to the parser.
+func MakeDetectingParser(makeParser func(Format) (MakeParserFn, error)) MakeParserFn {
+	return func(file io.Reader) (Parser, error) {
+		// Archives are parsed from the compressed input, which is replayed.
+		input := &recorder{r: file}
+		decompressed, err := Decompress(input)
+		if err != nil {
+			return nil, err
+		}
+		head := make([]byte, sniffLength)
+		n, err := io.ReadFull(decompressed, head)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return nil, err
+		}
+		head = head[:n]
+		f, ok := Sniff(head)
+		if !ok {
+			return nil, fmt.Errorf("Could not detect the format of the input, choose one of %s with --format",
+				strings.Join(FormatNames(), ", "))
+		}
+		parserFn, err := makeParser(f)
+		if err != nil {
+			return nil, err
+		}
+		if f.Archive {
+			return parserFn(input.replay())
+		}
+		input.stop()
+		return parserFn(io.MultiReader(bytes.NewReader(head), decompressed))
+	}
+}
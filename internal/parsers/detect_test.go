@@ -0,0 +1,78 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parsers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r    io.Reader
+	read int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.read += n
+	return n, err
+}
+
+func TestDetectingParserStreams(t *testing.T) {
+	var rows strings.Builder
+	rows.WriteString(content)
+	for i := 0; rows.Len() < 64*sniffLength; i++ {
+		fmt.Fprintf(&rows, "%d.0 ms  0.1%%\t%d.0 ms\t \t  function_%x\n", i%997, i%991, i*7919)
+	}
+	input := rows.String()
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	w.Write([]byte(input))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{"plain": []byte(input), "gzip": compressed.Bytes()} {
+		source := &countingReader{r: bytes.NewReader(data)}
+		var readBeforeParsing int
+		var parsed []byte
+		parserFn := MakeDetectingParser(func(f Format) (MakeParserFn, error) {
+			if f.Name != "instruments" {
+				t.Errorf("%s: expected a deep copy, got %s", name, f.Name)
+			}
+			return func(r io.Reader) (Parser, error) {
+				readBeforeParsing = source.read
+				var err error
+				parsed, err = ioutil.ReadAll(r)
+				return nil, err
+			}, nil
+		})
+		if _, err := parserFn(source); err != nil {
+			t.Fatal(err)
+		}
+		if readBeforeParsing >= len(data) {
+			t.Errorf("%s: expected the input to be streamed, %d of %d bytes were read before parsing",
+				name, readBeforeParsing, len(data))
+		}
+		if string(parsed) != input {
+			t.Errorf("%s: the parser got %d bytes instead of the %d of the input", name, len(parsed), len(input))
+		}
+	}
+}
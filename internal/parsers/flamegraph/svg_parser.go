@@ -0,0 +1,249 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package flamegraph parses the flame graph SVGs of Brendan Gregg's
+// flamegraph.pl and compatible tools.
+package flamegraph
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// rootName is the name of the synthetic frame flamegraph.pl puts below
+// all stacks.
+const rootName = "all"
+
+// widthEpsilon is the tolerance when matching frames to their parents, the
+// SVG coordinates are rounded.
+const widthEpsilon = 0.01
+
+// box is a frame of the flame graph, as drawn in the SVG.
+type box struct {
+	name  string
+	count int64
+	x     float64
+	y     float64
+	width float64
+	frame *internal.Frame
+}
+
+type SvgParser struct {
+	boxes     []*box
+	countName string
+}
+
+var (
+	// Titles look like "foo (1,234 samples, 12.34%)". Differential flame
+	// graphs add the change, e.g. "foo (1,234 samples, 12.34%; +0.50%)".
+	titleRe = regexp.MustCompile(`^(.*) \(([\d,]+) (\w+), [\d.]+%.*\)$`)
+)
+
+func MakeSvgParser(file io.Reader) (p SvgParser, err error) {
+	decoder := xml.NewDecoder(file)
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+	var title string
+	inTitle := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return p, fmt.Errorf("Could not parse flame graph SVG: %v", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "g":
+				title = ""
+			case "title":
+				inTitle = true
+			case "rect":
+				if title == "" {
+					continue
+				}
+				b, err := parseBox(title, t.Attr)
+				if err != nil {
+					return p, err
+				}
+				if b == nil {
+					continue
+				}
+				if p.countName == "" {
+					p.countName = strings.TrimSpace(titleRe.FindStringSubmatch(title)[3])
+				}
+				p.boxes = append(p.boxes, b)
+				title = ""
+			}
+		case xml.EndElement:
+			if t.Name.Local == "title" {
+				inTitle = false
+			}
+		case xml.CharData:
+			if inTitle {
+				title += string(t)
+			}
+		}
+	}
+	if len(p.boxes) == 0 {
+		return p, errors.New("No flame graph frames found in SVG.")
+	}
+	return p, nil
+}
+
+// parseBox returns the box of a frame's rect, or nil if the title is not the
+// title of a frame.
+func parseBox(title string, attrs []xml.Attr) (*box, error) {
+	matches := titleRe.FindStringSubmatch(strings.TrimSpace(title))
+	if matches == nil {
+		return nil, nil
+	}
+	count, err := strconv.ParseInt(strings.ReplaceAll(matches[2], ",", ""), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing count of %s: %v", title, err)
+	}
+	b := &box{name: matches[1], count: count}
+	for _, attr := range attrs {
+		var field *float64
+		switch attr.Name.Local {
+		case "x":
+			field = &b.x
+		case "y":
+			field = &b.y
+		case "width":
+			field = &b.width
+		default:
+			continue
+		}
+		if *field, err = strconv.ParseFloat(attr.Value, 64); err != nil {
+			return nil, fmt.Errorf("Error parsing %s of %s: %v", attr.Name.Local, title, err)
+		}
+	}
+	return b, nil
+}
+
+func (s SvgParser) valueType() internal.ValueType {
+	switch s.countName {
+	case "samples":
+		return internal.ValueType{Type: "samples", Unit: "count"}
+	case "bytes":
+		return internal.ValueType{Type: "space", Unit: "bytes"}
+	}
+	return internal.ValueType{Type: s.countName, Unit: "count"}
+}
+
+// Capabilities of flame graph SVGs: a single call tree of counts.
+func (s SvgParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		Units: []internal.ValueType{{Type: "samples", Unit: "count"}, {Type: "space", Unit: "bytes"}},
+	}
+}
+
+// ParseProfile rebuilds the stacks from the position of the frames. Every
+// frame sits on top of the frame of the previous level whose extent contains
+// it. The SVG only has cumulative counts, and frames too narrow to draw are
+// left out, so their weight is attributed to their parent.
+func (s SvgParser) ParseProfile() (p *internal.TimeProfile, err error) {
+	// Levels are ordered from the root, which is at the bottom of a flame
+	// graph and at the top of an icicle graph.
+	var root *box
+	for _, b := range s.boxes {
+		if b.name == rootName && (root == nil || b.width > root.width) {
+			root = b
+		}
+	}
+	ys := make([]float64, 0)
+	seen := make(map[float64]bool)
+	for _, b := range s.boxes {
+		if !seen[b.y] {
+			seen[b.y] = true
+			ys = append(ys, b.y)
+		}
+	}
+	sort.Float64s(ys)
+	if root != nil && root.y == ys[len(ys)-1] {
+		sort.Sort(sort.Reverse(sort.Float64Slice(ys)))
+	}
+	levels := make(map[float64]int, len(ys))
+	for i, y := range ys {
+		levels[y] = i
+	}
+	byLevel := make([][]*box, len(ys))
+	for _, b := range s.boxes {
+		byLevel[levels[b.y]] = append(byLevel[levels[b.y]], b)
+	}
+
+	thread := &internal.Thread{
+		Name:   "flamegraph",
+		Frames: make([]*internal.Frame, 0),
+	}
+	for level, boxes := range byLevel {
+		sort.Slice(boxes, func(i, j int) bool { return boxes[i].x < boxes[j].x })
+		for _, b := range boxes {
+			if b == root {
+				continue
+			}
+			b.frame = &internal.Frame{
+				Children:     make([]*internal.Frame, 0),
+				SelfWeightNs: b.count,
+				SymbolName:   b.name,
+				Depth:        level,
+			}
+			parent := findParent(byLevel, level, b)
+			if parent == nil || parent == root {
+				thread.Frames = append(thread.Frames, b.frame)
+				continue
+			}
+			if parent.frame == nil {
+				return nil, fmt.Errorf("Frame %s has no parent frame", b.name)
+			}
+			b.frame.Parent = parent.frame
+			parent.frame.Children = append(parent.frame.Children, b.frame)
+			parent.frame.SelfWeightNs -= b.count
+			if parent.frame.SelfWeightNs < 0 {
+				return nil, fmt.Errorf("Frame %s has a higher count than its children", parent.name)
+			}
+		}
+	}
+	return &internal.TimeProfile{
+		Processes: []*internal.Process{{
+			Name:    "flamegraph",
+			Threads: []*internal.Thread{thread},
+		}},
+		ValueType: s.valueType(),
+	}, nil
+}
+
+func findParent(byLevel [][]*box, level int, b *box) *box {
+	if level == 0 {
+		return nil
+	}
+	for _, candidate := range byLevel[level-1] {
+		if b.x >= candidate.x-widthEpsilon && b.x+b.width <= candidate.x+candidate.width+widthEpsilon {
+			return candidate
+		}
+	}
+	return nil
+}
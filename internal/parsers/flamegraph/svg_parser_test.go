@@ -0,0 +1,101 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flamegraph
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const (
+	validSvg = `<?xml version="1.0" standalone="no"?>
+<!DOCTYPE svg PUBLIC "-//W3C//DTD SVG 1.1//EN" "http://www.w3.org/Graphics/SVG/1.1/DTD/svg11.dtd">
+<svg version="1.1" width="1200" height="86" xmlns="http://www.w3.org/2000/svg">
+<text id="title" x="600.00" y="24">Flame Graph</text>
+<g id="frames">
+<g>
+<title>all (10 samples, 100%)</title><rect x="10.0" y="53" width="1000.0" height="15.0" fill="rgb(1,1,1)" rx="2" ry="2" />
+<text x="13.00" y="63.5">all</text>
+</g>
+<g>
+<title>main (10 samples, 100.00%)</title><rect x="10.0" y="37" width="1000.0" height="15.0" fill="rgb(1,1,1)" rx="2" ry="2" />
+</g>
+<g>
+<title>std::vector&lt;int&gt;::push_back (6 samples, 60.00%)</title><rect x="10.0" y="21" width="600.0" height="15.0" fill="rgb(1,1,1)" rx="2" ry="2" />
+</g>
+<g>
+<title>eat (3 samples, 30.00%)</title><rect x="610.0" y="21" width="300.0" height="15.0" fill="rgb(1,1,1)" rx="2" ry="2" />
+</g>
+<g>
+<title>chew (1 samples, 10.00%)</title><rect x="610.0" y="5" width="100.0" height="15.0" fill="rgb(1,1,1)" rx="2" ry="2" />
+</g>
+</g>
+</svg>`
+)
+
+func TestSvgParsing(t *testing.T) {
+	parser, err := MakeSvgParser(strings.NewReader(validSvg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{
+			{
+				Name: "flamegraph",
+				Threads: []*internal.Thread{
+					{
+						Name: "flamegraph",
+						Frames: []*internal.Frame{
+							{
+								SymbolName:   "main",
+								Depth:        1,
+								SelfWeightNs: 1,
+								Children: []*internal.Frame{
+									{
+										SymbolName:   "std::vector<int>::push_back",
+										Depth:        2,
+										SelfWeightNs: 6,
+									},
+									{
+										SymbolName:   "eat",
+										Depth:        2,
+										SelfWeightNs: 2,
+										Children: []*internal.Frame{
+											{
+												SymbolName:   "chew",
+												Depth:        3,
+												SelfWeightNs: 1,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	internal.TimeProfileEquals(t, timeProfile, expected)
+	if timeProfile.GetValueType().Type != "samples" {
+		t.Errorf("Expected samples value type, got %v", timeProfile.GetValueType())
+	}
+}
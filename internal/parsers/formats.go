@@ -0,0 +1,176 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parsers
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/google/instrumentsToPprof/internal/parsers/instruments"
+)
+
+// MakeParserFn makes a parser of an input.
+type MakeParserFn func(io.Reader) (Parser, error)
+
+// Format is an input format, with the parser of its inputs.
+type Format struct {
+	// Name is the value of --format selecting the format.
+	Name string
+	// Help completes "--format=<name> for ..." in the help.
+	Help string
+	// Sniff reports whether the start of an input looks like the format. It
+	// is nil for formats that can't be recognized from their content.
+	Sniff func(head []byte) bool
+	// Make makes a parser with the default options.
+	Make MakeParserFn
+	// Flags, if set, registers the options of the format on fs and returns a
+	// function making the parser factory with the parsed options. It fails
+	// on invalid option values.
+	Flags func(fs *flag.FlagSet) func() (MakeParserFn, error)
+	// Archive is set for formats whose parser reads a compressed archive
+	// itself, so the input must not be decompressed first.
+	Archive bool
+}
+
+var formats []Format
+
+// Register adds a format. Formats are listed in the order they are
+// registered.
+func Register(f Format) {
+	if _, ok := LookupFormat(f.Name); ok {
+		panic(fmt.Sprintf("format %s registered twice", f.Name))
+	}
+	formats = append(formats, f)
+}
+
+// LookupFormat returns the registered format with the name.
+func LookupFormat(name string) (Format, bool) {
+	for _, f := range formats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Format{}, false
+}
+
+// Formats returns the registered formats.
+func Formats() []Format {
+	return append([]Format(nil), formats...)
+}
+
+// FormatNames returns the names of the registered formats.
+func FormatNames() []string {
+	names := make([]string, len(formats))
+	for i, f := range formats {
+		names[i] = f.Name
+	}
+	return names
+}
+
+// contains returns a sniffer matching inputs containing any of the markers.
+func contains(markers ...string) func([]byte) bool {
+	return func(head []byte) bool {
+		for _, marker := range markers {
+			if bytes.Contains(head, []byte(marker)) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// matches returns a sniffer matching inputs matching the expression.
+func matches(expr string) func([]byte) bool {
+	re := regexp.MustCompile(expr)
+	return re.Match
+}
+
+// isSysdiagnoseTar reports whether the decompressed input is a tar archive of
+// a sysdiagnose, whose first entry is its directory.
+func isSysdiagnoseTar(head []byte) bool {
+	const nameLength, magicOffset = 100, 257
+	return len(head) > magicOffset+5 && bytes.Equal(head[magicOffset:magicOffset+5], []byte("ustar")) &&
+		bytes.Contains(head[:nameLength], []byte("sysdiagnose"))
+}
+
+func deepCopyFlags(fs *flag.FlagSet) func() (MakeParserFn, error) {
+	boundedWeights := fs.String("bounded-weights", "upper-bound",
+		"How deep copy weights shown as '< 0.1 ms' are converted: upper-bound or zero.")
+	rounding := fs.String("rounding", "floor",
+		"How fractional nanoseconds of deep copy weights are converted: floor, round, or distribute to "+
+			"carry the rounding error to the next frame, so the weights add up to the total.")
+	indent := fs.Int("deep-copy-indent", 0,
+		"Number of spaces per level of the deep copy's symbol names. 0 learns the indentation from the "+
+			"first thread of each process.")
+	return func() (MakeParserFn, error) {
+		policy, err := instruments.ParseBoundPolicy(*boundedWeights)
+		if err != nil {
+			return nil, err
+		}
+		roundingPolicy, err := instruments.ParseRoundingPolicy(*rounding)
+		if err != nil {
+			return nil, err
+		}
+		if *indent < 0 {
+			return nil, fmt.Errorf("--deep-copy-indent %d must not be negative", *indent)
+		}
+		return MakeDeepCopyParserWithOptions(policy, roundingPolicy, *indent), nil
+	}
+}
+
+func init() {
+	Register(Format{Name: "sample", Help: "parsing sample files",
+		Sniff: contains("Analysis of sampling", "\nCall graph:\n"), Make: MakeSampleParser})
+	// Deep copies without a header are recognized by their rows, e.g.
+	// "10.0 s  100%\t4.0 s\t \tmain", a weight with its percentage and a
+	// self weight.
+	Register(Format{Name: "instruments", Help: "instruments deep-copy.",
+		Sniff: matches(`(?m)(^|\t)Self Weight\t|^(< )?[\d.,]+ \S+\s+[\d.,]+%\t(< )?[\d.,]+ \S+\t`),
+		Make:  MakeDeepCopyParser, Flags: deepCopyFlags})
+	Register(Format{Name: "allocations", Help: "the call tree deep-copied from the Allocations instrument.",
+		Sniff: matches(`(?m)(^|\t)Bytes Used\t`), Make: MakeAllocationsParser})
+	Register(Format{Name: "metrickit", Help: "MetricKit diagnostic payload JSON.",
+		Sniff: contains(`"callStackTree"`), Make: MakeMetricKitParser})
+	Register(Format{Name: "crash", Help: ".crash and .ips crash reports.",
+		Sniff: contains("\nCrashed Thread:", "\nException Type:", `"bug_type"`), Make: MakeCrashParser})
+	Register(Format{Name: "spindump", Help: "spindump and symbolicated tailspin reports.",
+		Sniff: matches(`(?m)^Steps:\s+\d+`), Make: MakeSpindumpParser})
+	Register(Format{Name: "sysdiagnose", Help: "the spindump reports inside a sysdiagnose .tar.gz archive.",
+		Sniff: isSysdiagnoseTar, Make: MakeSysdiagnoseParser, Archive: true})
+	Register(Format{Name: "speedscope", Help: "speedscope JSON files.",
+		Sniff: matches(`speedscope\.app/file-format-schema|"shared"\s*:\s*\{\s*"frames"`), Make: MakeSpeedscopeParser})
+	Register(Format{Name: "flamegraph-svg", Help: "the stacks embedded in flamegraph.pl SVGs.",
+		Sniff: contains("<svg"), Make: MakeFlameGraphSvgParser})
+	Register(Format{Name: "ir", Help: "the JSON intermediate representation written by --write-ir.",
+		Sniff: matches(`^\s*\{\s*"version":\s*\d+\s*,`), Make: MakeIRParser})
+	Register(Format{Name: "xctrace", Help: "the time profile tables of 'xctrace export'.",
+		Sniff: contains("<trace-query-result"), Make: MakeXctraceParser})
+	// Bundles are exported by the caller, as parsers read a single stream.
+	Register(Format{Name: "trace", Help: "Instruments .trace bundles, exported with xctrace, or the XML of 'xctrace export'.",
+		Make: MakeXctraceParser})
+	Register(Format{Name: "heap", Help: "the objects by class of 'heap <pid>'.",
+		Sniff: matches(`(?m)^\s*COUNT\s+BYTES\s+AVG\s+CLASS_NAME`), Make: MakeHeapParser})
+	Register(Format{Name: "vmmap", Help: "the memory by region type of 'vmmap --summary <pid>'.",
+		Sniff: matches(`(?m)^REGION TYPE\s`), Make: MakeVmmapParser})
+	Register(Format{Name: "leaks", Help: "the leaked memory by allocation stack of 'leaks <pid>'.",
+		Sniff: matches(`(?m)^Process \d+: \d+ leaks? for`), Make: MakeLeaksParser})
+	Register(Format{Name: "malloc-history", Help: "the allocations by stack of 'malloc_history <pid> -allBySize'.",
+		Sniff: matches(`(?m)^\d+ calls? for \d+ bytes:`), Make: MakeMallocHistoryParser})
+	Register(Format{Name: "fs-usage", Help: "the time spent in file system calls logged by 'fs_usage -w'.",
+		Sniff: matches(`(?m)^\d\d:\d\d:\d\d\.\d+\s+\S+.*\s\d+\.\d+( W)?\s+\S+\.\d+\s*$`), Make: MakeFsUsageParser})
+}
@@ -0,0 +1,145 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fsusage parses the output of fs_usage, aggregating the time spent
+// in file system calls by process, thread and call.
+package fsusage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+type FsUsageParser struct {
+	lines []string
+	// offsets of the start of each line in the input.
+	offsets []int64
+}
+
+func MakeFsUsageParser(file io.Reader) (p FsUsageParser, err error) {
+	p.lines, p.offsets, err = internal.ScanLines(file)
+	return p, err
+}
+
+// LatencyValueType is the value type of the time spent in the calls.
+var LatencyValueType = internal.ValueType{Type: "latency", Unit: "nanoseconds"}
+
+var (
+	// Call lines look like,
+	// 15:41:58.406001  open              F=4        (R_____)  /usr/lib/libfoo.dylib    0.000123   Sandwich.1234
+	// The elapsed time is followed by a W if the thread was scheduled out
+	// during the call. Process names and paths can have spaces.
+	callRe = regexp.MustCompile(`^(\d\d):(\d\d):(\d\d(?:\.\d+)?)\s+(\S+)\s*(.*?)\s+(\d+\.\d+)(?:\s+W)?\s+(.+)\.(\d+)$`)
+)
+
+// Capabilities of fs_usage output: timed calls of every process with the
+// tids of their threads.
+func (f FsUsageParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		Timestamps:   true,
+		ThreadIDs:    true,
+		MultiProcess: true,
+		Units:        []internal.ValueType{LatencyValueType, {Type: "calls", Unit: "count"}},
+	}
+}
+
+func (f FsUsageParser) ParseProfile() (*internal.TimeProfile, error) {
+	p := &internal.TimeProfile{
+		ValueType:       LatencyValueType,
+		ExtraValueTypes: []internal.ValueType{{Type: "calls", Unit: "count"}},
+	}
+	processes := make(map[string]*internal.Process)
+	type threadKey struct {
+		process string
+		tid     uint64
+	}
+	threads := make(map[threadKey]*internal.Thread)
+	for i, line := range f.lines {
+		matches := callRe.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			// Headers and the lines of events without a duration.
+			if strings.TrimSpace(line) != "" {
+				internal.Reject(internal.PositionOf(f.offsets, i), line, "not a call with a duration")
+			}
+			continue
+		}
+		position := internal.PositionOf(f.offsets, i)
+		time, err := parseTimeOfDay(matches[1], matches[2], matches[3])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing time %s: %v", line, err)
+		}
+		elapsed, err := strconv.ParseFloat(matches[6], 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing elapsed time %s: %v", line, err)
+		}
+		tid, err := strconv.ParseUint(matches[8], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing thread id %s: %v", line, err)
+		}
+		name := matches[7]
+		process, ok := processes[name]
+		if !ok {
+			process = &internal.Process{Name: name, Threads: make([]*internal.Thread, 0), Position: position}
+			processes[name] = process
+			p.Processes = append(p.Processes, process)
+		}
+		key := threadKey{name, tid}
+		thread, ok := threads[key]
+		if !ok {
+			thread = &internal.Thread{
+				Name:     fmt.Sprintf("Thread %d", tid),
+				Tid:      tid,
+				Frames:   make([]*internal.Frame, 0),
+				Position: position,
+			}
+			threads[key] = thread
+			process.Threads = append(process.Threads, thread)
+		}
+		thread.AddTimedStack(time, []string{matches[4]}, int64(math.Round(elapsed*1e9)))
+		call := thread.Timeline[len(thread.Timeline)-1].Frame
+		if len(call.ExtraWeights) == 0 {
+			call.ExtraWeights = []int64{0}
+			call.Position = position
+		}
+		call.ExtraWeights[0]++
+	}
+	if len(p.Processes) == 0 {
+		return nil, errors.New("No calls found in fs_usage output.")
+	}
+	return p, nil
+}
+
+// parseTimeOfDay returns the nanoseconds since midnight of a timestamp.
+func parseTimeOfDay(hours string, minutes string, seconds string) (int64, error) {
+	h, err := strconv.ParseInt(hours, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.ParseInt(minutes, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	s, err := strconv.ParseFloat(seconds, 64)
+	if err != nil {
+		return 0, err
+	}
+	return (h*3600+m*60)*1_000_000_000 + int64(math.Round(s*1e9)), nil
+}
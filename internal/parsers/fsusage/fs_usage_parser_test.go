@@ -0,0 +1,89 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fsusage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const validFsUsage = `TIMESTAMP        CALL              FILE DESCRIPTOR                          TIME       PROCESS
+15:41:58.406001  open              F=4        (R_____)  /Users/me/My Sandwich.txt   0.000100   Sandwich.1234
+15:41:58.406100  read              F=4    B=0x1000                                0.002000 W Sandwich.1234
+15:41:58.406200  read              F=4    B=0x1000                                0.001000   Sandwich.1234
+15:41:58.406300  stat64            [  2]           /no/such/file                  0.000010   Google Chrome H.777
+15:41:58.406400  PAGE_IN_FILE      A=0x0104a80000                                 0.000500   Sandwich.5678
+`
+
+func TestFsUsageParsing(t *testing.T) {
+	parser, err := MakeFsUsageParser(strings.NewReader(validFsUsage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := &internal.Thread{Name: "Thread 1234", Tid: 1234}
+	main.AddStack([]string{"open"}, 100_000)
+	main.AddStack([]string{"read"}, 3_000_000)
+	pager := &internal.Thread{Name: "Thread 5678", Tid: 5678}
+	pager.AddStack([]string{"PAGE_IN_FILE"}, 500_000)
+	chrome := &internal.Thread{Name: "Thread 777", Tid: 777}
+	chrome.AddStack([]string{"stat64"}, 10_000)
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{
+			{Name: "Sandwich", Threads: []*internal.Thread{main, pager}},
+			{Name: "Google Chrome H", Threads: []*internal.Thread{chrome}},
+		},
+	}
+	internal.TimeProfileEquals(t, got, expected)
+
+	gotMain := got.Processes[0].Threads[0]
+	if read := gotMain.Frames[1]; read.ExtraWeights[0] != 2 {
+		t.Errorf("Expected 2 read calls, got %v", read.ExtraWeights)
+	}
+	if len(gotMain.Timeline) != 3 || gotMain.Timeline[0].Time != (15*3600+41*60+58)*1_000_000_000+406_001_000 {
+		t.Errorf("Unexpected timeline %v", gotMain.Timeline)
+	}
+}
+
+func TestFsUsageWithoutCalls(t *testing.T) {
+	parser, err := MakeFsUsageParser(strings.NewReader("TIMESTAMP        CALL    TIME       PROCESS\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err == nil {
+		t.Error("Expected an error for fs_usage output without calls")
+	}
+}
+
+func TestFsUsageRejects(t *testing.T) {
+	var rejects strings.Builder
+	internal.SetRejectOutput(&rejects)
+	defer internal.SetRejectOutput(nil)
+	parser, err := MakeFsUsageParser(strings.NewReader(validFsUsage))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if got := rejects.String(); !strings.HasPrefix(got, "1\tnot a call with a duration\tTIMESTAMP") || strings.Count(got, "\n") != 1 {
+		t.Errorf("Expected only the header to be rejected, got %q", got)
+	}
+}
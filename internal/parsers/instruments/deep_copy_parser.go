@@ -15,35 +15,421 @@
 package instruments
 
 import (
-	"bufio"
 	"fmt"
 	"io"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/instrumentsToPprof/internal"
 )
 
+// MakeDeepCopyParser returns a parser of the deep copy read from file.
+// ParseProfile reads the file one line at a time, so it can only be called
+// once.
 func MakeDeepCopyParser(file io.Reader) (d DeepCopyParser, err error) {
-	d = DeepCopyParser{
-		lines: []string{},
+	d.file = file
+	d.setColumns(defaultHeader)
+	return d, nil
+}
+
+// MakeAllocationsParser returns a parser of the deep copy of the call tree
+// of the Allocations instrument read from file, weighted by the bytes and
+// number of allocations of each frame and its callees.
+func MakeAllocationsParser(file io.Reader) (d DeepCopyParser, err error) {
+	d.file = file
+	d.allocations = true
+	d.setColumns(allocationsHeader)
+	return d, nil
+}
+
+type DeepCopyParser struct {
+	file io.Reader
+	// scanner reads the lines of file during ParseProfile.
+	scanner *internal.LineScanner
+	// inTree is set after the first row of the call tree.
+	inTree bool
+	// checks check the depths of the rows read so far.
+	checks *depthChecks
+	// boundPolicy decides the weight of frames like "< 0.1 ms".
+	boundPolicy BoundPolicy
+	// rounding decides how fractional nanoseconds of weights are converted.
+	rounding RoundingPolicy
+	// indent is the number of spaces per depth of the symbol names, or 0 to
+	// learn it from the first thread of each process, see learnIndent.
+	indent int
+	// learnedIndent is the indentation per depth learned by learnIndent.
+	learnedIndent int
+	// header are the column names of the header line, see getHeader.
+	header []string
+	// extraColumns are the optional columns of the call tree, e.g. wakeups.
+	extraColumns []extraColumn
+	// weightType is the value type of the weights, learned from the unit of
+	// the first row with weight, see weightUnits.
+	weightType *internal.ValueType
+	// weightColumn and selfWeightColumn are the indices of the weight
+	// columns in the header, which can be reordered in Instruments. The
+	// Allocations instrument has no self weight column, selfWeightColumn is
+	// then -1 and the self weights are computed from the totals.
+	weightColumn     int
+	selfWeightColumn int
+	// allocations is set for deep copies of the Allocations instrument.
+	allocations bool
+}
+
+// extraColumn is an optional column of the call tree, converted to an
+// additional sample value.
+type extraColumn struct {
+	index     int
+	valueType internal.ValueType
+}
+
+// extraColumnTypes are the value types of the optional columns Instruments
+// can add to the call tree, by column name.
+var extraColumnTypes = map[string]internal.ValueType{
+	"Wakeups":       {Type: "wakeups", Unit: "count"},
+	"Energy Impact": {Type: "energy_impact", Unit: "count"},
+	"Count":         {Type: "alloc_objects", Unit: "count"},
+}
+
+// Names of the weight columns of the header line. The columns can be
+// reordered, only the symbol name is always last.
+const (
+	weightColumnName     = "Weight"
+	selfWeightColumnName = "Self Weight"
+	// bytesUsedColumnName is the weight column of the Allocations
+	// instrument, which includes the callees.
+	bytesUsedColumnName = "Bytes Used"
+)
+
+// columnIndex returns the index of the named column of a header, or -1.
+func columnIndex(header []string, name string) int {
+	for i, column := range header {
+		if strings.TrimSpace(column) == name {
+			return i
+		}
 	}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		d.lines = append(d.lines, scanner.Text())
+	return -1
+}
+
+// isHeader reports whether line is the header of the call tree, which has
+// both weight columns in any order, or the bytes used by allocations.
+func isHeader(line string) bool {
+	header := strings.Split(line, "\t")
+	return columnIndex(header, weightColumnName) >= 0 && columnIndex(header, selfWeightColumnName) >= 0 ||
+		columnIndex(header, bytesUsedColumnName) >= 0
+}
+
+// heaviestStackHeaders start the heaviest stack Instruments appends to the
+// call tree when both panes are selected while copying: its title, or the
+// header of its columns, which has no self weight.
+var heaviestStackHeaders = []string{"Heaviest Stack", "Weight\tSymbol Name"}
+
+// isHeaviestStackHeader reports whether line starts the heaviest stack.
+func isHeaviestStackHeader(line string) bool {
+	for _, header := range heaviestStackHeaders {
+		if strings.HasPrefix(line, header) {
+			return true
+		}
 	}
-	if err := scanner.Err(); err != nil {
-		return d, err
+	return false
+}
+
+// parseHeader returns the optional columns of the header. Unknown columns
+// are ignored.
+func parseHeader(header []string) []extraColumn {
+	var columns []extraColumn
+	for i, name := range header {
+		if valueType, ok := extraColumnTypes[strings.TrimSpace(name)]; ok {
+			columns = append(columns, extraColumn{index: i, valueType: valueType})
+		}
 	}
-	return d, nil
+	return columns
 }
 
-type DeepCopyParser struct {
-	lines []string
+// defaultHeader are the columns of deep copies without a header line.
+var defaultHeader = []string{"Weight", "Self Weight", "", "Symbol Name"}
+
+// allocationsHeader are the columns of deep copies of the Allocations
+// instrument without a header line.
+var allocationsHeader = []string{"Bytes Used", "Count", "", "Symbol Name"}
+
+// setHeader uses the columns of the header line.
+func (d *DeepCopyParser) setHeader(line string) {
+	d.header = strings.Split(strings.TrimRight(line, "\t"), "\t")
+	d.setColumns(d.header)
+}
+
+// setColumns finds the weight and optional columns of the header.
+func (d *DeepCopyParser) setColumns(header []string) {
+	d.extraColumns = parseHeader(header)
+	d.weightColumn = columnIndex(header, weightColumnName)
+	d.selfWeightColumn = columnIndex(header, selfWeightColumnName)
+	if bytesUsed := columnIndex(header, bytesUsedColumnName); bytesUsed >= 0 {
+		d.weightColumn = bytesUsed
+		d.selfWeightColumn = -1
+	}
+}
+
+func (d DeepCopyParser) getHeader() []string {
+	if d.header == nil {
+		if d.allocations {
+			return allocationsHeader
+		}
+		return defaultHeader
+	}
+	return d.header
+}
+
+func isBlank(field string) bool {
+	return strings.TrimSpace(field) == ""
+}
+
+// splitFields splits a line into the columns of the header. Depending on the
+// column configuration, Instruments emits double tabs, which add empty
+// fields, or leaves out the blank column before the symbol name. Lines that
+// don't have a field per column are aligned by their non-blank fields, which
+// must match the named columns of the header, with the symbol name last.
+func (d DeepCopyParser) splitFields(line string) ([]string, error) {
+	header := d.getHeader()
+	// Some column configurations end every line with a tab.
+	fields := strings.Split(strings.TrimRight(line, "\t"), "\t")
+	if len(fields) == len(header) {
+		return fields, nil
+	}
+	// The symbol is always the last field, its leading spaces are the depth.
+	values := make([]string, 0, len(fields))
+	for _, field := range fields[:len(fields)-1] {
+		if !isBlank(field) {
+			values = append(values, field)
+		}
+	}
+	named := make([]int, 0, len(header))
+	for i, name := range header[:len(header)-1] {
+		if !isBlank(name) {
+			named = append(named, i)
+		}
+	}
+	if len(values) != len(named) {
+		return nil, fmt.Errorf(
+			"Could not parse line \"%s\", found %d tab-seperated fields instead of %d",
+			line, len(fields), len(header))
+	}
+	aligned := make([]string, len(header))
+	for i := range aligned {
+		aligned[i] = " "
+	}
+	for i, index := range named {
+		aligned[index] = values[i]
+	}
+	aligned[len(header)-1] = fields[len(fields)-1]
+	return aligned, nil
+}
+
+// BoundPolicy decides how weights that Instruments only shows as an upper
+// bound, e.g. "< 0.1 ms", are converted.
+type BoundPolicy int
+
+const (
+	// UpperBound uses the bound as the weight, so "< 0.1 ms" is 0.1 ms.
+	UpperBound BoundPolicy = iota
+	// ZeroBound drops the weight of bounded frames.
+	ZeroBound
+)
+
+// ParseBoundPolicy parses the names "upper-bound" and "zero".
+func ParseBoundPolicy(name string) (BoundPolicy, error) {
+	switch name {
+	case "upper-bound":
+		return UpperBound, nil
+	case "zero":
+		return ZeroBound, nil
+	}
+	return UpperBound, fmt.Errorf("Unknown bounded weight policy '%s', expected upper-bound or zero", name)
+}
+
+// WithBoundPolicy returns a parser that uses the given policy for bounded
+// weights.
+func (d DeepCopyParser) WithBoundPolicy(policy BoundPolicy) DeepCopyParser {
+	d.boundPolicy = policy
+	return d
+}
+
+// RoundingPolicy decides how weights with fractional nanoseconds, e.g.
+// "2.3 ms" that is 2299999.9999999995 ns as a float, are converted.
+type RoundingPolicy int
+
+const (
+	// FloorRounding drops the fractions, losing up to 1 ns per frame.
+	FloorRounding RoundingPolicy = iota
+	// NearestRounding rounds to the nearest nanosecond.
+	NearestRounding
+	// DistributeRounding carries the rounding error of each frame to the
+	// next, so the weights of a deep copy add up to its rounded total.
+	DistributeRounding
+)
+
+// ParseRoundingPolicy parses the names "floor", "round" and "distribute".
+func ParseRoundingPolicy(name string) (RoundingPolicy, error) {
+	switch name {
+	case "floor":
+		return FloorRounding, nil
+	case "round":
+		return NearestRounding, nil
+	case "distribute":
+		return DistributeRounding, nil
+	}
+	return FloorRounding, fmt.Errorf("Unknown rounding policy '%s', expected floor, round or distribute", name)
+}
+
+// toNs converts a weight in fractional nanoseconds with the policy.
+// DistributeRounding adds the remainder carried from the previous weights and
+// updates it, or rounds to the nearest nanosecond without a remainder.
+func (r RoundingPolicy) toNs(value float64, remainder *float64) int64 {
+	switch r {
+	case NearestRounding:
+		return int64(math.Round(value))
+	case DistributeRounding:
+		if remainder == nil || value == 0 {
+			return int64(math.Round(value))
+		}
+		value += *remainder
+		ns := math.Round(value)
+		*remainder = value - ns
+		return int64(ns)
+	}
+	return int64(value)
+}
+
+// WithRounding returns a parser that uses the given policy for fractional
+// nanoseconds of weights.
+func (d DeepCopyParser) WithRounding(policy RoundingPolicy) DeepCopyParser {
+	d.rounding = policy
+	return d
+}
+
+// WithIndent returns a parser for deep copies whose symbol names are indented
+// by the given number of spaces per depth, instead of the learned indentation.
+func (d DeepCopyParser) WithIndent(indent int) DeepCopyParser {
+	d.indent = indent
+	return d
+}
+
+// getIndent returns the number of indentation characters per depth.
+func (d DeepCopyParser) getIndent() int {
+	if d.indent > 0 {
+		return d.indent
+	}
+	if d.learnedIndent > 0 {
+		return d.learnedIndent
+	}
+	return 1
+}
+
+// isIndent reports whether r indents symbol names. Depending on the font and
+// localization of the table, Instruments indents with spaces, no-break spaces
+// or other fixed width spaces.
+func isIndent(r rune) bool {
+	return r != '\t' && unicode.IsSpace(r)
+}
+
+// splitIndent returns the number of indentation characters of a symbol field
+// and the symbol name.
+func splitIndent(symbol string) (int, string) {
+	name := strings.TrimLeftFunc(symbol, isIndent)
+	return utf8.RuneCountInString(symbol[:len(symbol)-len(name)]), name
+}
+
+// learnIndent learns the indentation per depth from a thread line, which is
+// at depth 1, unless the indent was set explicitly.
+func (d *DeepCopyParser) learnIndent(line string) {
+	if d.indent > 0 {
+		return
+	}
+	fields, err := d.splitFields(line)
+	if err != nil {
+		return
+	}
+	if indent, _ := splitIndent(fields[len(fields)-1]); indent > 0 {
+		d.learnedIndent = indent
+	}
+}
+
+// Capabilities of deep copies: call trees of any number of processes with
+// the tids of their threads, but not when samples were taken.
+func (d DeepCopyParser) Capabilities() internal.Capabilities {
+	if d.allocations {
+		return internal.Capabilities{
+			ThreadIDs:    true,
+			MultiProcess: true,
+			Units:        []internal.ValueType{allocSpaceValueType, extraColumnTypes["Count"]},
+		}
+	}
+	return internal.Capabilities{
+		ThreadIDs:    true,
+		MultiProcess: true,
+		Units: []internal.ValueType{internal.CPUValueType, internal.CyclesValueType,
+			extraColumnTypes["Wakeups"], extraColumnTypes["Energy Impact"]},
+	}
 }
 
 func (d DeepCopyParser) ParseProfile() (p *internal.TimeProfile, err error) {
+	d.start()
+	p, err = d.parseProfile()
+	if err != nil {
+		// Check the rest of the call tree to explain the error.
+		for d.next() {
+		}
+	}
+	hint := d.checks.depthHint()
+	if err != nil {
+		if hint != "" {
+			return nil, fmt.Errorf("%v\n%s", err, hint)
+		}
+		return nil, err
+	}
+	if hint != "" {
+		internal.Warnf("%s", hint)
+	}
+	return p, nil
+}
+
+// start starts reading the lines of the file.
+func (d *DeepCopyParser) start() {
+	d.scanner = internal.NewLineScanner(d.file)
+	d.checks = newDepthChecks(d.indent)
+	d.weightType = &internal.ValueType{}
+}
+
+// next advances to the next line of the call tree and checks its depth. It
+// returns false at the end of the input or when the heaviest stack follows
+// the call tree, which is ignored since its frames are already in the tree.
+func (d *DeepCopyParser) next() bool {
+	if !d.scanner.Scan() {
+		return false
+	}
+	line := strings.TrimSpace(d.scanner.Text())
+	if d.inTree && isHeaviestStackHeader(line) {
+		internal.Warnf("Ignoring the heaviest stack copied after the call tree, from line %s", d.scanner.Position())
+		for ok := true; ok; ok = d.scanner.Scan() {
+			if strings.TrimSpace(d.scanner.Text()) != "" {
+				internal.Reject(d.scanner.Position(), d.scanner.Text(), "heaviest stack after the call tree")
+			}
+		}
+		return false
+	}
+	if d.header == nil && isHeader(line) {
+		d.setHeader(line)
+	}
+	d.inTree = d.inTree || (line != "" && !isHeader(line))
+	d.checks.add(d, d.scanner.Position().Line-1, line)
+	return true
+}
+
+func (d *DeepCopyParser) parseProfile() (p *internal.TimeProfile, err error) {
 	// TODO: Implement parsing in the struct.
 	p = &internal.TimeProfile{}
 
@@ -52,8 +438,20 @@ func (d DeepCopyParser) ParseProfile() (p *internal.TimeProfile, err error) {
 	var lastFrame *internal.Frame = nil
 	var currentProcess *internal.Process = nil
 	var currentThread *internal.Thread = nil
-	for _, line := range d.lines {
-		line = strings.TrimSpace(line)
+	// totalNs is the weight of the totals row some exports have before the
+	// first process, or -1.
+	var totalNs int64 = -1
+	// rows are the total weights of the rows, to detect filtered call trees.
+	rows := make(totals)
+	// remainder is the rounding error carried to the next weight, see
+	// DistributeRounding.
+	var remainder float64
+	// threadLines are the lines of threads without tid, see
+	// internal.CheckThreadIDs.
+	threadLines := make(map[int]string)
+	for d.next() {
+		position := d.scanner.Position()
+		line := strings.TrimSpace(d.scanner.Text())
 		if line == "" {
 			// Process end. Start again with new process.
 			currentProcess = nil
@@ -64,34 +462,52 @@ func (d DeepCopyParser) ParseProfile() (p *internal.TimeProfile, err error) {
 		// Try to fetch process
 		if currentProcess == nil {
 			// Header line
-			if line == "Weight\tSelf Weight\t\tSymbol Name" {
+			if isHeader(line) {
+				continue
+			}
+			if len(p.Processes) == 0 && totalNs < 0 && d.isTotalsRow(line) {
+				totalNs, err = d.parseTotalWeight(line)
+				if err != nil {
+					return nil, fmt.Errorf("Error parsing totals row: %v", err)
+				}
 				continue
 			}
-			f, err := parseLine(line)
+			f, err := d.parseRow(line, &remainder)
 			if err != nil {
 				return nil, fmt.Errorf("Error parsing process frame: %v", err)
 			}
+			f.Position = position
 			currentProcess, err = newProcessFromFrame(f)
 			if err != nil {
 				return nil, err
 			}
+			currentProcess.Position = position
+			rows.add(currentProcess, d.rowTotal(line))
 			p.Processes = append(p.Processes, currentProcess)
 		} else if currentThread == nil {
-			f, err := parseLine(line)
+			d.learnIndent(line)
+			f, err := d.parseRow(line, &remainder)
 			if err != nil {
 				return nil, fmt.Errorf("Error parsing thread frame: %v", err)
 			}
+			f.Position = position
 			currentThread, err = newThreadFromFrame(f)
 			if err != nil {
 				return nil, err
 			}
+			currentThread.Position = position
+			rows.add(currentThread, d.rowTotal(line))
 			currentProcess.Threads = append(currentProcess.Threads, currentThread)
+			if currentThread.Tid == 0 {
+				threadLines[position.Line] = d.scanner.Text()
+			}
 		} else {
 			// Parse frame
-			currentFrame, err := parseLine(line)
+			currentFrame, err := d.parseRow(line, &remainder)
 			if err != nil {
 				return nil, err
 			}
+			currentFrame.Position = position
 			if currentFrame.Depth == 0 {
 				return nil, fmt.Errorf("Unexpected new process, should have occurred after header line %s", line)
 			}
@@ -101,10 +517,20 @@ func (d DeepCopyParser) ParseProfile() (p *internal.TimeProfile, err error) {
 				if err != nil {
 					return nil, fmt.Errorf("Error parsing thread frame: %v", err)
 				}
+				currentThread.Position = position
+				rows.add(currentThread, d.rowTotal(line))
 				currentProcess.Threads = append(currentProcess.Threads, currentThread)
+				if currentThread.Tid == 0 {
+					threadLines[position.Line] = d.scanner.Text()
+				}
 				lastFrame = nil
 				continue
 			}
+			total := d.rowTotal(line)
+			rows.add(currentFrame, total)
+			if total > 0 {
+				currentFrame.TotalWeightNs = total
+			}
 			if lastFrame == nil {
 				// First frame in thread.
 				if currentFrame.Depth != 2 {
@@ -141,9 +567,226 @@ func (d DeepCopyParser) ParseProfile() (p *internal.TimeProfile, err error) {
 			lastFrame = currentFrame
 		}
 	}
+	if err := d.scanner.Err(); err != nil {
+		return nil, err
+	}
+	if d.selfWeightColumn < 0 {
+		for _, proc := range p.Processes {
+			for _, th := range proc.Threads {
+				for _, f := range th.Frames {
+					selfWeights(f)
+				}
+			}
+		}
+	}
+	if len(d.extraColumns) > 0 {
+		for _, column := range d.extraColumns {
+			p.ExtraValueTypes = append(p.ExtraValueTypes, column.valueType)
+		}
+		for _, proc := range p.Processes {
+			for _, th := range proc.Threads {
+				for _, f := range th.Frames {
+					selfExtraWeights(f)
+				}
+			}
+		}
+	}
+	internal.CheckThreadIDs(p, threadLines)
+	internal.DisambiguateThreads(p)
+	addFilteredFrames(p, rows)
+	if totalNs >= 0 {
+		checkTotal(p, totalNs)
+	}
+	if t := *d.weightType; t != (internal.ValueType{}) && t != internal.CPUValueType {
+		p.ValueType = t
+	}
+	// Call trees recorded with all thread states can be separated by state.
+	internal.SplitThreadStates(p)
 	return p, nil
 }
 
+// isTotalsRow reports whether the current line is a totals row: a row at the
+// depth of processes directly followed by another one, so it has no threads.
+func (d DeepCopyParser) isTotalsRow(line string) bool {
+	next, ok := d.scanner.Peek()
+	if next = strings.TrimSpace(next); !ok || next == "" {
+		return false
+	}
+	row, err := d.parseLine(line)
+	if err != nil || row.Depth != 0 {
+		return false
+	}
+	nextRow, err := d.parseLine(next)
+	return err == nil && nextRow.Depth == 0
+}
+
+// parseTotalWeight parses the total weight column of a line, e.g.
+// "10.0 s  100%".
+func (d DeepCopyParser) parseTotalWeight(line string) (int64, error) {
+	columns, err := d.splitFields(line)
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(columns[d.weightColumn])
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("Total weight not parsable: %s", line)
+	}
+	return parseSelfWeight(fields[0]+" "+fields[1], UpperBound)
+}
+
+// rowTotal returns the total weight of a row, including its children, or -1
+// if it can't be parsed, e.g. for weights too small to display.
+func (d DeepCopyParser) rowTotal(line string) int64 {
+	fields, err := d.splitFields(line)
+	if err != nil {
+		return -1
+	}
+	text := withoutPercentage(fields[d.weightColumn])
+	for _, prefix := range boundPrefixes {
+		if strings.HasPrefix(text, prefix) {
+			return -1
+		}
+	}
+	total, err := parseSelfWeight(text, UpperBound)
+	if err != nil {
+		return -1
+	}
+	return total
+}
+
+// totals are the total weights of the processes, threads and frames of the
+// call tree, by row.
+type totals map[interface{}]int64
+
+func (t totals) add(row interface{}, total int64) {
+	if total >= 0 {
+		t[row] = total
+	}
+}
+
+// sum returns the sum of the totals of the frames, and false if one of them
+// is unknown.
+func (t totals) sum(frames []*internal.Frame) (int64, bool) {
+	var sum int64
+	for _, f := range frames {
+		total, ok := t[f]
+		if !ok {
+			return 0, false
+		}
+		sum += total
+	}
+	return sum, true
+}
+
+// filteredTolerance is the part of a row's total weight that may be missing
+// from its self weight and children, since Instruments rounds the displayed
+// weights.
+const filteredTolerance = 0.01
+
+// missingWeight returns the weight of a row that is neither its self weight
+// nor in its children, or 0 if it's within the rounding of the weights.
+func missingWeight(total int64, self int64, children int64) int64 {
+	missing := total - self - children
+	if float64(missing) <= filteredTolerance*float64(total) {
+		return 0
+	}
+	return missing
+}
+
+// addFilteredFrames adds "[filtered out]" frames with the weight missing from
+// the rows of a call tree copied with filters like "Show Obj-C Only" or
+// "Hide System Libraries", whose rows don't add up to their totals, and notes
+// the filtering in the profile's comments.
+func addFilteredFrames(p *internal.TimeProfile, rows totals) {
+	var filteredNs int64
+	newFrame := func(parent *internal.Frame, depth int, weight int64) *internal.Frame {
+		filteredNs += weight
+		return &internal.Frame{
+			Parent:       parent,
+			Children:     make([]*internal.Frame, 0),
+			SelfWeightNs: weight,
+			SymbolName:   internal.FilteredFrameName,
+			Depth:        depth,
+		}
+	}
+	var addToFrame func(f *internal.Frame)
+	addToFrame = func(f *internal.Frame) {
+		for _, child := range f.Children {
+			addToFrame(child)
+		}
+		total, ok := rows[f]
+		children, known := rows.sum(f.Children)
+		if !ok || !known {
+			return
+		}
+		if missing := missingWeight(total, f.SelfWeightNs, children); missing > 0 {
+			f.Children = append(f.Children, newFrame(f, f.Depth+1, missing))
+		}
+	}
+	for _, proc := range p.Processes {
+		var threadsNs int64
+		threadsKnown := true
+		for _, th := range proc.Threads {
+			for _, f := range th.Frames {
+				addToFrame(f)
+			}
+			total, ok := rows[th]
+			frames, known := rows.sum(th.Frames)
+			threadsNs += total
+			threadsKnown = threadsKnown && ok
+			if !ok || !known {
+				continue
+			}
+			if missing := missingWeight(total, 0, frames); missing > 0 {
+				th.Frames = append(th.Frames, newFrame(nil, 2, missing))
+			}
+		}
+		total, ok := rows[proc]
+		if !ok || !threadsKnown {
+			continue
+		}
+		if missing := missingWeight(total, 0, threadsNs); missing > 0 {
+			proc.Threads = append(proc.Threads, &internal.Thread{
+				Name:   internal.FilteredFrameName,
+				Frames: []*internal.Frame{newFrame(nil, 2, missing)},
+			})
+		}
+	}
+	if filteredNs > 0 {
+		comment := fmt.Sprintf("The call tree was filtered, e.g. with \"Show Obj-C Only\": %d ns of its weight are in %s frames.",
+			filteredNs, internal.FilteredFrameName)
+		p.Comments = append(p.Comments, comment)
+		internal.Warnf("%s", comment)
+	}
+}
+
+// totalTolerance is the relative difference allowed between the totals row
+// and the parsed weights, since Instruments rounds the displayed weights.
+const totalTolerance = 0.01
+
+// checkTotal warns if the weights of the profile don't add up to the totals
+// row, which suggests that part of the export was not parsed.
+func checkTotal(p *internal.TimeProfile, totalNs int64) {
+	var sum int64
+	var add func(f *internal.Frame)
+	add = func(f *internal.Frame) {
+		sum += f.SelfWeightNs
+		for _, child := range f.Children {
+			add(child)
+		}
+	}
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			for _, f := range th.Frames {
+				add(f)
+			}
+		}
+	}
+	if diff := math.Abs(float64(sum - totalNs)); diff > totalTolerance*float64(totalNs) {
+		internal.Warnf("Parsed weights add up to %d ns, but the totals row is %d ns.", sum, totalNs)
+	}
+}
+
 func newThreadFromFrame(f *internal.Frame) (*internal.Thread, error) {
 	if f.Depth != 1 {
 		return nil, fmt.Errorf("Thread must have depth 1, was %d: %v", f.Depth, f)
@@ -152,7 +795,8 @@ func newThreadFromFrame(f *internal.Frame) (*internal.Thread, error) {
 	threadRe := regexp.MustCompile(`(.*)\s\s0x([0-9a-f]+)$`)
 	matches := threadRe.FindStringSubmatch(f.SymbolName)
 	if len(matches) != 3 {
-		fmt.Printf("WARNING: Error parsing thread '%s'. Skipping thread name parsing.\n", f.SymbolName)
+		internal.Warnf("Error parsing thread '%s'. Skipping thread name parsing.", f.SymbolName)
+		internal.Reject(f.Position, f.SymbolName, "thread without a tid")
 		return &internal.Thread{
 			Name:   f.SymbolName,
 			Tid:    0,
@@ -161,7 +805,8 @@ func newThreadFromFrame(f *internal.Frame) (*internal.Thread, error) {
 	}
 	tid, err := strconv.ParseUint(matches[2], 16, 64)
 	if err != nil {
-		fmt.Printf("WARNING: Error parsing tid '%s'. Skipping thread id parsing. %v\n", matches[2], err)
+		internal.Warnf("Error parsing tid '%s'. Skipping thread id parsing. %v", matches[2], err)
+		internal.Reject(f.Position, f.SymbolName, "invalid tid")
 		tid = 0
 	}
 	return &internal.Thread{
@@ -179,7 +824,8 @@ func newProcessFromFrame(f *internal.Frame) (*internal.Process, error) {
 	processRe := regexp.MustCompile(`(.*)\s\((\d+)\)$`)
 	matches := processRe.FindStringSubmatch(f.SymbolName)
 	if len(matches) != 3 {
-		fmt.Printf("WARNING: Error parsing process '%s'. Skipping process name parsing.\n", f.SymbolName)
+		internal.Warnf("Error parsing process '%s'. Skipping process name parsing.", f.SymbolName)
+		internal.Reject(f.Position, f.SymbolName, "process without a pid")
 		return &internal.Process{
 			Name:    f.SymbolName,
 			Pid:     0,
@@ -188,7 +834,8 @@ func newProcessFromFrame(f *internal.Frame) (*internal.Process, error) {
 	}
 	pid, err := strconv.ParseUint(matches[2], 10, 64)
 	if err != nil {
-		fmt.Printf("WARNING: Error parsing pid '%s'. Skipping process id parsing. %v\n", matches[2], err)
+		internal.Warnf("Error parsing pid '%s'. Skipping process id parsing. %v", matches[2], err)
+		internal.Reject(f.Position, f.SymbolName, "invalid pid")
 		pid = 0
 	}
 	return &internal.Process{
@@ -198,58 +845,376 @@ func newProcessFromFrame(f *internal.Frame) (*internal.Process, error) {
 	}, nil
 }
 
-func parseSelfWeight(selfWeightText string) (int64, error) {
-	// String is in the format "2.00 ms" where valid units
-	// that I know about are "s", "ms", "µs", and "ns".
-	// returns nanoseconds.
+// boundPrefixes mark weights too small for Instruments to display.
+var boundPrefixes = []string{"<=", "≤", "<"}
+
+// weightUnit is a unit of the weight columns, with the value type of the
+// weights and the factor to its unit.
+type weightUnit struct {
+	valueType internal.ValueType
+	scale     float64
+}
+
+// weightUnits are the units of the weights by their suffix: times for the
+// Time Profiler, cycles, e.g. "1.23 Gc", for the CPU Profiler and bytes,
+// e.g. "1.50 MB", for Allocations.
+var weightUnits = map[string]weightUnit{
+	"s":     {internal.CPUValueType, 1_000_000_000},
+	"ms":    {internal.CPUValueType, 1_000_000},
+	"µs":    {internal.CPUValueType, 1_000},
+	"ns":    {internal.CPUValueType, 1},
+	"Gc":    {internal.CyclesValueType, 1_000_000_000},
+	"Mc":    {internal.CyclesValueType, 1_000_000},
+	"Kc":    {internal.CyclesValueType, 1_000},
+	"c":     {internal.CyclesValueType, 1},
+	"GB":    {allocSpaceValueType, 1 << 30},
+	"MB":    {allocSpaceValueType, 1 << 20},
+	"KB":    {allocSpaceValueType, 1 << 10},
+	"Bytes": {allocSpaceValueType, 1},
+}
+
+// allocSpaceValueType is the value type of the bytes of the Allocations
+// instrument.
+var allocSpaceValueType = internal.ValueType{Type: "alloc_space", Unit: "bytes"}
 
-	fields := strings.Split(selfWeightText, " ")
+func parseSelfWeight(selfWeightText string, policy BoundPolicy) (int64, error) {
+	value, _, err := parseWeight(selfWeightText, policy)
+	return int64(value), err
+}
+
+func parseWeight(selfWeightText string, policy BoundPolicy) (float64, internal.ValueType, error) {
+	// String is in the format "2.00 ms" or "1.23 Gc", see weightUnits.
+	// Tiny weights are shown as an upper bound, "< 0.1 ms".
+	// returns fractional nanoseconds or cycles.
+
+	text := strings.TrimSpace(selfWeightText)
+	bounded := false
+	for _, prefix := range boundPrefixes {
+		if strings.HasPrefix(text, prefix) {
+			text = strings.TrimPrefix(text, prefix)
+			bounded = true
+			break
+		}
+	}
+	// Fields also splits on the non-breaking spaces of some locales.
+	fields := strings.Fields(text)
 	if len(fields) != 2 {
-		return 0, fmt.Errorf("Self weight not parsable: was not 2 fields in \"%s\"", selfWeightText)
+		return 0, internal.ValueType{}, fmt.Errorf("Self weight not parsable: was not 2 fields in \"%s\"", selfWeightText)
 	}
 	value, err := strconv.ParseFloat(fields[0], 64)
 	if err != nil {
-		return 0, fmt.Errorf("Could not parse self weight %s: %v", selfWeightText, err)
+		return 0, internal.ValueType{}, fmt.Errorf("Could not parse self weight %s: %v", selfWeightText, err)
 	}
-	switch fields[1] {
-	case "s":
-		value *= 1_000_000_000
-	case "ms":
-		value *= 1_000_000
-	case "µs":
-		value *= 1_000
-	case "ns":
-		value *= 1
-	default:
-		return 0, fmt.Errorf("Could not interpret time unit '%s' in %s", selfWeightText, fields[1])
+	unit, ok := weightUnits[fields[1]]
+	if !ok {
+		return 0, internal.ValueType{}, fmt.Errorf("Could not interpret unit '%s' in %s, expected a time like "+
+			"'2.00 ms' or cycles like '1.23 Gc'", fields[1], selfWeightText)
 	}
+	value *= unit.scale
+	if bounded && policy == ZeroBound {
+		return 0, unit.valueType, nil
+	}
+
+	return value, unit.valueType, nil
+}
 
-	return int64(value), nil
+func (d DeepCopyParser) parseLine(line string) (*internal.Frame, error) {
+	return d.parseRow(line, nil)
 }
 
-func parseLine(line string) (*internal.Frame, error) {
+// parseRow parses a row of the call tree, rounding its self weight with the
+// remainder of the previous rows, see RoundingPolicy.toNs.
+func (d DeepCopyParser) parseRow(line string, remainder *float64) (*internal.Frame, error) {
 	// Each line is tab seperated into 4 fields
 	// 1. Total weight "254.00 ms   22.5%"
 	// 2. Self weight "2.00ms"
 	// 3. A space
 	// 4. Depth (leading spaces) + Symbol name "    foo"
-	fields := strings.Split(line, "\t")
-	if len(fields) != 4 {
-		return nil, fmt.Errorf(
-			"Could not parse line \"%s\", only found %d tab-seperated fields",
-			line, len(fields))
+	// Optional columns, e.g. wakeups, come before the space. The weight
+	// columns can be reordered, see weightColumn.
+	fields, err := d.splitFields(line)
+	if err != nil {
+		return nil, err
 	}
-	weight, err := parseSelfWeight(fields[1])
+	selfWeight := fields[d.weightColumn]
+	if d.selfWeightColumn >= 0 {
+		selfWeight = fields[d.selfWeightColumn]
+	} else {
+		// The total for now, see selfWeights.
+		selfWeight = withoutPercentage(selfWeight)
+	}
+	value, valueType, err := parseWeight(selfWeight, d.boundPolicy)
 	if err != nil {
 		return nil, err
 	}
-	name := strings.TrimLeft(fields[3], " ")
-	depth := len(fields[3]) - len(name)
+	if err := d.checkWeightType(valueType, value); err != nil {
+		return nil, err
+	}
+	weight := d.rounding.toNs(value, remainder)
+	indent, name := splitIndent(fields[len(fields)-1])
+	depth := indent / d.getIndent()
+	var extraWeights []int64
+	for _, column := range d.extraColumns {
+		value, err := parseCount(fields[column.index])
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse %s in line \"%s\": %v", column.valueType.Type, line, err)
+		}
+		extraWeights = append(extraWeights, value)
+	}
 	return &internal.Frame{
 		Parent:       nil,
 		Children:     make([]*internal.Frame, 0),
 		SelfWeightNs: weight,
-		SymbolName:   name,
+		SymbolName:   detach(name),
 		Depth:        depth,
+		ExtraWeights: extraWeights,
 	}, nil
 }
+
+// checkWeightType learns the value type of the weights from the first row
+// with weight, and fails if a later row has weights of another type. Rows
+// without weight, e.g. "0 s" in a profile of cycles, can have any unit.
+func (d DeepCopyParser) checkWeightType(valueType internal.ValueType, value float64) error {
+	if value == 0 || d.weightType == nil {
+		return nil
+	}
+	if *d.weightType == (internal.ValueType{}) {
+		*d.weightType = valueType
+	} else if *d.weightType != valueType {
+		return fmt.Errorf("Weight in %s, but the previous rows are weighted in %s", valueType.Type, d.weightType.Type)
+	}
+	return nil
+}
+
+// detach copies a symbol name out of its line, so the frame doesn't keep the
+// whole line in memory.
+func detach(name string) string {
+	return string([]byte(name))
+}
+
+// parseCount parses the value of an optional column, e.g. "1,234" wakeups or
+// "1,234  12.5%" allocations. Empty cells are 0 and fractions are rounded.
+func parseCount(text string) (int64, error) {
+	text = strings.Replace(withoutPercentage(text), ",", "", -1)
+	if text == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(math.Round(value)), nil
+}
+
+// selfWeights turns the weights of deep copies without a self weight
+// column, which include the frame's children, into self weights.
+func selfWeights(f *internal.Frame) {
+	for _, child := range f.Children {
+		f.SelfWeightNs -= child.SelfWeightNs
+		selfWeights(child)
+	}
+	if f.SelfWeightNs < 0 {
+		f.SelfWeightNs = 0
+	}
+}
+
+// withoutPercentage returns the weight of a total weight column, e.g.
+// "1.50 MB" of "1.50 MB  12.5%".
+func withoutPercentage(totalWeight string) string {
+	fields := strings.Fields(totalWeight)
+	if len(fields) > 0 && strings.HasSuffix(fields[len(fields)-1], "%") {
+		fields = fields[:len(fields)-1]
+	}
+	return strings.Join(fields, " ")
+}
+
+// selfExtraWeights turns the values of the optional columns, which like the
+// total weight include the frame's children, into self values.
+func selfExtraWeights(f *internal.Frame) {
+	for _, child := range f.Children {
+		for i, w := range child.ExtraWeights {
+			if i < len(f.ExtraWeights) {
+				f.ExtraWeights[i] -= w
+			}
+		}
+		selfExtraWeights(child)
+	}
+	for i, w := range f.ExtraWeights {
+		if w < 0 {
+			f.ExtraWeights[i] = 0
+		}
+	}
+}
+
+// percentTolerance allows for the rounding of the displayed percentages.
+const percentTolerance = 0.05
+
+// parsePercentage parses the percentage of the total weight column, e.g.
+// "254.00 ms   22.5%".
+func parsePercentage(totalWeight string) (float64, bool) {
+	fields := strings.Fields(totalWeight)
+	if len(fields) == 0 || !strings.HasSuffix(fields[len(fields)-1], "%") {
+		return 0, false
+	}
+	text := strings.Replace(strings.TrimSuffix(fields[len(fields)-1], "%"), ",", ".", 1)
+	percent, err := strconv.ParseFloat(text, 64)
+	return percent, err == nil
+}
+
+// depthCheck finds the first row whose depth doesn't fit the rows above it
+// when indent spaces are one level of depth, or the learned indentation if
+// indent is 0: it is more than one level deeper than the previous row, or it
+// has a larger percentage of the total weight than its parent.
+type depthCheck struct {
+	indent int
+	// percentOfParent is set if the percentages are relative to the parent
+	// row.
+	percentOfParent bool
+	// parents are the percentages of the last row at each depth.
+	parents []float64
+	learned int
+	// inconsistent is the index of the first row that doesn't fit, or -1.
+	inconsistent int
+}
+
+// add checks the row at index with the percentage of its weight and the
+// number of spaces indenting its symbol name.
+func (c *depthCheck) add(index int, percent float64, spaces int) {
+	if c.inconsistent >= 0 {
+		return
+	}
+	width := c.indent
+	if width == 0 {
+		// The first indented line of a process is its first thread.
+		if c.learned == 0 && spaces > 0 {
+			c.learned = spaces
+		}
+		width = c.learned
+	}
+	if width == 0 {
+		width = 1
+	}
+	depth := spaces / width
+	if spaces%width != 0 || depth > len(c.parents) {
+		c.inconsistent = index
+		return
+	}
+	if depth > 0 && c.percentOfParent {
+		// Compare the rows by their percentage of the total.
+		percent *= c.parents[depth-1] / 100
+	}
+	if depth > 0 && percent > c.parents[depth-1]+percentTolerance {
+		c.inconsistent = index
+		return
+	}
+	c.parents = append(c.parents[:depth], percent)
+}
+
+// endProcess starts checking a new process at the blank line after the last.
+func (c *depthCheck) endProcess() {
+	c.parents = c.parents[:0]
+	c.learned = 0
+}
+
+// maxIndent is the largest indent depthHint tries.
+const maxIndent = 4
+
+// depthChecks check the depths of the rows while they are parsed, with the
+// indent of the parser and the indents depthHint suggests, for both modes of
+// the percentages.
+type depthChecks struct {
+	indent int
+	// ofTotal and ofParent are the checks by indent for percentages of the
+	// total and of the parent row.
+	ofTotal, ofParent map[int]*depthCheck
+	// scale is the percentage per nanosecond of the first row, see
+	// percentOfParent.
+	scale float64
+	// percentOfParent is set when the percentages of the weight column are
+	// relative to the parent row, as Instruments shows them with "Percent of
+	// Parent". Percentages of the total are proportional to the weights of
+	// the rows, so the first rows that aren't tell the modes apart.
+	percentOfParent bool
+	// sample is set if the input looks like the output of sample.
+	sample bool
+}
+
+func newDepthChecks(indent int) *depthChecks {
+	c := &depthChecks{
+		indent:   indent,
+		ofTotal:  make(map[int]*depthCheck),
+		ofParent: make(map[int]*depthCheck),
+	}
+	for i := 0; i <= maxIndent; i++ {
+		c.ofTotal[i] = &depthCheck{indent: i, inconsistent: -1}
+		c.ofParent[i] = &depthCheck{indent: i, percentOfParent: true, inconsistent: -1}
+	}
+	c.ofTotal[indent] = &depthCheck{indent: indent, inconsistent: -1}
+	c.ofParent[indent] = &depthCheck{indent: indent, percentOfParent: true, inconsistent: -1}
+	return c
+}
+
+// add checks the trimmed line at index, split into columns by d.
+func (c *depthChecks) add(d *DeepCopyParser, index int, line string) {
+	if strings.HasPrefix(line, "Analysis of sampling") || strings.HasPrefix(line, "Call graph:") {
+		c.sample = true
+	}
+	if line == "" {
+		for i := range c.ofTotal {
+			c.ofTotal[i].endProcess()
+			c.ofParent[i].endProcess()
+		}
+		return
+	}
+	fields, err := d.splitFields(line)
+	if err != nil {
+		return
+	}
+	percent, ok := parsePercentage(fields[d.weightColumn])
+	if !ok {
+		return
+	}
+	if total := d.rowTotal(line); percent != 0 && total > 0 && !c.percentOfParent {
+		if c.scale == 0 {
+			c.scale = percent / float64(total)
+		} else {
+			// Both the weights and the percentages are rounded.
+			expected := float64(total) * c.scale
+			c.percentOfParent = math.Abs(percent-expected) > 2*percentTolerance+expected*filteredTolerance
+		}
+	}
+	spaces, _ := splitIndent(fields[len(fields)-1])
+	for i := range c.ofTotal {
+		c.ofTotal[i].add(index, percent, spaces)
+		c.ofParent[i].add(index, percent, spaces)
+	}
+}
+
+// inconsistentRow returns the index of the first line whose depth doesn't fit
+// the lines above it with the indent, see depthCheck, or -1 if all lines fit.
+func (c *depthChecks) inconsistentRow(indent int) int {
+	if c.percentOfParent {
+		return c.ofParent[indent].inconsistent
+	}
+	return c.ofTotal[indent].inconsistent
+}
+
+// depthHint explains lines whose depth was likely detected wrong, suggesting
+// the settings that would parse the input, or returns "" if all lines fit.
+func (c *depthChecks) depthHint() string {
+	if c.sample {
+		return "The input looks like the output of sample, convert it with --format=sample."
+	}
+	index := c.inconsistentRow(c.indent)
+	if index < 0 {
+		return ""
+	}
+	hint := fmt.Sprintf("Line %d is deeper than its parent allows or has a larger percentage than its parent, "+
+		"so the depth of the lines was likely detected wrong.", index+1)
+	for indent := 1; indent <= maxIndent; indent++ {
+		if indent != c.indent && c.inconsistentRow(indent) < 0 {
+			return hint + fmt.Sprintf(" All lines fit with --deep-copy-indent=%d.", indent)
+		}
+	}
+	return hint + " Was the indentation of the symbol names changed when copying?"
+}
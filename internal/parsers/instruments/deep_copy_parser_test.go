@@ -15,10 +15,24 @@
 package instruments
 
 import (
+	"fmt"
+	"reflect"
+	"runtime"
 	"strings"
 	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
 )
 
+// checkDepths reads the lines of the parser's input and returns the checks of
+// their depths.
+func checkDepths(parser DeepCopyParser) *depthChecks {
+	parser.start()
+	for parser.next() {
+	}
+	return parser.checks
+}
+
 func TestFrameTimeUnitParsing(t *testing.T) {
 	type testCase struct {
 		input string
@@ -42,10 +56,15 @@ func TestFrameTimeUnitParsing(t *testing.T) {
 			input: "100.00 ns",
 			expectedNs: 100,
 		},
+		{
+			// Cycles of the CPU Profiler instrument.
+			input: "1.50 Gc",
+			expectedNs: 1_500_000_000,
+		},
 	}
 
 	for _, c := range cases {
-		selfWeightNs, err := parseSelfWeight(c.input)
+		selfWeightNs, err := parseSelfWeight(c.input, UpperBound)
 		if err != nil {
 			t.Error(err)
 		} else if selfWeightNs != c.expectedNs {
@@ -135,3 +154,685 @@ func TestInvalidThreadAndProcessNames(t *testing.T) {
 		t.Errorf("Expected thread name %s was %s", "Thread 1 0x1ee7", got.Processes[0].Threads[0].Name)
 	}
 }
+
+func TestDeepCopyPositions(t *testing.T) {
+	const deepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+		"5.0 s  50%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"5.0 s  50%\t5.0 s\t \t  foo\n"
+
+	parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc := got.Processes[0]
+	if proc.Position.Line != 2 || proc.Position.Offset != 32 {
+		t.Errorf("Process position was %v, expected line 2 at offset 32", proc.Position)
+	}
+	th := proc.Threads[0]
+	if th.Position.Line != 3 || th.Position.Offset != 70 {
+		t.Errorf("Thread position was %v, expected line 3 at offset 70", th.Position)
+	}
+	foo := th.Frames[0]
+	if foo.Position.Line != 4 || foo.Position.Offset != 105 {
+		t.Errorf("Frame position was %v, expected line 4 at offset 105", foo.Position)
+	}
+}
+
+func TestBoundedWeightParsing(t *testing.T) {
+	type testCase struct {
+		input      string
+		policy     BoundPolicy
+		expectedNs int64
+	}
+	cases := []testCase{
+		{
+			input:      "< 0.1 ms",
+			policy:     UpperBound,
+			expectedNs: 100_000,
+		},
+		{
+			input:      "<0.1 ms",
+			policy:     UpperBound,
+			expectedNs: 100_000,
+		},
+		{
+			input:      "≤ 1.0 µs",
+			policy:     UpperBound,
+			expectedNs: 1_000,
+		},
+		{
+			input:      "< 0.1 ms",
+			policy:     ZeroBound,
+			expectedNs: 0,
+		},
+		{
+			// Unbounded weights ignore the policy.
+			input:      "2.0\u00a0ms",
+			policy:     ZeroBound,
+			expectedNs: 2_000_000,
+		},
+	}
+
+	for _, c := range cases {
+		selfWeightNs, err := parseSelfWeight(c.input, c.policy)
+		if err != nil {
+			t.Error(err)
+		} else if selfWeightNs != c.expectedNs {
+			t.Errorf("Parsing '%s' resulted %d ns. Expected %d ns.", c.input, selfWeightNs, c.expectedNs)
+		}
+	}
+}
+
+func TestThreadStateParsing(t *testing.T) {
+	const deepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+		"10.0 s  100%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"6.0 s  60%\t0 s\t \t  Running\n" +
+		"6.0 s  60%\t6.0 s\t \t   foo\n" +
+		"4.0 s  40%\t0 s\t \t  Blocked\n" +
+		"4.0 s  40%\t1.0 s\t \t   foo\n" +
+		"3.0 s  30%\t3.0 s\t \t    mach_msg_trap\n"
+
+	parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ValueType != internal.RunningValueType || len(got.ExtraValueTypes) != 1 ||
+		got.ExtraValueTypes[0] != internal.BlockedValueType {
+		t.Errorf("Expected running and blocked value types, got %v and %v", got.ValueType, got.ExtraValueTypes)
+	}
+	frames := got.Processes[0].Threads[0].Frames
+	if len(frames) != 2 {
+		t.Fatalf("Expected the state frames to be removed, got %v", frames)
+	}
+	running, blocked := frames[0], frames[1]
+	if running.SymbolName != "foo" || running.Depth != 2 || running.SelfWeightNs != 6_000_000_000 ||
+		running.Labels[internal.StateLabel] != "Running" {
+		t.Errorf("Unexpected running frame %v with labels %v", running, running.Labels)
+	}
+	if blocked.SymbolName != "foo" || blocked.SelfWeightNs != 0 || blocked.ExtraWeights[0] != 1_000_000_000 ||
+		blocked.Labels[internal.StateLabel] != "Blocked" {
+		t.Errorf("Unexpected blocked frame %v with weights %v and labels %v", blocked, blocked.ExtraWeights, blocked.Labels)
+	}
+	trap := blocked.Children[0]
+	if trap.Depth != 3 || trap.ExtraWeights[0] != 3_000_000_000 || trap.Parent != blocked {
+		t.Errorf("Unexpected blocked child %v with weights %v", trap, trap.ExtraWeights)
+	}
+}
+
+func TestTotalsRowSkipped(t *testing.T) {
+	const deepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tTotal\n" +
+		"6.0 s  60%\t0 s\t \tMain Process (123)\n" +
+		"6.0 s  60%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"6.0 s  60%\t6.0 s\t \t  foo\n" +
+		"\n" +
+		"4.0 s  40%\t0 s\t \tOther Process (456)\n" +
+		"4.0 s  40%\t0 s\t \t Thread 1  0x2ee7\n" +
+		"4.0 s  40%\t4.0 s\t \t  bar\n"
+
+	parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Processes) != 2 {
+		t.Fatalf("Expected 2 processes, got %v", got.Processes)
+	}
+	if got.Processes[0].Name != "Main Process" || got.Processes[0].Pid != 123 {
+		t.Errorf("Totals row was parsed as a process: %v", got.Processes[0])
+	}
+}
+
+func TestFilteredCallTree(t *testing.T) {
+	// Copied with "Show Obj-C Only": foo's callees and the second thread are
+	// hidden, but still counted in the totals.
+	const deepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+		"6.0 s  60%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"6.0 s  60%\t1.0 s\t \t  foo\n" +
+		"2.0 s  20%\t2.0 s\t \t   bar\n" +
+		"\n"
+
+	parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	threads := got.Processes[0].Threads
+	if len(threads) != 2 || threads[1].Name != "[filtered out]" {
+		t.Fatalf("Expected a [filtered out] thread for the hidden thread, got %v", threads)
+	}
+	if w := threads[1].Frames[0].SelfWeightNs; w != 4_000_000_000 {
+		t.Errorf("[filtered out] thread should have weight %d, was %d", 4_000_000_000, w)
+	}
+	foo := threads[0].Frames[0]
+	if len(foo.Children) != 2 || foo.Children[1].SymbolName != "[filtered out]" {
+		t.Fatalf("Expected a [filtered out] callee of foo, got %v", foo.Children)
+	}
+	if filtered := foo.Children[1]; filtered.SelfWeightNs != 3_000_000_000 || filtered.Parent != foo || filtered.Depth != 3 {
+		t.Errorf("[filtered out] callee was wrong: %v", filtered)
+	}
+	if len(got.Comments) != 1 || !strings.Contains(got.Comments[0], "7000000000 ns") {
+		t.Errorf("Expected a comment on the filtered weight, was %v", got.Comments)
+	}
+}
+
+func TestIndentationLearned(t *testing.T) {
+	cases := []struct {
+		name   string
+		indent string
+	}{
+		{"two spaces", "  "},
+		{"no-break spaces", "\u00a0"},
+		{"figure spaces", "\u2007\u2007"},
+	}
+	for _, c := range cases {
+		deepCopy := "Weight\tSelf Weight\t\tSymbol Name\n" +
+			"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+			"10.0 s  100%\t0 s\t \t" + c.indent + "Thread 1  0x1ee7\n" +
+			"10.0 s  100%\t2.0 s\t \t" + strings.Repeat(c.indent, 2) + "foo\n" +
+			"8.0 s  80%\t8.0 s\t \t" + strings.Repeat(c.indent, 3) + "bar\n" +
+			"\n" +
+			"5.0 s  100%\t0 s\t \tOther Process (456)\n" +
+			"5.0 s  100%\t0 s\t \t Thread 1  0x2ee7\n" +
+			"5.0 s  100%\t5.0 s\t \t  baz\n"
+
+		parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parser.ParseProfile()
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		bar := got.Processes[0].Threads[0].Frames[0].Children[0]
+		if bar.SymbolName != "bar" || bar.Depth != 3 {
+			t.Errorf("%s: unexpected frame %v", c.name, bar)
+		}
+		// Every process learns its own indentation.
+		if baz := got.Processes[1].Threads[0].Frames[0]; baz.SymbolName != "baz" || baz.Depth != 2 {
+			t.Errorf("%s: unexpected frame %v", c.name, baz)
+		}
+		parser, err = MakeDeepCopyParser(strings.NewReader(deepCopy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index := checkDepths(parser).inconsistentRow(0); index != -1 {
+			t.Errorf("%s: expected all lines to fit, line %d didn't", c.name, index+1)
+		}
+	}
+}
+
+func TestExplicitIndent(t *testing.T) {
+	const deepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+		"10.0 s  100%\t0 s\t \t  Thread 1  0x1ee7\n" +
+		"10.0 s  100%\t2.0 s\t \t    foo\n" +
+		"8.0 s  80%\t8.0 s\t \t      bar\n"
+
+	parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index := checkDepths(parser).inconsistentRow(1); index != 2 {
+		t.Errorf("Expected the thread line not to fit an indent of 1, got index %d", index)
+	}
+	parser, err = MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.WithIndent(2).ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar := got.Processes[0].Threads[0].Frames[0].Children[0]
+	if bar.SymbolName != "bar" || bar.Depth != 3 {
+		t.Errorf("Unexpected frame %v", bar)
+	}
+}
+
+func TestDepthHintPercentages(t *testing.T) {
+	// The copy lost a space of bar, which looks like a sibling of foo with a
+	// larger percentage than the thread.
+	const deepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+		"4.0 s  40%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"4.0 s  40%\t4.0 s\t \t  foo\n" +
+		"6.0 s  60%\t6.0 s\t \t  bar\n"
+
+	parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checks := checkDepths(parser)
+	if index := checks.inconsistentRow(1); index != 4 {
+		t.Errorf("Expected line 5 to be inconsistent, got index %d", index)
+	}
+	if hint := checks.depthHint(); !strings.Contains(hint, "Line 5") {
+		t.Errorf("Expected a hint for line 5, got %q", hint)
+	}
+}
+
+func TestDepthHintSuggestsFormat(t *testing.T) {
+	const sample = "Analysis of sampling Sandwich (pid 1234) every 1 millisecond\n" +
+		"Call graph:\n" +
+		"    2 Thread_1   DispatchQueue_1: com.apple.main-thread  (serial)\n"
+
+	parser, err := MakeDeepCopyParser(strings.NewReader(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = parser.ParseProfile()
+	if err == nil || !strings.Contains(err.Error(), "--format=sample") {
+		t.Errorf("Expected an error suggesting --format=sample, got %v", err)
+	}
+}
+
+func TestExtraColumnParsing(t *testing.T) {
+	const deepCopy = "Weight\tSelf Weight\tWakeups\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t1,200\t \tMain Process (123)\n" +
+		"10.0 s  100%\t0 s\t1,200\t \t Thread 1  0x1ee7\n" +
+		"10.0 s  100%\t4.0 s\t1,200\t \t  foo\n" +
+		"6.0 s  60%\t6.0 s\t200\t \t   bar\n"
+
+	parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.ExtraValueTypes) != 1 || got.ExtraValueTypes[0].Type != "wakeups" {
+		t.Fatalf("Expected a wakeups value type, got %v", got.ExtraValueTypes)
+	}
+	foo := got.Processes[0].Threads[0].Frames[0]
+	bar := foo.Children[0]
+	if foo.SelfWeightNs != 4_000_000_000 || foo.ExtraWeights[0] != 1_000 {
+		t.Errorf("Expected foo to have 1000 self wakeups, got %v", foo.ExtraWeights)
+	}
+	if bar.SelfWeightNs != 6_000_000_000 || bar.ExtraWeights[0] != 200 {
+		t.Errorf("Expected bar to have 200 self wakeups, got %v", bar.ExtraWeights)
+	}
+}
+
+func TestColumnSeparatorVariants(t *testing.T) {
+	const header = "Weight\tSelf Weight\t\tSymbol Name\n"
+	cases := []struct {
+		name     string
+		deepCopy string
+	}{
+		{
+			name: "trailing tabs",
+			deepCopy: header +
+				"10.0 s  100%\t0 s\t \tMain Process (123)\t\n" +
+				"10.0 s  100%\t0 s\t \t Thread 1  0x1ee7\t\n" +
+				"10.0 s  100%\t4.0 s\t \t  foo\t\n" +
+				"6.0 s  60%\t6.0 s\t \t   bar\t\n",
+		},
+		{
+			name: "double tab before the symbol",
+			deepCopy: header +
+				"10.0 s  100%\t0 s\t \t\tMain Process (123)\n" +
+				"10.0 s  100%\t0 s\t \t\t Thread 1  0x1ee7\n" +
+				"10.0 s  100%\t4.0 s\t \t\t  foo\n" +
+				"6.0 s  60%\t6.0 s\t \t\t   bar\n",
+		},
+		{
+			name: "double tab between the weights",
+			deepCopy: header +
+				"10.0 s  100%\t\t0 s\t \tMain Process (123)\n" +
+				"10.0 s  100%\t\t0 s\t \t Thread 1  0x1ee7\n" +
+				"10.0 s  100%\t\t4.0 s\t \t  foo\n" +
+				"6.0 s  60%\t\t6.0 s\t \t   bar\n",
+		},
+		{
+			name: "no blank column",
+			deepCopy: "Weight\tSelf Weight\tSymbol Name\n" +
+				"10.0 s  100%\t0 s\tMain Process (123)\n" +
+				"10.0 s  100%\t0 s\t Thread 1  0x1ee7\n" +
+				"10.0 s  100%\t4.0 s\t  foo\n" +
+				"6.0 s  60%\t6.0 s\t   bar\n",
+		},
+		{
+			name: "double tab in the header",
+			deepCopy: "Weight\tSelf Weight\t\t\tSymbol Name\t\n" +
+				"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+				"10.0 s  100%\t0 s\t \t Thread 1  0x1ee7\n" +
+				"10.0 s  100%\t4.0 s\t \t  foo\n" +
+				"6.0 s  60%\t6.0 s\t \t   bar\n",
+		},
+	}
+	for _, c := range cases {
+		parser, err := MakeDeepCopyParser(strings.NewReader(c.deepCopy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parser.ParseProfile()
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		th := got.Processes[0].Threads[0]
+		if got.Processes[0].Pid != 123 || th.Tid != 0x1ee7 {
+			t.Errorf("%s: unexpected process %v and thread %v", c.name, got.Processes[0], th)
+			continue
+		}
+		foo := th.Frames[0]
+		if foo.SymbolName != "foo" || foo.SelfWeightNs != 4_000_000_000 || len(foo.Children) != 1 ||
+			foo.Children[0].SymbolName != "bar" || foo.Children[0].SelfWeightNs != 6_000_000_000 {
+			t.Errorf("%s: unexpected frames %v", c.name, foo)
+		}
+	}
+}
+
+func TestHeaviestStackIgnored(t *testing.T) {
+	const tree = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+		"10.0 s  100%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"10.0 s  100%\t4.0 s\t \t  foo\n" +
+		"6.0 s  60%\t6.0 s\t \t   bar\n"
+	for _, heaviestStack := range []string{
+		"\nHeaviest Stack Trace\n10.0 s  100%\tfoo\n6.0 s  60%\tbar\n",
+		"\nWeight\tSymbol Name\n10.0 s  100%\tfoo\n6.0 s  60%\tbar\n",
+	} {
+		expectedParser, err := MakeDeepCopyParser(strings.NewReader(tree))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := expectedParser.ParseProfile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parser, err := MakeDeepCopyParser(strings.NewReader(tree + heaviestStack))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parser.ParseProfile()
+		if err != nil {
+			t.Fatalf("Failed to parse the tree followed by %q: %v", heaviestStack, err)
+		}
+		internal.TimeProfileEquals(t, got, expected)
+	}
+}
+
+func TestPercentOfParent(t *testing.T) {
+	const tree = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100.0%%\t0 s\t \tMain Process (123)\n" +
+		"4.0 s  40.0%%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"4.0 s  %s\t1.0 s\t \t  foo\n" +
+		"3.0 s  %s\t3.0 s\t \t   bar\n" +
+		"6.0 s  60.0%%\t6.0 s\t \t Thread 2  0x7ee1\n" +
+		"6.0 s  %s\t6.0 s\t \t  spin\n"
+	cases := []struct {
+		name            string
+		percentages     []interface{}
+		percentOfParent bool
+	}{
+		{"total", []interface{}{"40.0%", "30.0%", "60.0%"}, false},
+		{"parent", []interface{}{"100.0%", "75.0%", "100.0%"}, true},
+	}
+	for _, c := range cases {
+		parser, err := MakeDeepCopyParser(strings.NewReader(fmt.Sprintf(tree, c.percentages...)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checks := checkDepths(parser)
+		if checks.percentOfParent != c.percentOfParent {
+			t.Errorf("%s: expected percentOfParent %v", c.name, c.percentOfParent)
+		}
+		if hint := checks.depthHint(); hint != "" {
+			t.Errorf("%s: expected the percentages to fit, got %s", c.name, hint)
+		}
+	}
+}
+
+func TestTotalWeights(t *testing.T) {
+	const deepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+		"5.0 s  50%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"5.0 s  50%\t0 s\t \t  foo\n" +
+		"2.0 s  20%\t2.0 s\t \t   bar1\n" +
+		"3.0 s  30%\t3.0 s\t \t   bar2\n" +
+		"5.0 s  50%\t0 s\t \t Thread 2  0x7ee1\n" +
+		"5.0 s  50%\t5.0 s\t \t  spin\n"
+	parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo := got.Processes[0].Threads[0].Frames[0]
+	if foo.TotalWeightNs != 5_000_000_000 || foo.Children[0].TotalWeightNs != 2_000_000_000 {
+		t.Errorf("Expected the total weights of the Weight column, got %d and %d",
+			foo.TotalWeightNs, foo.Children[0].TotalWeightNs)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	const deepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"179.8 ns  100%\t0 ns\t \tMain Process (123)\n" +
+		"179.8 ns  100%\t0 ns\t \t Thread 1  0x1ee7\n" +
+		"179.8 ns  100%\t178 ns\t \t  foo\n" +
+		"0.6 ns  0%\t0.6 ns\t \t   bar1\n" +
+		"0.6 ns  0%\t0.6 ns\t \t   bar2\n" +
+		"0.6 ns  0%\t0.6 ns\t \t   bar3\n"
+	for _, c := range []struct {
+		policy   RoundingPolicy
+		expected []int64
+	}{
+		{FloorRounding, []int64{0, 0, 0}},
+		{NearestRounding, []int64{1, 1, 1}},
+		{DistributeRounding, []int64{1, 0, 1}},
+	} {
+		parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := parser.WithRounding(c.policy).ParseProfile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var weights []int64
+		for _, f := range got.Processes[0].Threads[0].Frames[0].Children {
+			weights = append(weights, f.SelfWeightNs)
+		}
+		if !reflect.DeepEqual(weights, c.expected) {
+			t.Errorf("Expected weights %v with policy %d, got %v", c.expected, c.policy, weights)
+		}
+	}
+}
+
+func TestParseRoundingPolicy(t *testing.T) {
+	if policy, err := ParseRoundingPolicy("distribute"); err != nil || policy != DistributeRounding {
+		t.Errorf("Expected distribute, got %d, %v", policy, err)
+	}
+	if _, err := ParseRoundingPolicy("ceil"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+func TestSelfWeightFirst(t *testing.T) {
+	const deepCopy = "Self Weight\tWeight\t\tSymbol Name\n" +
+		"0 s\t10.0 s  100%\t \tMain Process (123)\n" +
+		"0 s\t10.0 s  100%\t \t Thread 1  0x1ee7\n" +
+		"0 s\t10.0 s  100%\t \t  foo\n" +
+		"4.0 s\t4.0 s  40%\t \t   bar1\n" +
+		"6.0 s\t6.0 s  60%\t \t   bar2\n"
+	parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	foo := got.Processes[0].Threads[0].Frames[0]
+	if foo.SelfWeightNs != 0 || foo.TotalWeightNs != 10_000_000_000 {
+		t.Errorf("Expected foo to have no self weight and a total of 10s, got %d and %d",
+			foo.SelfWeightNs, foo.TotalWeightNs)
+	}
+	if bar1 := foo.Children[0]; bar1.SelfWeightNs != 4_000_000_000 {
+		t.Errorf("Expected bar1 to have a self weight of 4s, got %d", bar1.SelfWeightNs)
+	}
+}
+
+// largeDeepCopy returns a consistent deep copy of a process with many
+// threads and frames, like the exports of long traces.
+func largeDeepCopy(threads, frames int) string {
+	var b strings.Builder
+	total := threads * frames
+	b.WriteString("Weight\tSelf Weight\t\tSymbol Name\n")
+	fmt.Fprintf(&b, "%d.0 ms  100.0%%\t0 ms\t \tMain Process (123)\n", total)
+	for t := 0; t < threads; t++ {
+		fmt.Fprintf(&b, "%d.0 ms  %.1f%%\t0 ms\t \t Thread %d  0x%x\n", frames, 100*float64(frames)/float64(total), t, t+1)
+		for f := 0; f < frames; f++ {
+			fmt.Fprintf(&b, "1.0 ms  %.1f%%\t1.0 ms\t \t  function_%d(int, char const*)\n", 100/float64(total), f)
+		}
+	}
+	return b.String()
+}
+
+// heapGrowth returns the growth of the heap in use while the result of fn is
+// alive.
+func heapGrowth(fn func() interface{}) int64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	result := fn()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(result)
+	return int64(after.HeapAlloc) - int64(before.HeapAlloc)
+}
+
+// BenchmarkDeepCopyParserMemory reports the heap kept by the parsed profile
+// per byte of input, and the heap the lines of the input would take if they
+// were buffered, as the parser did before reading one line at a time.
+func BenchmarkDeepCopyParserMemory(b *testing.B) {
+	input := largeDeepCopy(100, 1000)
+	var parsed, buffered int64
+	for i := 0; i < b.N; i++ {
+		parsed += heapGrowth(func() interface{} {
+			parser, err := MakeDeepCopyParser(strings.NewReader(input))
+			if err != nil {
+				b.Fatal(err)
+			}
+			p, err := parser.ParseProfile()
+			if err != nil {
+				b.Fatal(err)
+			}
+			return p
+		})
+		buffered += heapGrowth(func() interface{} {
+			lines, offsets, err := internal.ScanLines(strings.NewReader(input))
+			if err != nil {
+				b.Fatal(err)
+			}
+			return []interface{}{lines, offsets}
+		})
+	}
+	perInputByte := func(heap int64) float64 {
+		return float64(heap) / float64(b.N) / float64(len(input))
+	}
+	b.ReportMetric(perInputByte(parsed), "parsed-heap/B")
+	b.ReportMetric(perInputByte(buffered), "buffered-lines-heap/B")
+}
+
+func TestCyclesParsing(t *testing.T) {
+	const deepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"1.50 Gc  100%\t0 c\t \tMain Process (123)\n" +
+		"1.50 Gc  100%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"1.50 Gc  100%\t1.00 Gc\t \t  foo\n" +
+		"500.00 Mc  33.3%\t500.00 Mc\t \t   bar\n"
+
+	parser, err := MakeDeepCopyParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetValueType() != internal.CyclesValueType {
+		t.Errorf("Expected weights in cycles, got %v", got.GetValueType())
+	}
+	foo := got.Processes[0].Threads[0].Frames[0]
+	if foo.SelfWeightNs != 1_000_000_000 || foo.Children[0].SelfWeightNs != 500_000_000 {
+		t.Errorf("Expected 1G cycles in foo and 500M in bar, got %d and %d",
+			foo.SelfWeightNs, foo.Children[0].SelfWeightNs)
+	}
+
+	const mixed = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+		"10.0 s  100%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"10.0 s  100%\t4.0 s\t \t  foo\n" +
+		"6.0 s  60%\t6.00 Gc\t \t   bar\n"
+	parser, err = MakeDeepCopyParser(strings.NewReader(mixed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err == nil || !strings.Contains(err.Error(), "weighted in cpu") {
+		t.Errorf("Expected an error for weights in cycles and time, got %v", err)
+	}
+}
+
+func TestAllocationsParsing(t *testing.T) {
+	const deepCopy = "Bytes Used\tCount\t\tSymbol Name\n" +
+		"3.00 MB  100.0%\t1,300  100.0%\t \tMain Process (123)\n" +
+		"3.00 MB  100.0%\t1,300  100.0%\t \t Thread 1  0x1ee7\n" +
+		"3.00 MB  100.0%\t1,300  100.0%\t \t  main\n" +
+		"2.00 MB  66.7%\t1,000  76.9%\t \t   makeSandwich\n" +
+		"512.00 KB  16.7%\t100  7.7%\t \t    malloc\n"
+
+	parser, err := MakeAllocationsParser(strings.NewReader(deepCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedTypes := []internal.ValueType{{Type: "alloc_objects", Unit: "count"}}
+	if got.GetValueType() != allocSpaceValueType || !reflect.DeepEqual(got.ExtraValueTypes, expectedTypes) {
+		t.Errorf("Expected alloc_space and alloc_objects, got %v and %v", got.GetValueType(), got.ExtraValueTypes)
+	}
+	main := got.Processes[0].Threads[0].Frames[0]
+	makeSandwich := main.Children[0]
+	malloc := makeSandwich.Children[0]
+	for _, test := range []struct {
+		frame          *internal.Frame
+		bytes, objects int64
+	}{
+		{main, 1 << 20, 300},
+		{makeSandwich, 1<<21 - 1<<19, 900},
+		{malloc, 1 << 19, 100},
+	} {
+		if test.frame.SelfWeightNs != test.bytes || test.frame.ExtraWeights[0] != test.objects {
+			t.Errorf("Expected %s to allocate %d bytes in %d objects itself, got %d in %v",
+				test.frame.SymbolName, test.bytes, test.objects, test.frame.SelfWeightNs, test.frame.ExtraWeights)
+		}
+	}
+	if caps := parser.Capabilities(); !caps.HasUnit("bytes") {
+		t.Errorf("Expected the capabilities to have bytes, got %v", caps.Units)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruments
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// Stack is a frame of a deep copy with self weight, passed to Stream.
+type Stack struct {
+	// Process and Thread are the process and thread of the frame, without
+	// their threads and frames.
+	Process *internal.Process
+	Thread  *internal.Thread
+	// Frames are the symbol names from the outermost frame of the thread to
+	// the frame. The slice is reused by the next call.
+	Frames    []string
+	Weight    int64
+	ValueType internal.ValueType
+}
+
+// openFrame is a frame of Stream whose callees are still being read.
+type openFrame struct {
+	name string
+	self int64
+}
+
+// Stream reads the deep copy one row at a time and calls fn for every frame
+// with self weight once the rows of its callees are read, without building
+// the call tree. Unlike ParseProfile, it doesn't add frames for the weight
+// of filtered out callees, nor split the threads by state. Returning an error
+// from fn stops the parsing with the error.
+func (d DeepCopyParser) Stream(fn func(Stack) error) error {
+	d.start()
+	var stopped error
+	err := d.stream(func(s Stack) error {
+		stopped = fn(s)
+		return stopped
+	})
+	if err != nil && err == stopped {
+		return err
+	}
+	hint := d.checks.depthHint()
+	if err != nil {
+		if hint != "" {
+			return fmt.Errorf("%v\n%s", err, hint)
+		}
+		return err
+	}
+	if hint != "" {
+		internal.Warnf("%s", hint)
+	}
+	return nil
+}
+
+func (d *DeepCopyParser) stream(fn func(Stack) error) error {
+	var process *internal.Process
+	var thread *internal.Thread
+	// open are the frames of the current stack, open[i] at depth i+2.
+	var open []openFrame
+	var names []string
+	var remainder float64
+	// closeTo emits the open frames deeper than depth, from the innermost.
+	closeTo := func(depth int) error {
+		for len(open) > depth-2 && len(open) > 0 {
+			last := open[len(open)-1]
+			if last.self > 0 {
+				valueType := *d.weightType
+				if valueType == (internal.ValueType{}) {
+					valueType = internal.CPUValueType
+				}
+				if err := fn(Stack{Process: process, Thread: thread, Frames: names[:len(open)],
+					Weight: last.self, ValueType: valueType}); err != nil {
+					return err
+				}
+			}
+			open = open[:len(open)-1]
+		}
+		return nil
+	}
+	seenProcess := false
+	for d.next() {
+		position := d.scanner.Position()
+		line := strings.TrimSpace(d.scanner.Text())
+		if line == "" {
+			if err := closeTo(0); err != nil {
+				return err
+			}
+			process, thread = nil, nil
+			continue
+		}
+		if process == nil {
+			if isHeader(line) {
+				continue
+			}
+			if !seenProcess && d.isTotalsRow(line) {
+				seenProcess = true
+				continue
+			}
+			f, err := d.parseRow(line, &remainder)
+			if err != nil {
+				return fmt.Errorf("Error parsing process frame: %v", err)
+			}
+			f.Position = position
+			if process, err = newProcessFromFrame(f); err != nil {
+				return err
+			}
+			seenProcess = true
+			continue
+		}
+		if thread == nil {
+			d.learnIndent(line)
+		}
+		f, err := d.parseRow(line, &remainder)
+		if err != nil {
+			return fmt.Errorf("Error parsing frame: %v", err)
+		}
+		f.Position = position
+		if err := closeTo(f.Depth); err != nil {
+			return err
+		}
+		switch {
+		case f.Depth == 0:
+			return fmt.Errorf("Unexpected new process, should have occurred after header line %s", line)
+		case f.Depth == 1:
+			if thread, err = newThreadFromFrame(f); err != nil {
+				return err
+			}
+			continue
+		case thread == nil:
+			return fmt.Errorf("Thread must have depth 1, was %d: %s", f.Depth, line)
+		case f.Depth-2 > len(open):
+			return fmt.Errorf("Skip children somehow?: %s", line)
+		}
+		if d.selfWeightColumn < 0 && len(open) > 0 {
+			// The weights include the callees, see selfWeights.
+			open[len(open)-1].self -= f.SelfWeightNs
+		}
+		open = append(open, openFrame{name: f.SymbolName, self: f.SelfWeightNs})
+		names = append(names[:len(open)-1], f.SymbolName)
+	}
+	if err := d.scanner.Err(); err != nil {
+		return err
+	}
+	return closeTo(0)
+}
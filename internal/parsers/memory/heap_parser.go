@@ -0,0 +1,145 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// HeapParser parses the output of `heap <pid>`, the objects allocated by
+// malloc by class.
+type HeapParser struct {
+	lines []string
+	// offsets of the start of each line in the input.
+	offsets []int64
+}
+
+func MakeHeapParser(file io.Reader) (p HeapParser, err error) {
+	p.lines, p.offsets, err = internal.ScanLines(file)
+	return p, err
+}
+
+var (
+	// All zones: 71284 nodes (8712560 bytes)
+	// Zone DefaultMallocZone_0x10a4f0000: 6834 nodes (512000 bytes)
+	heapSectionRe = regexp.MustCompile(`^(All zones|Zone \S+?):\s+\d+ nodes`)
+	//    COUNT      BYTES       AVG   CLASS_NAME    TYPE    BINARY
+	heapHeaderRe = regexp.MustCompile(`^COUNT\s+BYTES\s+AVG\s+CLASS_NAME\b`)
+	//     789      50496      64.0   NSString       ObjC    Foundation
+	// Objects that aren't instances of a class have no type and binary.
+	heapRowRe = regexp.MustCompile(`^(\d+)\s+(\d+)\s+[\d.]+\s+(.*?)(?:\s+(ObjC|CFType|Swift|C\+\+|C)\s+(\S+))?$`)
+)
+
+// allZones is the section of the objects of every malloc zone.
+const allZones = "All zones"
+
+// Capabilities of heap reports: the live allocations of a single process.
+func (h HeapParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		Units: []internal.ValueType{{Type: "inuse_space", Unit: "bytes"}, {Type: "inuse_objects", Unit: "count"}},
+	}
+}
+
+func (h HeapParser) ParseProfile() (*internal.TimeProfile, error) {
+	p := &internal.TimeProfile{
+		ValueType:       internal.ValueType{Type: "inuse_space", Unit: "bytes"},
+		ExtraValueTypes: []internal.ValueType{{Type: "inuse_objects", Unit: "count"}},
+	}
+	process, err := parseProcess(h.lines, h.offsets, "heap")
+	if err != nil {
+		return nil, err
+	}
+	var section *internal.Thread
+	var sections []*internal.Thread
+	// rows are the indexes of the lines of each section's table.
+	rows := make(map[*internal.Thread][]int)
+	inTable := false
+	for i, line := range h.lines {
+		trimmed := strings.TrimSpace(line)
+		if matches := heapSectionRe.FindStringSubmatch(trimmed); matches != nil {
+			section = &internal.Thread{Name: matches[1], Frames: make([]*internal.Frame, 0), Position: internal.PositionOf(h.offsets, i)}
+			sections = append(sections, section)
+			continue
+		}
+		if heapHeaderRe.MatchString(trimmed) {
+			if section == nil {
+				section = &internal.Thread{Name: allZones, Frames: make([]*internal.Frame, 0)}
+				sections = append(sections, section)
+			}
+			inTable = true
+			continue
+		}
+		if !inTable {
+			if trimmed != "" {
+				internal.Reject(internal.PositionOf(h.offsets, i), line, "outside of a class table")
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, "=") {
+			continue
+		}
+		if trimmed == "" {
+			inTable = false
+			section = nil
+			continue
+		}
+		matches := heapRowRe.FindStringSubmatch(trimmed)
+		if matches == nil {
+			return nil, fmt.Errorf("Could not parse heap row: %s", line)
+		}
+		count, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing count %s: %v", line, err)
+		}
+		bytes, err := strconv.ParseInt(matches[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing bytes %s: %v", line, err)
+		}
+		addGroup(section, matches[3], matches[5], []int64{bytes, count}, internal.PositionOf(h.offsets, i))
+		rows[section] = append(rows[section], i)
+	}
+	// The zones' objects are also in the all zones section.
+	for _, s := range sections {
+		if s.Name == allZones && len(s.Frames) > 0 {
+			for _, zone := range sections {
+				if zone == s {
+					continue
+				}
+				for _, i := range rows[zone] {
+					internal.Reject(internal.PositionOf(h.offsets, i), h.lines[i], "zone already counted in All zones")
+				}
+			}
+			sections = []*internal.Thread{s}
+			break
+		}
+	}
+	for _, s := range sections {
+		if len(s.Frames) > 0 {
+			process.Threads = append(process.Threads, s)
+		}
+	}
+	if len(process.Threads) == 0 {
+		return nil, errors.New("No class table found in heap output.")
+	}
+	p.Processes = append(p.Processes, process)
+	return p, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const validHeap = `Process:         Sandwich [1234]
+Path:            /Applications/Sandwich.app/Contents/MacOS/Sandwich
+Load Address:    0x10a3c0000
+Identifier:      com.example.Sandwich
+
+----
+
+Process 1234: 2 zones
+
+All zones: 6000 nodes (651840 bytes)
+
+   COUNT      BYTES       AVG   CLASS_NAME                                     TYPE    BINARY
+   =====      =====       ===   ==========                                     ====    ======
+    5000     600000     120.0   non-object
+     789      50496      64.0   NSString                                       ObjC    Foundation
+     211       1344       6.4   Swift closure context                          Swift   Sandwich
+
+Zone DefaultMallocZone_0x10a4f0000: 5000 nodes (600000 bytes)
+
+   COUNT      BYTES       AVG   CLASS_NAME                                     TYPE    BINARY
+   =====      =====       ===   ==========                                     ====    ======
+    5000     600000     120.0   non-object
+`
+
+func TestHeapParsing(t *testing.T) {
+	parser, err := MakeHeapParser(strings.NewReader(validHeap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := &internal.Thread{Name: "All zones"}
+	thread.AddStack([]string{"non-object"}, 600000)
+	thread.AddStack([]string{"NSString"}, 50496)
+	thread.AddStack([]string{"Swift closure context"}, 1344)
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{{Name: "Sandwich", Pid: 1234, Threads: []*internal.Thread{thread}}},
+	}
+	// The zone's objects are only counted in all zones.
+	internal.TimeProfileEquals(t, got, expected)
+
+	if got.ValueType.Type != "inuse_space" || got.ExtraValueTypes[0].Type != "inuse_objects" {
+		t.Errorf("Unexpected value types %v %v", got.ValueType, got.ExtraValueTypes)
+	}
+	nsString := got.Processes[0].Threads[0].Frames[1]
+	if nsString.Binary != "Foundation" || nsString.ExtraWeights[0] != 789 {
+		t.Errorf("Unexpected NSString frame %v, binary %s, objects %v", nsString, nsString.Binary, nsString.ExtraWeights)
+	}
+}
+
+func TestHeapWithoutTable(t *testing.T) {
+	parser, err := MakeHeapParser(strings.NewReader("Process:         Sandwich [1234]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err == nil {
+		t.Error("Expected an error for heap output without a class table")
+	}
+}
+
+func TestHeapRejects(t *testing.T) {
+	var rejects strings.Builder
+	internal.SetRejectOutput(&rejects)
+	defer internal.SetRejectOutput(nil)
+	parser, err := MakeHeapParser(strings.NewReader(validHeap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err != nil {
+		t.Fatal(err)
+	}
+	got := rejects.String()
+	if !strings.HasPrefix(got, "1\toutside of a class table\tProcess:") ||
+		!strings.HasSuffix(got, "22\tzone already counted in All zones\t    5000     600000     120.0   non-object\n") ||
+		strings.Contains(got, "NSString") {
+		t.Errorf("Expected the header and the rows of the zone to be rejected, got %q", got)
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// LeaksParser parses the output of `leaks <pid>`. Processes run with
+// MallocStackLogging have the stacks that allocated the leaks, otherwise the
+// leaks are grouped by type.
+type LeaksParser struct {
+	lines []string
+	// offsets of the start of each line in the input.
+	offsets []int64
+}
+
+func MakeLeaksParser(file io.Reader) (p LeaksParser, err error) {
+	p.lines, p.offsets, err = internal.ScanLines(file)
+	return p, err
+}
+
+var (
+	// STACK OF 2 INSTANCES OF 'ROOT LEAK: <NSMutableArray>':
+	leakStackRe = regexp.MustCompile(`^STACK OF \d+ INSTANCES? OF '(?:ROOT LEAK: |ROOT CYCLE: )?(.*)':$`)
+	// 4   Sandwich                              0x10a3c1f3e main + 30
+	leakFrameRe = regexp.MustCompile(`^(\d+)\s+(.*?)\s+0x[0-9a-fA-F]+\s+(.*?)(?:\s+\+\s+\d+)?$`)
+	// The first line below the stack has the leaks it allocated in total.
+	//     2 (128 bytes) << TOTAL >>
+	//     1 (1.50K) ROOT LEAK: <NSMutableArray 0x600000c04000> [1536]
+	leakTotalRe = regexp.MustCompile(`^(\d+) \((\d+(?:\.\d+)?) ?(bytes|[KMG]B?)\)`)
+	// Leak: 0x600000c04000  size=64  zone: DefaultMallocZone_0x10a4f0000   NSMutableArray  ObjC  CoreFoundation
+	leakRe = regexp.MustCompile(`^Leak: 0x[0-9a-fA-F]+\s+size=(\d+)\s+zone: \S+\s*(.*)$`)
+	// The type of a leak, NSMutableArray  ObjC  CoreFoundation
+	leakTypeRe = regexp.MustCompile(`^(\S+)\s+(?:ObjC|CFType|Swift|C\+\+)\s+(\S+)`)
+)
+
+type leakFrame struct {
+	index    int
+	symbol   string
+	binary   string
+	position internal.Position
+}
+
+// Capabilities of leaks reports: the leaked allocations of a single process.
+func (l LeaksParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		Units: []internal.ValueType{{Type: "leaked_space", Unit: "bytes"}, {Type: "leaked_objects", Unit: "count"}},
+	}
+}
+
+func (l LeaksParser) ParseProfile() (*internal.TimeProfile, error) {
+	p := &internal.TimeProfile{
+		ValueType:       internal.ValueType{Type: "leaked_space", Unit: "bytes"},
+		ExtraValueTypes: []internal.ValueType{{Type: "leaked_objects", Unit: "count"}},
+	}
+	process, err := parseProcess(l.lines, l.offsets, "leaks")
+	if err != nil {
+		return nil, err
+	}
+	thread := &internal.Thread{Name: "Leaks", Frames: make([]*internal.Frame, 0)}
+	stacks := false
+	var leak string
+	var frames []leakFrame
+	inStack := false
+	for i, line := range l.lines {
+		position := internal.PositionOf(l.offsets, i)
+		trimmed := strings.TrimSpace(line)
+		if matches := leakStackRe.FindStringSubmatch(trimmed); matches != nil {
+			leak, frames, inStack = matches[1], nil, true
+			stacks = true
+			continue
+		}
+		if inStack && trimmed == "====" {
+			inStack = false
+			continue
+		}
+		if inStack {
+			matches := leakFrameRe.FindStringSubmatch(trimmed)
+			if matches == nil {
+				return nil, fmt.Errorf("Could not parse leak stack line: %s", line)
+			}
+			index, err := strconv.Atoi(matches[1])
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing frame number %s: %v", line, err)
+			}
+			frames = append(frames, leakFrame{index: index, symbol: matches[3], binary: matches[2], position: position})
+			continue
+		}
+		if matches := leakTotalRe.FindStringSubmatch(trimmed); matches != nil && frames != nil {
+			count, size, err := parseAllocations(matches[1], matches[2], matches[3])
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing leaks %s: %v", line, err)
+			}
+			addLeak(thread, frames, leak, size, count)
+			frames = nil
+			continue
+		}
+		if matches := leakRe.FindStringSubmatch(trimmed); matches != nil && !stacks {
+			size, err := strconv.ParseInt(matches[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing leak size %s: %v", line, err)
+			}
+			name, binary := fmt.Sprintf("malloc<%d>", size), ""
+			if t := leakTypeRe.FindStringSubmatch(matches[2]); t != nil {
+				name, binary = t[1], t[2]
+			}
+			addGroup(thread, name, binary, []int64{size, 1}, position)
+			continue
+		}
+		if trimmed == "" {
+			continue
+		}
+		reason := "not a leak or leak stack"
+		if leakTotalRe.MatchString(trimmed) || leakRe.MatchString(trimmed) {
+			reason = "leak already counted in the total of its stack"
+		}
+		internal.Reject(position, line, reason)
+	}
+	if len(thread.Frames) == 0 {
+		return nil, errors.New("No leaks found in leaks output.")
+	}
+	process.Threads = append(process.Threads, thread)
+	p.Processes = append(p.Processes, process)
+	return p, nil
+}
+
+// addLeak adds the leaks allocated by a stack, with the type of the leaked
+// object as the innermost frame.
+func addLeak(thread *internal.Thread, frames []leakFrame, leak string, size int64, count int64) {
+	// Frames are numbered from the innermost one.
+	sort.SliceStable(frames, func(i, j int) bool { return frames[i].index > frames[j].index })
+	stack := make([]string, 0, len(frames)+1)
+	for _, f := range frames {
+		stack = append(stack, f.symbol)
+	}
+	stack = append(stack, leak)
+	leaf := thread.AddStack(stack, size)
+	if len(leaf.ExtraWeights) == 0 {
+		leaf.ExtraWeights = []int64{0}
+	}
+	leaf.ExtraWeights[0] += count
+	// Record the binaries and positions of the frames created for the stack.
+	f := leaf.Parent
+	for i := len(frames) - 1; f != nil; i, f = i-1, f.Parent {
+		if f.Binary == "" {
+			f.Binary = frames[i].binary
+			f.Position = frames[i].position
+		}
+	}
+}
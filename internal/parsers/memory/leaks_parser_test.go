@@ -0,0 +1,115 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const validLeaks = `Process:         Sandwich [1234]
+Path:            /Applications/Sandwich.app/Contents/MacOS/Sandwich
+
+leaks Report Version: 4.0
+Process 1234: 12345 nodes malloced for 2345 KB
+Process 1234: 3 leaks for 1664 total leaked bytes.
+
+STACK OF 2 INSTANCES OF 'ROOT LEAK: <NSMutableArray>':
+3   dyld                                  0x7fff2037a6f1 start + 1
+2   Sandwich                              0x10a3c1f3e main + 30
+1   Sandwich                              0x10a3c2000 makeSandwich + 5
+0   libobjc.A.dylib                       0x7fff20300000 _objc_rootAllocWithZone + 20
+====
+    2 (128 bytes) << TOTAL >>
+      1 (64 bytes) ROOT LEAK: <NSMutableArray 0x600000c04000> [64]
+      1 (64 bytes) ROOT LEAK: <NSMutableArray 0x600000c04040> [64]
+
+STACK OF 1 INSTANCE OF 'ROOT LEAK: malloc<1536>':
+2   dyld                                  0x7fff2037a6f1 start + 1
+1   Sandwich                              0x10a3c1f3e main + 40
+0   libsystem_malloc.dylib                0x7fff20200000 _malloc_zone_malloc + 78
+====
+    1 (1.50K) ROOT LEAK: 0x600000c08000 [1536]
+`
+
+func TestLeaksParsing(t *testing.T) {
+	parser, err := MakeLeaksParser(strings.NewReader(validLeaks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := &internal.Thread{Name: "Leaks"}
+	thread.AddStack([]string{"start", "main", "makeSandwich", "_objc_rootAllocWithZone", "<NSMutableArray>"}, 128)
+	thread.AddStack([]string{"start", "main", "_malloc_zone_malloc", "malloc<1536>"}, 1536)
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{{Name: "Sandwich", Pid: 1234, Threads: []*internal.Thread{thread}}},
+	}
+	internal.TimeProfileEquals(t, got, expected)
+
+	makeSandwich := got.Processes[0].Threads[0].Frames[0].Children[0].Children[0]
+	if makeSandwich.Binary != "Sandwich" || makeSandwich.Position.Line != 11 {
+		t.Errorf("Unexpected binary %s or position %v of makeSandwich", makeSandwich.Binary, makeSandwich.Position)
+	}
+	if leak := makeSandwich.Children[0].Children[0]; leak.ExtraWeights[0] != 2 {
+		t.Errorf("Expected 2 leaked objects, got %v", leak.ExtraWeights)
+	}
+}
+
+func TestLeaksWithoutStacks(t *testing.T) {
+	const leaks = `Process 1234: 2 leaks for 112 total leaked bytes.
+
+Leak: 0x600000c04000  size=64  zone: DefaultMallocZone_0x10a4f0000   NSMutableArray  ObjC  CoreFoundation
+Leak: 0x600000c08000  size=48  zone: DefaultMallocZone_0x10a4f0000
+`
+	parser, err := MakeLeaksParser(strings.NewReader(leaks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := &internal.Thread{Name: "Leaks"}
+	thread.AddStack([]string{"NSMutableArray"}, 64)
+	thread.AddStack([]string{"malloc<48>"}, 48)
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{{Name: "leaks", Threads: []*internal.Thread{thread}}},
+	}
+	internal.TimeProfileEquals(t, got, expected)
+}
+
+func TestLeaksRejects(t *testing.T) {
+	var rejects strings.Builder
+	internal.SetRejectOutput(&rejects)
+	defer internal.SetRejectOutput(nil)
+	parser, err := MakeLeaksParser(strings.NewReader(validLeaks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err != nil {
+		t.Fatal(err)
+	}
+	got := rejects.String()
+	if !strings.HasPrefix(got, "1\tnot a leak or leak stack\tProcess:") ||
+		!strings.Contains(got, "15\tleak already counted in the total of its stack\t      1 (64 bytes) ROOT LEAK") ||
+		strings.Contains(got, "STACK OF") || strings.Contains(got, "<< TOTAL >>") {
+		t.Errorf("Expected the header and the leaks of the totals to be rejected, got %q", got)
+	}
+}
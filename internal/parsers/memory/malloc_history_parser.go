@@ -0,0 +1,120 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// MallocHistoryParser parses the output of `malloc_history <pid> -allBySize`
+// or -allByCount, the live allocations of a process run with
+// MallocStackLogging by stack.
+type MallocHistoryParser struct {
+	lines []string
+	// offsets of the start of each line in the input.
+	offsets []int64
+}
+
+func MakeMallocHistoryParser(file io.Reader) (p MallocHistoryParser, err error) {
+	p.lines, p.offsets, err = internal.ScanLines(file)
+	return p, err
+}
+
+var (
+	// 2 calls for 128 bytes: thread_7fff8d3bb380 |start (in libdyld.dylib) | main (in Sandwich) | malloc (in libsystem_malloc.dylib)
+	allocationsRe = regexp.MustCompile(`^(\d+) calls? for (\d+(?:\.\d+)?) ?(bytes|[KMG]B?): (\S+) ?\|(.*)$`)
+	// main (in Sandwich) + 30
+	allocationFrameRe = regexp.MustCompile(`^(.*?)\s+\(in (.+?)\)(?:\s+\+\s+\d+)?$`)
+)
+
+// Capabilities of malloc_history reports: the allocations of a single
+// process.
+func (m MallocHistoryParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		Units: []internal.ValueType{{Type: "alloc_space", Unit: "bytes"}, {Type: "alloc_objects", Unit: "count"}},
+	}
+}
+
+func (m MallocHistoryParser) ParseProfile() (*internal.TimeProfile, error) {
+	p := &internal.TimeProfile{
+		ValueType:       internal.ValueType{Type: "alloc_space", Unit: "bytes"},
+		ExtraValueTypes: []internal.ValueType{{Type: "alloc_objects", Unit: "count"}},
+	}
+	process, err := parseProcess(m.lines, m.offsets, "malloc_history")
+	if err != nil {
+		return nil, err
+	}
+	threads := make(map[string]*internal.Thread)
+	for i, line := range m.lines {
+		matches := allocationsRe.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			if strings.TrimSpace(line) != "" {
+				internal.Reject(internal.PositionOf(m.offsets, i), line, "not an allocation stack")
+			}
+			continue
+		}
+		position := internal.PositionOf(m.offsets, i)
+		count, size, err := parseAllocations(matches[1], matches[2], matches[3])
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing allocations %s: %v", line, err)
+		}
+		thread, ok := threads[matches[4]]
+		if !ok {
+			thread = &internal.Thread{Name: matches[4], Frames: make([]*internal.Frame, 0), Position: position}
+			threads[matches[4]] = thread
+			process.Threads = append(process.Threads, thread)
+		}
+		var stack, binaries []string
+		for _, frame := range strings.Split(matches[5], "|") {
+			frame = strings.TrimSpace(frame)
+			if frame == "" {
+				continue
+			}
+			binary := ""
+			if f := allocationFrameRe.FindStringSubmatch(frame); f != nil {
+				frame, binary = f[1], f[2]
+			}
+			stack = append(stack, frame)
+			binaries = append(binaries, binary)
+		}
+		if len(stack) == 0 {
+			continue
+		}
+		leaf := thread.AddStack(stack, size)
+		if len(leaf.ExtraWeights) == 0 {
+			leaf.ExtraWeights = []int64{0}
+		}
+		leaf.ExtraWeights[0] += count
+		// Record the binaries and positions of the frames created for the stack.
+		f := leaf
+		for j := len(stack) - 1; f != nil; j, f = j-1, f.Parent {
+			if f.Binary == "" {
+				f.Binary = binaries[j]
+				f.Position = position
+			}
+		}
+	}
+	if len(process.Threads) == 0 {
+		return nil, errors.New("No allocations found in malloc_history output, was it run with -allBySize?")
+	}
+	p.Processes = append(p.Processes, process)
+	return p, nil
+}
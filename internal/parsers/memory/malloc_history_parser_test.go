@@ -0,0 +1,68 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const validMallocHistory = `malloc_history Report Version:  2.0
+Process:         Sandwich [1234]
+Path:            /Applications/Sandwich.app/Contents/MacOS/Sandwich
+
+----
+
+2 calls for 2.50K: thread_7fff8d3bb380 |start (in libdyld.dylib) | main (in Sandwich) + 30 | malloc (in libsystem_malloc.dylib)
+1 call for 64 bytes: thread_7fff8d3bb380 |start (in libdyld.dylib) | main (in Sandwich) | calloc (in libsystem_malloc.dylib)
+1 call for 16 bytes: thread_70000a1b2000 |start_wqthread (in libsystem_pthread.dylib) | malloc (in libsystem_malloc.dylib)
+`
+
+func TestMallocHistoryParsing(t *testing.T) {
+	parser, err := MakeMallocHistoryParser(strings.NewReader(validMallocHistory))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := &internal.Thread{Name: "thread_7fff8d3bb380"}
+	main.AddStack([]string{"start", "main", "malloc"}, 2560)
+	main.AddStack([]string{"start", "main", "calloc"}, 64)
+	worker := &internal.Thread{Name: "thread_70000a1b2000"}
+	worker.AddStack([]string{"start_wqthread", "malloc"}, 16)
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{{Name: "Sandwich", Pid: 1234, Threads: []*internal.Thread{main, worker}}},
+	}
+	internal.TimeProfileEquals(t, got, expected)
+
+	malloc := got.Processes[0].Threads[0].Frames[0].Children[0].Children[0]
+	if malloc.Binary != "libsystem_malloc.dylib" || malloc.ExtraWeights[0] != 2 {
+		t.Errorf("Unexpected binary %s or objects %v of malloc", malloc.Binary, malloc.ExtraWeights)
+	}
+}
+
+func TestMallocHistoryWithoutAllocations(t *testing.T) {
+	parser, err := MakeMallocHistoryParser(strings.NewReader("malloc_history Report Version:  2.0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err == nil {
+		t.Error("Expected an error for malloc_history output without allocations")
+	}
+}
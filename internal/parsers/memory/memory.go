@@ -0,0 +1,91 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memory parses the memory reports of the macOS heap, vmmap, leaks
+// and malloc_history tools. The "stacks" of heap and vmmap profiles are the class names or
+// region types the memory is grouped by.
+package memory
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// sizeUnits are the units of the sizes in the reports, in bytes.
+var sizeUnits = map[string]float64{
+	"B":     1,
+	"bytes": 1,
+	"K":     1 << 10,
+	"KB":    1 << 10,
+	"M":     1 << 20,
+	"MB":    1 << 20,
+	"G":     1 << 30,
+	"GB":    1 << 30,
+	"T":     1 << 40,
+}
+
+// Process:         Sandwich [1234]
+var processRe = regexp.MustCompile(`^Process:\s+(.*?)\s\[(\d+)\]`)
+
+// parseProcess returns the process of the report's header, named name if the
+// report has none.
+func parseProcess(lines []string, offsets []int64, name string) (*internal.Process, error) {
+	for i, line := range lines {
+		matches := processRe.FindStringSubmatch(strings.TrimSpace(line))
+		if matches == nil {
+			continue
+		}
+		pid, err := strconv.ParseUint(matches[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing pid %s: %v", line, err)
+		}
+		return &internal.Process{
+			Name:     matches[1],
+			Pid:      pid,
+			Threads:  make([]*internal.Thread, 0),
+			Position: internal.PositionOf(offsets, i),
+		}, nil
+	}
+	return &internal.Process{Name: name, Threads: make([]*internal.Thread, 0)}, nil
+}
+
+// parseAllocations parses the number of allocations and their size, e.g.
+// "2" and "1.50" "K".
+func parseAllocations(countText string, sizeText string, unit string) (count int64, size int64, err error) {
+	if count, err = strconv.ParseInt(countText, 10, 64); err != nil {
+		return 0, 0, err
+	}
+	value, err := strconv.ParseFloat(sizeText, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	return count, int64(value * sizeUnits[unit]), nil
+}
+
+// addGroup adds a frame for a group of memory, e.g. a class, to the thread.
+func addGroup(thread *internal.Thread, name string, binary string, weights []int64, position internal.Position) {
+	frame := thread.AddStack([]string{name}, weights[0])
+	frame.Position = position
+	frame.Binary = binary
+	for len(frame.ExtraWeights) < len(weights)-1 {
+		frame.ExtraWeights = append(frame.ExtraWeights, 0)
+	}
+	for i, w := range weights[1:] {
+		frame.ExtraWeights[i] += w
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// VmmapParser parses the output of `vmmap --summary <pid>`, the virtual
+// memory of a process by region type.
+type VmmapParser struct {
+	lines []string
+	// offsets of the start of each line in the input.
+	offsets []int64
+}
+
+func MakeVmmapParser(file io.Reader) (p VmmapParser, err error) {
+	p.lines, p.offsets, err = internal.ScanLines(file)
+	return p, err
+}
+
+var (
+	// 256K, 1.2G, the sizes of the regions.
+	regionSizeRe = regexp.MustCompile(`^(\d+(?:\.\d+)?)([BKMGT])$`)
+	// The region table's header is two lines, the column names are split
+	// between them.
+	//                                 VIRTUAL RESIDENT    DIRTY  SWAPPED ...
+	// REGION TYPE                        SIZE     SIZE     SIZE     SIZE ...
+	regionHeaderRe = regexp.MustCompile(`^REGION TYPE\s`)
+)
+
+// regionColumns are the columns converted to sample values, in the order of
+// the values. The first one the report has is the main value, dirty memory is
+// what counts towards the footprint.
+var regionColumns = []string{"DIRTY", "SWAPPED", "RESIDENT", "VIRTUAL"}
+
+// parseRegionSize parses a size of the region table, e.g. "1.2G", in bytes.
+func parseRegionSize(text string) (int64, error) {
+	matches := regionSizeRe.FindStringSubmatch(text)
+	if matches == nil {
+		return 0, fmt.Errorf("Region size not parsable: %s", text)
+	}
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(value * sizeUnits[matches[2]]), nil
+}
+
+// Capabilities of vmmap reports: the memory regions of a single process, in
+// bytes of the size columns of the report.
+func (v VmmapParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		Units: []internal.ValueType{{Unit: "bytes"}},
+	}
+}
+
+func (v VmmapParser) ParseProfile() (*internal.TimeProfile, error) {
+	process, err := parseProcess(v.lines, v.offsets, "vmmap")
+	if err != nil {
+		return nil, err
+	}
+	header := -1
+	for i, line := range v.lines {
+		if i > 0 && regionHeaderRe.MatchString(line) {
+			header = i
+			break
+		}
+	}
+	if header < 0 {
+		return nil, errors.New("No region table found in vmmap output, was it run with --summary?")
+	}
+	// The values of each row are in the order of the upper header line.
+	columns := strings.Fields(v.lines[header-1])
+	var indexes []int
+	p := &internal.TimeProfile{}
+	for _, name := range regionColumns {
+		for i, column := range columns {
+			if column != name {
+				continue
+			}
+			indexes = append(indexes, i)
+			valueType := internal.ValueType{Type: strings.ToLower(name), Unit: "bytes"}
+			if p.ValueType == (internal.ValueType{}) {
+				p.ValueType = valueType
+			} else {
+				p.ExtraValueTypes = append(p.ExtraValueTypes, valueType)
+			}
+		}
+	}
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("No size columns found in vmmap region table: %s", v.lines[header-1])
+	}
+	thread := &internal.Thread{Name: "Regions", Frames: make([]*internal.Frame, 0), Position: internal.PositionOf(v.offsets, header)}
+	separators := 0
+	// end is the index of the first line after the rows of the table.
+	end := len(v.lines)
+	for i := header + 1; i < len(v.lines); i++ {
+		trimmed := strings.TrimSpace(v.lines[i])
+		if strings.HasPrefix(trimmed, "===") {
+			// The second separator is above the totals.
+			if separators++; separators == 2 {
+				end = i
+				break
+			}
+			continue
+		}
+		if trimmed == "" {
+			end = i
+			break
+		}
+		// Region types can have spaces, the values start at the first size.
+		fields := strings.Fields(trimmed)
+		start := 0
+		for start < len(fields) && !regionSizeRe.MatchString(fields[start]) {
+			start++
+		}
+		if start == 0 || len(fields)-start < len(columns) {
+			return nil, fmt.Errorf("Could not parse vmmap row: %s", v.lines[i])
+		}
+		weights := make([]int64, len(indexes))
+		for j, index := range indexes {
+			if weights[j], err = parseRegionSize(fields[start+index]); err != nil {
+				return nil, fmt.Errorf("Error parsing vmmap row %s: %v", v.lines[i], err)
+			}
+		}
+		addGroup(thread, strings.Join(fields[:start], " "), "", weights, internal.PositionOf(v.offsets, i))
+	}
+	// The totals and the other tables, e.g. of the malloc zones, are left out.
+	for i, line := range v.lines {
+		trimmed := strings.TrimSpace(line)
+		if (i < header-1 || i >= end) && trimmed != "" && !strings.HasPrefix(trimmed, "===") {
+			internal.Reject(internal.PositionOf(v.offsets, i), line, "outside of the region table")
+		}
+	}
+	if len(thread.Frames) == 0 {
+		return nil, errors.New("No regions found in vmmap output.")
+	}
+	process.Threads = append(process.Threads, thread)
+	p.Processes = append(p.Processes, process)
+	return p, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const validVmmap = `Process:         Sandwich [1234]
+Path:            /Applications/Sandwich.app/Contents/MacOS/Sandwich
+
+ReadOnly portion of Libraries: Total=500.0M resident=200.0M(40%) swapped_out_or_unallocated=300.0M(60%)
+
+                                VIRTUAL RESIDENT    DIRTY  SWAPPED VOLATILE   NONVOL    EMPTY   REGION 
+REGION TYPE                        SIZE     SIZE     SIZE     SIZE     SIZE     SIZE     SIZE    COUNT (non-coalesced) 
+===========                     ======= ========    =====  ======= ========   ======    =====  ======= 
+Activity Tracing                   256K      32K      32K       0K       0K      32K       0K        1 
+MALLOC_SMALL                      16.0M    1.5M     1.5M      64K       0K       0K       0K        2         see MALLOC ZONE table below
+Memory Tag 253                      48K      48K      16K       0K       0K       0K       0K        3 
+===========                     ======= ========    =====  ======= ========   ======    =====  ======= 
+TOTAL                             16.3M    1.6M     1.5M      64K       0K      32K       0K        6 
+
+                                 VIRTUAL   RESIDENT      DIRTY    SWAPPED ALLOCATION      BYTES DIRTY+SWAP          REGION
+MALLOC ZONE                         SIZE       SIZE       SIZE       SIZE      COUNT  ALLOCATED  FRAG SIZE  % FRAG   COUNT
+===========                      =======  =========  =========  =========  =========  =========  =========  ======  ======
+DefaultMallocZone_0x10a4f0000      16.0M       1.5M       1.5M        64K      14329      1685K         0K      0%       2
+`
+
+func TestVmmapParsing(t *testing.T) {
+	parser, err := MakeVmmapParser(strings.NewReader(validVmmap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := &internal.Thread{Name: "Regions"}
+	thread.AddStack([]string{"Activity Tracing"}, 32<<10)
+	thread.AddStack([]string{"MALLOC_SMALL"}, 3<<19)
+	thread.AddStack([]string{"Memory Tag 253"}, 16<<10)
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{{Name: "Sandwich", Pid: 1234, Threads: []*internal.Thread{thread}}},
+	}
+	internal.TimeProfileEquals(t, got, expected)
+
+	if got.ValueType.Type != "dirty" || len(got.ExtraValueTypes) != 3 {
+		t.Fatalf("Unexpected value types %v %v", got.ValueType, got.ExtraValueTypes)
+	}
+	// swapped, resident and virtual.
+	malloc := got.Processes[0].Threads[0].Frames[1]
+	if expected := []int64{64 << 10, 3 << 19, 16 << 20}; len(malloc.ExtraWeights) != 3 ||
+		malloc.ExtraWeights[0] != expected[0] || malloc.ExtraWeights[1] != expected[1] || malloc.ExtraWeights[2] != expected[2] {
+		t.Errorf("Expected MALLOC_SMALL sizes %v, got %v", expected, malloc.ExtraWeights)
+	}
+}
+
+func TestVmmapWithoutSummary(t *testing.T) {
+	parser, err := MakeVmmapParser(strings.NewReader("Process:         Sandwich [1234]\n\n==== Non-writable regions for process 1234\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err == nil {
+		t.Error("Expected an error for vmmap output without a summary")
+	}
+}
+
+func TestVmmapRejects(t *testing.T) {
+	var rejects strings.Builder
+	internal.SetRejectOutput(&rejects)
+	defer internal.SetRejectOutput(nil)
+	parser, err := MakeVmmapParser(strings.NewReader(validVmmap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err != nil {
+		t.Fatal(err)
+	}
+	got := rejects.String()
+	for _, expected := range []string{"1\toutside of the region table\tProcess:", "\n13\toutside of the region table\tTOTAL",
+		"\n18\toutside of the region table\tDefaultMallocZone_0x10a4f0000"} {
+		if !strings.Contains("\n"+got, expected) {
+			t.Errorf("Expected %q to be rejected, got %q", expected, got)
+		}
+	}
+	for _, row := range []string{"REGION TYPE", "Activity Tracing", "MALLOC_SMALL", "Memory Tag 253"} {
+		if strings.Contains(got, row) {
+			t.Errorf("Expected the region table's %s line to be used, got %q", row, got)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrickit parses MetricKit diagnostic payloads.
+package metrickit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// callStackFrame is a node of an MXCallStackTree. The sub frames are the
+// functions called from this frame.
+type callStackFrame struct {
+	BinaryName                  string            `json:"binaryName"`
+	OffsetIntoBinaryTextSegment uint64            `json:"offsetIntoBinaryTextSegment"`
+	SampleCount                 int64             `json:"sampleCount"`
+	SubFrames                   []*callStackFrame `json:"subFrames"`
+}
+
+type callStack struct {
+	ThreadAttributed    bool              `json:"threadAttributed"`
+	CallStackRootFrames []*callStackFrame `json:"callStackRootFrames"`
+}
+
+// callStackTree is the JSON representation of an MXCallStackTree.
+type callStackTree struct {
+	CallStacks         []*callStack `json:"callStacks"`
+	CallStackPerThread bool         `json:"callStackPerThread"`
+}
+
+type diagnosticMetaData struct {
+	BundleIdentifier string `json:"bundleIdentifier"`
+}
+
+type diagnostic struct {
+	CallStackTree      *callStackTree     `json:"callStackTree"`
+	DiagnosticMetaData diagnosticMetaData `json:"diagnosticMetaData"`
+}
+
+// payload is the JSON representation of an MXDiagnosticPayload. A single
+// diagnostic or a bare MXCallStackTree are also accepted, in which case only
+// CallStackTree or CallStacks is set.
+type payload struct {
+	CPUExceptionDiagnostics       []*diagnostic  `json:"cpuExceptionDiagnostics"`
+	HangDiagnostics               []*diagnostic  `json:"hangDiagnostics"`
+	DiskWriteExceptionDiagnostics []*diagnostic  `json:"diskWriteExceptionDiagnostics"`
+	CrashDiagnostics              []*diagnostic  `json:"crashDiagnostics"`
+	CallStackTree                 *callStackTree `json:"callStackTree"`
+	CallStacks                    []*callStack   `json:"callStacks"`
+}
+
+type MetricKitParser struct {
+	payload payload
+}
+
+func MakeMetricKitParser(file io.Reader) (p MetricKitParser, err error) {
+	decoder := json.NewDecoder(file)
+	if err := decoder.Decode(&p.payload); err != nil {
+		return p, fmt.Errorf("Could not decode MetricKit JSON: %v", err)
+	}
+	return p, nil
+}
+
+// Capabilities of MetricKit payloads: sample counts of the call stacks of a
+// single app, whose threads have no tids.
+func (m MetricKitParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		Units: []internal.ValueType{{Type: "samples", Unit: "count"}},
+	}
+}
+
+func (m MetricKitParser) ParseProfile() (p *internal.TimeProfile, err error) {
+	p = &internal.TimeProfile{
+		ValueType: internal.ValueType{Type: "samples", Unit: "count"},
+	}
+	tree := m.payload.CallStackTree
+	if len(m.payload.CallStacks) > 0 {
+		tree = &callStackTree{CallStacks: m.payload.CallStacks}
+	}
+	if tree != nil {
+		process, err := parseCallStackTree("MetricKit", tree)
+		if err != nil {
+			return nil, err
+		}
+		p.Processes = append(p.Processes, process)
+		return p, nil
+	}
+	kinds := []struct {
+		name        string
+		diagnostics []*diagnostic
+	}{
+		{"CPU Exception", m.payload.CPUExceptionDiagnostics},
+		{"Hang", m.payload.HangDiagnostics},
+		{"Disk Write Exception", m.payload.DiskWriteExceptionDiagnostics},
+		{"Crash", m.payload.CrashDiagnostics},
+	}
+	for _, kind := range kinds {
+		for i, d := range kind.diagnostics {
+			if d.CallStackTree == nil {
+				continue
+			}
+			name := fmt.Sprintf("%s %d", kind.name, i+1)
+			if d.DiagnosticMetaData.BundleIdentifier != "" {
+				name = fmt.Sprintf("%s %s", d.DiagnosticMetaData.BundleIdentifier, name)
+			}
+			process, err := parseCallStackTree(name, d.CallStackTree)
+			if err != nil {
+				return nil, err
+			}
+			p.Processes = append(p.Processes, process)
+		}
+	}
+	if len(p.Processes) == 0 {
+		return nil, errors.New("No call stack trees found in MetricKit payload.")
+	}
+	return p, nil
+}
+
+func parseCallStackTree(name string, tree *callStackTree) (*internal.Process, error) {
+	process := &internal.Process{
+		Name:    name,
+		Threads: make([]*internal.Thread, 0),
+	}
+	for i, stack := range tree.CallStacks {
+		thread := &internal.Thread{
+			Name:   fmt.Sprintf("Thread %d", i),
+			Frames: make([]*internal.Frame, 0),
+		}
+		if stack.ThreadAttributed {
+			thread.Name += " [attributed]"
+		}
+		for _, root := range stack.CallStackRootFrames {
+			frame, err := convertFrame(root, nil, 1)
+			if err != nil {
+				return nil, err
+			}
+			thread.Frames = append(thread.Frames, frame)
+		}
+		process.Threads = append(process.Threads, thread)
+	}
+	return process, nil
+}
+
+func convertFrame(f *callStackFrame, parent *internal.Frame, depth int) (*internal.Frame, error) {
+	// MetricKit payloads are not symbolicated, so name the frame by its
+	// offset in the binary, the same way sample(1) names unknown symbols.
+	frame := &internal.Frame{
+		Parent:       parent,
+		Children:     make([]*internal.Frame, 0),
+		SelfWeightNs: f.SampleCount,
+		SymbolName:   fmt.Sprintf("0x%x (in %s)", f.OffsetIntoBinaryTextSegment, f.BinaryName),
+		Binary:       f.BinaryName,
+		Depth:        depth,
+	}
+	for _, sub := range f.SubFrames {
+		child, err := convertFrame(sub, frame, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		frame.Children = append(frame.Children, child)
+		// Sample counts include the sub frames.
+		frame.SelfWeightNs -= sub.SampleCount
+	}
+	if frame.SelfWeightNs < 0 {
+		return nil, fmt.Errorf("Frame %s had fewer samples than its sub frames.", frame.SymbolName)
+	}
+	return frame, nil
+}
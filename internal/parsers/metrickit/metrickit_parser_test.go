@@ -0,0 +1,147 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrickit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const (
+	validPayload = `{
+  "timeStampBegin" : "2021-03-15 15:00:00",
+  "cpuExceptionDiagnostics" : [
+    {
+      "diagnosticMetaData" : {
+        "bundleIdentifier" : "com.example.App",
+        "appVersion" : "1.0"
+      },
+      "callStackTree" : {
+        "callStackPerThread" : false,
+        "callStacks" : [
+          {
+            "threadAttributed" : true,
+            "callStackRootFrames" : [
+              {
+                "binaryName" : "App",
+                "offsetIntoBinaryTextSegment" : 16,
+                "sampleCount" : 10,
+                "subFrames" : [
+                  {
+                    "binaryName" : "App",
+                    "offsetIntoBinaryTextSegment" : 32,
+                    "sampleCount" : 6
+                  },
+                  {
+                    "binaryName" : "libsystem_kernel.dylib",
+                    "offsetIntoBinaryTextSegment" : 255,
+                    "sampleCount" : 3
+                  }
+                ]
+              }
+            ]
+          }
+        ]
+      }
+    }
+  ]
+}`
+)
+
+func TestMetricKitParsing(t *testing.T) {
+	r := strings.NewReader(validPayload)
+	parser, err := MakeMetricKitParser(r)
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{
+			{
+				Name: "com.example.App CPU Exception 1",
+				Threads: []*internal.Thread{
+					{
+						Name: "Thread 0 [attributed]",
+						Frames: []*internal.Frame{
+							{
+								SymbolName:   "0x10 (in App)",
+								Depth:        1,
+								SelfWeightNs: 1,
+								Children: []*internal.Frame{
+									{
+										SymbolName:   "0x20 (in App)",
+										Depth:        2,
+										SelfWeightNs: 6,
+										Children:     []*internal.Frame{},
+									},
+									{
+										SymbolName:   "0xff (in libsystem_kernel.dylib)",
+										Depth:        2,
+										SelfWeightNs: 3,
+										Children:     []*internal.Frame{},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	internal.TimeProfileEquals(t, timeProfile, expected)
+	if timeProfile.GetValueType().Unit != "count" {
+		t.Errorf("Expected count values, got %v", timeProfile.GetValueType())
+	}
+}
+
+func TestMetricKitBareCallStackTree(t *testing.T) {
+	const tree = `{"callStacks": [{"callStackRootFrames": [
+		{"binaryName": "App", "offsetIntoBinaryTextSegment": 1, "sampleCount": 2}]}]}`
+	parser, err := MakeMetricKitParser(strings.NewReader(tree))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if len(timeProfile.Processes) != 1 || len(timeProfile.Processes[0].Threads) != 1 {
+		t.Fatalf("Expected a single process and thread, got %v", timeProfile.Processes)
+	}
+}
+
+func TestMetricKitInvalidSampleCounts(t *testing.T) {
+	const tree = `{"callStacks": [{"callStackRootFrames": [
+		{"binaryName": "App", "sampleCount": 1, "subFrames": [{"binaryName": "App", "sampleCount": 2}]}]}]}`
+	parser, err := MakeMetricKitParser(strings.NewReader(tree))
+	if err != nil {
+		t.Error(err)
+		t.FailNow()
+	}
+	if _, err := parser.ParseProfile(); err == nil {
+		t.Error("Expected an error for sub frames with more samples than their parent")
+	}
+}
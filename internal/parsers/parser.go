@@ -18,12 +18,24 @@ import (
 	"io"
 
 	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/instrumentsToPprof/internal/ir"
+	"github.com/google/instrumentsToPprof/internal/parsers/crash"
+	"github.com/google/instrumentsToPprof/internal/parsers/flamegraph"
+	"github.com/google/instrumentsToPprof/internal/parsers/fsusage"
 	"github.com/google/instrumentsToPprof/internal/parsers/instruments"
+	"github.com/google/instrumentsToPprof/internal/parsers/memory"
+	"github.com/google/instrumentsToPprof/internal/parsers/metrickit"
 	"github.com/google/instrumentsToPprof/internal/parsers/sample"
+	"github.com/google/instrumentsToPprof/internal/parsers/speedscope"
+	"github.com/google/instrumentsToPprof/internal/parsers/spindump"
+	"github.com/google/instrumentsToPprof/internal/parsers/xctrace"
 )
 
 type Parser interface {
 	ParseProfile() (p *internal.TimeProfile, err error)
+	// Capabilities describe what the inputs of the parser's format record,
+	// known before parsing.
+	Capabilities() internal.Capabilities
 }
 
 func MakeSampleParser(file io.Reader) (Parser, error) {
@@ -33,3 +45,71 @@ func MakeSampleParser(file io.Reader) (Parser, error) {
 func MakeDeepCopyParser(file io.Reader) (Parser, error) {
 	return instruments.MakeDeepCopyParser(file)
 }
+
+// MakeDeepCopyParserWithOptions returns a deep copy parser factory that
+// converts weights like "< 0.1 ms" with the given policy, rounds fractional
+// nanoseconds with rounding, and reads the depth of symbol names indented by
+// indent spaces per level.
+func MakeDeepCopyParserWithOptions(policy instruments.BoundPolicy, rounding instruments.RoundingPolicy,
+	indent int) func(io.Reader) (Parser, error) {
+	return func(file io.Reader) (Parser, error) {
+		parser, err := instruments.MakeDeepCopyParser(file)
+		return parser.WithBoundPolicy(policy).WithRounding(rounding).WithIndent(indent), err
+	}
+}
+
+func MakeAllocationsParser(file io.Reader) (Parser, error) {
+	return instruments.MakeAllocationsParser(file)
+}
+
+func MakeMetricKitParser(file io.Reader) (Parser, error) {
+	return metrickit.MakeMetricKitParser(file)
+}
+
+func MakeCrashParser(file io.Reader) (Parser, error) {
+	return crash.MakeCrashParser(file)
+}
+
+func MakeSpindumpParser(file io.Reader) (Parser, error) {
+	return spindump.MakeSpindumpParser(file)
+}
+
+func MakeSysdiagnoseParser(file io.Reader) (Parser, error) {
+	return spindump.MakeSysdiagnoseParser(file)
+}
+
+func MakeSpeedscopeParser(file io.Reader) (Parser, error) {
+	return speedscope.MakeSpeedscopeParser(file)
+}
+
+func MakeFlameGraphSvgParser(file io.Reader) (Parser, error) {
+	return flamegraph.MakeSvgParser(file)
+}
+
+func MakeIRParser(file io.Reader) (Parser, error) {
+	return ir.MakeIRParser(file)
+}
+
+func MakeXctraceParser(file io.Reader) (Parser, error) {
+	return xctrace.MakeXctraceParser(file)
+}
+
+func MakeHeapParser(file io.Reader) (Parser, error) {
+	return memory.MakeHeapParser(file)
+}
+
+func MakeVmmapParser(file io.Reader) (Parser, error) {
+	return memory.MakeVmmapParser(file)
+}
+
+func MakeLeaksParser(file io.Reader) (Parser, error) {
+	return memory.MakeLeaksParser(file)
+}
+
+func MakeMallocHistoryParser(file io.Reader) (Parser, error) {
+	return memory.MakeMallocHistoryParser(file)
+}
+
+func MakeFsUsageParser(file io.Reader) (Parser, error) {
+	return fsusage.MakeFsUsageParser(file)
+}
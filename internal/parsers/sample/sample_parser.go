@@ -16,12 +16,12 @@
 package sample
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -30,20 +30,21 @@ import (
 
 type SampleParser struct {
 	lines []string
+	// offsets of the start of each line in the input.
+	offsets []int64
 }
 
 func MakeSampleParser(file io.Reader) (p SampleParser, err error) {
-	p = SampleParser{
-		lines: []string{},
-	}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		p.lines = append(p.lines, scanner.Text())
-	}
-	if err := scanner.Err(); err != nil {
-		return p, err
+	p.lines, p.offsets, err = internal.ScanLines(file)
+	return p, err
+}
+
+// Capabilities of sample reports: the call trees of the threads of a single
+// process, which are named but have no thread ids.
+func (s SampleParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		Units: []internal.ValueType{internal.CPUValueType},
 	}
-	return p, nil
 }
 
 func (s SampleParser) ParseProfile() (p *internal.TimeProfile, err error) {
@@ -55,24 +56,34 @@ func (s SampleParser) ParseProfile() (p *internal.TimeProfile, err error) {
 	// TODO(eshr): Parse sample rate
 	// Parse header
 	var lastIndex int
+	invertedIndex := -1
+	foundCallGraph := false
+	attributes := make(map[string]string)
+	// The process of the "Analysis of sampling" line, for the reports of
+	// Activity Monitor without a Process line.
+	var analyzedProcess *internal.Process
 	for i, line := range s.lines {
 		lastIndex = i
 		line = strings.TrimSpace(line)
+		if label, value, ok := internal.ParseAttribute(line); ok {
+			attributes[label] = value
+			continue
+		}
 		if strings.HasPrefix(line, "Analysis of sampling") {
 			sampleRate = parseSampleRate(line)
+			analyzedProcess = parseAnalysisLine(line)
+			if analyzedProcess != nil {
+				analyzedProcess.Position = internal.PositionOf(s.offsets, i)
+			}
+			continue
 		}
 		if strings.HasPrefix(line, "Report Version") {
-			parts := strings.Split(line, ":")
-			if len(parts) != 2 {
-				return nil, fmt.Errorf("Could not parse report version line: %s", line)
-			}
-			reportVersion, err := strconv.Atoi(strings.TrimSpace(parts[1]))
-			if err != nil {
-				return nil, fmt.Errorf("Error parsing report version: %v", err)
-			}
-			if reportVersion != 7 {
-				return nil, fmt.Errorf("Report Version was %d, only report version 7 is supported", reportVersion)
+			// Activity Monitor's "Sample Process" reports of older macOS
+			// versions have other or no versions, but the same call graph.
+			if version := strings.TrimSpace(strings.TrimPrefix(line, "Report Version:")); version != "7" {
+				internal.Warnf("Report Version was %s, only report version 7 is tested. Converting anyway.", version)
 			}
+			continue
 		}
 		if strings.HasPrefix(line, "Process") {
 			if len(p.Processes) > 0 {
@@ -82,11 +93,41 @@ func (s SampleParser) ParseProfile() (p *internal.TimeProfile, err error) {
 			if err != nil {
 				return nil, err
 			}
+			process.Position = internal.PositionOf(s.offsets, i)
 			p.Processes = append(p.Processes, process)
+			continue
 		}
-		if strings.HasPrefix(line, "Call graph") {
+		if callGraphRe.MatchString(line) {
+			if invertedCallGraphRe.MatchString(line) {
+				// Prefer the regular call graph when both are present.
+				if invertedIndex < 0 {
+					invertedIndex = i
+				}
+				continue
+			}
+			foundCallGraph = true
 			break
 		}
+		// The lines of an inverted call graph are rejected once it is known
+		// whether it is used.
+		if line != "" && invertedIndex < 0 {
+			internal.Reject(internal.PositionOf(s.offsets, i), s.lines[i], "not an attribute of the header")
+		}
+	}
+	if foundCallGraph && invertedIndex >= 0 {
+		for i := invertedIndex + 1; i < len(s.lines) && strings.TrimSpace(s.lines[i]) != ""; i++ {
+			internal.Reject(internal.PositionOf(s.offsets, i), s.lines[i], "inverted call graph, the regular one is used")
+		}
+	}
+	if len(p.Processes) == 0 {
+		if analyzedProcess == nil {
+			return nil, errors.New("Found neither a Process line nor the pid of the sampled process.")
+		}
+		p.Processes = append(p.Processes, analyzedProcess)
+	}
+	inverted := !foundCallGraph && invertedIndex >= 0
+	if inverted {
+		lastIndex = invertedIndex
 	}
 	process := p.Processes[0]
 	var currentThread *internal.Thread = nil
@@ -94,7 +135,8 @@ func (s SampleParser) ParseProfile() (p *internal.TimeProfile, err error) {
 	if len(s.lines) < lastIndex {
 		return nil, errors.New("Reached the end of the input before parsing the call graph.")
 	}
-	for _, line := range s.lines[lastIndex+1:] {
+	for i, line := range s.lines[lastIndex+1:] {
+		position := internal.PositionOf(s.offsets, lastIndex+1+i)
 		line = strings.TrimSpace(line)
 		// Call stack is over
 		if line == "" {
@@ -105,10 +147,12 @@ func (s SampleParser) ParseProfile() (p *internal.TimeProfile, err error) {
 		if err != nil {
 			return nil, err
 		}
+		currentFrame.Position = position
 		if currentFrame.Depth == 0 {
 			// New thread!
 			currentThread = &internal.Thread{
-				Name: currentFrame.SymbolName,
+				Name:     currentFrame.SymbolName,
+				Position: position,
 			}
 			process.Threads = append(process.Threads, currentThread)
 		} else if currentFrame.Depth == 1 {
@@ -138,6 +182,20 @@ func (s SampleParser) ParseProfile() (p *internal.TimeProfile, err error) {
 		lastFrame = currentFrame
 	}
 
+	if inverted {
+		for i, thread := range process.Threads {
+			process.Threads[i], err = reinvertThread(thread)
+			if err != nil {
+				return nil, err
+			}
+		}
+		checkStackTotals(p, s.parseStackTotals(), sampleRate)
+		internal.DisambiguateThreads(p)
+		internal.SetAttributes(process, attributes)
+		internal.AddSampleCounts(p, sampleRate)
+		return p, nil
+	}
+
 	// Fix weights
 	for _, thread := range process.Threads {
 		for _, frame := range thread.Frames {
@@ -147,12 +205,102 @@ func (s SampleParser) ParseProfile() (p *internal.TimeProfile, err error) {
 			}
 		}
 	}
+	checkStackTotals(p, s.parseStackTotals(), sampleRate)
+	internal.DisambiguateThreads(p)
+	internal.SetAttributes(process, attributes)
+	internal.AddSampleCounts(p, sampleRate)
 
 	return p, nil
 }
 
+var (
+	// stackTotalRe matches the lines of the "Total number in stack" section,
+	// e.g. "20       start  (in dyld) + 462  [0x1000151d2]".
+	stackTotalRe = regexp.MustCompile(`^(\d+)\s+(.*)$`)
+	// symbolOffsetRe matches the offset and address after a symbol, e.g.
+	// " + 1  [0x7fff2037a6f1]".
+	symbolOffsetRe = regexp.MustCompile(`\s+\+\s+\d+(\s+\[0x[0-9a-f]+\])?$`)
+)
+
+// symbolKey returns the symbol and binary of a frame name without the offset
+// and address, which differ between call sites.
+func symbolKey(name string) string {
+	return strings.Join(strings.Fields(symbolOffsetRe.ReplaceAllString(name, "")), " ")
+}
+
+// parseStackTotals parses the "Total number in stack" section, the number of
+// samples each symbol is in with recursive calls counted multiple times. The
+// section only lists symbols in at least 5 samples.
+func (s SampleParser) parseStackTotals() map[string]int64 {
+	totals := make(map[string]int64)
+	inSection := false
+	for _, line := range s.lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Total number in stack") {
+			inSection = true
+			continue
+		}
+		if !inSection {
+			continue
+		}
+		matches := stackTotalRe.FindStringSubmatch(line)
+		if matches == nil {
+			break
+		}
+		count, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			break
+		}
+		totals[symbolKey(matches[2])] += count
+	}
+	return totals
+}
+
+// checkStackTotals warns about the symbols whose samples in the parsed call
+// graph don't add up to the stack totals of the report, which means part of
+// the call graph was parsed wrong.
+func checkStackTotals(p *internal.TimeProfile, totals map[string]int64, sampleRate int64) {
+	if len(totals) == 0 {
+		return
+	}
+	counts := make(map[string]int64)
+	var count func(f *internal.Frame) int64
+	count = func(f *internal.Frame) int64 {
+		total := f.SelfWeightNs
+		for _, child := range f.Children {
+			total += count(child)
+		}
+		counts[symbolKey(f.SymbolName)] += total / sampleRate
+		return total
+	}
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			for _, f := range th.Frames {
+				count(f)
+			}
+		}
+	}
+	symbols := make([]string, 0, len(totals))
+	for symbol := range totals {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	for _, symbol := range symbols {
+		if counts[symbol] != totals[symbol] {
+			internal.Warnf("%s is in %d samples of the call graph, but in %d according to the stack totals of the report.",
+				symbol, counts[symbol], totals[symbol])
+		}
+	}
+}
+
 var (
 	functionRe = regexp.MustCompile(`([+\s!:|]*)(\d+)\s+(.*)$`)
+	// The call graph section starts with "Call graph:", the inverted one
+	// with e.g. "Call graph (inverted):".
+	callGraphRe         = regexp.MustCompile(`(?i)^call ?graph\b`)
+	invertedCallGraphRe = regexp.MustCompile(`(?i)^call ?graph\s*\(inverted\)`)
+	// Frames name their binary, e.g. "start  (in libdyld.dylib) + 1  [0x7fff2037a6f1]".
+	binaryRe = regexp.MustCompile(`\(in ([^)]+)\)`)
 )
 
 func parseCallLine(line string) (f *internal.Frame, err error) {
@@ -165,8 +313,13 @@ func parseCallLine(line string) (f *internal.Frame, err error) {
 		return nil, fmt.Errorf("Error parsing function line %s: %v", line, err)
 	}
 
+	var binary string
+	if binaryMatches := binaryRe.FindStringSubmatch(matches[3]); binaryMatches != nil {
+		binary = binaryMatches[1]
+	}
 	return &internal.Frame{
 		SymbolName:   matches[3],
+		Binary:       binary,
 		SelfWeightNs: hits,
 		// 2 spaces per depth.
 		Depth: len(matches[1]) / 2,
@@ -186,8 +339,59 @@ func fixSelfWeight(frame *internal.Frame) error {
 	return nil
 }
 
+// reinvertThread turns the inverted call graph of a thread, where the roots
+// are the innermost frames, back into a regular call graph. The samples of a
+// node that aren't in its callers are stacks ending in that node. The frames
+// keep their binary and offset.
+func reinvertThread(inverted *internal.Thread) (*internal.Thread, error) {
+	thread := &internal.Thread{
+		Name:     inverted.Name,
+		Tid:      inverted.Tid,
+		Frames:   make([]*internal.Frame, 0),
+		Position: inverted.Position,
+	}
+	var walk func(frame *internal.Frame, path []*internal.Frame) error
+	walk = func(frame *internal.Frame, path []*internal.Frame) error {
+		path = append(path, frame)
+		weight := frame.SelfWeightNs
+		for _, caller := range frame.Children {
+			weight -= caller.SelfWeightNs
+			if err := walk(caller, path); err != nil {
+				return err
+			}
+		}
+		if weight < 0 {
+			return fmt.Errorf(
+				"Fatal error parsing inverted sample file. Frame %s had negative weight.",
+				frame.SymbolName)
+		}
+		if weight > 0 {
+			stack := make([]string, len(path))
+			for i, f := range path {
+				stack[len(path)-1-i] = f.SymbolName
+			}
+			// path is the stack from the innermost frame, like the parents
+			// of the frame AddStack returns.
+			added := thread.AddStack(stack, weight)
+			for _, f := range path {
+				added.Binary = f.Binary
+				added.Offset = f.Offset
+				added = added.Parent
+			}
+		}
+		return nil
+	}
+	for _, frame := range inverted.Frames {
+		if err := walk(frame, nil); err != nil {
+			return nil, err
+		}
+	}
+	return thread, nil
+}
+
 var (
-	pidRe = regexp.MustCompile(`(.*)\s\[(\d+)\]`)
+	pidRe      = regexp.MustCompile(`(.*)\s\[(\d+)\]`)
+	analysisRe = regexp.MustCompile(`^Analysis of sampling (.*) \(pid (\d+)\)`)
 )
 
 func parseProcess(line string) (p *internal.Process, err error) {
@@ -197,7 +401,8 @@ func parseProcess(line string) (p *internal.Process, err error) {
 	if !strings.HasPrefix(line, "Process") {
 		return nil, invalid_line
 	}
-	parts := strings.Split(line, ":")
+	// Process names can contain colons.
+	parts := strings.SplitN(line, ":", 2)
 	if len(parts) != 2 {
 		return nil, invalid_line
 	}
@@ -213,6 +418,21 @@ func parseProcess(line string) (p *internal.Process, err error) {
 	}, nil
 }
 
+// parseAnalysisLine returns the process of the line starting the report,
+// e.g. "Analysis of sampling Safari (pid 584) every 1 millisecond", or nil if
+// the line doesn't name it.
+func parseAnalysisLine(line string) *internal.Process {
+	matches := analysisRe.FindStringSubmatch(line)
+	if matches == nil {
+		return nil
+	}
+	pid, err := strconv.ParseUint(matches[2], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &internal.Process{Name: matches[1], Pid: pid}
+}
+
 func parseSampleRate(line string) int64 {
 	parts := strings.Split(line, " ")
 	n := len(parts)
@@ -15,6 +15,8 @@
 package sample
 
 import (
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -136,3 +138,280 @@ func TestSampleParsing(t *testing.T) {
 
 	internal.TimeProfileEquals(t, timeProfile, expected)
 }
+
+const (
+	sampleHeader = `Analysis of sampling Process Name (pid 56690) every 1 millisecond
+Process:         ProcessName [56690]
+Report Version:  7
+
+`
+	invertedCallGraph = `Call graph (inverted):
+    3 Thread1
+    + 2 makeSandwhich
+    + ! 2 eatLunch
+    + !   1 start
+    + 1 eatFood(Food const&)
+    + ! 1 eatLunch
+    + !   1 start
+
+`
+	regularCallGraph = `Call graph:
+    3 Thread1
+    + 3 start
+    +   3 eatLunch
+    +   : 1 makeSandwhich
+    +   : 1 eatFood(Food const&)
+
+`
+)
+
+func TestInvertedSampleParsing(t *testing.T) {
+	parser, err := MakeSampleParser(strings.NewReader(sampleHeader + invertedCallGraph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{
+			{
+				Name: "ProcessName",
+				Pid:  56690,
+				Threads: []*internal.Thread{
+					{
+						Name: "Thread1",
+						Frames: []*internal.Frame{
+							{
+								SymbolName: "start",
+								Depth:      1,
+								Children: []*internal.Frame{
+									{
+										SymbolName: "eatLunch",
+										Depth:      2,
+										Children: []*internal.Frame{
+											{
+												SymbolName:   "makeSandwhich",
+												Depth:        3,
+												SelfWeightNs: 1_000_000,
+											},
+											{
+												SymbolName:   "eatFood(Food const&)",
+												Depth:        3,
+												SelfWeightNs: 1_000_000,
+											},
+										},
+									},
+								},
+							},
+							{
+								SymbolName: "eatLunch",
+								Depth:      1,
+								Children: []*internal.Frame{
+									{
+										SymbolName:   "makeSandwhich",
+										Depth:        2,
+										SelfWeightNs: 1_000_000,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	internal.TimeProfileEquals(t, timeProfile, expected)
+
+	const withBinaries = `Call graph (inverted):
+    2 Thread1
+    + 2 malloc  (in libsystem_malloc.dylib) + 12  [0x7fff1]
+    + ! 2 main  (in Sandwich) + 40  [0x1000a]
+
+`
+	parser, err = MakeSampleParser(strings.NewReader(sampleHeader + withBinaries))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeProfile, err = parser.ParseProfile(); err != nil {
+		t.Fatal(err)
+	}
+	main := timeProfile.Processes[0].Threads[0].Frames[0]
+	if main.Binary != "Sandwich" || main.Children[0].Binary != "libsystem_malloc.dylib" {
+		t.Errorf("Expected the binaries of the inverted frames to be kept, got %s and %s",
+			main.Binary, main.Children[0].Binary)
+	}
+}
+
+func TestRegularCallGraphPreferredOverInverted(t *testing.T) {
+	parser, err := MakeSampleParser(strings.NewReader(sampleHeader + invertedCallGraph + regularCallGraph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	threads := timeProfile.Processes[0].Threads
+	if len(threads) != 1 || len(threads[0].Frames) != 1 || threads[0].Frames[0].SymbolName != "start" {
+		t.Errorf("Expected only the regular call graph to be parsed, got %v", threads)
+	}
+}
+
+func TestStackTotalsChecked(t *testing.T) {
+	const callGraph = `Call graph:
+    6 Thread1
+    + 6 start  (in dyld) + 462  [0x1000151d2]
+    +   5 eatLunch  (in Sandwich) + 10  [0x100003f00]
+    +   1 eatLunch  (in Sandwich) + 20  [0x100003f10]
+    5 Thread2
+    + 5 listenToMusic()  (in Sandwich) + 4  [0x100003e00]
+
+`
+	cases := []struct {
+		totals   string
+		expected string
+	}{
+		{
+			totals: `Total number in stack (recursive counted multiple, when >=5):
+        6       eatLunch  (in Sandwich) + 10  [0x100003f00]
+        6       start  (in dyld) + 462  [0x1000151d2]
+        5       listenToMusic()  (in Sandwich) + 4  [0x100003e00]
+`,
+			expected: "",
+		},
+		{
+			totals: `Total number in stack (recursive counted multiple, when >=5):
+        6       start  (in dyld) + 462  [0x1000151d2]
+        9       listenToMusic()  (in Sandwich) + 4  [0x100003e00]
+`,
+			expected: "WARNING: listenToMusic() (in Sandwich) is in 5 samples of the call graph, " +
+				"but in 9 according to the stack totals of the report.\n",
+		},
+	}
+	for _, c := range cases {
+		var out strings.Builder
+		internal.SetWarningOutput(&out)
+		parser, err := MakeSampleParser(strings.NewReader(sampleHeader + callGraph + c.totals))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parser.ParseProfile(); err != nil {
+			t.Fatal(err)
+		}
+		internal.FlushWarnings()
+		internal.SetWarningOutput(os.Stdout)
+		if out.String() != c.expected {
+			t.Errorf("Expected warnings %q, got %q", c.expected, out.String())
+		}
+	}
+}
+
+func TestSampleCounts(t *testing.T) {
+	for name, callGraph := range map[string]string{"regular": regularCallGraph, "inverted": invertedCallGraph} {
+		parser, err := MakeSampleParser(strings.NewReader(sampleHeader + callGraph))
+		if err != nil {
+			t.Fatal(err)
+		}
+		timeProfile, err := parser.ParseProfile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(timeProfile.ExtraValueTypes, []internal.ValueType{internal.SampleCountValueType}) {
+			t.Errorf("%s: expected a samples value, got %v", name, timeProfile.ExtraValueTypes)
+		}
+		var samples int64
+		for _, f := range timeProfile.Processes[0].Threads[0].Frames {
+			var count func(f *internal.Frame)
+			count = func(f *internal.Frame) {
+				if len(f.ExtraWeights) > 0 {
+					samples += f.ExtraWeights[0]
+				}
+				for _, child := range f.Children {
+					count(child)
+				}
+			}
+			count(f)
+		}
+		if samples != 3 {
+			t.Errorf("%s: expected 3 samples, got %d", name, samples)
+		}
+	}
+}
+
+func TestActivityMonitorReports(t *testing.T) {
+	cases := []struct {
+		name, header, process, warnings string
+	}{
+		{
+			name: "older version",
+			header: `Sampling process 584 for 3 seconds with 1 millisecond of run time between samples
+Sampling completed, processing symbols...
+Analysis of sampling Safari (pid 584) every 1 millisecond
+Process:         Safari: Web Content [584]
+Report Version:  6
+
+`,
+			process:  "Safari: Web Content",
+			warnings: "WARNING: Report Version was 6, only report version 7 is tested. Converting anyway.\n",
+		},
+		{
+			name: "no process or version line",
+			header: `Analysis of sampling Safari (pid 584) every 1 millisecond
+
+`,
+			process: "Safari",
+		},
+	}
+	for _, c := range cases {
+		var out strings.Builder
+		internal.SetWarningOutput(&out)
+		parser, err := MakeSampleParser(strings.NewReader(c.header + regularCallGraph))
+		if err != nil {
+			t.Fatal(err)
+		}
+		timeProfile, err := parser.ParseProfile()
+		internal.FlushWarnings()
+		internal.SetWarningOutput(os.Stdout)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if proc := timeProfile.Processes[0]; proc.Name != c.process || proc.Pid != 584 || len(proc.Threads) != 1 {
+			t.Errorf("%s: expected the threads of %s [584], got %v", c.name, c.process, proc)
+		}
+		if out.String() != c.warnings {
+			t.Errorf("%s: expected warnings %q, got %q", c.name, c.warnings, out.String())
+		}
+	}
+
+	parser, err := MakeSampleParser(strings.NewReader("Analysis of sampling\n\n" + regularCallGraph))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err == nil {
+		t.Errorf("Expected an error for a report without the sampled process")
+	}
+}
+
+func TestSampleRejects(t *testing.T) {
+	var rejects strings.Builder
+	internal.SetRejectOutput(&rejects)
+	defer internal.SetRejectOutput(nil)
+	input := "Sampling process 56690 for 3 seconds with 1 millisecond of run time between samples\n" +
+		sampleHeader + invertedCallGraph + regularCallGraph
+	parser, err := MakeSampleParser(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSuffix(rejects.String(), "\n"), "\n")
+	if len(got) != 8 || !strings.HasPrefix(got[0], "1\tnot an attribute of the header\tSampling process") ||
+		!strings.HasPrefix(got[1], "7\tinverted call graph, the regular one is used\t    3 Thread1") {
+		t.Errorf("Expected the sampling line and the inverted call graph to be rejected, got %q", got)
+	}
+}
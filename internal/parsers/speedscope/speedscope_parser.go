@@ -0,0 +1,207 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package speedscope parses speedscope JSON files.
+// See https://github.com/jlfwong/speedscope/wiki/Importing-from-custom-sources
+package speedscope
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+type frame struct {
+	Name string `json:"name"`
+}
+
+type event struct {
+	Type  string  `json:"type"`
+	Frame int     `json:"frame"`
+	At    float64 `json:"at"`
+}
+
+type profile struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	Unit string `json:"unit"`
+	// StartValue is the time of the first sample or event.
+	StartValue float64 `json:"startValue"`
+	// EndValue is the time the last sample or event ended.
+	EndValue float64 `json:"endValue"`
+	// Evented profiles.
+	Events []event `json:"events,omitempty"`
+	// Sampled profiles. Each sample is a stack of frame indices, from the
+	// outermost frame.
+	Samples [][]int   `json:"samples,omitempty"`
+	Weights []float64 `json:"weights,omitempty"`
+}
+
+type file struct {
+	Schema string `json:"$schema,omitempty"`
+	Name   string `json:"name"`
+	Shared struct {
+		Frames []frame `json:"frames"`
+	} `json:"shared"`
+	Profiles []profile `json:"profiles"`
+}
+
+type SpeedscopeParser struct {
+	file file
+}
+
+func MakeSpeedscopeParser(r io.Reader) (p SpeedscopeParser, err error) {
+	if err := json.NewDecoder(r).Decode(&p.file); err != nil {
+		return p, fmt.Errorf("Could not decode speedscope JSON: %v", err)
+	}
+	return p, nil
+}
+
+// unitValueType returns the value type of a speedscope unit and the factor
+// converting values to that type.
+func unitValueType(unit string) (internal.ValueType, float64, error) {
+	switch unit {
+	case "nanoseconds":
+		return internal.CPUValueType, 1, nil
+	case "microseconds":
+		return internal.CPUValueType, 1_000, nil
+	case "milliseconds":
+		return internal.CPUValueType, 1_000_000, nil
+	case "seconds":
+		return internal.CPUValueType, 1_000_000_000, nil
+	case "bytes":
+		return internal.ValueType{Type: "space", Unit: "bytes"}, 1, nil
+	case "none", "":
+		return internal.ValueType{Type: "samples", Unit: "count"}, 1, nil
+	}
+	return internal.ValueType{}, 0, fmt.Errorf("Unknown speedscope unit '%s'", unit)
+}
+
+// Capabilities of speedscope files: a thread per profile, without tids, in
+// one of the units of speedscope. Evented profiles record when frames ran.
+func (s SpeedscopeParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		Timestamps: true,
+		Units:      []internal.ValueType{internal.CPUValueType, {Type: "space", Unit: "bytes"}, {Type: "samples", Unit: "count"}},
+	}
+}
+
+func (s SpeedscopeParser) ParseProfile() (p *internal.TimeProfile, err error) {
+	if len(s.file.Profiles) == 0 {
+		return nil, errors.New("No profiles found in speedscope file.")
+	}
+	name := s.file.Name
+	if name == "" {
+		name = "speedscope"
+	}
+	process := &internal.Process{
+		Name:    name,
+		Threads: make([]*internal.Thread, 0),
+	}
+	p = &internal.TimeProfile{
+		Processes: []*internal.Process{process},
+	}
+	for i, prof := range s.file.Profiles {
+		valueType, scale, err := unitValueType(prof.Unit)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			p.ValueType = valueType
+		} else if valueType != p.ValueType {
+			return nil, fmt.Errorf("Profile %s has unit %s, which can't be combined with %v",
+				prof.Name, prof.Unit, p.ValueType)
+		}
+		thread := &internal.Thread{
+			Name:   prof.Name,
+			Tid:    uint64(i),
+			Frames: make([]*internal.Frame, 0),
+		}
+		switch prof.Type {
+		case "sampled":
+			err = s.addSamples(thread, prof, scale)
+		case "evented":
+			err = s.addEvents(thread, prof, scale)
+		default:
+			err = fmt.Errorf("Unknown speedscope profile type '%s'", prof.Type)
+		}
+		if err != nil {
+			return nil, err
+		}
+		process.Threads = append(process.Threads, thread)
+	}
+	return p, nil
+}
+
+func (s SpeedscopeParser) frameName(index int) (string, error) {
+	if index < 0 || index >= len(s.file.Shared.Frames) {
+		return "", fmt.Errorf("Invalid speedscope frame index %d", index)
+	}
+	return s.file.Shared.Frames[index].Name, nil
+}
+
+// addSamples adds the samples in order, each one starting when the previous
+// one ended.
+func (s SpeedscopeParser) addSamples(thread *internal.Thread, prof profile, scale float64) error {
+	at := prof.StartValue
+	for i, sample := range prof.Samples {
+		weight := 1.0
+		if i < len(prof.Weights) {
+			weight = prof.Weights[i]
+		}
+		stack := make([]string, len(sample))
+		for j, index := range sample {
+			name, err := s.frameName(index)
+			if err != nil {
+				return err
+			}
+			stack[j] = name
+		}
+		thread.AddTimedStack(int64(at*scale), stack, int64(weight*scale))
+		at += weight
+	}
+	return nil
+}
+
+// addEvents attributes the time between consecutive events to the stack of
+// open frames.
+func (s SpeedscopeParser) addEvents(thread *internal.Thread, prof profile, scale float64) error {
+	var stack []string
+	var last float64
+	for _, e := range prof.Events {
+		if len(stack) > 0 && e.At > last {
+			thread.AddTimedStack(int64(last*scale), stack, int64((e.At-last)*scale))
+		}
+		last = e.At
+		name, err := s.frameName(e.Frame)
+		if err != nil {
+			return err
+		}
+		switch e.Type {
+		case "O":
+			stack = append(stack, name)
+		case "C":
+			if len(stack) == 0 || stack[len(stack)-1] != name {
+				return fmt.Errorf("Closed frame %s in %s is not the innermost open frame", name, prof.Name)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			return fmt.Errorf("Unknown speedscope event type '%s'", e.Type)
+		}
+	}
+	return nil
+}
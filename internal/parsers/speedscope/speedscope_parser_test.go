@@ -0,0 +1,122 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package speedscope
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const (
+	validSpeedscope = `{
+  "$schema": "https://www.speedscope.app/file-format-schema.json",
+  "name": "lunch",
+  "shared": {"frames": [{"name": "main"}, {"name": "eat"}, {"name": "cook"}]},
+  "profiles": [
+    {
+      "type": "sampled", "name": "Thread 1", "unit": "milliseconds",
+      "startValue": 0, "endValue": 4,
+      "samples": [[0, 1], [0, 2], [0, 1]],
+      "weights": [1, 2, 1.5]
+    },
+    {
+      "type": "evented", "name": "Thread 2", "unit": "milliseconds",
+      "startValue": 0, "endValue": 10,
+      "events": [
+        {"type": "O", "frame": 0, "at": 0},
+        {"type": "O", "frame": 2, "at": 2},
+        {"type": "C", "frame": 2, "at": 5},
+        {"type": "C", "frame": 0, "at": 10}
+      ]
+    }
+  ]
+}`
+)
+
+func TestSpeedscopeParsing(t *testing.T) {
+	parser, err := MakeSpeedscopeParser(strings.NewReader(validSpeedscope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{
+			{
+				Name: "lunch",
+				Threads: []*internal.Thread{
+					{
+						Name: "Thread 1",
+						Tid:  0,
+						Frames: []*internal.Frame{
+							{
+								SymbolName: "main",
+								Depth:      1,
+								Children: []*internal.Frame{
+									{
+										SymbolName:   "eat",
+										Depth:        2,
+										SelfWeightNs: 2_500_000,
+									},
+									{
+										SymbolName:   "cook",
+										Depth:        2,
+										SelfWeightNs: 2_000_000,
+									},
+								},
+							},
+						},
+					},
+					{
+						Name: "Thread 2",
+						Tid:  1,
+						Frames: []*internal.Frame{
+							{
+								SymbolName:   "main",
+								Depth:        1,
+								SelfWeightNs: 7_000_000,
+								Children: []*internal.Frame{
+									{
+										SymbolName:   "cook",
+										Depth:        2,
+										SelfWeightNs: 3_000_000,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	internal.TimeProfileEquals(t, timeProfile, expected)
+}
+
+func TestSpeedscopeMixedUnits(t *testing.T) {
+	const mixed = `{"shared": {"frames": [{"name": "main"}]}, "profiles": [
+		{"type": "sampled", "name": "a", "unit": "bytes", "samples": [[0]], "weights": [1]},
+		{"type": "sampled", "name": "b", "unit": "seconds", "samples": [[0]], "weights": [1]}]}`
+	parser, err := MakeSpeedscopeParser(strings.NewReader(mixed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err == nil {
+		t.Error("Expected an error combining bytes and seconds")
+	}
+}
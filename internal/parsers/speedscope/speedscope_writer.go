@@ -0,0 +1,145 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package speedscope
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// schemaURL is the JSON schema of speedscope files.
+const schemaURL = "https://www.speedscope.app/file-format-schema.json"
+
+// unitOf returns the speedscope unit of a value type.
+func unitOf(valueType internal.ValueType) string {
+	switch valueType.Unit {
+	case "nanoseconds", "bytes":
+		return valueType.Unit
+	}
+	return "none"
+}
+
+// writer collects the shared frames of a speedscope file.
+type writer struct {
+	file   file
+	frames map[string]int
+}
+
+// frameIndex returns the index of the shared frame with the name.
+func (w *writer) frameIndex(name string) int {
+	if index, ok := w.frames[name]; ok {
+		return index
+	}
+	index := len(w.file.Shared.Frames)
+	w.file.Shared.Frames = append(w.file.Shared.Frames, frame{Name: name})
+	w.frames[name] = index
+	return index
+}
+
+// stackOf returns the indices of the frame and its parents, from the
+// outermost frame.
+func (w *writer) stackOf(f *internal.Frame) []int {
+	var stack []int
+	for ; f != nil; f = f.Parent {
+		stack = append([]int{w.frameIndex(f.SymbolName)}, stack...)
+	}
+	return stack
+}
+
+// sampled returns a sampled profile of the self weights of the thread's call
+// tree.
+func (w *writer) sampled(prof profile, th *internal.Thread) profile {
+	prof.Type = "sampled"
+	var walk func(f *internal.Frame)
+	walk = func(f *internal.Frame) {
+		if f.SelfWeightNs != 0 {
+			prof.Samples = append(prof.Samples, w.stackOf(f))
+			prof.Weights = append(prof.Weights, float64(f.SelfWeightNs))
+			prof.EndValue += float64(f.SelfWeightNs)
+		}
+		for _, child := range f.Children {
+			walk(child)
+		}
+	}
+	for _, f := range th.Frames {
+		walk(f)
+	}
+	return prof
+}
+
+// evented returns an evented profile of the thread's timeline, opening and
+// closing frames where the stacks of consecutive samples differ. Samples
+// overlapping the previous one start when it ends.
+func (w *writer) evented(prof profile, th *internal.Thread) profile {
+	prof.Type = "evented"
+	var open []int
+	closeTo := func(depth int, at float64) {
+		for len(open) > depth {
+			prof.Events = append(prof.Events, event{Type: "C", Frame: open[len(open)-1], At: at})
+			open = open[:len(open)-1]
+		}
+	}
+	prof.StartValue = float64(th.Timeline[0].Time)
+	end := prof.StartValue
+	for _, sample := range th.Timeline {
+		at := float64(sample.Time)
+		if at > end {
+			closeTo(0, end)
+		} else {
+			at = end
+		}
+		stack := w.stackOf(sample.Frame)
+		common := 0
+		for common < len(open) && common < len(stack) && open[common] == stack[common] {
+			common++
+		}
+		closeTo(common, at)
+		for _, index := range stack[common:] {
+			prof.Events = append(prof.Events, event{Type: "O", Frame: index, At: at})
+			open = append(open, index)
+		}
+		end = at + float64(sample.Weight)
+	}
+	closeTo(0, end)
+	prof.EndValue = end
+	return prof
+}
+
+// WriteSpeedscope writes the profile as a speedscope file with a profile per
+// thread, named after the thread and its process. Threads with a timeline
+// are written as evented profiles, the others as sampled profiles of their
+// call trees.
+func WriteSpeedscope(out io.Writer, p *internal.TimeProfile) error {
+	w := writer{frames: make(map[string]int)}
+	w.file.Schema = schemaURL
+	w.file.Name = "instrumentsToPprof"
+	w.file.Shared.Frames = make([]frame, 0)
+	unit := unitOf(p.GetValueType())
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			prof := profile{Name: fmt.Sprintf("%s (%d): %s", proc.Name, proc.Pid, th.Name), Unit: unit}
+			if len(th.Timeline) > 0 {
+				prof = w.evented(prof, th)
+			} else {
+				prof = w.sampled(prof, th)
+			}
+			w.file.Profiles = append(w.file.Profiles, prof)
+		}
+	}
+	return json.NewEncoder(out).Encode(w.file)
+}
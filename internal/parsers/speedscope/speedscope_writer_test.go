@@ -0,0 +1,111 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package speedscope
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// stacksOf returns the stacks of the thread's self weights, e.g. "main;eat 3".
+func stacksOf(th *internal.Thread) []string {
+	var stacks []string
+	var walk func(f *internal.Frame, stack string)
+	walk = func(f *internal.Frame, stack string) {
+		stack += f.SymbolName
+		if f.SelfWeightNs != 0 {
+			stacks = append(stacks, fmt.Sprintf("%s %d", stack, f.SelfWeightNs))
+		}
+		for _, child := range f.Children {
+			walk(child, stack+";")
+		}
+	}
+	for _, f := range th.Frames {
+		walk(f, "")
+	}
+	sort.Strings(stacks)
+	return stacks
+}
+
+func expectStacks(t *testing.T, th *internal.Thread, expected []string) {
+	t.Helper()
+	if got := stacksOf(th); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected the stacks %v in %s, got %v", expected, th.Name, got)
+	}
+}
+
+// roundTrip writes the profile as speedscope file and parses it again.
+func roundTrip(t *testing.T, p *internal.TimeProfile) (file, *internal.TimeProfile) {
+	var out bytes.Buffer
+	if err := WriteSpeedscope(&out, p); err != nil {
+		t.Fatal(err)
+	}
+	var written file
+	if err := json.Unmarshal(out.Bytes(), &written); err != nil {
+		t.Fatal(err)
+	}
+	parser, err := MakeSpeedscopeParser(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return written, parsed
+}
+
+func TestWriteSpeedscopeEvented(t *testing.T) {
+	parser, err := MakeSpeedscopeParser(strings.NewReader(validSpeedscope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, got := roundTrip(t, p)
+	for _, prof := range written.Profiles {
+		if prof.Type != "evented" || prof.Unit != "nanoseconds" {
+			t.Errorf("Expected evented profiles in nanoseconds, got %s in %s", prof.Type, prof.Unit)
+		}
+	}
+	for i, th := range p.Processes[0].Threads {
+		expectStacks(t, got.Processes[0].Threads[i], stacksOf(th))
+	}
+}
+
+func TestWriteSpeedscopeSampled(t *testing.T) {
+	thread := &internal.Thread{Name: "main-thread", Tid: 1}
+	thread.AddStack([]string{"main", "eat"}, 3)
+	thread.AddStack([]string{"main", "cook"}, 2)
+	p := &internal.TimeProfile{Processes: []*internal.Process{{Name: "Lunch", Pid: 42, Threads: []*internal.Thread{thread}}}}
+	written, got := roundTrip(t, p)
+	if len(written.Profiles) != 1 {
+		t.Fatalf("Expected a profile per thread, got %d", len(written.Profiles))
+	}
+	prof := written.Profiles[0]
+	if prof.Type != "sampled" || prof.Name != "Lunch (42): main-thread" || prof.EndValue != 5 {
+		t.Errorf("Unexpected profile %+v", prof)
+	}
+	expectStacks(t, got.Processes[0].Threads[0], []string{"main;cook 2", "main;eat 3"})
+}
@@ -0,0 +1,235 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spindump parses spindump and symbolicated tailspin reports.
+package spindump
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+type SpindumpParser struct {
+	lines []string
+	// offsets of the start of each line in the input.
+	offsets []int64
+}
+
+func MakeSpindumpParser(file io.Reader) (p SpindumpParser, err error) {
+	p.lines, p.offsets, err = internal.ScanLines(file)
+	return p, err
+}
+
+var (
+	// Steps:            1000 (10ms sampling interval)
+	intervalRe = regexp.MustCompile(`\((\d+(?:\.\d+)?)ms sampling interval\)`)
+	// Process:          Sandwich [1234]
+	processRe = regexp.MustCompile(`^Process:\s+(.*?)\s\[(\d+)\]`)
+	// Thread 0x1a2b    DispatchQueue "com.apple.main-thread"(1)    1000 samples (1-1000)    priority 46
+	threadRe = regexp.MustCompile(`^Thread 0x([0-9a-f]+)\s*(.*?)\s+(\d+) samples?\b`)
+	// 1000  main + 20 (Sandwich + 1234) [0x10a3c1f3e]
+	// Kernel frames are prefixed with a '*'.
+	frameRe = regexp.MustCompile(`^(\s*)\*?(\d+)\s+(.*)$`)
+	// main + 20 (Sandwich + 1234) [0x10a3c1f3e]
+	symbolRe = regexp.MustCompile(`^(.*?) \((.+?) \+ (\d+)\)(?: \[0x[0-9a-f]+\])?$`)
+)
+
+// Capabilities of spindumps: the call trees of every process with the tids
+// of their threads.
+func (s SpindumpParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		ThreadIDs:    true,
+		MultiProcess: true,
+		Units:        []internal.ValueType{internal.CPUValueType},
+	}
+}
+
+func (s SpindumpParser) ParseProfile() (p *internal.TimeProfile, err error) {
+	p = &internal.TimeProfile{}
+
+	// Default sampling interval of 10ms == 10,000,000 ns
+	var interval int64 = 10_000_000
+	var currentProcess *internal.Process
+	var currentThread *internal.Thread
+	var lastFrame *internal.Frame
+	var threadIndent int
+	// attributes of the device, from the header before the processes.
+	attributes := make(map[string]string)
+	for i, line := range s.lines {
+		position := internal.PositionOf(s.offsets, i)
+		trimmed := strings.TrimSpace(line)
+		if currentThread != nil {
+			if trimmed == "" {
+				currentThread = nil
+				continue
+			}
+			matches := frameRe.FindStringSubmatch(line)
+			if matches == nil {
+				return nil, fmt.Errorf("Could not parse stack line: %s", line)
+			}
+			if lastFrame == nil {
+				threadIndent = len(matches[1])
+			}
+			count, err := strconv.ParseInt(matches[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing sample count %s: %v", line, err)
+			}
+			name, binary := symbolName(matches[3])
+			frame := &internal.Frame{
+				Children:     make([]*internal.Frame, 0),
+				SelfWeightNs: count * interval,
+				SymbolName:   name,
+				Binary:       binary,
+				// 2 spaces per depth.
+				Depth:    (len(matches[1])-threadIndent)/2 + 1,
+				Position: position,
+			}
+			if err := addFrame(currentThread, lastFrame, frame); err != nil {
+				return nil, err
+			}
+			lastFrame = frame
+			continue
+		}
+		if matches := intervalRe.FindStringSubmatch(trimmed); matches != nil && strings.HasPrefix(trimmed, "Steps:") {
+			ms, err := strconv.ParseFloat(matches[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing sampling interval %s: %v", trimmed, err)
+			}
+			interval = int64(ms * 1_000_000)
+			continue
+		}
+		if label, value, ok := internal.ParseAttribute(trimmed); ok && currentProcess == nil {
+			attributes[label] = value
+			continue
+		}
+		if matches := processRe.FindStringSubmatch(trimmed); matches != nil {
+			pid, err := strconv.ParseUint(matches[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing pid %s: %v", trimmed, err)
+			}
+			currentProcess = &internal.Process{
+				Name:     matches[1],
+				Pid:      pid,
+				Threads:  make([]*internal.Thread, 0),
+				Position: position,
+			}
+			p.Processes = append(p.Processes, currentProcess)
+			continue
+		}
+		if matches := threadRe.FindStringSubmatch(trimmed); matches != nil && currentProcess != nil {
+			tid, err := strconv.ParseUint(matches[1], 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing tid %s: %v", trimmed, err)
+			}
+			name := strings.TrimSpace(matches[2])
+			if name == "" {
+				name = fmt.Sprintf("Thread 0x%s", matches[1])
+			}
+			currentThread = &internal.Thread{
+				Name:     name,
+				Tid:      tid,
+				Frames:   make([]*internal.Frame, 0),
+				Position: position,
+			}
+			currentProcess.Threads = append(currentProcess.Threads, currentThread)
+			lastFrame = nil
+			continue
+		}
+		if trimmed != "" {
+			internal.Reject(position, line, "not a process, thread or stack line")
+		}
+	}
+	if len(p.Processes) == 0 {
+		return nil, errors.New("No processes found in spindump.")
+	}
+
+	// Sample counts include the children.
+	for _, process := range p.Processes {
+		internal.SetAttributes(process, attributes)
+		for _, thread := range process.Threads {
+			for _, frame := range thread.Frames {
+				if err := fixSelfWeight(frame); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+func addFrame(thread *internal.Thread, lastFrame *internal.Frame, frame *internal.Frame) error {
+	if frame.Depth == 1 {
+		thread.Frames = append(thread.Frames, frame)
+		return nil
+	}
+	if lastFrame == nil {
+		return fmt.Errorf("First frame %s of thread %s is indented", frame.SymbolName, thread.Name)
+	}
+	if frame.Depth > lastFrame.Depth {
+		if frame.Depth-lastFrame.Depth != 1 {
+			return fmt.Errorf("Skipped frame depth from frame %s to %s",
+				lastFrame.SymbolName, frame.SymbolName)
+		}
+		lastFrame.Children = append(lastFrame.Children, frame)
+		frame.Parent = lastFrame
+		return nil
+	}
+	// Find parent
+	for parent := lastFrame.Parent; parent != nil; parent = parent.Parent {
+		if parent.Depth == frame.Depth-1 {
+			parent.Children = append(parent.Children, frame)
+			frame.Parent = parent
+			return nil
+		}
+	}
+	return fmt.Errorf("Could not find the parent of frame %s", frame.SymbolName)
+}
+
+// symbolName removes the binary and address from a spindump symbol, keeping
+// the binary only for unsymbolicated frames. It also returns the binary.
+func symbolName(symbol string) (string, string) {
+	matches := symbolRe.FindStringSubmatch(symbol)
+	if matches == nil {
+		return symbol, ""
+	}
+	if matches[1] != "???" {
+		return matches[1], matches[2]
+	}
+	offset, err := strconv.ParseUint(matches[3], 10, 64)
+	if err != nil {
+		return symbol, matches[2]
+	}
+	return fmt.Sprintf("0x%x (in %s)", offset, matches[2]), matches[2]
+}
+
+func fixSelfWeight(frame *internal.Frame) error {
+	for _, child := range frame.Children {
+		frame.SelfWeightNs -= child.SelfWeightNs
+		if frame.SelfWeightNs < 0 {
+			return fmt.Errorf(
+				"Frame %s had fewer samples than its children. The file is either corrupt or this is a bug.",
+				frame.SymbolName)
+		}
+		if err := fixSelfWeight(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spindump
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const (
+	validSpindump = `Date/Time:        2021-03-15 15:41:58.406 +0100
+OS Version:       macOS 11.2.2 (Build 20D80)
+Duration:         1.00s
+Steps:            100 (10ms sampling interval)
+
+Process:          Sandwich [1234]
+Path:             /Applications/Sandwich.app/Contents/MacOS/Sandwich
+
+  Thread 0x1a2b    DispatchQueue "com.apple.main-thread"(1)    4 samples (1-4)    priority 46 (base 46)
+  4  start + 1 (libdyld.dylib + 87921) [0x7fff2037a6f1]
+    4  main + 20 (Sandwich + 1234) [0x10a3c1f3e]
+      3  makeSandwich + 5 (Sandwich + 2000) [0x10a3c2000]
+        *1  ??? (kernel + 4096) [0xffffff8000201000]
+      1  ??? (Sandwich + 255) [0x10a3c10ff]
+
+  Thread 0x1a2c    1 sample (1)    priority 31 (base 31)
+  1  start_wqthread + 0 (libsystem_pthread.dylib + 8) [0x7fff2035c458]
+
+Binary Images:
+         0x10a3c0000 -        0x10a3c3fff  Sandwich (1.0) <UUID> /Applications/Sandwich.app/Contents/MacOS/Sandwich
+`
+)
+
+func TestSpindumpParsing(t *testing.T) {
+	parser, err := MakeSpindumpParser(strings.NewReader(validSpindump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{
+			{
+				Name: "Sandwich",
+				Pid:  1234,
+				Threads: []*internal.Thread{
+					{
+						Name: `DispatchQueue "com.apple.main-thread"(1)`,
+						Tid:  0x1a2b,
+						Frames: []*internal.Frame{
+							{
+								SymbolName: "start + 1",
+								Depth:      1,
+								Children: []*internal.Frame{
+									{
+										SymbolName: "main + 20",
+										Depth:      2,
+										Children: []*internal.Frame{
+											{
+												SymbolName:   "makeSandwich + 5",
+												Depth:        3,
+												SelfWeightNs: 20_000_000,
+												Children: []*internal.Frame{
+													{
+														SymbolName:   "0x1000 (in kernel)",
+														Depth:        4,
+														SelfWeightNs: 10_000_000,
+													},
+												},
+											},
+											{
+												SymbolName:   "0xff (in Sandwich)",
+												Depth:        3,
+												SelfWeightNs: 10_000_000,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+					{
+						Name: "Thread 0x1a2c",
+						Tid:  0x1a2c,
+						Frames: []*internal.Frame{
+							{
+								SymbolName:   "start_wqthread + 0",
+								Depth:        1,
+								SelfWeightNs: 10_000_000,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	internal.TimeProfileEquals(t, timeProfile, expected)
+}
+
+func TestSysdiagnoseParsing(t *testing.T) {
+	var archive bytes.Buffer
+	gz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gz)
+	files := map[string]string{
+		"sysdiagnose_2021.03.15/logs/README.txt":    "Not a spindump",
+		"sysdiagnose_2021.03.15/spindump.txt":       validSpindump,
+		"sysdiagnose_2021.03.15/tailspind.tailspin": "\x00binary",
+	}
+	for name, content := range files {
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+	gz.Close()
+
+	parser, err := MakeSysdiagnoseParser(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(timeProfile.Processes) != 1 || timeProfile.Processes[0].Name != "Sandwich" {
+		t.Errorf("Expected the Sandwich process from spindump.txt, got %v", timeProfile.Processes)
+	}
+}
+
+func TestSpindumpBinaries(t *testing.T) {
+	for symbol, expected := range map[string][2]string{
+		"start + 1 (libdyld.dylib + 87921) [0x7fff2037a6f1]": {"start + 1", "libdyld.dylib"},
+		"??? (Sandwich + 255) [0x10a3c10ff]":                 {"0xff (in Sandwich)", "Sandwich"},
+		"<truncated backtrace>":                              {"<truncated backtrace>", ""},
+	} {
+		name, binary := symbolName(symbol)
+		if name != expected[0] || binary != expected[1] {
+			t.Errorf("%s: got %s in %s, expected %s in %s", symbol, name, binary, expected[0], expected[1])
+		}
+	}
+}
+
+func TestSpindumpRejects(t *testing.T) {
+	var rejects strings.Builder
+	internal.SetRejectOutput(&rejects)
+	defer internal.SetRejectOutput(nil)
+	parser, err := MakeSpindumpParser(strings.NewReader(validSpindump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseProfile(); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, reject := range strings.Split(strings.TrimSuffix(rejects.String(), "\n"), "\n") {
+		lines = append(lines, strings.SplitN(reject, "\t", 2)[0])
+	}
+	// The unused header lines and the binary images, but none of the stacks.
+	if got := strings.Join(lines, ","); got != "1,3,7,19,20" {
+		t.Errorf("Expected the lines 1,3,7,19,20 to be rejected, got %s in %q", got, rejects.String())
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spindump
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// SysdiagnoseParser parses the spindump reports inside a sysdiagnose archive.
+type SysdiagnoseParser struct {
+	spindumps []SpindumpParser
+}
+
+// isSpindump matches the text reports of a sysdiagnose that have the spindump
+// format, e.g. spindump.txt and the tailspin reports symbolicated on device.
+func isSpindump(name string) bool {
+	base := strings.ToLower(path.Base(name))
+	return strings.HasSuffix(base, ".txt") &&
+		(strings.Contains(base, "spindump") || strings.Contains(base, "tailspin"))
+}
+
+func MakeSysdiagnoseParser(file io.Reader) (p SysdiagnoseParser, err error) {
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return p, fmt.Errorf("sysdiagnose is not a .tar.gz archive: %v", err)
+	}
+	defer gz.Close()
+	archive := tar.NewReader(gz)
+	var tailspins []string
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return p, fmt.Errorf("Error reading sysdiagnose archive: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if strings.HasSuffix(header.Name, ".tailspin") {
+			tailspins = append(tailspins, header.Name)
+			continue
+		}
+		if !isSpindump(header.Name) {
+			continue
+		}
+		spindump, err := MakeSpindumpParser(archive)
+		if err != nil {
+			return p, fmt.Errorf("Error reading %s: %v", header.Name, err)
+		}
+		p.spindumps = append(p.spindumps, spindump)
+	}
+	if len(p.spindumps) == 0 {
+		if len(tailspins) > 0 {
+			return p, fmt.Errorf(
+				"Only binary tailspin files were found (%s). Convert them on a Mac with "+
+					"'spindump -i <file>.tailspin -o spindump.txt' and use --format=spindump.",
+				strings.Join(tailspins, ", "))
+		}
+		return p, errors.New("No spindump reports found in sysdiagnose archive.")
+	}
+	return p, nil
+}
+
+// Capabilities of sysdiagnose archives are those of the spindump in them.
+func (s SysdiagnoseParser) Capabilities() internal.Capabilities {
+	return SpindumpParser{}.Capabilities()
+}
+
+func (s SysdiagnoseParser) ParseProfile() (p *internal.TimeProfile, err error) {
+	p = &internal.TimeProfile{}
+	for _, spindump := range s.spindumps {
+		report, err := spindump.ParseProfile()
+		if err != nil {
+			return nil, err
+		}
+		p.Processes = append(p.Processes, report.Processes...)
+	}
+	return p, nil
+}
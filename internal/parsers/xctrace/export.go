@@ -0,0 +1,136 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xctrace
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// tables are the paths of the tables ExportTable can export within a run, by
+// the names of the --instrument-table flag.
+var tables = map[string]string{
+	"time-profile": `data/table[@schema="time-profile"]`,
+	"cpu-profile":  `data/table[@schema="cpu-profile"]`,
+	"allocations":  `tracks/track[@name="Allocations"]/details/detail[@name="Allocations List"]`,
+}
+
+// DefaultTable is the table of the Time Profiler instrument.
+const DefaultTable = "time-profile"
+
+// TableNames returns the names of the tables ExportTable can export.
+func TableNames() []string {
+	names := make([]string, 0, len(tables))
+	for name := range tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// IsTraceBundle reports whether path names an Instruments .trace bundle.
+func IsTraceBundle(path string) bool {
+	return strings.HasSuffix(strings.TrimRight(filepath.Clean(path), "/"), ".trace")
+}
+
+// tableXPath returns the xpath of a table of a run, counted from 1.
+func tableXPath(table string, run int) (string, error) {
+	path, ok := tables[table]
+	if !ok {
+		return "", fmt.Errorf("Unknown instrument table %s, must be one of %s", table, strings.Join(TableNames(), ", "))
+	}
+	if run < 1 {
+		return "", fmt.Errorf("Runs are counted from 1, was %d", run)
+	}
+	return fmt.Sprintf(`/trace-toc/run[@number="%d"]/%s`, run, path), nil
+}
+
+// ExportTable runs `xcrun xctrace export` on a .trace bundle and returns the
+// XML of a table of one of its runs, e.g. the time profile of run 1. It
+// requires Xcode on macOS.
+func ExportTable(tracePath string, table string, run int) ([]byte, error) {
+	xpath, err := tableXPath(table, run)
+	if err != nil {
+		return nil, err
+	}
+	return export(tracePath, "--xpath", xpath)
+}
+
+// ExportAttributes returns the attributes of the device a run of a .trace
+// bundle was recorded on, from its table of contents.
+func ExportAttributes(tracePath string, run int) (map[string]string, error) {
+	exported, err := export(tracePath, "--toc")
+	if err != nil {
+		return nil, err
+	}
+	return parseTOC(exported, run)
+}
+
+// toc is the table of contents of a .trace bundle.
+type toc struct {
+	Runs []struct {
+		Number int `xml:"number,attr"`
+		Device struct {
+			Platform  string `xml:"platform,attr"`
+			Model     string `xml:"model,attr"`
+			OSVersion string `xml:"os-version,attr"`
+		} `xml:"info>target>device"`
+	} `xml:"run"`
+}
+
+func parseTOC(content []byte, run int) (map[string]string, error) {
+	var t toc
+	if err := xml.Unmarshal(content, &t); err != nil {
+		return nil, fmt.Errorf("Could not parse xctrace table of contents: %v", err)
+	}
+	for _, r := range t.Runs {
+		if r.Number != run {
+			continue
+		}
+		attributes := make(map[string]string)
+		if r.Device.OSVersion != "" {
+			// Like the OS Version of reports, e.g. "macOS 11.2.3 (20D91)".
+			attributes[internal.OSVersionLabel] = strings.TrimSpace(r.Device.Platform + " " + r.Device.OSVersion)
+		}
+		if r.Device.Model != "" {
+			attributes[internal.DeviceModelLabel] = r.Device.Model
+		}
+		return attributes, nil
+	}
+	return nil, fmt.Errorf("Run %d not found in xctrace table of contents", run)
+}
+
+// export runs `xcrun xctrace export` on a .trace bundle with the arguments
+// selecting what to export.
+func export(tracePath string, args ...string) ([]byte, error) {
+	cmd := exec.Command("xcrun", append([]string{"xctrace", "export", "--input", tracePath}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if _, ok := err.(*exec.Error); ok {
+			return nil, fmt.Errorf("Could not run xctrace, converting .trace bundles requires Xcode on macOS: %v", err)
+		}
+		return nil, fmt.Errorf("xctrace export of %s failed: %v\n%s", tracePath, err, stderr.String())
+	}
+	return out, nil
+}
@@ -0,0 +1,334 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xctrace parses the time profile tables exported by
+// `xctrace export`, and exports them from Instruments .trace bundles.
+package xctrace
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// element is an XML element of a row. Elements that were already written in
+// the export are replaced by a ref attribute with the id of the first one.
+type element struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*element
+}
+
+func (e *element) child(name string) *element {
+	for _, c := range e.children {
+		if c.name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// sample is a row of the time profile table.
+type sample struct {
+	time    int64
+	pid     uint64
+	process string
+	tid     uint64
+	thread  string
+	weight  int64
+	// weightElement is the name of the element of the weight, see weightTypes.
+	weightElement string
+	// coreType is "P" or "E", if the export records it.
+	coreType string
+	// stack from the innermost frame.
+	stack    []string
+	binaries []string
+}
+
+type XctraceParser struct {
+	samples []sample
+}
+
+var (
+	// Thread fmt attributes look like "Main Thread 0x1a2b (Sandwich, pid: 1234)".
+	threadNameRe = regexp.MustCompile(`^(.*?)\s+0x[0-9a-f]+\b`)
+	// Process fmt attributes look like "Sandwich (1234)".
+	processNameRe = regexp.MustCompile(`^(.*?)\s+\(\d+\)$`)
+	// Core fmt attributes on Apple Silicon look like "CPU 4 (P Core)".
+	coreTypeRe = regexp.MustCompile(`\b([PE])[ -]?[Cc]ore\b`)
+)
+
+// weightTypes are the value types of the weight elements of the tables, e.g.
+// the cycles of the cpu-profile table.
+var weightTypes = map[string]internal.ValueType{
+	"weight":        internal.CPUValueType,
+	"cycle-weight":  {Type: "cycles", Unit: "count"},
+	"size-in-bytes": {Type: "space", Unit: "bytes"},
+}
+
+func MakeXctraceParser(file io.Reader) (p XctraceParser, err error) {
+	decoder := xml.NewDecoder(file)
+	ids := make(map[string]*element)
+	var stack []*element
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return p, fmt.Errorf("Could not parse xctrace XML: %v", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			e := &element{name: t.Name.Local, attrs: make(map[string]string)}
+			for _, attr := range t.Attr {
+				e.attrs[attr.Name.Local] = attr.Value
+			}
+			if id, ok := e.attrs["id"]; ok {
+				ids[id] = e
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, e)
+			}
+			if e.name == "row" || len(stack) > 0 {
+				stack = append(stack, e)
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		case xml.EndElement:
+			if len(stack) == 0 {
+				continue
+			}
+			row := stack[0]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				s, err := parseRow(row, ids)
+				if err != nil {
+					return p, err
+				}
+				p.samples = append(p.samples, s)
+			}
+		}
+	}
+	if len(p.samples) == 0 {
+		return p, errors.New("No rows found in xctrace XML, the trace may not have the instrument table or run.")
+	}
+	return p, nil
+}
+
+// resolve returns the element a ref element refers to.
+func resolve(e *element, ids map[string]*element) (*element, error) {
+	if e == nil {
+		return nil, nil
+	}
+	ref, ok := e.attrs["ref"]
+	if !ok {
+		return e, nil
+	}
+	target, ok := ids[ref]
+	if !ok {
+		return nil, fmt.Errorf("Unknown reference %s in <%s>", ref, e.name)
+	}
+	return target, nil
+}
+
+func parseInt(e *element) (int64, error) {
+	return strconv.ParseInt(strings.TrimSpace(e.text), 10, 64)
+}
+
+func parseRow(row *element, ids map[string]*element) (s sample, err error) {
+	for _, c := range row.children {
+		c, err := resolve(c, ids)
+		if err != nil {
+			return s, err
+		}
+		switch c.name {
+		case "sample-time":
+			if s.time, err = parseInt(c); err != nil {
+				return s, fmt.Errorf("Error parsing sample time: %v", err)
+			}
+		case "weight", "cycle-weight", "size-in-bytes":
+			if s.weight, err = parseInt(c); err != nil {
+				return s, fmt.Errorf("Error parsing %s: %v", c.name, err)
+			}
+			s.weightElement = c.name
+		case "thread":
+			if err := s.parseThread(c, ids); err != nil {
+				return s, err
+			}
+		case "core":
+			if matches := coreTypeRe.FindStringSubmatch(c.attrs["fmt"]); matches != nil {
+				s.coreType = matches[1]
+			}
+		case "process":
+			// Tables of process events have no thread.
+			if err := s.parseProcess(c, ids); err != nil {
+				return s, err
+			}
+		case "backtrace":
+			if err := s.parseBacktrace(c, ids); err != nil {
+				return s, err
+			}
+		case "tagged-backtrace":
+			// Newer Xcode versions wrap the backtrace with its tags.
+			backtrace, err := resolve(c.child("backtrace"), ids)
+			if err != nil {
+				return s, err
+			}
+			if backtrace != nil {
+				if err := s.parseBacktrace(backtrace, ids); err != nil {
+					return s, err
+				}
+			}
+		}
+	}
+	return s, nil
+}
+
+func (s *sample) parseThread(thread *element, ids map[string]*element) error {
+	s.thread = thread.attrs["fmt"]
+	if matches := threadNameRe.FindStringSubmatch(s.thread); matches != nil {
+		s.thread = matches[1]
+	}
+	tid, err := resolve(thread.child("tid"), ids)
+	if err != nil {
+		return err
+	}
+	if tid != nil {
+		if s.tid, err = strconv.ParseUint(strings.TrimSpace(tid.text), 10, 64); err != nil {
+			return fmt.Errorf("Error parsing tid: %v", err)
+		}
+	}
+	process, err := resolve(thread.child("process"), ids)
+	if err != nil || process == nil {
+		return err
+	}
+	return s.parseProcess(process, ids)
+}
+
+func (s *sample) parseProcess(process *element, ids map[string]*element) error {
+	s.process = process.attrs["fmt"]
+	if matches := processNameRe.FindStringSubmatch(s.process); matches != nil {
+		s.process = matches[1]
+	}
+	pid, err := resolve(process.child("pid"), ids)
+	if err != nil {
+		return err
+	}
+	if pid != nil {
+		if s.pid, err = strconv.ParseUint(strings.TrimSpace(pid.text), 10, 64); err != nil {
+			return fmt.Errorf("Error parsing pid: %v", err)
+		}
+	}
+	return nil
+}
+
+func (s *sample) parseBacktrace(backtrace *element, ids map[string]*element) error {
+	for _, f := range backtrace.children {
+		f, err := resolve(f, ids)
+		if err != nil {
+			return err
+		}
+		if f.name != "frame" {
+			continue
+		}
+		name := f.attrs["name"]
+		if name == "" {
+			name = f.attrs["addr"]
+		}
+		if name == "" {
+			// Unsymbolicated frames only have their formatted address.
+			name = f.attrs["fmt"]
+		}
+		binary, err := resolve(f.child("binary"), ids)
+		if err != nil {
+			return err
+		}
+		binaryName := ""
+		if binary != nil {
+			binaryName = binary.attrs["name"]
+		}
+		s.stack = append(s.stack, name)
+		s.binaries = append(s.binaries, binaryName)
+	}
+	return nil
+}
+
+// Capabilities of xctrace exports: timed samples of every process with the
+// tids of their threads.
+func (x XctraceParser) Capabilities() internal.Capabilities {
+	return internal.Capabilities{
+		Timestamps:   true,
+		ThreadIDs:    true,
+		MultiProcess: true,
+		Units:        []internal.ValueType{weightTypes["weight"], weightTypes["cycle-weight"], weightTypes["size-in-bytes"]},
+	}
+}
+
+func (x XctraceParser) ParseProfile() (*internal.TimeProfile, error) {
+	p := &internal.TimeProfile{}
+	processes := make(map[uint64]*internal.Process)
+	type threadKey struct{ pid, tid uint64 }
+	threads := make(map[threadKey]*internal.Thread)
+	weightElement := x.samples[0].weightElement
+	if weightElement != "" && weightElement != "weight" {
+		p.ValueType = weightTypes[weightElement]
+	}
+	for _, s := range x.samples {
+		if s.weightElement != weightElement {
+			return nil, fmt.Errorf("Rows mix %s and %s weights", weightElement, s.weightElement)
+		}
+		proc, ok := processes[s.pid]
+		if !ok {
+			proc = &internal.Process{Name: s.process, Pid: s.pid, Threads: make([]*internal.Thread, 0)}
+			processes[s.pid] = proc
+			p.Processes = append(p.Processes, proc)
+		}
+		key := threadKey{s.pid, s.tid}
+		thread, ok := threads[key]
+		if !ok {
+			thread = &internal.Thread{Name: s.thread, Tid: s.tid, Frames: make([]*internal.Frame, 0)}
+			threads[key] = thread
+			proc.Threads = append(proc.Threads, thread)
+		}
+		if len(s.stack) == 0 {
+			continue
+		}
+		stack := make([]string, len(s.stack))
+		for i, name := range s.stack {
+			stack[len(s.stack)-1-i] = name
+		}
+		thread.AddTimedStack(s.time, stack, s.weight)
+		timed := &thread.Timeline[len(thread.Timeline)-1]
+		timed.CoreType = s.coreType
+		// Record the binaries of the frames created for the stack.
+		f := timed.Frame
+		for i := 0; f != nil; i, f = i+1, f.Parent {
+			if f.Binary == "" {
+				f.Binary = s.binaries[i]
+			}
+		}
+	}
+	return p, nil
+}
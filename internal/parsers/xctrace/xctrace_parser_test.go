@@ -0,0 +1,196 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xctrace
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+const (
+	validXctrace = `<?xml version="1.0"?>
+<trace-query-result>
+<node xpath='//trace-toc[1]/run[1]/data[1]/table[5]'>
+<schema name="time-profile"><col><mnemonic>time</mnemonic></col></schema>
+<row>
+<sample-time id="1" fmt="00:00.001.000">1000000</sample-time>
+<thread id="2" fmt="Main Thread 0x1a2b (Sandwich, pid: 1234)"><tid id="3" fmt="0x1a2b">6699</tid><process id="4" fmt="Sandwich (1234)"><pid id="5" fmt="1234">1234</pid></process></thread>
+<core id="6" fmt="CPU 2">2</core>
+<thread-state id="7" fmt="Running">Running</thread-state>
+<weight id="8" fmt="1.00 ms">1000000</weight>
+<backtrace id="9"><frame id="10" name="eat" addr="0x100003f00"><binary id="11" name="Sandwich"/></frame><frame id="12" name="main" addr="0x100003e00"><binary ref="11"/></frame></backtrace>
+</row>
+<row>
+<sample-time id="13" fmt="00:00.002.000">2000000</sample-time>
+<thread ref="2"/>
+<core ref="6"/>
+<thread-state ref="7"/>
+<weight ref="8"/>
+<backtrace id="14"><frame id="15" name="" addr="0x7fff2035c458"><binary id="16" name="libsystem_kernel.dylib"/></frame><frame ref="12"/></backtrace>
+</row>
+<row>
+<sample-time id="17" fmt="00:00.003.000">3000000</sample-time>
+<thread ref="2"/>
+<core ref="6"/>
+<thread-state ref="7"/>
+<weight ref="8"/>
+<backtrace ref="9"/>
+</row>
+</node>
+</trace-query-result>
+`
+)
+
+func TestXctraceParsing(t *testing.T) {
+	parser, err := MakeXctraceParser(strings.NewReader(validXctrace))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := &internal.Thread{Name: "Main Thread", Tid: 6699}
+	thread.AddStack([]string{"main", "eat"}, 2_000_000)
+	thread.AddStack([]string{"main", "0x7fff2035c458"}, 1_000_000)
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{{Name: "Sandwich", Pid: 1234, Threads: []*internal.Thread{thread}}},
+	}
+	internal.TimeProfileEquals(t, got, expected)
+
+	gotThread := got.Processes[0].Threads[0]
+	if len(gotThread.Timeline) != 3 || gotThread.Timeline[2].Time != 3_000_000 {
+		t.Errorf("Unexpected timeline %v", gotThread.Timeline)
+	}
+	main := gotThread.Frames[0]
+	if main.Binary != "Sandwich" || main.Children[1].Binary != "libsystem_kernel.dylib" {
+		t.Errorf("Unexpected binaries %s and %s", main.Binary, main.Children[1].Binary)
+	}
+}
+
+func TestXctraceCoreTypes(t *testing.T) {
+	input := strings.Replace(validXctrace, `fmt="CPU 2"`, `fmt="CPU 2 (E Core)"`, 1)
+	parser, err := MakeXctraceParser(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sample := range got.Processes[0].Threads[0].Timeline {
+		if sample.CoreType != "E" {
+			t.Errorf("Expected samples on E cores, got %q", sample.CoreType)
+		}
+	}
+}
+
+func TestXctraceCycleWeights(t *testing.T) {
+	// The cpu-profile table weighs samples by cycles.
+	input := strings.Replace(validXctrace, `<weight id="8" fmt="1.00 ms">1000000</weight>`, `<cycle-weight id="8">3000</cycle-weight>`, 1)
+	input = strings.Replace(input, `<weight ref="8"/>`, `<cycle-weight ref="8"/>`, -1)
+	parser, err := MakeXctraceParser(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vt := got.GetValueType(); vt.Type != "cycles" || vt.Unit != "count" {
+		t.Errorf("Expected cycle counts, got %v", vt)
+	}
+	if eat := got.Processes[0].Threads[0].Frames[0].Children[0]; eat.SelfWeightNs != 6000 {
+		t.Errorf("Expected 6000 cycles in eat, got %d", eat.SelfWeightNs)
+	}
+}
+
+func TestTableXPath(t *testing.T) {
+	got, err := tableXPath("cpu-profile", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `/trace-toc/run[@number="2"]/data/table[@schema="cpu-profile"]`; got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if _, err := tableXPath("time-profile", 0); err == nil {
+		t.Error("Expected an error for run 0")
+	}
+	if _, err := tableXPath("counters", 1); err == nil {
+		t.Error("Expected an error for an unknown table")
+	}
+}
+
+func TestParseTOC(t *testing.T) {
+	const tableOfContents = `<?xml version="1.0"?>
+<trace-toc>
+<run number="1"><info><target>
+<device platform="macOS" model="MacBook Pro" name="My Mac" os-version="11.2.3 (20D91)" uuid="C0FFEE"/>
+<process type="attached" name="Sandwich" pid="1234"/>
+</target></info></run>
+<run number="2"><info><target>
+<device platform="iOS" model="iPhone13,2" os-version="14.4 (18D52)"/>
+</target></info></run>
+</trace-toc>`
+	got, err := parseTOC([]byte(tableOfContents), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[internal.OSVersionLabel] != "iOS 14.4 (18D52)" || got[internal.DeviceModelLabel] != "iPhone13,2" {
+		t.Errorf("Unexpected attributes of run 2: %v", got)
+	}
+	if _, err := parseTOC([]byte(tableOfContents), 3); err == nil {
+		t.Error("Expected an error for a missing run")
+	}
+}
+
+func TestIsTraceBundle(t *testing.T) {
+	for path, expected := range map[string]bool{
+		"Launch.trace":       true,
+		"/tmp/Launch.trace/": true,
+		"profile.txt":        false,
+	} {
+		if IsTraceBundle(path) != expected {
+			t.Errorf("IsTraceBundle(%s) != %v", path, expected)
+		}
+	}
+}
+
+func TestXctraceTaggedBacktraces(t *testing.T) {
+	const tagged = `<?xml version="1.0"?>
+<trace-query-result><node><schema name="time-profile"/>
+<row><sample-time id="1">1000000</sample-time>
+<process id="2" fmt="Sandwich (1234)"><pid id="3">1234</pid></process>
+<weight id="4">1000000</weight>
+<tagged-backtrace id="5" fmt="eat ← main"><backtrace id="6"><frame id="7" name="eat"/><frame id="8" fmt="0x100003e00"/></backtrace></tagged-backtrace></row>
+<row><sample-time id="9">2000000</sample-time><process ref="2"/><weight ref="4"/><tagged-backtrace ref="5"/></row>
+</node></trace-query-result>`
+	parser, err := MakeXctraceParser(strings.NewReader(tagged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parser.ParseProfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	thread := &internal.Thread{}
+	thread.AddStack([]string{"0x100003e00", "eat"}, 2_000_000)
+	expected := &internal.TimeProfile{
+		Processes: []*internal.Process{{Name: "Sandwich", Pid: 1234, Threads: []*internal.Thread{thread}}},
+	}
+	internal.TimeProfileEquals(t, got, expected)
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Position is the location in the input that a Process, Thread or Frame was
+// parsed from, so that tools like editors can map samples back to the input.
+type Position struct {
+	// Line is the 1-based line number, or 0 if the parser does not track
+	// positions.
+	Line int
+	// Offset is the byte offset of the start of the line.
+	Offset int64
+}
+
+// IsValid reports whether the position was set by the parser.
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d (offset %d)", p.Line, p.Offset)
+}
+
+// maxLineLength is the longest line ScanLines accepts. Symbol names of C++
+// templates can get very long.
+const maxLineLength = 16 * 1024 * 1024
+
+// ScanLines reads all lines of the input, together with the byte offset of the
+// start of each line.
+func ScanLines(file io.Reader) (lines []string, offsets []int64, err error) {
+	scanner := NewLineScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		offsets = append(offsets, scanner.Position().Offset)
+	}
+	return lines, offsets, scanner.Err()
+}
+
+// LineScanner reads the lines of the input one at a time with their
+// positions, for parsers that don't keep the whole input in memory. It can
+// look one line ahead.
+type LineScanner struct {
+	scanner *bufio.Scanner
+	// consumed is the number of bytes the scanner consumed, start the offset
+	// of its last line.
+	consumed, start int64
+	text            string
+	position        Position
+	// next is the line after the current one if peeked is set, and hasNext
+	// whether there is one.
+	next     string
+	peeked   bool
+	hasNext  bool
+	nextLine Position
+}
+
+// NewLineScanner returns a scanner of the lines of file.
+func NewLineScanner(file io.Reader) *LineScanner {
+	s := &LineScanner{scanner: bufio.NewScanner(file)}
+	s.scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	s.scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		advance, token, err := bufio.ScanLines(data, atEOF)
+		if token != nil {
+			s.start = s.consumed
+		}
+		s.consumed += int64(advance)
+		return advance, token, err
+	})
+	return s
+}
+
+// read reads the next line.
+func (s *LineScanner) read() {
+	s.hasNext = s.scanner.Scan()
+	if s.hasNext {
+		s.next = s.scanner.Text()
+		s.nextLine = Position{Line: s.position.Line + 1, Offset: s.start}
+	}
+}
+
+// Scan advances to the next line, and returns false at the end of the input
+// or on an error.
+func (s *LineScanner) Scan() bool {
+	if !s.peeked {
+		s.read()
+	}
+	s.peeked = false
+	if !s.hasNext {
+		return false
+	}
+	s.text, s.position = s.next, s.nextLine
+	return true
+}
+
+// Peek returns the line after the current one without advancing, and false
+// if there is none.
+func (s *LineScanner) Peek() (string, bool) {
+	if !s.peeked {
+		s.read()
+		s.peeked = true
+	}
+	return s.next, s.hasNext
+}
+
+// Text returns the current line.
+func (s *LineScanner) Text() string {
+	return s.text
+}
+
+// Position returns the position of the current line.
+func (s *LineScanner) Position() Position {
+	return s.position
+}
+
+// Err returns the error that ended the scanning, if any.
+func (s *LineScanner) Err() error {
+	return s.scanner.Err()
+}
+
+// PositionOf returns the position of the line with the given 0-based index.
+func PositionOf(offsets []int64, index int) Position {
+	if index < 0 || index >= len(offsets) {
+		return Position{}
+	}
+	return Position{Line: index + 1, Offset: offsets[index]}
+}
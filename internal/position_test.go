@@ -0,0 +1,66 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScanLines(t *testing.T) {
+	lines, offsets, err := ScanLines(strings.NewReader("first\r\nsecond\n\nµs last"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedLines := []string{"first", "second", "", "µs last"}
+	expectedOffsets := []int64{0, 7, 14, 15}
+	if len(lines) != len(expectedLines) || len(offsets) != len(expectedOffsets) {
+		t.Fatalf("Expected %d lines, got %v at %v", len(expectedLines), lines, offsets)
+	}
+	for i := range lines {
+		if lines[i] != expectedLines[i] || offsets[i] != expectedOffsets[i] {
+			t.Errorf("Line %d was %q at %d, expected %q at %d",
+				i, lines[i], offsets[i], expectedLines[i], expectedOffsets[i])
+		}
+	}
+	if p := PositionOf(offsets, 1); p.Line != 2 || p.Offset != 7 {
+		t.Errorf("Expected line 2 at offset 7, was %v", p)
+	}
+	if p := PositionOf(offsets, 4); p.IsValid() {
+		t.Errorf("Expected invalid position past the end, was %v", p)
+	}
+}
+
+func TestLineScannerPeek(t *testing.T) {
+	scanner := NewLineScanner(strings.NewReader("first\nsecond\n"))
+	if next, ok := scanner.Peek(); !ok || next != "first" {
+		t.Errorf("Expected to peek the first line, got %q, %v", next, ok)
+	}
+	if !scanner.Scan() || scanner.Text() != "first" || scanner.Position().Line != 1 {
+		t.Fatalf("Expected the first line, got %q at %v", scanner.Text(), scanner.Position())
+	}
+	if next, ok := scanner.Peek(); !ok || next != "second" {
+		t.Errorf("Expected to peek the second line, got %q, %v", next, ok)
+	}
+	if !scanner.Scan() || scanner.Text() != "second" || scanner.Position() != (Position{Line: 2, Offset: 6}) {
+		t.Fatalf("Expected the second line at offset 6, got %q at %v", scanner.Text(), scanner.Position())
+	}
+	if _, ok := scanner.Peek(); ok {
+		t.Error("Expected nothing to peek at the end")
+	}
+	if scanner.Scan() {
+		t.Errorf("Expected the end of the input, got %q", scanner.Text())
+	}
+}
@@ -0,0 +1,48 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// ProcessFilter matches processes by pid, or by a regular expression on
+// their name.
+type ProcessFilter struct {
+	pid  uint64
+	name *regexp.Regexp
+}
+
+// ParseProcessFilter parses a pid, if expr is a number, or else a regular
+// expression matching the process names.
+func ParseProcessFilter(expr string) (*ProcessFilter, error) {
+	if pid, err := strconv.ParseUint(expr, 10, 64); err == nil {
+		return &ProcessFilter{pid: pid}, nil
+	}
+	name, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &ProcessFilter{name: name}, nil
+}
+
+// Matches returns whether proc has the pid or a matching name.
+func (f *ProcessFilter) Matches(proc *Process) bool {
+	if f.name == nil {
+		return proc.Pid == f.pid
+	}
+	return f.name.MatchString(proc.Name)
+}
@@ -0,0 +1,45 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestProcessFilter(t *testing.T) {
+	renderer := &Process{Name: "Google Chrome Helper (Renderer)", Pid: 4242}
+	gpu := &Process{Name: "Google Chrome Helper (GPU)", Pid: 42}
+	for _, test := range []struct {
+		expr     string
+		expected []bool
+	}{
+		{expr: "Renderer", expected: []bool{true, false}},
+		{expr: "Helper", expected: []bool{true, true}},
+		{expr: "42", expected: []bool{false, true}},
+		{expr: "4242", expected: []bool{true, false}},
+		{expr: "^42", expected: []bool{false, false}},
+	} {
+		filter, err := ParseProcessFilter(test.expr)
+		if err != nil {
+			t.Fatalf("%s: %v", test.expr, err)
+		}
+		for i, proc := range []*Process{renderer, gpu} {
+			if got := filter.Matches(proc); got != test.expected[i] {
+				t.Errorf("%s: expected %v for %s, got %v", test.expr, test.expected[i], proc.Name, got)
+			}
+		}
+	}
+	if _, err := ParseProcessFilter("(Renderer"); err == nil {
+		t.Errorf("Expected an error for an invalid regular expression")
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RedactApp is the --redact keyword redacting every frame outside of the
+// system's binaries.
+const RedactApp = "app"
+
+// systemBinaryRe matches the paths and file names of the binaries shipped
+// with the OS, whose symbols are not confidential.
+var systemBinaryRe = regexp.MustCompile(`^(?:/System/|/usr/lib/|/Library/Apple/)|^(?:lib.*\.dylib|dyld)$`)
+
+// Redaction selects the frames whose names are replaced by a hash.
+type Redaction struct {
+	// Patterns are matched against the frame names.
+	Patterns []*regexp.Regexp
+	// App redacts the frames of every binary that isn't part of the system,
+	// and the frames of inputs that don't record binaries.
+	App bool
+}
+
+// ParseRedaction parses a comma separated list of regular expressions and
+// the keyword "app".
+func ParseRedaction(value string) (r Redaction, err error) {
+	for _, element := range strings.Split(value, ",") {
+		element = strings.TrimSpace(element)
+		if element == "" {
+			continue
+		}
+		if element == RedactApp {
+			r.App = true
+			continue
+		}
+		pattern, err := regexp.Compile(element)
+		if err != nil {
+			return r, fmt.Errorf("Invalid redaction pattern '%s': %v", element, err)
+		}
+		r.Patterns = append(r.Patterns, pattern)
+	}
+	return r, nil
+}
+
+func isSystemBinary(binary string) bool {
+	return systemBinaryRe.MatchString(binary) || systemBinaryRe.MatchString(path.Base(binary))
+}
+
+func (r Redaction) matches(f *Frame) bool {
+	if r.App && (f.Binary == "" || !isSystemBinary(f.Binary)) {
+		return true
+	}
+	for _, pattern := range r.Patterns {
+		if pattern.MatchString(f.SymbolName) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactedName returns a stable replacement for name, so equal names are
+// still merged and the same symbol can be recognized across profiles.
+func redactedName(name string) string {
+	return "redacted_" + shortHash(name)
+}
+
+// shortHash returns a 12 digit hex hash of s.
+func shortHash(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Redact replaces the names of the selected frames, and of their binaries, by
+// a hash, keeping the structure and weights of the profile. Root frame labels
+// naming a redacted frame are replaced as well.
+func Redact(p *TimeProfile, r Redaction) {
+	redacted := make(map[string]string)
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		if !r.matches(f) {
+			return
+		}
+		name := redactedName(f.SymbolName)
+		redacted[f.SymbolName] = name
+		f.SymbolName = name
+		if f.Binary != "" && !isSystemBinary(f.Binary) {
+			f.Binary = redactedName(f.Binary)
+		}
+	})
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		if name, ok := redacted[f.Labels[RootFrameLabel]]; ok {
+			f.Labels[RootFrameLabel] = name
+		}
+	})
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestRedactPatterns(t *testing.T) {
+	got := makeStacks(
+		[]string{"main", "SecretEngine::run", "malloc"},
+		[]string{"main", "SecretEngine::run", "SecretEngine::step"},
+	)
+	redaction, err := ParseRedaction(`^SecretEngine::`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	Redact(got, redaction)
+	run := redactedName("SecretEngine::run")
+	expected := makeStacks(
+		[]string{"main", run, "malloc"},
+		[]string{"main", run, redactedName("SecretEngine::step")},
+	)
+	TimeProfileEquals(t, got, expected)
+}
+
+func TestRedactApp(t *testing.T) {
+	got := makeStacks([]string{"start", "main", "write"}, []string{"start", "main", "unknown"})
+	th := got.Processes[0].Threads[0]
+	start := th.Frames[0]
+	main := start.Children[0]
+	start.Binary = "/usr/lib/dyld"
+	main.Binary = "MyApp"
+	main.Children[0].Binary = "libsystem_kernel.dylib"
+	main.Labels = map[string]string{RootFrameLabel: "main"}
+
+	redaction, err := ParseRedaction("app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Redact(got, redaction)
+	expected := makeStacks(
+		[]string{"start", redactedName("main"), "write"},
+		[]string{"start", redactedName("main"), redactedName("unknown")},
+	)
+	TimeProfileEquals(t, got, expected)
+	if main.Binary != redactedName("MyApp") {
+		t.Errorf("Expected the app binary to be redacted, got %s", main.Binary)
+	}
+	if main.Labels[RootFrameLabel] != redactedName("main") {
+		t.Errorf("Expected the root frame label to be redacted, got %s", main.Labels[RootFrameLabel])
+	}
+}
+
+func TestParseRedactionInvalid(t *testing.T) {
+	if _, err := ParseRedaction("app,(unclosed"); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
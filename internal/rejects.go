@@ -0,0 +1,50 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+var rejects = struct {
+	sync.Mutex
+	out io.Writer
+}{}
+
+// SetRejectOutput sets the writer Reject writes to, or nil to discard the
+// rejected lines, the default.
+func SetRejectOutput(w io.Writer) {
+	rejects.Lock()
+	defer rejects.Unlock()
+	rejects.out = w
+}
+
+// Reject records a line of the input that the parser skipped or only partly
+// used, with the reason, so users can check what is missing from a profile.
+// Each line is written as "<line number>\t<reason>\t<line>".
+func Reject(position Position, line string, reason string) {
+	rejects.Lock()
+	defer rejects.Unlock()
+	if rejects.out == nil {
+		return
+	}
+	number := "-"
+	if position.IsValid() {
+		number = fmt.Sprint(position.Line)
+	}
+	fmt.Fprintf(rejects.out, "%s\t%s\t%s\n", number, reason, line)
+}
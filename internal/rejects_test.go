@@ -0,0 +1,33 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReject(t *testing.T) {
+	Reject(Position{Line: 1}, "dropped", "no output set")
+	var out strings.Builder
+	SetRejectOutput(&out)
+	defer SetRejectOutput(nil)
+	Reject(Position{Line: 12, Offset: 340}, "  garbage line", "not a frame")
+	Reject(Position{}, "unknown", "no position")
+	expected := "12\tnot a frame\t  garbage line\n-\tno position\tunknown\n"
+	if out.String() != expected {
+		t.Errorf("Expected rejects %q, got %q", expected, out.String())
+	}
+}
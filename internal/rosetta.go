@@ -0,0 +1,69 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "strings"
+
+// TranslatedLabel is "true" on the samples of processes translated by
+// Rosetta.
+const TranslatedLabel = "translated"
+
+// rosettaBinaries are the binaries of Rosetta's runtime, which only appear in
+// the stacks of translated processes.
+var rosettaBinaries = map[string]bool{
+	"libRosettaRuntime": true,
+	"oahd":              true,
+	"oahd-helper":       true,
+}
+
+// isTranslated reports whether the process was translated by Rosetta: its
+// architecture is marked as translated, e.g. "X86-64 (Translated)", or its
+// stacks have frames of the Rosetta runtime.
+func isTranslated(proc *Process) bool {
+	if strings.Contains(strings.ToLower(proc.Name), "(translated)") {
+		return true
+	}
+	for _, th := range proc.Threads {
+		if th.Labels[TranslatedLabel] == "true" || strings.Contains(strings.ToLower(th.Labels[ArchLabel]), "translated") {
+			return true
+		}
+	}
+	translated := false
+	walkFrames(&TimeProfile{Processes: []*Process{proc}}, func(_ *Process, _ *Thread, f *Frame) {
+		translated = translated || rosettaBinaries[f.Binary] || strings.Contains(f.SymbolName, "libRosettaRuntime")
+	})
+	return translated
+}
+
+// TagTranslated labels the samples of the processes translated by Rosetta
+// with translated=true.
+func TagTranslated(p *TimeProfile) {
+	for _, proc := range p.Processes {
+		if isTranslated(proc) {
+			SetAttributes(proc, map[string]string{TranslatedLabel: "true"})
+		}
+	}
+}
+
+// ExcludeTranslated removes the processes translated by Rosetta.
+func ExcludeTranslated(p *TimeProfile) {
+	processes := make([]*Process, 0, len(p.Processes))
+	for _, proc := range p.Processes {
+		if !isTranslated(proc) {
+			processes = append(processes, proc)
+		}
+	}
+	p.Processes = processes
+}
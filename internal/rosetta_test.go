@@ -0,0 +1,50 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func makeRosettaProfile() *TimeProfile {
+	native := &Thread{Name: "main", Labels: map[string]string{ArchLabel: "ARM-64 (Native)"}}
+	native.AddStack([]string{"main"}, 1)
+	codeType := &Thread{Name: "main", Labels: map[string]string{ArchLabel: "X86-64 (Translated)"}}
+	codeType.AddStack([]string{"main"}, 1)
+	runtime := &Thread{Name: "main"}
+	runtime.AddStack([]string{"main", "translate"}, 1).Binary = "libRosettaRuntime"
+	return &TimeProfile{Processes: []*Process{
+		{Name: "native", Threads: []*Thread{native}},
+		{Name: "code type", Threads: []*Thread{codeType}},
+		{Name: "runtime", Threads: []*Thread{runtime}},
+	}}
+}
+
+func TestTagTranslated(t *testing.T) {
+	p := makeRosettaProfile()
+	TagTranslated(p)
+	for i, expected := range []string{"", "true", "true"} {
+		proc := p.Processes[i]
+		if got := proc.Threads[0].Labels[TranslatedLabel]; got != expected {
+			t.Errorf("Expected translated=%q for %s, got %q", expected, proc.Name, got)
+		}
+	}
+}
+
+func TestExcludeTranslated(t *testing.T) {
+	p := makeRosettaProfile()
+	ExcludeTranslated(p)
+	if len(p.Processes) != 1 || p.Processes[0].Name != "native" {
+		t.Errorf("Expected only the native process, got %v", p.Processes)
+	}
+}
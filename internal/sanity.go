@@ -0,0 +1,99 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// IssueURL is where problems with the conversion are reported.
+const IssueURL = "https://github.com/google/instrumentsToPprof/issues"
+
+// maxSnippetLength is the number of characters redacted snippets are cut to.
+const maxSnippetLength = 120
+
+// RedactLine keeps the layout of an input line while hiding its content, so it
+// can be pasted into a public bug report: letters become 'x' and digits '0',
+// while whitespace and punctuation are kept.
+func RedactLine(line string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range line {
+		if n >= maxSnippetLength {
+			b.WriteString("...")
+			break
+		}
+		n++
+		switch {
+		case unicode.IsLetter(r):
+			b.WriteRune('x')
+		case unicode.IsDigit(r):
+			b.WriteRune('0')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// CheckThreadIDs warns when several threads of a process have tid 0, which
+// happens when the parser did not recognize the format of the thread lines.
+// lines are the input lines of the threads by line number, to include a
+// redacted snippet of the first offending thread line for a bug report.
+func CheckThreadIDs(p *TimeProfile, lines map[int]string) {
+	for _, proc := range p.Processes {
+		var zero []*Thread
+		for _, th := range proc.Threads {
+			if th.Tid == 0 {
+				zero = append(zero, th)
+			}
+		}
+		if len(zero) < 2 {
+			continue
+		}
+		snippet := ""
+		if line, ok := lines[zero[0].Position.Line]; ok {
+			snippet = RedactLine(line)
+		}
+		Warnf("%d threads of %s have tid 0, so their thread lines were probably not parsed correctly. "+
+			"Please file an issue at "+IssueURL+" including "+
+			"this redacted thread line: %q", len(zero), proc.Name, snippet)
+	}
+}
+
+// DisambiguateThreads adds the suffixes " #1", " #2", ... to the names of
+// threads of a process that share their name and have tid 0, i.e. whose tid
+// is unknown, so pprof doesn't merge the stacks of unrelated threads under
+// one thread frame when ids are excluded.
+func DisambiguateThreads(p *TimeProfile) {
+	for _, proc := range p.Processes {
+		counts := make(map[string]int)
+		for _, th := range proc.Threads {
+			if th.Tid == 0 {
+				counts[th.Name]++
+			}
+		}
+		next := make(map[string]int)
+		for _, th := range proc.Threads {
+			if th.Tid != 0 || counts[th.Name] < 2 {
+				continue
+			}
+			next[th.Name]++
+			th.Name = fmt.Sprintf("%s #%d", th.Name, next[th.Name])
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestRedactLine(t *testing.T) {
+	got := RedactLine("5.0 s  50%\t0 s\t \t Secret Thread 0x1ee7")
+	expected := "0.0 x  00%\t0 x\t \t xxxxxx xxxxxx 0x0xx0"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	if long := RedactLine(strings.Repeat("a", 200)); len(long) != maxSnippetLength+3 {
+		t.Errorf("Expected long lines to be cut, got %d characters", len(long))
+	}
+	// Symbols with multibyte characters are cut by characters, not bytes.
+	long := RedactLine(strings.Repeat("€", 200))
+	if n := utf8.RuneCountInString(long); n != maxSnippetLength+3 || !utf8.ValidString(long) {
+		t.Errorf("Expected multibyte lines to be cut at %d characters, got %d", maxSnippetLength, n)
+	}
+	if got := RedactLine("main(1) → 2"); got != "xxxx(0) → 0" {
+		t.Errorf("Expected the arrow to be kept, got %q", got)
+	}
+}
+
+func TestCheckThreadIDs(t *testing.T) {
+	var out strings.Builder
+	warnings.out = &out
+	defer func() { warnings.out = os.Stdout }()
+
+	lines := map[int]string{1: "Process (1)", 2: "Thread 1 0x1ee7", 3: "Thread 2 0x2ee7"}
+	p := &TimeProfile{Processes: []*Process{{
+		Name: "Process",
+		Threads: []*Thread{
+			{Name: "Thread 1 0x1ee7", Position: Position{Line: 2}},
+			{Name: "Thread 2 0x2ee7", Position: Position{Line: 3}},
+		},
+	}}}
+	CheckThreadIDs(p, lines)
+	FlushWarnings()
+	if !strings.Contains(out.String(), `"xxxxxx 0 0x0xx0"`) {
+		t.Errorf("Expected a warning with the redacted thread line, got %s", out.String())
+	}
+
+	out.Reset()
+	p.Processes[0].Threads[0].Tid = 1
+	CheckThreadIDs(p, lines)
+	FlushWarnings()
+	if out.String() != "" {
+		t.Errorf("Expected no warning for a single thread with tid 0, got %s", out.String())
+	}
+}
+
+func TestDisambiguateThreads(t *testing.T) {
+	p := &TimeProfile{Processes: []*Process{{
+		Name: "Process",
+		Threads: []*Thread{
+			{Name: "Worker"},
+			{Name: "Main"},
+			{Name: "Worker"},
+			{Name: "Timer", Tid: 1},
+			{Name: "Timer", Tid: 2},
+		},
+	}}}
+	DisambiguateThreads(p)
+	expected := []string{"Worker #1", "Main", "Worker #2", "Timer", "Timer"}
+	for i, th := range p.Processes[0].Threads {
+		if th.Name != expected[i] {
+			t.Errorf("Expected thread %d to be named %s, got %s", i, expected[i], th.Name)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+// StateLabel is the label holding the thread state of a sample, e.g.
+// "Running" or "Blocked".
+const StateLabel = "state"
+
+var (
+	// RunningValueType is the on-CPU time of a profile split by thread state.
+	RunningValueType = ValueType{Type: "running", Unit: "nanoseconds"}
+	// BlockedValueType is the off-CPU time of a profile split by thread state.
+	BlockedValueType = ValueType{Type: "blocked", Unit: "nanoseconds"}
+)
+
+// FilteredFrameName is the name of the frames carrying the weight of the rows
+// a filtered call tree hides.
+const FilteredFrameName = "[filtered out]"
+
+// threadStates are the states Instruments inserts below each thread when
+// the call tree is separated by state, and whether the thread is on-CPU.
+var threadStates = map[string]bool{
+	"Running":     true,
+	"Runnable":    false,
+	"Preempted":   false,
+	"Blocked":     false,
+	"Waiting":     false,
+	"Interrupted": false,
+}
+
+// isStateThread reports whether the outermost frames of the thread are
+// thread states, besides the frame of filtered out state rows.
+func isStateThread(th *Thread) bool {
+	states := 0
+	for _, f := range th.Frames {
+		if f.SymbolName == FilteredFrameName {
+			continue
+		}
+		if _, ok := threadStates[f.SymbolName]; !ok {
+			return false
+		}
+		states++
+	}
+	return states > 0
+}
+
+// SplitThreadStates removes the thread state frames of profiles recorded with
+// all thread states, and splits the time into two sample values: the running
+// time and the blocked time of any other state. The state is kept as a label.
+// The pprof sample_index then switches between on-CPU and off-CPU views.
+// The state of filtered out state rows is unknown, so their frame is kept
+// without a state and its weight stays in the running time. Profiles without
+// thread states, or with states this doesn't know, are left as they are.
+func SplitThreadStates(p *TimeProfile) {
+	split := false
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			if !isStateThread(th) {
+				continue
+			}
+			split = true
+			frames := make([]*Frame, 0)
+			for _, state := range th.Frames {
+				if state.SymbolName == FilteredFrameName {
+					if len(state.ExtraWeights) > 0 {
+						state.ExtraWeights = append([]int64{0}, state.ExtraWeights...)
+					}
+					frames = append(frames, state)
+					continue
+				}
+				running := threadStates[state.SymbolName]
+				var move func(f *Frame)
+				move = func(f *Frame) {
+					f.Depth--
+					if f.Labels == nil {
+						f.Labels = make(map[string]string)
+					}
+					f.Labels[StateLabel] = state.SymbolName
+					// The blocked time is the first extra value, before
+					// those of the parser.
+					var blocked int64
+					if !running {
+						blocked = f.SelfWeightNs
+						f.SelfWeightNs = 0
+					}
+					if blocked != 0 || len(f.ExtraWeights) > 0 {
+						f.ExtraWeights = append([]int64{blocked}, f.ExtraWeights...)
+					}
+					for _, child := range f.Children {
+						move(child)
+					}
+				}
+				for _, f := range state.Children {
+					f.Parent = nil
+					move(f)
+					frames = append(frames, f)
+				}
+			}
+			th.Frames = frames
+		}
+	}
+	if split {
+		p.ValueType = RunningValueType
+		p.ExtraValueTypes = append([]ValueType{BlockedValueType}, p.ExtraValueTypes...)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestSplitThreadStates(t *testing.T) {
+	type stack struct {
+		frames []string
+		weight int64
+	}
+	cases := []struct {
+		name   string
+		stacks []stack
+		split  bool
+		// want are the outermost frames after the split, as
+		// "name running blocked state".
+		want []string
+	}{
+		{
+			name:   "running only",
+			stacks: []stack{{[]string{"Running", "main"}, 3}},
+			split:  true,
+			want:   []string{"main 3 0 Running"},
+		},
+		{
+			name:   "blocked only",
+			stacks: []stack{{[]string{"Blocked", "main"}, 2}},
+			split:  true,
+			want:   []string{"main 0 2 Blocked"},
+		},
+		{
+			name:   "mixed",
+			stacks: []stack{{[]string{"Running", "main"}, 3}, {[]string{"Waiting", "read"}, 2}},
+			split:  true,
+			want:   []string{"main 3 0 Running", "read 0 2 Waiting"},
+		},
+		{
+			name:   "filtered out",
+			stacks: []stack{{[]string{"Running", "main"}, 3}, {[]string{FilteredFrameName}, 1}},
+			split:  true,
+			want:   []string{"main 3 0 Running", "[filtered out] 1 0 "},
+		},
+		{
+			name:   "unknown state",
+			stacks: []stack{{[]string{"Running", "main"}, 3}, {[]string{"Suspended", "main"}, 1}},
+			split:  false,
+			want:   []string{"Running 0 0 ", "Suspended 0 0 "},
+		},
+	}
+	for _, c := range cases {
+		th := &Thread{Name: "main", Tid: 1}
+		for _, s := range c.stacks {
+			th.AddStack(s.frames, s.weight)
+		}
+		p := &TimeProfile{Processes: []*Process{{Name: "app", Pid: 7, Threads: []*Thread{th}}}}
+		SplitThreadStates(p)
+
+		if split := p.ValueType == RunningValueType; split != c.split {
+			t.Errorf("%s: expected split %v, got value type %v", c.name, c.split, p.ValueType)
+		}
+		var got []string
+		for _, f := range th.Frames {
+			var blocked int64
+			if len(f.ExtraWeights) > 0 {
+				blocked = f.ExtraWeights[0]
+			}
+			got = append(got, fmt.Sprintf("%s %d %d %s", f.SymbolName, f.SelfWeightNs, blocked, f.Labels[StateLabel]))
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: expected frames %q, got %q", c.name, c.want, got)
+		}
+	}
+}
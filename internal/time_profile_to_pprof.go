@@ -16,6 +16,8 @@ package internal
 
 import (
 	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -45,48 +47,93 @@ func (m *ProcessAnnotationMap) Set(value string) error {
 	return nil
 }
 
+// The labels identifying the process and thread of every sample.
+const (
+	PidLabel         = "pid"
+	TidLabel         = "tid"
+	ProcessNameLabel = "process_name"
+	ThreadNameLabel  = "thread_name"
+)
+
+// CrashedLabel is set to "true" on the samples of the crashed thread of a
+// crash report.
+const CrashedLabel = "crashed"
+
+// SampleLabels are the labels ConvertOptions.Labels chooses from: those of
+// the process and thread, and the thread labels the parsers add. Labels added
+// on request, e.g. by AddRootFrameLabels, are always attached.
+var SampleLabels = []string{PidLabel, TidLabel, ProcessNameLabel, ThreadNameLabel,
+	OSVersionLabel, ArchLabel, DeviceModelLabel, TranslatedLabel, CrashedLabel}
+
+// ProcessTagLabel is the label holding the text of a process annotation, so
+// annotated processes can be selected even without their frames.
+const ProcessTagLabel = "process_tag"
+
+// DocURLLabel is the label holding the URL of a process annotation, e.g. of
+// the bug or test scenario the profile was taken for.
+const DocURLLabel = "doc_url"
+
+var annotationURLRe = regexp.MustCompile(`https?://\S+`)
+
+// splitAnnotation returns the text of an annotation without the URL it
+// contains, and the URL, if any.
+func splitAnnotation(annotation string) (text string, url string) {
+	url = annotationURLRe.FindString(annotation)
+	if url == "" {
+		return annotation, ""
+	}
+	return strings.Join(strings.Fields(strings.Replace(annotation, url, "", 1)), " "), url
+}
+
+// annotation returns the text and URL of the annotation of the process,
+// marking it used, or "" if it has none.
+func (toPprof *deepCopyToPprofConverter) annotation(proc *Process) (text string, url string) {
+	// Skip unparsable pids.
+	if proc.Pid == 0 {
+		return "", ""
+	}
+	annotation, ok := toPprof.Annotations[proc.Pid]
+	if !ok {
+		return "", ""
+	}
+	toPprof.consumedAnnotations[proc.Pid] = annotation
+	return splitAnnotation(annotation)
+}
+
 type location struct {
 	pid        uint64
 	tid        uint64
 	methodName string
+	offset     uint64
 }
 
 type deepCopyToPprofConverter struct {
 	deepCopy *TimeProfile
-	// Settings
-	excludeProcessesFromStack  bool
-	excludeThreadsFromStack    bool
-	includeThreadAndProcessIds bool
-	annotations                ProcessAnnotationMap
-	consumedAnnotations        ProcessAnnotationMap
+	ConvertOptions
+	consumedAnnotations ProcessAnnotationMap
 
 	// functions by name
 	functions      map[string]*profile.Function
 	nextFunctionID uint64
 	locations      map[location]*profile.Location
 	nextLocationID uint64
+	// mappings by binary
+	mappings map[string]*profile.Mapping
 
 	samples []*profile.Sample
 }
 
-func newPprofConverter(
-	deepCopy *TimeProfile,
-	excludeProcessesFromStack bool,
-	excludeThreadsFromStack bool,
-	includeThreadAndProcessIds bool,
-	annotations ProcessAnnotationMap) *deepCopyToPprofConverter {
+func newPprofConverter(deepCopy *TimeProfile, opts ConvertOptions) *deepCopyToPprofConverter {
 	return &deepCopyToPprofConverter{
-		deepCopy:                   deepCopy,
-		excludeProcessesFromStack:  excludeProcessesFromStack,
-		excludeThreadsFromStack:    excludeThreadsFromStack,
-		includeThreadAndProcessIds: includeThreadAndProcessIds,
-		annotations:                annotations,
-		consumedAnnotations:        make(map[uint64](string)),
-		functions:                  make(map[string]*profile.Function),
-		nextFunctionID:             1,
-		locations:                  make(map[location]*profile.Location),
-		nextLocationID:             1,
-		samples:                    make([]*profile.Sample, 0),
+		deepCopy:            deepCopy,
+		ConvertOptions:      opts,
+		consumedAnnotations: make(map[uint64](string)),
+		functions:           make(map[string]*profile.Function),
+		nextFunctionID:      1,
+		locations:           make(map[location]*profile.Location),
+		nextLocationID:      1,
+		mappings:            make(map[string]*profile.Mapping),
+		samples:             make([]*profile.Sample, 0),
 	}
 }
 
@@ -105,13 +152,51 @@ func (toPprof *deepCopyToPprofConverter) getFunction(name string) *profile.Funct
 	return f
 }
 
-func (toPprof *deepCopyToPprofConverter) getLocation(symbolName string, proc *Process, th *Thread) *profile.Location {
-	id := location{methodName: symbolName, pid: proc.Pid, tid: th.Tid}
+// getMapping returns a synthetic mapping of the binary, so that pprof's
+// -hide, -focus and -ignore can match all the frames of a library, e.g. of
+// the dyld shared cache, by its name. The functions are already symbolized.
+func (toPprof *deepCopyToPprofConverter) getMapping(binary string) *profile.Mapping {
+	if binary == "" {
+		return nil
+	}
+	m, ok := toPprof.mappings[binary]
+	if !ok {
+		m = &profile.Mapping{
+			ID:           uint64(len(toPprof.mappings) + 1),
+			File:         binary,
+			HasFunctions: true,
+		}
+		toPprof.mappings[binary] = m
+	}
+	return m
+}
+
+// binarySuffixRe matches the binary some inputs append to the symbol names,
+// e.g. "mach_msg_trap (in libsystem_kernel.dylib) + 8".
+var binarySuffixRe = regexp.MustCompile(`\(in ([^()]+)\)(?: \+ \d+)?$`)
+
+// frameBinary returns the binary of the frame, from the input or the suffix
+// of its name, or "".
+func frameBinary(f *Frame) string {
+	if f.Binary != "" {
+		return f.Binary
+	}
+	if matches := binarySuffixRe.FindStringSubmatch(f.SymbolName); matches != nil {
+		return matches[1]
+	}
+	return ""
+}
+
+func (toPprof *deepCopyToPprofConverter) getLocation(frame *Frame, proc *Process, th *Thread) *profile.Location {
+	symbolName := frame.SymbolName
+	id := location{methodName: symbolName, pid: proc.Pid, tid: th.Tid, offset: frame.Offset}
 	loc, ok := toPprof.locations[id]
 	if !ok {
 		loc = &profile.Location{
-			ID:   toPprof.nextLocationID,
-			Line: []profile.Line{{Function: toPprof.getFunction(symbolName)}},
+			ID:      toPprof.nextLocationID,
+			Mapping: toPprof.getMapping(frameBinary(frame)),
+			Address: frame.Offset,
+			Line:    []profile.Line{{Function: toPprof.getFunction(symbolName)}},
 		}
 		toPprof.locations[id] = loc
 		toPprof.nextLocationID++
@@ -122,7 +207,7 @@ func (toPprof *deepCopyToPprofConverter) getLocation(symbolName string, proc *Pr
 
 func (toPprof *deepCopyToPprofConverter) getThreadLocation(proc *Process, th *Thread) *profile.Location {
 	var name string
-	if toPprof.includeThreadAndProcessIds {
+	if toPprof.IncludeIDs && toPprof.hasThreadIDs() {
 		name = fmt.Sprintf("%s [tid: 0x%x]", th.Name, th.Tid)
 	} else {
 		name = th.Name
@@ -143,18 +228,14 @@ func (toPprof *deepCopyToPprofConverter) getThreadLocation(proc *Process, th *Th
 
 func (toPprof *deepCopyToPprofConverter) getProcessLocation(proc *Process) *profile.Location {
 	var name string
-	if toPprof.includeThreadAndProcessIds {
+	if toPprof.IncludeIDs {
 		name = fmt.Sprintf("%s [pid: %d]", proc.Name, proc.Pid)
 	} else {
 		name = proc.Name
 	}
-	// Skip unparsable pids.
-	if proc.Pid != 0 {
-		annotation, ok := toPprof.annotations[proc.Pid]
-		if ok {
-			toPprof.consumedAnnotations[proc.Pid] = annotation
-			name = fmt.Sprintf("%s [%s]", name, annotation)
-		}
+	// URLs are in the doc_url label and the comments instead.
+	if text, _ := toPprof.annotation(proc); text != "" {
+		name = fmt.Sprintf("%s [%s]", name, text)
 	}
 	id := location{methodName: proc.Name, pid: proc.Pid, tid: 0}
 	loc, ok := toPprof.locations[id]
@@ -170,6 +251,22 @@ func (toPprof *deepCopyToPprofConverter) getProcessLocation(proc *Process) *prof
 	return loc
 }
 
+// getRootLocation returns the location of the synthetic root frame.
+func (toPprof *deepCopyToPprofConverter) getRootLocation() *profile.Location {
+	name := toPprof.RootFrameName
+	id := location{methodName: name}
+	loc, ok := toPprof.locations[id]
+	if !ok {
+		loc = &profile.Location{
+			ID:   toPprof.nextLocationID,
+			Line: []profile.Line{{Function: toPprof.getFunction(name)}},
+		}
+		toPprof.locations[id] = loc
+		toPprof.nextLocationID++
+	}
+	return loc
+}
+
 func (toPprof *deepCopyToPprofConverter) convertSample(sample *Frame, th *Thread, proc *Process) *profile.Sample {
 	stackTrace := make([]*profile.Location, 0)
 	currentFrame := sample
@@ -177,29 +274,69 @@ func (toPprof *deepCopyToPprofConverter) convertSample(sample *Frame, th *Thread
 		if currentFrame == nil {
 			break
 		}
-		stackTrace = append(stackTrace, toPprof.getLocation(currentFrame.SymbolName, proc, th))
+		stackTrace = append(stackTrace, toPprof.getLocation(currentFrame, proc, th))
 		currentFrame = currentFrame.Parent
 	}
-	if !toPprof.excludeThreadsFromStack {
+	singleThread := toPprof.OmitSingleThreads && len(proc.Threads) == 1
+	if !toPprof.ExcludeThreadFrames && !singleThread {
 		stackTrace = append(stackTrace, toPprof.getThreadLocation(proc, th))
 	}
-	if !toPprof.excludeProcessesFromStack {
+	singleProcess := toPprof.OmitSingleProcess && len(toPprof.deepCopy.Processes) == 1 &&
+		len(toPprof.Annotations) == 0
+	if !toPprof.ExcludeProcessFrames && !singleProcess {
 		stackTrace = append(stackTrace, toPprof.getProcessLocation(proc))
 	}
+	if toPprof.RootFrameName != "" {
+		stackTrace = append(stackTrace, toPprof.getRootLocation())
+	}
+	labels := make(map[string][]string)
+	if toPprof.hasLabel(PidLabel) {
+		labels[PidLabel] = []string{strconv.FormatUint(proc.Pid, 10)}
+	}
+	if toPprof.hasLabel(ProcessNameLabel) {
+		labels[ProcessNameLabel] = []string{proc.Name}
+	}
+	if toPprof.hasLabel(ThreadNameLabel) {
+		labels[ThreadNameLabel] = []string{th.Name}
+	}
+	if toPprof.hasLabel(TidLabel) && toPprof.hasThreadIDs() {
+		labels[TidLabel] = []string{strconv.FormatUint(th.Tid, 10)}
+	}
+	text, url := toPprof.annotation(proc)
+	if text != "" {
+		labels[ProcessTagLabel] = []string{text}
+	}
+	if url != "" {
+		labels[DocURLLabel] = []string{url}
+	}
+	for key, value := range th.Labels {
+		if toPprof.hasLabel(key) {
+			labels[key] = []string{value}
+		}
+	}
+	for key, value := range sample.Labels {
+		labels[key] = []string{value}
+	}
+	var numLabels map[string][]int64
+	if len(sample.NumLabels) > 0 {
+		numLabels = make(map[string][]int64)
+		for key, value := range sample.NumLabels {
+			numLabels[key] = []int64{value}
+		}
+	}
+	values := make([]int64, 1+len(toPprof.deepCopy.ExtraValueTypes))
+	values[0] = sample.SelfWeightNs
+	copy(values[1:], sample.ExtraWeights)
 	return &profile.Sample{
 		Location: stackTrace,
-		Value:    []int64{sample.SelfWeightNs},
-		Label: map[string][]string{
-			"pid":          {strconv.FormatUint(proc.Pid, 10)},
-			"tid":          {strconv.FormatUint(th.Tid, 10)},
-			"process_name": {proc.Name},
-			"thread_name":  {th.Name},
-		},
+		Value:    values,
+		Label:    labels,
+		NumLabel: numLabels,
 	}
 }
 
 func (toPprof *deepCopyToPprofConverter) findSamplesInFrame(proc *Process, th *Thread, currentFrame *Frame) {
-	if currentFrame.SelfWeightNs != 0 {
+	if currentFrame.hasWeight() {
 		toPprof.samples = append(toPprof.samples, toPprof.convertSample(currentFrame, th, proc))
 	}
 	for _, f := range currentFrame.Children {
@@ -217,51 +354,343 @@ func (toPprof *deepCopyToPprofConverter) findSamples(proc *Process, th *Thread)
 }
 
 func (toPprof *deepCopyToPprofConverter) convertToPprof() *profile.Profile {
+	kept := 0
 	for _, proc := range toPprof.deepCopy.Processes {
 		for _, th := range proc.Threads {
-			toPprof.findSamples(proc, th)
+			if toPprof.keepsThread(proc, th) {
+				toPprof.findSamples(proc, th)
+				kept++
+			}
 		}
 	}
+	if kept == 0 && toPprof.hasFilters() {
+		Warnf("No thread is left by the process and thread filters, the profile is empty.")
+	}
 
-	locations := make([]*profile.Location, len(toPprof.locations))
-	i := 0
-	for _, loc := range toPprof.locations {
-		locations[i] = loc
-		i++
+	if len(toPprof.consumedAnnotations) < len(toPprof.Annotations) {
+		var missing string
+		for pid, annotation := range toPprof.Annotations {
+			if _, ok := toPprof.consumedAnnotations[pid]; !ok {
+				missing += fmt.Sprintf("\n  %d: %s", pid, annotation)
+			}
+		}
+		Warnf("Not all annotations were used. The following pids could not be found:%s", missing)
 	}
-	functions := make([]*profile.Function, len(toPprof.functions))
-	i = 0
-	for _, fn := range toPprof.functions {
-		functions[i] = fn
-		i++
+	valueType := toPprof.deepCopy.GetValueType()
+	sampleTypes := []*profile.ValueType{{Type: valueType.Type, Unit: valueType.Unit}}
+	for _, extra := range toPprof.deepCopy.ExtraValueTypes {
+		sampleTypes = append(sampleTypes, &profile.ValueType{Type: extra.Type, Unit: extra.Unit})
 	}
+	prof := &profile.Profile{
+		SampleType: sampleTypes,
+		// pprof shows the last value by default, but sample counts are an
+		// alternative view of the weight, as in Go CPU profiles.
+		DefaultSampleType: toPprof.defaultSampleType(),
+		Sample:            toPprof.samples,
+		Comments:          append(append([]string(nil), toPprof.deepCopy.Comments...), archComments(toPprof.deepCopy)...),
+	}
+	for _, proc := range toPprof.deepCopy.Processes {
+		if _, url := toPprof.annotation(proc); url != "" {
+			prof.Comments = append(prof.Comments, fmt.Sprintf("%s [pid: %d]: %s", proc.Name, proc.Pid, url))
+		}
+	}
+	// The locations and functions are collected from the samples.
+	compactProfile(prof)
+	prof.DropFrames = toPprof.DropFrames
+	prof.KeepFrames = toPprof.KeepFrames
+	if toPprof.PruneFrames {
+		if err := prof.RemoveUninteresting(); err != nil {
+			Warnf("Frames not pruned: %v", err)
+		}
+		compactProfile(prof)
+	}
+	return prof
+}
 
-	if len(toPprof.consumedAnnotations) < len(toPprof.annotations) {
-		warning := "Not all annotations were used. The following pids could not be found:"
-		for pid, annotation := range toPprof.annotations {
-			if _, ok := toPprof.consumedAnnotations[pid]; !ok {
-				warning += fmt.Sprintf("\n  %d: %s", pid, annotation)
+// defaultSampleType returns the weight's type if sample counts are the last
+// value, or "" for pprof's default.
+func (toPprof *deepCopyToPprofConverter) defaultSampleType() string {
+	extra := toPprof.deepCopy.ExtraValueTypes
+	if len(extra) > 0 && extra[len(extra)-1] == SampleCountValueType {
+		return toPprof.deepCopy.GetValueType().Type
+	}
+	return ""
+}
+
+// compactProfile dedupes functions with the same name, removes the locations,
+// functions and mappings no sample references, and renumbers the remaining
+// ones in order, so the ID spaces stay valid whichever way the profile was
+// assembled. The mappings of the application come before those of the
+// system, as pprof names the profile after the first one.
+func compactProfile(prof *profile.Profile) {
+	type functionKey struct {
+		name, systemName, filename string
+	}
+	functions := make(map[functionKey]*profile.Function)
+	usedLocations := make(map[*profile.Location]bool)
+	usedMappings := make(map[*profile.Mapping]bool)
+	prof.Location = prof.Location[:0]
+	prof.Function = prof.Function[:0]
+	prof.Mapping = prof.Mapping[:0]
+	for _, s := range prof.Sample {
+		for _, loc := range s.Location {
+			if usedLocations[loc] {
+				continue
+			}
+			usedLocations[loc] = true
+			loc.ID = uint64(len(prof.Location) + 1)
+			prof.Location = append(prof.Location, loc)
+			if m := loc.Mapping; m != nil && !usedMappings[m] {
+				usedMappings[m] = true
+				prof.Mapping = append(prof.Mapping, m)
 			}
+			for i, line := range loc.Line {
+				key := functionKey{line.Function.Name, line.Function.SystemName, line.Function.Filename}
+				fn, ok := functions[key]
+				if !ok {
+					fn = line.Function
+					fn.ID = uint64(len(prof.Function) + 1)
+					functions[key] = fn
+					prof.Function = append(prof.Function, fn)
+				}
+				loc.Line[i].Function = fn
+			}
+		}
+	}
+	sort.SliceStable(prof.Mapping, func(i, j int) bool {
+		return !isSystemBinary(prof.Mapping[i].File) && isSystemBinary(prof.Mapping[j].File)
+	})
+	for i, m := range prof.Mapping {
+		m.ID = uint64(i + 1)
+	}
+}
+
+// ConvertOptions are the options of ConvertToPprof.
+type ConvertOptions struct {
+	// ExcludeProcessFrames leaves out the frames of the processes.
+	ExcludeProcessFrames bool
+	// ExcludeThreadFrames leaves out the frames of the threads.
+	ExcludeThreadFrames bool
+	// IncludeIDs adds the pids and tids to the names of the process and
+	// thread frames.
+	IncludeIDs bool
+	// Annotations are appended to the names of the processes with the pids.
+	Annotations ProcessAnnotationMap
+	// Capabilities of the input's format, or nil if unknown. The tids of
+	// threads are left out of the names and labels of inputs without them.
+	Capabilities *Capabilities
+	// IncludeThreads keeps only the threads whose name matches, if set.
+	IncludeThreads *regexp.Regexp
+	// ExcludeThreads leaves out the threads whose name matches, if set.
+	ExcludeThreads *regexp.Regexp
+	// IncludeProcesses keeps only the matching processes, if set.
+	IncludeProcesses *ProcessFilter
+	// ExcludeProcesses leaves out the matching processes, if set.
+	ExcludeProcesses *ProcessFilter
+	// DropFrames and KeepFrames are set as the regular expressions of the
+	// profile, which pprof uses to prune the frames matching DropFrames but
+	// not KeepFrames, with their callees.
+	DropFrames, KeepFrames string
+	// PruneFrames prunes the frames at conversion time instead, so that
+	// every tool reading the profile shows the stacks without them.
+	PruneFrames bool
+	// Labels are the SampleLabels attached to every sample. Nil attaches all
+	// of them, an empty list none, which makes large profiles smaller.
+	Labels []string
+	// RootFrameName is the name of a synthetic frame above the processes of
+	// every stack, or empty for none.
+	RootFrameName string
+	// OmitSingleThreads leaves out the thread frame of processes with a
+	// single thread, which adds nothing to their stacks.
+	OmitSingleThreads bool
+	// OmitSingleProcess leaves out the process frame of profiles with a
+	// single process, unless it is annotated.
+	OmitSingleProcess bool
+}
+
+// hasLabel returns whether the samples get the label. Labels other than
+// SampleLabels, e.g. of IR inputs, are always attached.
+func (o ConvertOptions) hasLabel(label string) bool {
+	if o.Labels == nil || !isSampleLabel(label) {
+		return true
+	}
+	for _, l := range o.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func isSampleLabel(label string) bool {
+	for _, l := range SampleLabels {
+		if l == label {
+			return true
 		}
-		fmt.Printf("WARNING: %s\n", warning)
 	}
-	return &profile.Profile{
-		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
-		Sample:     toPprof.samples,
-		Location:   locations,
-		Function:   functions,
+	return false
+}
+
+func (o ConvertOptions) hasFilters() bool {
+	return o.IncludeThreads != nil || o.ExcludeThreads != nil ||
+		o.IncludeProcesses != nil || o.ExcludeProcesses != nil
+}
+
+// keepsThread returns whether the samples of th in proc are converted.
+func (o ConvertOptions) keepsThread(proc *Process, th *Thread) bool {
+	if o.IncludeProcesses != nil && !o.IncludeProcesses.Matches(proc) {
+		return false
+	}
+	if o.ExcludeProcesses != nil && o.ExcludeProcesses.Matches(proc) {
+		return false
+	}
+	if o.IncludeThreads != nil && !o.IncludeThreads.MatchString(th.Name) {
+		return false
+	}
+	return o.ExcludeThreads == nil || !o.ExcludeThreads.MatchString(th.Name)
+}
+
+// Filter removes the threads left out by the process and thread filters
+// from p, and the processes left without threads, for the outputs written
+// from the profile rather than its conversion.
+func (o ConvertOptions) Filter(p *TimeProfile) {
+	var processes []*Process
+	for _, proc := range p.Processes {
+		var threads []*Thread
+		for _, th := range proc.Threads {
+			if o.keepsThread(proc, th) {
+				threads = append(threads, th)
+			}
+		}
+		if len(threads) > 0 {
+			proc.Threads = threads
+			processes = append(processes, proc)
+		}
+	}
+	p.Processes = processes
+}
+
+// hasThreadIDs returns whether the threads have tids worth showing.
+func (o ConvertOptions) hasThreadIDs() bool {
+	return o.Capabilities == nil || o.Capabilities.ThreadIDs
+}
+
+// ConvertOption sets one of the ConvertOptions.
+type ConvertOption func(*ConvertOptions)
+
+// ExcludeProcessFrames leaves out the frames of the processes.
+func ExcludeProcessFrames(exclude bool) ConvertOption {
+	return func(o *ConvertOptions) { o.ExcludeProcessFrames = exclude }
+}
+
+// ExcludeThreadFrames leaves out the frames of the threads.
+func ExcludeThreadFrames(exclude bool) ConvertOption {
+	return func(o *ConvertOptions) { o.ExcludeThreadFrames = exclude }
+}
+
+// IncludeIDs adds the pids and tids to the names of the process and thread
+// frames.
+func IncludeIDs(include bool) ConvertOption {
+	return func(o *ConvertOptions) { o.IncludeIDs = include }
+}
+
+// WithAnnotations appends the annotations to the names of the processes
+// with the pids.
+func WithAnnotations(annotations ProcessAnnotationMap) ConvertOption {
+	return func(o *ConvertOptions) { o.Annotations = annotations }
+}
+
+// IncludeThreads keeps only the threads whose name matches expr, or all if
+// it is nil.
+func IncludeThreads(expr *regexp.Regexp) ConvertOption {
+	return func(o *ConvertOptions) { o.IncludeThreads = expr }
+}
+
+// ExcludeThreads leaves out the threads whose name matches expr, or none if
+// it is nil.
+func ExcludeThreads(expr *regexp.Regexp) ConvertOption {
+	return func(o *ConvertOptions) { o.ExcludeThreads = expr }
+}
+
+// IncludeProcesses keeps only the processes matching filter, or all if it
+// is nil.
+func IncludeProcesses(filter *ProcessFilter) ConvertOption {
+	return func(o *ConvertOptions) { o.IncludeProcesses = filter }
+}
+
+// ExcludeProcesses leaves out the processes matching filter, or none if it
+// is nil.
+func ExcludeProcesses(filter *ProcessFilter) ConvertOption {
+	return func(o *ConvertOptions) { o.ExcludeProcesses = filter }
+}
+
+// DropFrames sets the frames to prune, with those to keep, and whether to
+// prune them at conversion time or leave it to pprof.
+func DropFrames(drop, keep string, prune bool) ConvertOption {
+	return func(o *ConvertOptions) {
+		o.DropFrames = drop
+		o.KeepFrames = keep
+		o.PruneFrames = prune
+	}
+}
+
+// WithLabels attaches only the given SampleLabels to the samples.
+func WithLabels(labels []string) ConvertOption {
+	return func(o *ConvertOptions) { o.Labels = append([]string{}, labels...) }
+}
+
+// RootFrameName inserts a frame with the name above every stack, or none if
+// it is empty.
+func RootFrameName(name string) ConvertOption {
+	return func(o *ConvertOptions) { o.RootFrameName = name }
+}
+
+// OmitSingleThreads leaves out the thread frame of single threaded
+// processes.
+func OmitSingleThreads(omit bool) ConvertOption {
+	return func(o *ConvertOptions) { o.OmitSingleThreads = omit }
+}
+
+// OmitSingleProcess leaves out the process frame of profiles with a single
+// unannotated process.
+func OmitSingleProcess(omit bool) ConvertOption {
+	return func(o *ConvertOptions) { o.OmitSingleProcess = omit }
+}
+
+// WithCapabilities converts according to the capabilities of the input's
+// format.
+func WithCapabilities(c Capabilities) ConvertOption {
+	return func(o *ConvertOptions) { o.Capabilities = &c }
+}
+
+// NewConvertOptions returns the options with opts applied, from the
+// defaults of the command line: with process and thread frames and their
+// ids.
+func NewConvertOptions(opts ...ConvertOption) ConvertOptions {
+	o := ConvertOptions{IncludeIDs: true}
+	for _, opt := range opts {
+		opt(&o)
 	}
+	return o
+}
+
+// ConvertToPprof converts a TimeProfile to a pprof Profile.
+func ConvertToPprof(deepCopy *TimeProfile, opts ConvertOptions) *profile.Profile {
+	return newPprofConverter(deepCopy, opts).convertToPprof()
 }
 
 // TimeProfileToPprof converts a TimeProfile to a pprof Profile.
+//
+// Deprecated: Use ConvertToPprof.
 func TimeProfileToPprof(deepCopy *TimeProfile,
 	excludeProcessesFromStack bool,
 	excludeThreadsFromStack bool,
 	includeThreadAndProcessIds bool,
 	annotations ProcessAnnotationMap) *profile.Profile {
-	converter := newPprofConverter(deepCopy, excludeProcessesFromStack, excludeThreadsFromStack, includeThreadAndProcessIds, annotations)
-	if excludeProcessesFromStack && len(annotations) > 0 {
-		fmt.Println("WARNING: Combined annotations with excluding process from the stack. Annotations will be ignored.")
-	}
-	return converter.convertToPprof()
+	return ConvertToPprof(deepCopy, ConvertOptions{
+		ExcludeProcessFrames: excludeProcessesFromStack,
+		ExcludeThreadFrames:  excludeThreadsFromStack,
+		IncludeIDs:           includeThreadAndProcessIds,
+		Annotations:          annotations,
+	})
 }
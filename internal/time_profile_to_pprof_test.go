@@ -14,7 +14,17 @@
 
 package internal
 
-import "testing"
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/profile"
+)
 
 func MakeDeepCopy() *TimeProfile {
 	thread1 := &Thread{
@@ -154,3 +164,470 @@ func TestProcessAnnotations(t *testing.T) {
 		t.Errorf("Expected process at frame 3, was %v", sample.Location[2])
 	}
 }
+
+func TestProcessAnnotationURL(t *testing.T) {
+	annotations := ProcessAnnotationMap{123: "crbug https://crbug.com/1234"}
+	got := TimeProfileToPprof(MakeDeepCopy(), false, true, true, annotations)
+	if len(got.Sample) != 1 {
+		t.Fatalf("Expected only 1 sample, got %v", got)
+	}
+	sample := got.Sample[0]
+	if name := sample.Location[2].Line[0].Function.Name; name != "proc [pid: 123] [crbug]" {
+		t.Errorf("Expected URL to be removed from the process frame, was %v", name)
+	}
+	if label := sample.Label[DocURLLabel]; len(label) != 1 || label[0] != "https://crbug.com/1234" {
+		t.Errorf("Expected doc_url label, was %v", sample.Label)
+	}
+	if want := []string{"proc [pid: 123]: https://crbug.com/1234"}; !reflect.DeepEqual(got.Comments, want) {
+		t.Errorf("Expected comments %v, was %v", want, got.Comments)
+	}
+}
+
+func TestProcessTagLabel(t *testing.T) {
+	annotations := ProcessAnnotationMap{123: "canary https://crbug.com/1234"}
+	// The label is there even without the process frame.
+	got := TimeProfileToPprof(MakeDeepCopy(), true, true, true, annotations)
+	if len(got.Sample) != 1 {
+		t.Fatalf("Expected only 1 sample, got %v", got)
+	}
+	if label := got.Sample[0].Label[ProcessTagLabel]; !reflect.DeepEqual(label, []string{"canary"}) {
+		t.Errorf("Expected process_tag label canary, was %v", got.Sample[0].Label)
+	}
+	got = TimeProfileToPprof(MakeDeepCopy(), false, true, true, ProcessAnnotationMap{123: "https://crbug.com/1234"})
+	if label, ok := got.Sample[0].Label[ProcessTagLabel]; ok {
+		t.Errorf("Expected no process_tag label for an annotation with only a URL, was %v", label)
+	}
+}
+
+func TestThreadLabels(t *testing.T) {
+	deepCopy := MakeDeepCopy()
+	deepCopy.Processes[0].Threads[0].Labels = map[string]string{"crashed": "true"}
+	got := TimeProfileToPprof(deepCopy, false, false, true, NoAnnotations)
+	if len(got.Sample) != 1 {
+		t.Fatalf("Expected only 1 sample, got %v", got)
+	}
+	if label := got.Sample[0].Label["crashed"]; len(label) != 1 || label[0] != "true" {
+		t.Errorf("Expected thread label crashed=true, was %v", got.Sample[0].Label)
+	}
+}
+
+func TestRowLabels(t *testing.T) {
+	deepCopy := MakeDeepCopy()
+	deepCopy.Processes[0].Threads[0].Frames[0].Children[0].Position = Position{Line: 4, Offset: 105}
+	AddRowLabels(deepCopy)
+	got := TimeProfileToPprof(deepCopy, false, false, true, NoAnnotations)
+	if len(got.Sample) != 1 {
+		t.Fatalf("Expected only 1 sample, got %v", got)
+	}
+	if row := got.Sample[0].NumLabel[RowLabel]; len(row) != 1 || row[0] != 4 {
+		t.Errorf("Expected row label 4, was %v", got.Sample[0].NumLabel)
+	}
+}
+
+func TestExtraValueTypes(t *testing.T) {
+	deepCopy := MakeDeepCopy()
+	deepCopy.ValueType = RunningValueType
+	deepCopy.ExtraValueTypes = []ValueType{BlockedValueType}
+	blocked := deepCopy.Processes[0].Threads[0].Frames[0]
+	blocked.ExtraWeights = []int64{7}
+	got := TimeProfileToPprof(deepCopy, false, false, true, NoAnnotations)
+	if len(got.SampleType) != 2 || got.SampleType[0].Type != "running" || got.SampleType[1].Type != "blocked" {
+		t.Fatalf("Expected running and blocked sample types, got %v", got.SampleType)
+	}
+	if len(got.Sample) != 2 {
+		t.Fatalf("Expected a sample for the blocked frame, got %v", got.Sample)
+	}
+	for _, s := range got.Sample {
+		if len(s.Value) != 2 {
+			t.Errorf("Expected 2 values in sample, got %v", s.Value)
+		}
+	}
+	if err := got.CheckValid(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRootFrameLabels(t *testing.T) {
+	p := makeStacks(
+		[]string{"start", "main", "CFRunLoopRun", "handleEvent"},
+		[]string{"thread_start", "_pthread_start", "WorkerThread::Run", "doJob"},
+		[]string{"start_wqthread"},
+	)
+	AddRootFrameLabels(p)
+	got := TimeProfileToPprof(p, false, false, true, NoAnnotations)
+	roots := make(map[string]string)
+	for _, s := range got.Sample {
+		name := s.Location[0].Line[0].Function.Name
+		if root := s.Label[RootFrameLabel]; len(root) == 1 {
+			roots[name] = root[0]
+		}
+	}
+	expected := map[string]string{
+		"handleEvent": "main",
+		"doJob":       "WorkerThread::Run",
+	}
+	for name, root := range expected {
+		if roots[name] != root {
+			t.Errorf("%s had root frame '%s', expected '%s'", name, roots[name], root)
+		}
+	}
+	if root, ok := roots["start_wqthread"]; ok {
+		t.Errorf("Expected no root frame for a stack of bootstrap frames, got %s", root)
+	}
+}
+
+func TestVerifyPprof(t *testing.T) {
+	deepCopy := MakeDeepCopy()
+	got := TimeProfileToPprof(deepCopy, false, false, true, NoAnnotations)
+	if err := VerifyPprof(deepCopy, got); err != nil {
+		t.Error(err)
+	}
+	got.Sample = got.Sample[1:]
+	if err := VerifyPprof(deepCopy, got); err == nil {
+		t.Error("Expected an error verifying a profile with a missing sample")
+	}
+}
+
+func TestCompactProfile(t *testing.T) {
+	foo := &profile.Function{ID: 7, Name: "foo", SystemName: "foo"}
+	fooAgain := &profile.Function{ID: 9, Name: "foo", SystemName: "foo"}
+	unused := &profile.Function{ID: 3, Name: "unused", SystemName: "unused"}
+	loc1 := &profile.Location{ID: 5, Line: []profile.Line{{Function: foo}}}
+	loc2 := &profile.Location{ID: 2, Line: []profile.Line{{Function: fooAgain}}}
+	unusedLoc := &profile.Location{ID: 4, Line: []profile.Line{{Function: unused}}}
+	prof := &profile.Profile{
+		SampleType: []*profile.ValueType{{Type: "cpu", Unit: "nanoseconds"}},
+		Sample: []*profile.Sample{
+			{Location: []*profile.Location{loc1}, Value: []int64{1}},
+			{Location: []*profile.Location{loc2, loc1}, Value: []int64{1}},
+		},
+		Location: []*profile.Location{unusedLoc, loc1, loc2},
+		Function: []*profile.Function{unused, foo, fooAgain},
+	}
+	compactProfile(prof)
+	if err := prof.CheckValid(); err != nil {
+		t.Fatal(err)
+	}
+	if len(prof.Function) != 1 || prof.Function[0].ID != 1 {
+		t.Errorf("Expected a single function with ID 1, got %v", prof.Function)
+	}
+	if len(prof.Location) != 2 || prof.Location[0].ID != 1 || prof.Location[1].ID != 2 {
+		t.Errorf("Expected the 2 used locations with IDs 1 and 2, got %v", prof.Location)
+	}
+	if loc2.Line[0].Function != foo {
+		t.Errorf("Expected the duplicate function to be replaced")
+	}
+}
+
+func TestRootFrameName(t *testing.T) {
+	deepCopy := MakeDeepCopy()
+	got := ConvertToPprof(deepCopy, NewConvertOptions(RootFrameName("capture 2021-05-01")))
+	for _, s := range got.Sample {
+		root := s.Location[len(s.Location)-1].Line[0].Function.Name
+		if root != "capture 2021-05-01" {
+			t.Errorf("Expected the synthetic root at the bottom of the stack, got %s", root)
+		}
+	}
+}
+
+func TestOmitSingleThreads(t *testing.T) {
+	single := &Thread{Name: "main", Tid: 1}
+	single.AddStack([]string{"main", "work"}, 1)
+	first := &Thread{Name: "main", Tid: 2}
+	first.AddStack([]string{"main"}, 1)
+	second := &Thread{Name: "worker", Tid: 3}
+	second.AddStack([]string{"run"}, 1)
+	p := &TimeProfile{
+		Processes: []*Process{
+			{Name: "tool", Pid: 1, Threads: []*Thread{single}},
+			{Name: "app", Pid: 2, Threads: []*Thread{first, second}},
+		},
+	}
+	got := ConvertToPprof(p, NewConvertOptions(IncludeIDs(false), OmitSingleThreads(true)))
+	for _, s := range got.Sample {
+		var names []string
+		for _, loc := range s.Location {
+			names = append(names, loc.Line[0].Function.Name)
+		}
+		// The process frame is outermost, followed by the thread frame.
+		caller := names[len(names)-2]
+		switch s.Label["process_name"][0] {
+		case "tool":
+			if caller != "main" {
+				t.Errorf("Expected no thread frame in the single threaded tool, got %v", names)
+			}
+		case "app":
+			if caller != s.Label["thread_name"][0] {
+				t.Errorf("Expected the thread frames of the app to be kept, got %v", names)
+			}
+		}
+	}
+}
+
+func TestSampleCountsAreNotTheDefault(t *testing.T) {
+	deepCopy := MakeDeepCopy()
+	AddSampleCounts(deepCopy, 1)
+	got := TimeProfileToPprof(deepCopy, false, false, true, NoAnnotations)
+	if got.DefaultSampleType != "cpu" {
+		t.Errorf("Expected cpu to be the default sample type, got %q", got.DefaultSampleType)
+	}
+}
+
+func TestOmitSingleProcess(t *testing.T) {
+	th := &Thread{Name: "main", Tid: 1}
+	th.AddStack([]string{"main", "work"}, 1)
+	p := &TimeProfile{
+		Processes: []*Process{{Name: "tool", Pid: 1, Threads: []*Thread{th}}},
+	}
+	outermost := func(prof *profile.Profile) string {
+		locations := prof.Sample[0].Location
+		return locations[len(locations)-1].Line[0].Function.Name
+	}
+	opts := NewConvertOptions(IncludeIDs(false), OmitSingleProcess(true))
+	if got := outermost(ConvertToPprof(p, opts)); got != "main" {
+		t.Errorf("Expected the thread to be the outermost frame, got %s", got)
+	}
+	opts.Annotations = ProcessAnnotationMap{1: "cli"}
+	if got := outermost(ConvertToPprof(p, opts)); got != "tool [cli]" {
+		t.Errorf("Expected the annotated process frame to be kept, got %s", got)
+	}
+}
+
+func TestNewConvertOptions(t *testing.T) {
+	annotations := ProcessAnnotationMap{123: "tool"}
+	got := NewConvertOptions(ExcludeThreadFrames(true), IncludeIDs(false), WithAnnotations(annotations))
+	want := ConvertOptions{ExcludeThreadFrames: true, Annotations: annotations}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected options %+v, got %+v", want, got)
+	}
+	if got := NewConvertOptions(); !got.IncludeIDs || got.ExcludeProcessFrames || got.ExcludeThreadFrames {
+		t.Errorf("Expected the defaults to keep the frames and ids, got %+v", got)
+	}
+}
+
+func TestConvertToPprofMatchesTimeProfileToPprof(t *testing.T) {
+	got := ConvertToPprof(MakeDeepCopy(), NewConvertOptions(ExcludeThreadFrames(true)))
+	want := TimeProfileToPprof(MakeDeepCopy(), false, true, true, NoAnnotations)
+	if got.String() != want.String() {
+		t.Errorf("Expected\n%v\ngot\n%v", want, got)
+	}
+}
+
+func TestConvertWithoutThreadIDs(t *testing.T) {
+	prof := ConvertToPprof(MakeDeepCopy(), NewConvertOptions(WithCapabilities(Capabilities{})))
+	for _, sample := range prof.Sample {
+		if _, ok := sample.Label["tid"]; ok {
+			t.Errorf("Expected no tid label without thread ids, got %v", sample.Label)
+		}
+	}
+	for _, fn := range prof.Function {
+		if strings.Contains(fn.Name, "[tid:") {
+			t.Errorf("Expected no tid in the thread names without thread ids, got %s", fn.Name)
+		}
+	}
+	prof = ConvertToPprof(MakeDeepCopy(), NewConvertOptions(WithCapabilities(AllCapabilities)))
+	if _, ok := prof.Sample[0].Label["tid"]; !ok {
+		t.Errorf("Expected a tid label with thread ids, got %v", prof.Sample[0].Label)
+	}
+}
+
+func TestThreadFilters(t *testing.T) {
+	withHelper := func() *TimeProfile {
+		deepCopy := MakeDeepCopy()
+		proc := deepCopy.Processes[0]
+		proc.Threads = append(proc.Threads, &Thread{
+			Name:   "GC helper",
+			Tid:    2,
+			Frames: []*Frame{{SymbolName: "collect", SelfWeightNs: 1}},
+		})
+		return deepCopy
+	}
+	threadsOf := func(prof *profile.Profile) []string {
+		var threads []string
+		for _, sample := range prof.Sample {
+			threads = append(threads, sample.Label["thread_name"]...)
+		}
+		sort.Strings(threads)
+		return threads
+	}
+	for _, test := range []struct {
+		name     string
+		opts     []ConvertOption
+		expected []string
+	}{
+		{name: "no filter", expected: []string{"GC helper", "thread1"}},
+		{name: "include", opts: []ConvertOption{IncludeThreads(regexp.MustCompile("^thread"))},
+			expected: []string{"thread1"}},
+		{name: "exclude", opts: []ConvertOption{ExcludeThreads(regexp.MustCompile("GC"))},
+			expected: []string{"thread1"}},
+		{name: "both", opts: []ConvertOption{
+			IncludeThreads(regexp.MustCompile("thread|GC")), ExcludeThreads(regexp.MustCompile("1"))},
+			expected: []string{"GC helper"}},
+		{name: "none left", opts: []ConvertOption{IncludeThreads(regexp.MustCompile("main"))}},
+	} {
+		got := threadsOf(ConvertToPprof(withHelper(), NewConvertOptions(test.opts...)))
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%s: expected the samples of %v, got %v", test.name, test.expected, got)
+		}
+	}
+}
+
+func TestConversionWarnings(t *testing.T) {
+	var out bytes.Buffer
+	SetWarningOutput(&out)
+	defer SetWarningOutput(os.Stdout)
+	ConvertToPprof(MakeDeepCopy(), NewConvertOptions(IncludeThreads(regexp.MustCompile("main"))))
+	ConvertToPprof(MakeDeepCopy(), NewConvertOptions(WithAnnotations(ProcessAnnotationMap{1337: "Extra"})))
+	ConvertToPprof(MakeDeepCopy(), NewConvertOptions(DropFrames("(", "", true)))
+	FlushWarnings()
+	for _, expected := range []string{
+		"WARNING: Frames not pruned: ",
+		"WARNING: No thread is left by the process and thread filters",
+		"WARNING: Not all annotations were used. The following pids could not be found:\n  1337: Extra\n",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected the warning %q, got %q", expected, out.String())
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	deepCopy := MakeDeepCopy()
+	deepCopy.Processes = append(deepCopy.Processes, &Process{
+		Name:    "other",
+		Pid:     456,
+		Threads: []*Thread{{Name: "GC helper", Tid: 2}},
+	})
+	NewConvertOptions(ExcludeThreads(regexp.MustCompile("GC"))).Filter(deepCopy)
+	if len(deepCopy.Processes) != 1 || deepCopy.Processes[0].Name != "proc" {
+		t.Fatalf("Expected only the process with threads left, got %v", deepCopy.Processes)
+	}
+	if threads := deepCopy.Processes[0].Threads; len(threads) != 1 || threads[0].Name != "thread1" {
+		t.Errorf("Expected only thread1 left, got %v", threads)
+	}
+}
+
+func TestProcessFilters(t *testing.T) {
+	deepCopy := MakeDeepCopy()
+	deepCopy.Processes = append(deepCopy.Processes, &Process{
+		Name: "helper",
+		Pid:  456,
+		Threads: []*Thread{{
+			Name:   "thread1",
+			Tid:    2,
+			Frames: []*Frame{{SymbolName: "work", SelfWeightNs: 1}},
+		}},
+	})
+	pidsOf := func(opts ...ConvertOption) []string {
+		var pids []string
+		for _, sample := range ConvertToPprof(deepCopy, NewConvertOptions(opts...)).Sample {
+			pids = append(pids, sample.Label["pid"]...)
+		}
+		sort.Strings(pids)
+		return pids
+	}
+	helper, _ := ParseProcessFilter("help")
+	pid, _ := ParseProcessFilter("123")
+	if got := pidsOf(); !reflect.DeepEqual(got, []string{"123", "456"}) {
+		t.Errorf("Expected both processes without filters, got %v", got)
+	}
+	if got := pidsOf(IncludeProcesses(helper)); !reflect.DeepEqual(got, []string{"456"}) {
+		t.Errorf("Expected only the helper, got %v", got)
+	}
+	if got := pidsOf(ExcludeProcesses(pid)); !reflect.DeepEqual(got, []string{"456"}) {
+		t.Errorf("Expected pid 123 left out, got %v", got)
+	}
+	if got := pidsOf(IncludeProcesses(pid), ExcludeThreads(regexp.MustCompile("1"))); got != nil {
+		t.Errorf("Expected no samples, got %v", got)
+	}
+}
+
+func TestLabels(t *testing.T) {
+	labelsOf := func(opts ...ConvertOption) []string {
+		prof := ConvertToPprof(MakeDeepCopy(), NewConvertOptions(opts...))
+		var keys []string
+		for key := range prof.Sample[0].Label {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	if got := labelsOf(); !reflect.DeepEqual(got, []string{"pid", "process_name", "thread_name", "tid"}) {
+		t.Errorf("Expected all the labels by default, got %v", got)
+	}
+	if got := labelsOf(WithLabels([]string{"pid", "thread_name"})); !reflect.DeepEqual(got, []string{"pid", "thread_name"}) {
+		t.Errorf("Expected only the pid and thread name, got %v", got)
+	}
+	if got := labelsOf(WithLabels(nil)); got != nil {
+		t.Errorf("Expected no labels, got %v", got)
+	}
+
+	deepCopy := MakeDeepCopy()
+	deepCopy.Processes[0].Threads[0].Labels = map[string]string{ArchLabel: "arm64", OSVersionLabel: "macOS 14.0", "queue": "main"}
+	AddRootFrameLabels(deepCopy)
+	prof := ConvertToPprof(deepCopy, NewConvertOptions(WithLabels([]string{ArchLabel})))
+	var keys []string
+	for key := range prof.Sample[0].Label {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{ArchLabel, "queue", RootFrameLabel}) {
+		t.Errorf("Expected the chosen thread label and the labels outside of SampleLabels, got %v", keys)
+	}
+}
+
+func TestDropFrames(t *testing.T) {
+	leafOf := func(prof *profile.Profile) string {
+		return prof.Sample[0].Location[0].Line[0].Function.Name
+	}
+	prof := ConvertToPprof(MakeDeepCopy(), NewConvertOptions(DropFrames("sub_.*", "", false)))
+	if prof.DropFrames != "sub_.*" || leafOf(prof) != "sub_frame" {
+		t.Errorf("Expected drop_frames set and the stacks unchanged, got %q and leaf %s", prof.DropFrames, leafOf(prof))
+	}
+	prof = ConvertToPprof(MakeDeepCopy(), NewConvertOptions(DropFrames("sub_.*", "", true)))
+	if leafOf(prof) != "first_frame" || prof.Sample[0].Value[0] != 1 {
+		t.Errorf("Expected sub_frame pruned with its weight on first_frame, got %v", prof)
+	}
+	for _, fn := range prof.Function {
+		if fn.Name == "sub_frame" {
+			t.Errorf("Expected no function left for the pruned frame, got %v", prof.Function)
+		}
+	}
+	prof = ConvertToPprof(MakeDeepCopy(), NewConvertOptions(DropFrames("sub_.*", "sub_frame", true)))
+	if leafOf(prof) != "sub_frame" {
+		t.Errorf("Expected sub_frame kept by keep_frames, got leaf %s", leafOf(prof))
+	}
+}
+
+func TestBinaryMappings(t *testing.T) {
+	deepCopy := MakeDeepCopy()
+	first := deepCopy.Processes[0].Threads[0].Frames[0]
+	first.Binary = "/usr/lib/system/libsystem_kernel.dylib"
+	first.Children[0].SymbolName = "main (in MyApp) + 12"
+	prof := ConvertToPprof(deepCopy, NewConvertOptions())
+	if err := prof.CheckValid(); err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, m := range prof.Mapping {
+		if !m.HasFunctions {
+			t.Errorf("Expected the mapping of %s to have functions", m.File)
+		}
+		files = append(files, m.File)
+	}
+	if !reflect.DeepEqual(files, []string{"MyApp", "/usr/lib/system/libsystem_kernel.dylib"}) {
+		t.Errorf("Expected the mappings of the app then the system, got %v", files)
+	}
+	for _, loc := range prof.Location {
+		name := loc.Line[0].Function.Name
+		if (loc.Mapping == nil) != (name == "proc [pid: 123]" || name == "thread1 [tid: 0x1]") {
+			t.Errorf("Unexpected mapping %v of %s", loc.Mapping, name)
+		}
+	}
+	prof.FilterSamplesByName(nil, nil, regexp.MustCompile("libsystem"), nil)
+	for _, loc := range prof.Sample[0].Location {
+		if loc.Line[0].Function.Name == "first_frame" {
+			t.Errorf("Expected -hide=libsystem to hide the frames of the library, got %v", prof.Sample[0])
+		}
+	}
+}
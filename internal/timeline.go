@@ -0,0 +1,97 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HasTimeline reports whether the parser recorded when samples were taken.
+func (p *TimeProfile) HasTimeline() bool {
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			if len(th.Timeline) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stackContains reports whether a frame of the stack ending in f is symbol.
+func stackContains(f *Frame, symbol string) bool {
+	for ; f != nil; f = f.Parent {
+		if f.SymbolName == symbol {
+			return true
+		}
+	}
+	return false
+}
+
+// firstOccurrence returns the time of the first sample at or after from that
+// has symbol in its stack.
+func (p *TimeProfile) firstOccurrence(symbol string, from int64) (int64, bool) {
+	var first int64
+	found := false
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			for _, sample := range th.Timeline {
+				if sample.Time < from || (found && sample.Time >= first) {
+					continue
+				}
+				if stackContains(sample.Frame, symbol) {
+					first = sample.Time
+					found = true
+				}
+			}
+		}
+	}
+	return first, found
+}
+
+// SelectBetween keeps only the samples taken between the first sample with
+// startSymbol in its stack and the first following sample with endSymbol in
+// its stack, both included. This trims a profile to a scenario bounded by
+// marker functions. The profile must have a timeline.
+func SelectBetween(p *TimeProfile, startSymbol string, endSymbol string) error {
+	if !p.HasTimeline() {
+		return errors.New("Selecting samples between symbols requires an input with timestamps.")
+	}
+	start, ok := p.firstOccurrence(startSymbol, 0)
+	if !ok {
+		return fmt.Errorf("Start symbol %s not found in any sample", startSymbol)
+	}
+	end, ok := p.firstOccurrence(endSymbol, start)
+	if !ok {
+		return fmt.Errorf("End symbol %s not found after start symbol %s", endSymbol, startSymbol)
+	}
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		f.SelfWeightNs = 0
+	})
+	for _, proc := range p.Processes {
+		for _, th := range proc.Threads {
+			timeline := make([]TimedSample, 0)
+			for _, sample := range th.Timeline {
+				if sample.Time >= start && sample.Time <= end {
+					sample.Frame.SelfWeightNs += sample.Weight
+					timeline = append(timeline, sample)
+				}
+			}
+			th.Timeline = timeline
+		}
+	}
+	return nil
+}
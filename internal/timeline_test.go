@@ -0,0 +1,51 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestSelectBetween(t *testing.T) {
+	thread := &Thread{Name: "thread", Tid: 1}
+	thread.AddTimedStack(0, []string{"main", "setup"}, 10)
+	thread.AddTimedStack(10, []string{"main", "scenarioStart"}, 10)
+	thread.AddTimedStack(20, []string{"main", "work"}, 10)
+	thread.AddTimedStack(30, []string{"main", "scenarioEnd"}, 10)
+	thread.AddTimedStack(40, []string{"main", "work"}, 10)
+	got := &TimeProfile{
+		Processes: []*Process{{Name: "proc", Pid: 1, Threads: []*Thread{thread}}},
+	}
+	if err := SelectBetween(got, "scenarioStart", "scenarioEnd"); err != nil {
+		t.Fatal(err)
+	}
+	weights := make(map[string]int64)
+	for _, f := range thread.Frames[0].Children {
+		weights[f.SymbolName] = f.SelfWeightNs
+	}
+	expected := map[string]int64{"setup": 0, "scenarioStart": 10, "work": 10, "scenarioEnd": 10}
+	for name, weight := range expected {
+		if weights[name] != weight {
+			t.Errorf("%s had weight %d, expected %d", name, weights[name], weight)
+		}
+	}
+	if len(thread.Timeline) != 3 {
+		t.Errorf("Expected 3 samples left in the timeline, got %v", thread.Timeline)
+	}
+}
+
+func TestSelectBetweenWithoutTimeline(t *testing.T) {
+	if err := SelectBetween(MakeDeepCopy(), "first_frame", "sub_frame"); err == nil {
+		t.Error("Expected an error selecting from a profile without timestamps")
+	}
+}
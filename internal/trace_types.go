@@ -24,8 +24,55 @@ type Frame struct {
 	Parent       *Frame
 	Children     []*Frame
 	SelfWeightNs int64
-	SymbolName   string
-	Depth        int
+	// TotalWeightNs is the weight of the frame and its callees as shown by
+	// the input, e.g. the Weight column of a deep copy, or 0 if the input
+	// doesn't show it.
+	TotalWeightNs int64
+	SymbolName    string
+	// Binary is the name of the image the frame's code is in, if the input
+	// records it.
+	Binary string
+	// Offset is the instruction offset into the function, recorded by
+	// StripSymbolOffsets, or 0.
+	Offset   uint64
+	Depth    int
+	Position Position
+	// Labels and NumLabels are attached to the sample of the frame's self
+	// weight.
+	Labels    map[string]string
+	NumLabels map[string]int64
+	// ExtraWeights are the frame's self weights of the profile's
+	// ExtraValueTypes, in the same order.
+	ExtraWeights []int64
+}
+
+// hasWeight reports whether the frame has self weight of any value type.
+func (f *Frame) hasWeight() bool {
+	if f.SelfWeightNs != 0 {
+		return true
+	}
+	for _, w := range f.ExtraWeights {
+		if w != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// addExtraWeight adds w to the extra weight at index i.
+func (f *Frame) addExtraWeight(i int, w int64) {
+	for len(f.ExtraWeights) <= i {
+		f.ExtraWeights = append(f.ExtraWeights, 0)
+	}
+	f.ExtraWeights[i] += w
+}
+
+// addWeights adds the self weights of other to the frame's.
+func (f *Frame) addWeights(other *Frame) {
+	f.SelfWeightNs += other.SelfWeightNs
+	for i, w := range other.ExtraWeights {
+		f.addExtraWeight(i, w)
+	}
 }
 
 func (f *Frame) String() string {
@@ -48,6 +95,64 @@ type Thread struct {
 	Name   string
 	Tid    uint64
 	Frames []*Frame
+	// Labels are attached to every sample of the thread.
+	Labels   map[string]string
+	Position Position
+	// Timeline of the samples, for inputs that record when samples were
+	// taken. The self weights of the frames are the sum of these samples.
+	Timeline []TimedSample
+}
+
+// TimedSample is the weight added to a stack at a point in time.
+type TimedSample struct {
+	// Time of the sample in the unit of the input, e.g. nanoseconds.
+	Time int64
+	// Frame is the innermost frame of the sample's stack.
+	Frame  *Frame
+	Weight int64
+	// CoreType is the type of CPU core the sample ran on, "P" or "E" on Apple
+	// Silicon, or empty if the input doesn't record it.
+	CoreType string
+}
+
+// AddStack adds weight to the self weight of the stack, given from the
+// outermost frame, creating the frames that don't exist yet. It returns the
+// innermost frame of the stack.
+func (t *Thread) AddStack(stack []string, weight int64) *Frame {
+	if len(stack) == 0 {
+		return nil
+	}
+	var parent *Frame
+	siblings := &t.Frames
+	for depth, name := range stack {
+		var frame *Frame
+		for _, f := range *siblings {
+			if f.SymbolName == name {
+				frame = f
+				break
+			}
+		}
+		if frame == nil {
+			frame = &Frame{
+				Parent:     parent,
+				Children:   make([]*Frame, 0),
+				SymbolName: name,
+				Depth:      depth + 1,
+			}
+			*siblings = append(*siblings, frame)
+		}
+		parent = frame
+		siblings = &frame.Children
+	}
+	parent.SelfWeightNs += weight
+	return parent
+}
+
+// AddTimedStack is AddStack for a sample taken at the given time.
+func (t *Thread) AddTimedStack(time int64, stack []string, weight int64) {
+	if frame := t.AddStack(stack, weight); frame != nil {
+		t.Timeline = append(t.Timeline, TimedSample{Time: time, Frame: frame, Weight: weight})
+	}
 }
 
 func (t *Thread) String() string {
@@ -56,16 +161,47 @@ func (t *Thread) String() string {
 
 // Process are the top level of the stack.
 type Process struct {
-	Name    string
-	Pid     uint64
-	Threads []*Thread
+	Name     string
+	Pid      uint64
+	Threads  []*Thread
+	Position Position
 }
 
 func (p *Process) String() string {
 	return fmt.Sprintf("process {name: %s pid: %d n_processes: %d}", p.Name, p.Pid, len(p.Threads))
 }
 
+// ValueType describes what the frame weights of a TimeProfile measure.
+type ValueType struct {
+	Type string
+	Unit string
+}
+
+// CPUValueType is the value type of a TimeProfile that doesn't set one.
+var CPUValueType = ValueType{Type: "cpu", Unit: "nanoseconds"}
+
+// CyclesValueType is the value type of profiles weighted by CPU cycles, e.g.
+// from the CPU Profiler instrument.
+var CyclesValueType = ValueType{Type: "cycles", Unit: "count"}
+
 // TimeProfile is a set of processes parsed from the deep copy.
 type TimeProfile struct {
 	Processes []*Process
+	// ValueType of the frame weights. SelfWeightNs is in nanoseconds unless
+	// the parser sets something else here, e.g. sample counts.
+	ValueType ValueType
+	// ExtraValueTypes are the value types of the frames' ExtraWeights,
+	// recorded as additional pprof sample values.
+	ExtraValueTypes []ValueType
+	// Comments are notes on the profile, e.g. how it was filtered, written to
+	// the pprof comments.
+	Comments []string
+}
+
+// GetValueType returns the value type of the weights, defaulting to cpu time.
+func (t *TimeProfile) GetValueType() ValueType {
+	if t.ValueType == (ValueType{}) {
+		return CPUValueType
+	}
+	return t.ValueType
 }
@@ -0,0 +1,137 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	treeBarWidth = 10
+
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiYellow = "\x1b[33m"
+	ansiGreen  = "\x1b[32m"
+)
+
+// TreeOptions control how WriteTree prints the call tree.
+type TreeOptions struct {
+	// Color uses ANSI colors for the bars and weights.
+	Color bool
+	// MinPercent hides the frames whose cumulative weight is below this
+	// share of the total, summarizing them in a line per parent.
+	MinPercent float64
+	// ConvertOptions name the root frame of the tree and leave out the
+	// process and thread frames it doesn't need.
+	ConvertOptions
+}
+
+// WriteTree prints the call tree of the profile for a terminal, a frame per
+// line with a bar and the percentage of its cumulative weight of the total,
+// its weight and its name indented below its caller. Callees are listed from
+// the heaviest.
+func WriteTree(w io.Writer, p *TimeProfile, opts TreeOptions) error {
+	root := flameTreeOf(p, opts.ConvertOptions)
+	out := bufio.NewWriter(w)
+	t := treeWriter{out: out, opts: opts, total: root.Value, unit: p.GetValueType().Unit}
+	if root.Value == 0 {
+		fmt.Fprintln(out, "The profile has no samples.")
+		return out.Flush()
+	}
+	t.node(root, "", "")
+	return out.Flush()
+}
+
+type treeWriter struct {
+	out   *bufio.Writer
+	opts  TreeOptions
+	total int64
+	unit  string
+}
+
+// node prints n after the prefix of its line, then its callees after the
+// prefix of their lines.
+func (t *treeWriter) node(n *flameNode, linePrefix, childPrefix string) {
+	share := 100 * float64(n.Value) / float64(t.total)
+	fmt.Fprintf(t.out, "%s %s %s%s\n", t.bar(share), t.weight(n.Value), linePrefix, n.Name)
+
+	children := make([]*flameNode, 0, len(n.Children))
+	var hidden int
+	var hiddenWeight int64
+	for _, child := range n.Children {
+		if 100*float64(child.Value)/float64(t.total) < t.opts.MinPercent {
+			hidden++
+			hiddenWeight += child.Value
+			continue
+		}
+		children = append(children, child)
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		return children[i].Value > children[j].Value
+	})
+	for i, child := range children {
+		if i == len(children)-1 && hidden == 0 {
+			t.node(child, childPrefix+"└─ ", childPrefix+"   ")
+		} else {
+			t.node(child, childPrefix+"├─ ", childPrefix+"│  ")
+		}
+	}
+	if hidden > 0 {
+		share := 100 * float64(hiddenWeight) / float64(t.total)
+		fmt.Fprintf(t.out, "%s %s %s└─ %s\n", t.bar(share), t.weight(hiddenWeight), childPrefix,
+			t.dim(fmt.Sprintf("(%d more below %g%%)", hidden, t.opts.MinPercent)))
+	}
+}
+
+// bar draws share as a bar with its percentage, colored by how heavy it is.
+func (t *treeWriter) bar(share float64) string {
+	filled := int(share/100*treeBarWidth + 0.5)
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", treeBarWidth-filled)
+	text := fmt.Sprintf("%s %5.1f%%", bar, share)
+	if !t.opts.Color {
+		return text
+	}
+	color := ansiGreen
+	if share >= 50 {
+		color = ansiRed
+	} else if share >= 10 {
+		color = ansiYellow
+	}
+	return color + text + ansiReset
+}
+
+func (t *treeWriter) weight(value int64) string {
+	var text string
+	if t.unit == "nanoseconds" {
+		text = time.Duration(value).Round(time.Microsecond).String()
+	} else {
+		text = fmt.Sprintf("%d %s", value, t.unit)
+	}
+	return t.dim(fmt.Sprintf("%10s", text))
+}
+
+func (t *treeWriter) dim(text string) string {
+	if !t.opts.Color {
+		return text
+	}
+	return ansiDim + text + ansiReset
+}
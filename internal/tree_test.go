@@ -0,0 +1,57 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTree(t *testing.T) {
+	p := &TimeProfile{}
+	collapsed := NewConvertOptions(OmitSingleThreads(true), OmitSingleProcess(true))
+	th := &Thread{Name: "main", Tid: 1}
+	th.AddStack([]string{"start", "run", "parse"}, 6000000)
+	th.AddStack([]string{"start", "run"}, 3000000)
+	th.AddStack([]string{"start", "idle"}, 995000)
+	th.AddStack([]string{"start", "log"}, 5000)
+	p.Processes = []*Process{{Name: "app", Pid: 7, Threads: []*Thread{th}}}
+
+	var out strings.Builder
+	if err := WriteTree(&out, p, TreeOptions{MinPercent: 1, ConvertOptions: collapsed}); err != nil {
+		t.Fatal(err)
+	}
+	expected := "" +
+		"██████████ 100.0%       10ms all\n" +
+		"██████████ 100.0%       10ms └─ start\n" +
+		"█████████░  90.0%        9ms    ├─ run\n" +
+		"██████░░░░  60.0%        6ms    │  └─ parse\n" +
+		"█░░░░░░░░░   9.9%      995µs    ├─ idle\n" +
+		"░░░░░░░░░░   0.1%        5µs    └─ (1 more below 1%)\n"
+	if out.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := WriteTree(&out, p, TreeOptions{Color: true, ConvertOptions: collapsed}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), ansiRed+"██████████ 100.0%"+ansiReset) {
+		t.Errorf("Expected red bars for the heaviest frames, got\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "└─ log") {
+		t.Errorf("Expected all frames without MinPercent, got\n%s", out.String())
+	}
+}
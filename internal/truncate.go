@@ -0,0 +1,37 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+// truncatedName cuts name to maxLength characters and appends a hash of the
+// full name, so names with the same prefix stay distinguishable.
+func truncatedName(name string, maxLength int) string {
+	runes := []rune(name)
+	if len(runes) <= maxLength {
+		return name
+	}
+	return string(runes[:maxLength]) + "…#" + shortHash(name)
+}
+
+// TruncateSymbols caps the frame names at maxLength characters, e.g. for huge
+// C++ template expansions that make pprof's UIs slow. Longer names are cut
+// and get a short hash of the full name appended, as do root frame labels.
+func TruncateSymbols(p *TimeProfile, maxLength int) {
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		f.SymbolName = truncatedName(f.SymbolName, maxLength)
+		if root, ok := f.Labels[RootFrameLabel]; ok {
+			f.Labels[RootFrameLabel] = truncatedName(root, maxLength)
+		}
+	})
+}
@@ -0,0 +1,41 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTruncateSymbols(t *testing.T) {
+	long1 := "std::__1::vector<" + strings.Repeat("std::pair<int, int>", 10) + ">::push_back"
+	long2 := "std::__1::vector<" + strings.Repeat("std::pair<int, int>", 10) + ">::pop_back"
+	got := makeStacks([]string{"main", long1}, []string{"main", long2}, []string{"main", "µs::short"})
+	TruncateSymbols(got, 20)
+
+	frames := got.Processes[0].Threads[0].Frames[0].Children
+	if len(frames) != 3 {
+		t.Fatalf("Expected 3 distinct frames, got %v", frames)
+	}
+	if !strings.HasPrefix(frames[0].SymbolName, "std::__1::vector<std…#") {
+		t.Errorf("Unexpected truncated name %s", frames[0].SymbolName)
+	}
+	if frames[0].SymbolName == frames[1].SymbolName {
+		t.Errorf("Expected truncated names to differ, both are %s", frames[0].SymbolName)
+	}
+	if frames[2].SymbolName != "µs::short" || got.Processes[0].Threads[0].Frames[0].SymbolName != "main" {
+		t.Errorf("Expected short names to be kept, got %v", frames[2])
+	}
+}
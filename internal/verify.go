@@ -0,0 +1,81 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/google/pprof/profile"
+)
+
+// totals returns the sum of the weights of each value type of the profile.
+func (p *TimeProfile) totals() []int64 {
+	totals := make([]int64, 1+len(p.ExtraValueTypes))
+	walkFrames(p, func(_ *Process, _ *Thread, f *Frame) {
+		totals[0] += f.SelfWeightNs
+		for i, w := range f.ExtraWeights {
+			totals[i+1] += w
+		}
+	})
+	return totals
+}
+
+// VerifyPprof writes the pprof profile converted from p, reads it back and
+// checks it the way pprof does when loading it: the profile must be valid,
+// every location must resolve to a named function and every sample must have
+// a value of each type. The totals of each value type must match the weights
+// of p, so no samples were lost in the conversion.
+func VerifyPprof(p *TimeProfile, pprof *profile.Profile) error {
+	var buf bytes.Buffer
+	if err := pprof.Write(&buf); err != nil {
+		return fmt.Errorf("Could not write profile: %v", err)
+	}
+	written, err := profile.Parse(&buf)
+	if err != nil {
+		return fmt.Errorf("Could not read back profile: %v", err)
+	}
+	if err := written.CheckValid(); err != nil {
+		return fmt.Errorf("Profile read back is invalid: %v", err)
+	}
+	for _, loc := range written.Location {
+		if len(loc.Line) == 0 || loc.Line[0].Function == nil || loc.Line[0].Function.Name == "" {
+			return fmt.Errorf("Location %d has no function name", loc.ID)
+		}
+	}
+	totals := make([]int64, len(written.SampleType))
+	for _, s := range written.Sample {
+		if len(s.Value) != len(written.SampleType) {
+			return fmt.Errorf("Sample has %d values, expected %d", len(s.Value), len(written.SampleType))
+		}
+		if len(s.Location) == 0 {
+			return fmt.Errorf("Sample with values %v has no locations", s.Value)
+		}
+		for i, v := range s.Value {
+			totals[i] += v
+		}
+	}
+	expected := p.totals()
+	if len(expected) != len(totals) {
+		return fmt.Errorf("Profile has %d sample types, expected %d", len(totals), len(expected))
+	}
+	for i := range expected {
+		if totals[i] != expected[i] {
+			return fmt.Errorf("Total %s is %d, but the input adds up to %d",
+				written.SampleType[i].Type, totals[i], expected[i])
+		}
+	}
+	return nil
+}
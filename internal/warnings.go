@@ -0,0 +1,77 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// MaxWarnings is the number of warnings of each kind that are printed, the
+// rest are only counted. Negative values print all warnings.
+var MaxWarnings = 5
+
+var warnings = struct {
+	sync.Mutex
+	out io.Writer
+	// counts of the warnings by format, in order of the first occurrence.
+	counts  map[string]int
+	formats []string
+	// first message of each format.
+	first map[string]string
+}{out: os.Stdout, counts: make(map[string]int), first: make(map[string]string)}
+
+// Warnf prints a warning. Warnings with the same format are of the same kind,
+// and after MaxWarnings of a kind they are only counted, so large inputs
+// don't drown the terminal.
+func Warnf(format string, args ...interface{}) {
+	warnings.Lock()
+	defer warnings.Unlock()
+	message := fmt.Sprintf(format, args...)
+	count, ok := warnings.counts[format]
+	if !ok {
+		warnings.formats = append(warnings.formats, format)
+		warnings.first[format] = message
+	}
+	warnings.counts[format] = count + 1
+	if MaxWarnings < 0 || count < MaxWarnings {
+		fmt.Fprintf(warnings.out, "WARNING: %s\n", message)
+	}
+}
+
+// FlushWarnings prints how many warnings of each kind were not printed, and
+// resets the counts.
+func FlushWarnings() {
+	warnings.Lock()
+	defer warnings.Unlock()
+	for _, format := range warnings.formats {
+		if hidden := warnings.counts[format] - MaxWarnings; MaxWarnings >= 0 && hidden > 0 {
+			fmt.Fprintf(warnings.out, "WARNING: ...and %d more warnings similar to: %s\n", hidden, warnings.first[format])
+		}
+	}
+	warnings.counts = make(map[string]int)
+	warnings.formats = nil
+	warnings.first = make(map[string]string)
+}
+
+// SetWarningOutput sets the writer warnings are printed to, os.Stdout by
+// default.
+func SetWarningOutput(w io.Writer) {
+	warnings.Lock()
+	defer warnings.Unlock()
+	warnings.out = w
+}
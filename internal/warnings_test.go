@@ -0,0 +1,44 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWarningDeduplication(t *testing.T) {
+	var out strings.Builder
+	warnings.out = &out
+	defer func() { warnings.out = os.Stdout }()
+
+	for i := 0; i < 10; i++ {
+		Warnf("Error parsing thread '%d'.", i)
+	}
+	Warnf("Error parsing process '%s'.", "proc")
+	FlushWarnings()
+
+	expected := "WARNING: Error parsing thread '0'.\n" +
+		"WARNING: Error parsing thread '1'.\n" +
+		"WARNING: Error parsing thread '2'.\n" +
+		"WARNING: Error parsing thread '3'.\n" +
+		"WARNING: Error parsing thread '4'.\n" +
+		"WARNING: Error parsing process 'proc'.\n" +
+		"WARNING: ...and 5 more warnings similar to: Error parsing thread '0'.\n"
+	if out.String() != expected {
+		t.Errorf("Expected warnings:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
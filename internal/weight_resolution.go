@@ -0,0 +1,74 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import (
+	"fmt"
+	"math"
+)
+
+// SampleCountValueType is the value type of the sample counts added by
+// AddSampleCounts.
+var SampleCountValueType = ValueType{Type: "samples", Unit: "count"}
+
+// SnapWeights rounds the self weights of the frames to multiples of the
+// sampling resolution, undoing the rounding of the weights Instruments
+// displays, and adds a "samples" value with the number of samples of each
+// frame. Extra values in nanoseconds are snapped as well. The profile's
+// weights must be in nanoseconds.
+func SnapWeights(p *TimeProfile, resolutionNs int64) error {
+	if resolutionNs <= 0 {
+		return fmt.Errorf("Weight resolution must be positive, was %d ns.", resolutionNs)
+	}
+	if unit := p.GetValueType().Unit; unit != "nanoseconds" {
+		return fmt.Errorf("Weight resolution requires weights in nanoseconds, the input's are in %s.", unit)
+	}
+	snap := func(w int64) int64 {
+		return int64(math.Round(float64(w)/float64(resolutionNs))) * resolutionNs
+	}
+	walkFrames(p, func(proc *Process, th *Thread, f *Frame) {
+		for i, w := range f.ExtraWeights {
+			if i < len(p.ExtraValueTypes) && p.ExtraValueTypes[i].Unit == "nanoseconds" {
+				f.ExtraWeights[i] = snap(w)
+			}
+		}
+		f.SelfWeightNs = snap(f.SelfWeightNs)
+	})
+	AddSampleCounts(p, resolutionNs)
+	return nil
+}
+
+// AddSampleCounts adds a "samples" value with the number of samples of
+// intervalNs in the self weight of each frame, like the sample counts of Go
+// CPU profiles. Counts the profile already has are replaced.
+func AddSampleCounts(p *TimeProfile, intervalNs int64) {
+	samples := len(p.ExtraValueTypes)
+	for i, valueType := range p.ExtraValueTypes {
+		if valueType == SampleCountValueType {
+			samples = i
+		}
+	}
+	if samples == len(p.ExtraValueTypes) {
+		p.ExtraValueTypes = append(p.ExtraValueTypes, SampleCountValueType)
+	}
+	walkFrames(p, func(proc *Process, th *Thread, f *Frame) {
+		if samples < len(f.ExtraWeights) {
+			f.ExtraWeights[samples] = 0
+		}
+		if count := f.SelfWeightNs / intervalNs; count != 0 {
+			f.addExtraWeight(samples, count)
+		}
+	})
+}
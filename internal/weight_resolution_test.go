@@ -0,0 +1,57 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package internal
+
+import "testing"
+
+func TestSnapWeights(t *testing.T) {
+	th := &Thread{Name: "thread", Tid: 1}
+	// Instruments shows 3 samples of 1ms as "2.99 ms".
+	th.AddStack([]string{"main", "foo"}, 2_990_000)
+	th.AddStack([]string{"main"}, 1_004_000)
+	// "< 0.1 ms" frames had no sample of their own.
+	th.AddStack([]string{"main", "bar"}, 100_000)
+	th.Frames[0].Children[0].ExtraWeights = []int64{1_999_000}
+	p := &TimeProfile{
+		Processes:       []*Process{{Name: "proc", Pid: 1, Threads: []*Thread{th}}},
+		ExtraValueTypes: []ValueType{BlockedValueType},
+	}
+
+	if err := SnapWeights(p, 1_000_000); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.ExtraValueTypes) != 2 || p.ExtraValueTypes[1] != SampleCountValueType {
+		t.Fatalf("Unexpected value types %v", p.ExtraValueTypes)
+	}
+	main := th.Frames[0]
+	if main.SelfWeightNs != 1_000_000 || main.ExtraWeights[1] != 1 {
+		t.Errorf("Unexpected weights of main %d %v", main.SelfWeightNs, main.ExtraWeights)
+	}
+	foo := main.Children[0]
+	if foo.SelfWeightNs != 3_000_000 || foo.ExtraWeights[0] != 2_000_000 || foo.ExtraWeights[1] != 3 {
+		t.Errorf("Unexpected weights of foo %d %v", foo.SelfWeightNs, foo.ExtraWeights)
+	}
+	if bar := main.Children[1]; bar.hasWeight() {
+		t.Errorf("Expected no weight for bar, got %d %v", bar.SelfWeightNs, bar.ExtraWeights)
+	}
+}
+
+func TestSnapWeightsRequiresNanoseconds(t *testing.T) {
+	p := makeStacks([]string{"main"})
+	p.ValueType = SampleCountValueType
+	if err := SnapWeights(p, 1_000_000); err == nil {
+		t.Error("Expected an error for a profile of sample counts")
+	}
+}
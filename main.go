@@ -15,103 +15,824 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/instrumentsToPprof/internal/cache"
+	"github.com/google/instrumentsToPprof/internal/ir"
 	"github.com/google/instrumentsToPprof/internal/parsers"
+	"github.com/google/instrumentsToPprof/internal/parsers/speedscope"
+	"github.com/google/instrumentsToPprof/internal/parsers/xctrace"
+	"github.com/google/pprof/profile"
 )
 
 const (
 	help = `usage %[1]s [options] [deepcopy-file]
+       %[1]s diff [options] base-file new-file
+       %[1]s --diff [options] before-file after-file
+       %[1]s extract [options] [input-file]
+       %[1]s selftest
 Converts a the deep copy output from Instrument's Time Profile tool to a pprof profile.
 
 If deepcopy-file is empty, reads from stdin. To perform a conversion from the clipbaord, use
-	$ pbpaste | %[1]s
+	$ %[1]s --from-clipboard
+If deepcopy-file is an Instruments .trace bundle, or any directory with --format=trace, its time
+profile is exported with xctrace, or the table and run given by --instrument-table and --run.
+If deepcopy-file is another directory, every file in it is parsed as a separate report and the
+sample values count how many reports contain each stack.
+The diff command reports the functions that changed the most between two inputs.
+--diff writes a pprof profile of before-file minus after-file, with after-file as the base like
+pprof -diff_base, so savings are positive and regressions negative.
+The extract command writes the processes and threads matching --pid and --thread as IR or folded
+stacks.
+The selftest command converts built-in inputs of every format to check the build works.
 Flags:
-`
-	formatHelp = `The format of the input. Use,
---format=sample for parsing sample files
---format=instruments for instruments deep-copy. This is the default.
-
-Sample copying is a new feature and may have issues. File an issue on github in that case.
 `
 	pidTagHelp = `Annotated a process with pid with the given tag. Format is <pid>:<tag>.
 For example, 'My Process Name [pid: 123] [Annotation]' with -pidTag=123:Annotation
+The tag is also the process_tag label of the process's samples.
+A http(s) URL in the tag is written to the doc_url label and the profile comments
+instead, e.g. -pidTag='123:flaky test https://crbug.com/1234'.
 `
 )
 
 const (
+	kAuto                string = "auto"
 	kSample              string = "sample"
 	kInstrumentsDeepCopy string = "instruments"
-)
+	kAllocations         string = "allocations"
+	kMetricKit           string = "metrickit"
+	kCrash               string = "crash"
+	kSpindump            string = "spindump"
+	kSysdiagnose         string = "sysdiagnose"
+	kSpeedscope          string = "speedscope"
+	kFlameGraphSvg       string = "flamegraph-svg"
+	kIR                  string = "ir"
+	kXctrace             string = "xctrace"
+	kTrace               string = "trace"
+	kHeap                string = "heap"
+	kVmmap               string = "vmmap"
+	kLeaks               string = "leaks"
+	kMallocHistory       string = "malloc-history"
+	kFsUsage             string = "fs-usage"
 
-type makeParserFn func(io.Reader) (parsers.Parser, error)
+	kPprofOutput      string = "pprof"
+	kCollapsedOutput  string = "collapsed"
+	kSpeedscopeOutput string = "speedscope"
+	kHTMLOutput       string = "html"
+	kTreeOutput       string = "tree"
+	// kTreeMinPercent hides the frames of the tree output below this share
+	// of the total, which would fill the terminal.
+	kTreeMinPercent float64 = 0.5
+
+	kKeepOffsets    string = "keep"
+	kMergeOffsets   string = "merge"
+	kAddressOffsets string = "address"
+)
 
 func main() {
+	if len(os.Args) >= 2 && os.Args[1] == "diff" {
+		if err := runDiff(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) >= 2 && os.Args[1] == "extract" {
+		if err := runExtract(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) == 2 && os.Args[1] == "selftest" {
+		if !runSelftest(os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
 	var outputFilename = flag.String("output", "profile.pb.gz", "Output file of the pprof profile.")
+	var outputFormat = flag.String("output-format", kPprofOutput,
+		"Format of the output file: pprof, collapsed for the folded stacks of flamegraph.pl, speedscope "+
+			"for a speedscope JSON file with a profile per thread, html for a standalone flame graph page, or "+
+			"tree to print the call tree, to stdout unless --output is given.")
+	var force bool
+	flag.BoolVar(&force, "force", false, "Overwrites the output file if it exists.")
+	flag.BoolVar(&force, "f", false, "Shorthand for --force.")
 	var excludeProcessInStack = flag.Bool("exclude-process-from-stack",
 		false, "Excludes processes from all stack traces.")
 	var excludeThreadsInStack = flag.Bool("exclude-threads-from-stack",
 		false, "Excludes threads from all stack traces.")
+	var omitSingleThreads = flag.Bool("omit-single-threads", false,
+		"Excludes the thread from the stack traces of processes with a single thread, e.g. command line tools.")
+	var autoCollapseSingletons = flag.Bool("auto-collapse-singletons", false,
+		"Excludes the process from the stack traces of profiles with a single process and no --pidTag, and the "+
+			"thread of processes with a single thread, as with --omit-single-threads.")
 	var excludeIds = flag.Bool("exclude-ids", false, "Excludes ids from threads and processes")
-	var format = flag.String("format", "instruments", formatHelp)
+	var labels = flag.String("labels", strings.Join(internal.SampleLabels, ","),
+		"Comma separated labels attached to the samples, out of "+strings.Join(internal.SampleLabels, ", ")+
+			". Empty attaches none of them, which makes profiles of millions of samples smaller. Labels "+
+			"requested by other flags, e.g. --root-frame-labels, are always attached.")
+	var includeThreads = flag.String("include-threads", "",
+		"Converts only the threads whose name matches this regular expression, e.g. com.apple.main-thread.")
+	var excludeThreads = flag.String("exclude-threads", "",
+		"Leaves out the threads whose name matches this regular expression, e.g. GC or JIT helper threads.")
+	var includeProcess = flag.String("include-process", "",
+		"Converts only the process with this pid, or the processes whose name matches this regular expression.")
+	var excludeProcess = flag.String("exclude-process", "",
+		"Leaves out the process with this pid, or the processes whose name matches this regular expression.")
+	var format = flag.String("format", kAuto, formatHelp())
+	formatOptions := registerFormatFlags(flag.CommandLine)
+	var instrumentTable = flag.String("instrument-table", xctrace.DefaultTable,
+		"The table of a .trace bundle to convert, one of "+strings.Join(xctrace.TableNames(), ", ")+".")
+	var run = flag.Int("run", 1, "The run of a .trace bundle to convert, counted from 1.")
+	var excludeTranslated = flag.Bool("exclude-translated", false,
+		"Drops the processes translated by Rosetta, whose samples are otherwise labeled translated=true.")
+	var splitByCoreType = flag.Bool("split-by-core-type", false,
+		"Adds cpu_p and cpu_e sample values with the time spent on performance and efficiency cores. "+
+			"Requires an input recording cores, e.g. xctrace on Apple Silicon.")
+	var weightResolution = flag.Duration("weight-resolution", 0,
+		"Rounds the weights to multiples of the sampling interval, e.g. 1ms, and adds a samples value "+
+			"with the exact number of samples of each stack.")
+	var cumulativeWeights = flag.Bool("cumulative-weights", false,
+		"Adds a cumulative sample value with the total weight of each frame and its callees, as shown by "+
+			"the input, e.g. the Weight column of a deep copy.")
+	var canonicalStartFrames = flag.Bool("canonical-start-frames", false,
+		"Renames the dyld bootstrap frames (_dyld_start, dyldbootstrap::start, ...) to 'start', "+
+			"so stacks of different processes line up when merged.")
+	var symbolOffsets = flag.String("symbol-offsets", kMergeOffsets,
+		"What to do with the instruction offsets like ' + 123' at the end of frame names: "+
+			"'merge' the frames of the same function, keep them apart as locations at different "+
+			"'address'es of the function or 'keep' them in the names.")
+	var normalizeSwift = flag.Bool("normalize-swift", false,
+		"Folds generated Swift closures, thunks and async partial functions into the function they belong to.")
+	var between = flag.String("between", "",
+		"Keeps only the samples between the first occurrence of two marker symbols, given as "+
+			"'startSymbol,endSymbol'. Requires an input with timestamps, e.g. speedscope or xctrace.")
+	var onlyBinary = flag.String("only-binary", "",
+		"Comma separated globs of binaries, e.g. 'MyApp'. Frames of other binaries are folded into their callers.")
+	var hideBinary = flag.String("hide-binary", "",
+		"Comma separated globs of binaries, e.g. 'libsystem*'. Their frames are folded into their callers.")
+	var frameRules = flag.String("frame-rules", "",
+		"Applies the fold, rename and drop rules of the given file to the frames, see the README.")
+	var dropFrames = flag.String("drop-frames", "",
+		"Sets the drop_frames of the profile: pprof removes the frames matching this regular expression, e.g. "+
+			"'mach_msg_trap|dyld_stub.*', with their callees, leaving their weight to their callers.")
+	var keepFrames = flag.String("keep-frames", "",
+		"Sets the keep_frames of the profile: frames matching this regular expression aren't dropped by --drop-frames.")
+	var pruneFrames = flag.Bool("prune-frames", false,
+		"Removes the frames of --drop-frames at conversion time, for tools other than pprof.")
+	var minWeight = flag.Duration("min-weight", 0,
+		"Folds the frames whose weight with their callees is below this duration, e.g. 5ms, into their callers.")
+	var minPercent = flag.Float64("min-percent", 0,
+		"Folds the frames whose weight with their callees is below this percentage of the total, e.g. 0.1, "+
+			"into their callers.")
+	var jsRuntime = flag.Bool("js-runtime", false,
+		"Labels samples running in a V8 or JavaScriptCore interpreter or JIT with runtime=js.")
+	var foldJSInterpreter = flag.Bool("fold-js-interpreter", false,
+		"Folds consecutive V8 and JavaScriptCore interpreter frames into one.")
+	var componentRules = flag.String("component-rules", "",
+		"Labels samples with the component of their symbols, from a file of '<symbol prefix> <component>' "+
+			"lines. Use 'chromium' for the built-in rules for Chromium.")
+	var rootFrameLabels = flag.Bool("root-frame-labels", false,
+		"Adds a 'root_frame' label with the outermost application frame of each sample's stack, "+
+			"below the process and thread bootstrap frames.")
+	var writeIR = flag.String("write-ir", "",
+		"Also writes the converted profile as versioned JSON to the given file, see the README.")
+	var verify = flag.Bool("verify", false,
+		"Reads the written profile back and checks that it is valid and that its totals match the input.")
+	var rootFrameName = flag.String("root-frame-name", "",
+		"Inserts a synthetic frame with the given name above every stack, e.g. to tell profiles apart after merging them.")
+	var rejectsPath = flag.String("rejects", "",
+		"Writes the input lines the parser skipped or only partly used to the given file, one "+
+			"'<line>\t<reason>\t<text>' per line, to check what is missing from the profile.")
+	var maxWarnings = flag.Int("max-warnings", 5,
+		"Maximum number of warnings of each kind to print, the rest are counted. -1 prints all warnings.")
+	var debugRows = flag.Bool("debug-rows", false,
+		"Adds a numeric 'row' label with the input line of each sample, for debugging conversions.")
+	var redact = flag.String("redact", "",
+		"Replaces the frame names matching the given comma separated regular expressions by a hash, so "+
+			"profiles can be shared. 'app' redacts every frame outside of the system's binaries.")
+	var reportBundlePath = flag.String("report-bundle", "",
+		"Writes a zip archive with the input, flags, warnings and output to attach to an issue. "+
+			"With --redact, the redacted profile replaces the input, and the warnings and the values of the "+
+			"flags are left out.")
+	var maxSymbolLength = flag.Int("max-symbol-length", 0,
+		"Truncates longer frame names, e.g. C++ template expansions, to this many characters plus a hash "+
+			"of the full name. 0 keeps all names.")
+	var store = flag.String("store", "",
+		"Also merges the profile of each process into <dir>/<process name>/<date>.pb.gz, building up "+
+			"daily profiles across conversions.")
+	var cacheDir = flag.String("cache-dir", "",
+		"Caches parsed inputs in the given directory, so converting the same input again with other "+
+			"flags skips parsing. Warnings of the parser are not repeated for cached inputs.")
+	var preset = flag.String("preset", "",
+		"Sets the flags of a preset, unless they are given: "+strings.Join(presetNames(), ", ")+
+			". See the README.")
 	var processAnnotations internal.ProcessAnnotationMap = make(map[uint64](string))
 	flag.Var(&processAnnotations, "pidTag", pidTagHelp)
+	var asserts assertions
+	flag.Var(&asserts, "assert", assertHelp)
+	var baseline = flag.String("baseline", "",
+		"Compares the self weight of each function to the given pprof profile, e.g. of a previous "+
+			"build, and fails with exit code 3 if any regressed by more than --tolerance.")
+	var tolerance = flag.String("tolerance", "10%",
+		"How much the self weight of a function may grow over the --baseline before it regresses.")
+	var baselineWarn = flag.Bool("baseline-warn", false,
+		"Only prints the regressions over the --baseline instead of failing.")
+	var fromClipboard = flag.Bool("from-clipboard", false,
+		"Reads the input from the macOS pasteboard instead of a file or stdin.")
+	var toClipboard = flag.String("to-clipboard", "",
+		"Copies the absolute path of the output file to the macOS pasteboard with 'path', or the "+
+			"output itself with 'content', e.g. for --output-format=collapsed.")
+	var diffMode = flag.Bool("diff", false,
+		"Converts two inputs, before and after a change, and writes the difference as a pprof profile "+
+			"with the second as base, like pprof -diff_base.")
+	var serveAddress serveFlag
+	flag.Var(&serveAddress, "serve", serveHelp)
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), help, os.Args[0])
-		flag.PrintDefaults()
+		formatOptions.printDefaults(flag.CommandLine)
 	}
 	flag.Parse()
+	if *preset != "" {
+		if err := applyPreset(flag.CommandLine, *preset); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := validateFlags(flag.CommandLine, formatOptions); err != nil {
+		log.Fatal(err)
+	}
+	if *diffMode {
+		parserFn, err := parserForFormat(*format, formatOptions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts := internal.NewConvertOptions(
+			internal.ExcludeProcessFrames(*excludeProcessInStack),
+			internal.ExcludeThreadFrames(*excludeThreadsInStack),
+			internal.IncludeThreads(compileThreadFilter(*includeThreads)),
+			internal.ExcludeThreads(compileThreadFilter(*excludeThreads)),
+			internal.IncludeProcesses(parseProcessFilter(*includeProcess)),
+			internal.ExcludeProcesses(parseProcessFilter(*excludeProcess)),
+			internal.DropFrames(*dropFrames, *keepFrames, *pruneFrames),
+			internal.WithLabels(splitList(*labels)),
+			internal.IncludeIDs(false))
+		if err := writeBaseDiff(flag.Arg(0), flag.Arg(1), parserFn, opts, *outputFilename, force); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 	if flag.NArg() > 1 {
 		flag.Usage()
 		os.Exit(-1)
 	}
 	inputFile := flag.Arg(0)
+	internal.MaxWarnings = *maxWarnings
+	if *rejectsPath != "" {
+		rejects, err := os.Create(*rejectsPath)
+		if err != nil {
+			log.Fatalf("Failed to create %s: %v", *rejectsPath, err)
+		}
+		defer rejects.Close()
+		internal.SetRejectOutput(rejects)
+	}
+	var bundle *reportBundle
+	if *reportBundlePath != "" {
+		bundle = &reportBundle{
+			path:      *reportBundlePath,
+			format:    *format,
+			args:      os.Args[1:],
+			redacted:  *redact != "",
+			inputName: "stdin",
+		}
+		if *fromClipboard {
+			bundle.inputName = "clipboard"
+		} else if inputFile != "" && inputFile != "-" {
+			bundle.inputName = filepath.Base(inputFile)
+		}
+		log.SetOutput(io.MultiWriter(os.Stderr, &bundle.log))
+		internal.SetWarningOutput(io.MultiWriter(os.Stdout, &bundle.log))
+	}
+	// fatalf writes the report bundle, if requested, before exiting.
+	fatalf := func(format string, args ...interface{}) {
+		if bundle != nil {
+			internal.FlushWarnings()
+			if err := bundle.write(fmt.Errorf(format, args...)); err != nil {
+				log.Printf("Failed to write report bundle: %v", err)
+			}
+		}
+		log.Fatalf(format, args...)
+	}
 
-	var input io.Reader
-	if inputFile == "-" || inputFile == "" {
-		input = os.Stdin
+	parserFn, err := parserForFormat(*format, formatOptions)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	// inputFormat names the format of the input in errors, once detected.
+	inputFormat := *format
+	if *format == kAuto {
+		parserFn = parsers.MakeDetectingParser(func(f parsers.Format) (parsers.MakeParserFn, error) {
+			inputFormat = f.Name
+			return formatOptions.configure(f)
+		})
+	}
+	var timeProfile *internal.TimeProfile
+	// capabilities of the input's format, unknown for directories of reports.
+	var capabilities *internal.Capabilities
+	// useCapabilities fails before parsing if the input can't support the
+	// flags.
+	useCapabilities := func(c internal.Capabilities) {
+		capabilities = &c
+		if err := checkCapabilities(flag.CommandLine, c); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if info, err := os.Stat(inputFile); err == nil && info.IsDir() && (*format == kTrace || xctrace.IsTraceBundle(inputFile)) {
+		useCapabilities(xctrace.XctraceParser{}.Capabilities())
+		timeProfile, err = parseTraceBundle(inputFile, *instrumentTable, *run)
+		if err != nil {
+			fatalf("%v", err)
+		}
+	} else if err == nil && info.IsDir() {
+		timeProfile, err = parseReportDirectory(inputFile, parserFn)
+		if err != nil {
+			fatalf("%v", err)
+		}
 	} else {
-		file, err := os.Open(inputFile)
+		var input io.Reader
+		if *fromClipboard {
+			data, err := readClipboard()
+			if err != nil {
+				fatalf("%v", err)
+			}
+			input = bytes.NewReader(data)
+		} else if inputFile == "-" || inputFile == "" {
+			input = os.Stdin
+		} else {
+			file, err := os.Open(inputFile)
+			if err != nil {
+				fatalf("Failed to open %s: %v", inputFile, err)
+			}
+			defer file.Close()
+			input = file
+		}
+		var cacheKey string
+		// hasher hashes inputs that can't be read twice while they are
+		// parsed, they are cached but can't be looked up before parsing.
+		var hasher *cache.Hasher
+		if *cacheDir != "" {
+			hasher = cache.NewHasher(append([]string{*format}, formatOptions.values(*format)...)...)
+			if seeker, ok := input.(io.ReadSeeker); ok && isSeekable(seeker) {
+				if _, err := io.Copy(hasher, seeker); err != nil {
+					fatalf("Failed to read input: %v", err)
+				}
+				if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+					fatalf("Failed to read input: %v", err)
+				}
+				cacheKey, hasher = hasher.Key(), nil
+				var cached internal.Capabilities
+				if timeProfile, cached, err = cache.Load(*cacheDir, cacheKey); err != nil {
+					log.Printf("WARNING: Ignoring the cached profile: %v", err)
+				} else if timeProfile != nil {
+					useCapabilities(cached)
+				}
+			} else {
+				input = io.TeeReader(input, hasher)
+			}
+		}
+		if bundle != nil {
+			input = io.TeeReader(input, &bundle.input)
+			if timeProfile != nil {
+				// The cached input isn't parsed, but is part of the bundle.
+				if _, err := io.Copy(ioutil.Discard, input); err != nil {
+					fatalf("Failed to read input: %v", err)
+				}
+			}
+		}
+		if timeProfile == nil {
+			parser, err := parserFn(input)
+			if err != nil {
+				fatalf("%v", err)
+			}
+			useCapabilities(parser.Capabilities())
+			timeProfile, err = parser.ParseProfile()
+			if err != nil {
+				fatalf("Failed to parse the %s input: %v", inputFormat, err)
+			}
+			if hasher != nil {
+				// The key covers the whole input, also what the parser skipped.
+				if _, err := io.Copy(ioutil.Discard, input); err != nil {
+					fatalf("Failed to read input: %v", err)
+				}
+				cacheKey = hasher.Key()
+			}
+			if cacheKey != "" {
+				if err := cache.Store(*cacheDir, cacheKey, timeProfile, *capabilities); err != nil {
+					log.Printf("WARNING: Failed to cache the parsed profile: %v", err)
+				}
+			}
+		}
+	}
+	if *between != "" {
+		markers := strings.SplitN(*between, ",", 2)
+		if len(markers) != 2 {
+			fatalf("Invalid --between %s, expected startSymbol,endSymbol", *between)
+		}
+		if err := internal.SelectBetween(timeProfile, markers[0], markers[1]); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	internal.TagTranslated(timeProfile)
+	if *excludeTranslated {
+		internal.ExcludeTranslated(timeProfile)
+	}
+	if *splitByCoreType {
+		if err := internal.SplitByCoreType(timeProfile); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if *weightResolution != 0 {
+		if err := internal.SnapWeights(timeProfile, weightResolution.Nanoseconds()); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if *canonicalStartFrames {
+		internal.CanonicalizeStartFrames(timeProfile)
+	}
+	if *symbolOffsets != kKeepOffsets {
+		internal.StripSymbolOffsets(timeProfile, *symbolOffsets == kMergeOffsets)
+	}
+	if *normalizeSwift {
+		internal.NormalizeSwiftSymbols(timeProfile)
+	}
+	if *onlyBinary != "" || *hideBinary != "" {
+		filter := internal.BinaryFilter{Only: splitList(*onlyBinary), Hide: splitList(*hideBinary)}
+		if err := filter.Validate(); err != nil {
+			fatalf("%v", err)
+		}
+		internal.FilterBinaries(timeProfile, filter)
+	}
+	if *frameRules != "" {
+		rules, err := loadFrameRules(*frameRules)
+		if err != nil {
+			fatalf("Failed to load frame rules: %v", err)
+		}
+		internal.ApplyFrameRules(timeProfile, rules)
+	}
+	if *jsRuntime {
+		internal.AddJSRuntimeLabels(timeProfile)
+	}
+	if *foldJSInterpreter {
+		internal.FoldJSInterpreterFrames(timeProfile)
+	}
+	if *componentRules != "" {
+		rules, err := loadComponentRules(*componentRules)
+		if err != nil {
+			fatalf("Failed to load component rules: %v", err)
+		}
+		internal.AddComponentLabels(timeProfile, rules)
+	}
+	if *rootFrameLabels {
+		internal.AddRootFrameLabels(timeProfile)
+	}
+	if *debugRows {
+		internal.AddRowLabels(timeProfile)
+	}
+	if *redact != "" {
+		redaction, err := internal.ParseRedaction(*redact)
+		if err != nil {
+			fatalf("%v", err)
+		}
+		internal.Redact(timeProfile, redaction)
+		if bundle != nil {
+			if err := bundle.setRedactedProfile(timeProfile); err != nil {
+				fatalf("Failed to write the redacted profile: %v", err)
+			}
+		}
+	}
+	if *minWeight > 0 {
+		if unit := timeProfile.GetValueType().Unit; unit != "nanoseconds" {
+			fatalf("--min-weight applies to time profiles, this one is in %s. Use --min-percent instead", unit)
+		}
+		internal.PruneBelow(timeProfile, minWeight.Nanoseconds())
+	}
+	if *minPercent > 0 {
+		internal.PruneBelow(timeProfile, internal.MinPercentWeight(timeProfile, *minPercent))
+	}
+	if *maxSymbolLength > 0 {
+		internal.TruncateSymbols(timeProfile, *maxSymbolLength)
+	}
+	if *cumulativeWeights {
+		if err := internal.AddCumulativeWeights(timeProfile); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if *writeIR != "" {
+		if err := writeIRFile(*writeIR, timeProfile); err != nil {
+			fatalf("Failed to write IR: %v", err)
+		}
+	}
+	convertOptions := internal.NewConvertOptions(
+		internal.ExcludeProcessFrames(*excludeProcessInStack),
+		internal.ExcludeThreadFrames(*excludeThreadsInStack),
+		internal.IncludeIDs(!*excludeIds),
+		internal.IncludeThreads(compileThreadFilter(*includeThreads)),
+		internal.ExcludeThreads(compileThreadFilter(*excludeThreads)),
+		internal.IncludeProcesses(parseProcessFilter(*includeProcess)),
+		internal.ExcludeProcesses(parseProcessFilter(*excludeProcess)),
+		internal.DropFrames(*dropFrames, *keepFrames, *pruneFrames),
+		internal.WithLabels(splitList(*labels)),
+		internal.RootFrameName(*rootFrameName),
+		internal.OmitSingleThreads(*omitSingleThreads || *autoCollapseSingletons),
+		internal.OmitSingleProcess(*autoCollapseSingletons),
+		internal.WithAnnotations(processAnnotations))
+	if capabilities != nil {
+		convertOptions.Capabilities = capabilities
+	}
+	convertOptions.Filter(timeProfile)
+	pprof := internal.ConvertToPprof(timeProfile, convertOptions)
+	internal.FlushWarnings()
+	if err := pprof.CheckValid(); err != nil {
+		fatalf("Invalid profile: %v\n", err)
+	}
+	if *verify {
+		if err := internal.VerifyPprof(timeProfile, pprof); err != nil {
+			fatalf("Verification failed: %v", err)
+		}
+	}
+	if *store != "" {
+		if err := appendToStore(*store, timeProfile, time.Now(), convertOptions); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	switch *outputFormat {
+	case kCollapsedOutput:
+		err = writeFile(*outputFilename, force, func(w io.Writer) error {
+			return internal.WriteCollapsed(w, pprof)
+		})
+	case kSpeedscopeOutput:
+		err = writeFile(*outputFilename, force, func(w io.Writer) error {
+			return speedscope.WriteSpeedscope(w, timeProfile)
+		})
+	case kHTMLOutput:
+		err = writeFile(*outputFilename, force, func(w io.Writer) error {
+			return internal.WriteFlameGraphHTML(w, timeProfile, convertOptions)
+		})
+	case kTreeOutput:
+		opts := internal.TreeOptions{MinPercent: kTreeMinPercent, ConvertOptions: convertOptions}
+		if !isFlagSet(flag.CommandLine, "output") {
+			opts.Color = useColor(os.Stdout)
+			err = internal.WriteTree(os.Stdout, timeProfile, opts)
+			break
+		}
+		err = writeFile(*outputFilename, force, func(w io.Writer) error {
+			return internal.WriteTree(w, timeProfile, opts)
+		})
+	default:
+		err = writeOutput(*outputFilename, pprof, force)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *toClipboard != "" {
+		if err := copyOutput(*toClipboard, *outputFilename); err != nil {
+			fatalf("%v", err)
+		}
+	}
+	if bundle != nil {
+		var profile bytes.Buffer
+		if err := pprof.Write(&profile); err != nil {
+			fatalf("failed to write: %v", err)
+		}
+		bundle.profile = profile.Bytes()
+		if err := bundle.write(nil); err != nil {
+			log.Fatalf("Failed to write report bundle: %v", err)
+		}
+		fmt.Printf("Wrote %s, please attach it to an issue at %s\n", bundle.path, internal.IssueURL)
+	}
+	// The output is written first, so a regression can be looked into.
+	regressed := false
+	if *baseline != "" {
+		base, err := readBaseline(*baseline)
 		if err != nil {
-			log.Fatalf("Failed to open %s: %v", inputFile, err)
+			log.Fatal(err)
+		}
+		maxGrowth, _ := parseTolerance(*tolerance)
+		regressions := regressionsOf(base, pprof, maxGrowth)
+		if err := writeRegressionReport(os.Stdout, regressions, maxGrowth, weightFormatter(pprof)); err != nil {
+			log.Fatal(err)
+		}
+		regressed = len(regressions) > 0 && !*baselineWarn
+	}
+	if violations := asserts.check(pprof); len(violations) > 0 {
+		for _, violation := range violations {
+			log.Print(violation)
+		}
+		regressed = true
+	}
+	if serveAddress != "" {
+		if err := serveProfile(string(serveAddress), *outputFilename, pprof, nil); err != nil {
+			log.Fatalf("Failed to serve the profile: %v", err)
 		}
-		defer file.Close()
-		input = file
 	}
+	if regressed {
+		os.Exit(kRegressionExitCode)
+	}
+}
 
-	var parserFn makeParserFn
-	if *format == kSample {
-		parserFn = parsers.MakeSampleParser
-	} else if *format == kInstrumentsDeepCopy {
-		parserFn = parsers.MakeDeepCopyParser
-	} else {
-		log.Fatalf("Invalid file format specified: %s", *format)
+// writeOutput writes the profile to path. Existing files are only
+// overwritten with force, so a previous conversion isn't lost by accident.
+func writeOutput(path string, prof *profile.Profile, force bool) error {
+	return writeFile(path, force, prof.Write)
+}
+
+// compileThreadFilter compiles a thread filter flag, already validated, or
+// returns nil if it is empty.
+func compileThreadFilter(expr string) *regexp.Regexp {
+	if expr == "" {
+		return nil
+	}
+	return regexp.MustCompile(expr)
+}
+
+// parseProcessFilter parses a process filter flag, already validated, or
+// returns nil if it is empty.
+func parseProcessFilter(expr string) *internal.ProcessFilter {
+	if expr == "" {
+		return nil
 	}
-	parser, err := parserFn(input)
+	filter, err := internal.ParseProcessFilter(expr)
 	if err != nil {
 		log.Fatal(err)
 	}
-	timeProfile, err := parser.ParseProfile()
+	return filter
+}
+
+// isFlagSet returns whether the flag was given on the command line.
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// useColor returns whether to color output to f, which is only done for
+// terminals and unless NO_COLOR is set, see https://no-color.org.
+func useColor(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// isSeekable reports whether the input can be read again from the start,
+// unlike pipes.
+func isSeekable(input io.Seeker) bool {
+	_, err := input.Seek(0, io.SeekCurrent)
+	return err == nil
+}
+
+// writeFile creates the file at path and writes it with write, unless it
+// exists and force isn't set.
+func writeFile(path string, force bool, write func(io.Writer) error) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !force {
+		flags |= os.O_EXCL
+	}
+	out, err := os.OpenFile(path, flags, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists, use --force to overwrite it or --output to choose another file", path)
+	}
+	if err != nil {
+		return fmt.Errorf("output failed: %v", err)
+	}
+	if err := write(out); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to write: %v", err)
+	}
+	return out.Close()
+}
+
+func loadFrameRules(path string) (internal.FrameRules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return internal.FrameRules{}, err
+	}
+	defer file.Close()
+	return internal.ParseFrameRules(file)
+}
+
+// splitList splits a comma separated flag value, ignoring empty elements.
+func splitList(value string) []string {
+	list := make([]string, 0)
+	for _, element := range strings.Split(value, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			list = append(list, element)
+		}
+	}
+	return list
+}
+
+func writeIRFile(path string, p *internal.TimeProfile) error {
+	out, err := os.Create(path)
 	if err != nil {
-		log.Fatalf("Failed to parse deep copy: %v", err)
+		return err
+	}
+	if err := ir.Write(out, p); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// loadComponentRules loads the rules file at path, or the built-in rules
+// named "chromium".
+func loadComponentRules(path string) (internal.ComponentRules, error) {
+	if path == "chromium" {
+		return internal.ParseComponentRules(strings.NewReader(internal.ChromiumComponentRules))
 	}
-	pprof := internal.TimeProfileToPprof(timeProfile, *excludeProcessInStack,
-		*excludeThreadsInStack, !*excludeIds, processAnnotations)
-	if err = pprof.CheckValid(); err != nil {
-		log.Fatalf("Invalid profile: %v\n", err)
+	file, err := os.Open(path)
+	if err != nil {
+		return internal.ComponentRules{}, err
 	}
-	out, err := os.Create(*outputFilename)
+	defer file.Close()
+	return internal.ParseComponentRules(file)
+}
+
+// parseTraceBundle exports a table of a run of an Instruments .trace bundle
+// with xctrace and parses it.
+func parseTraceBundle(path string, table string, run int) (*internal.TimeProfile, error) {
+	exported, err := xctrace.ExportTable(path, table, run)
+	if err != nil {
+		return nil, err
+	}
+	parser, err := xctrace.MakeXctraceParser(bytes.NewReader(exported))
+	if err != nil {
+		return nil, err
+	}
+	p, err := parser.ParseProfile()
+	if err != nil {
+		return nil, err
+	}
+	attributes, err := xctrace.ExportAttributes(path, run)
+	if err != nil {
+		log.Printf("WARNING: Not labeling the samples with the device: %v", err)
+	}
+	for _, proc := range p.Processes {
+		internal.SetAttributes(proc, attributes)
+	}
+	return p, nil
+}
+
+// parseReportDirectory parses every file in dir as a separate report and
+// aggregates them into a profile counting the reports containing each stack.
+// Files that fail to parse are skipped with a warning.
+func parseReportDirectory(dir string, parserFn parsers.MakeParserFn) (*internal.TimeProfile, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	reports := make([]*internal.TimeProfile, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		report, err := parseReport(path, parserFn)
+		if err != nil {
+			log.Printf("WARNING: Skipping %s: %v", path, err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("No reports could be parsed in %s", dir)
+	}
+	return internal.AggregateReports(reports), nil
+}
+
+func parseReport(path string, parserFn parsers.MakeParserFn) (*internal.TimeProfile, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Fatalf("output failed: %v", err)
+		return nil, err
 	}
-	defer out.Close()
-	err = pprof.Write(out)
+	defer file.Close()
+	parser, err := parserFn(file)
 	if err != nil {
-		log.Fatalf("failed to write: %v", err)
+		return nil, err
 	}
+	return parser.ParseProfile()
 }
@@ -0,0 +1,52 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+func TestWriteOutputRefusesOverwrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "profile.pb.gz")
+	if err := ioutil.WriteFile(path, []byte("previous"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prof := internal.TimeProfileToPprof(storeProfile(), false, false, true, make(internal.ProcessAnnotationMap))
+
+	err = writeOutput(path, prof, false)
+	if err == nil || !strings.Contains(err.Error(), "--force") {
+		t.Errorf("Expected an error suggesting --force, got %v", err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) != "previous" {
+		t.Errorf("Expected the existing file to be kept, got %q", content)
+	}
+	if err := writeOutput(path, prof, true); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ioutil.ReadFile(path); string(content) == "previous" {
+		t.Error("Expected the existing file to be overwritten with --force")
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package convert lets other Go programs use the parsers and the pprof
+// converter of instrumentsToPprof.
+package convert
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/instrumentsToPprof/internal/parsers"
+	"github.com/google/pprof/profile"
+)
+
+// Format is an input format, named like the values of the --format flag.
+type Format string
+
+const (
+	// Auto detects the format from the input.
+	Auto          Format = "auto"
+	DeepCopy      Format = "instruments"
+	Allocations   Format = "allocations"
+	Sample        Format = "sample"
+	MetricKit     Format = "metrickit"
+	Crash         Format = "crash"
+	Spindump      Format = "spindump"
+	Sysdiagnose   Format = "sysdiagnose"
+	Speedscope    Format = "speedscope"
+	FlameGraphSvg Format = "flamegraph-svg"
+	IR            Format = "ir"
+	Xctrace       Format = "xctrace"
+	Heap          Format = "heap"
+	Vmmap         Format = "vmmap"
+	Leaks         Format = "leaks"
+	MallocHistory Format = "malloc-history"
+	FsUsage       Format = "fs-usage"
+)
+
+// TimeProfile is a parsed input: its processes, their threads and the call
+// trees of the threads.
+type TimeProfile = internal.TimeProfile
+
+// ProcessFilter matches processes by pid or name, for Options.
+type ProcessFilter = internal.ProcessFilter
+
+// ParseProcessFilter parses a pid, if expr is a number, or else a regular
+// expression matching the process names.
+func ParseProcessFilter(expr string) (*ProcessFilter, error) {
+	return internal.ParseProcessFilter(expr)
+}
+
+// Options are the options of ToPprof. The zero value converts like the
+// command line without flags.
+type Options struct {
+	// ExcludeProcessFrames leaves out the frames of the processes.
+	ExcludeProcessFrames bool
+	// ExcludeThreadFrames leaves out the frames of the threads.
+	ExcludeThreadFrames bool
+	// ExcludeIDs leaves the pids and tids out of the names of the process
+	// and thread frames.
+	ExcludeIDs bool
+	// Annotations are appended to the names of the processes with the pids.
+	Annotations map[uint64]string
+	// RootFrameName inserts a frame with the name above every stack.
+	RootFrameName string
+	// OmitSingleThreads leaves out the thread frame of single threaded
+	// processes.
+	OmitSingleThreads bool
+	// OmitSingleProcess leaves out the process frame of profiles with a
+	// single unannotated process.
+	OmitSingleProcess bool
+	// IncludeThreads keeps only the threads whose name matches, if set.
+	IncludeThreads *regexp.Regexp
+	// ExcludeThreads leaves out the threads whose name matches, if set.
+	ExcludeThreads *regexp.Regexp
+	// IncludeProcesses keeps only the matching processes, if set. See
+	// ParseProcessFilter.
+	IncludeProcesses *ProcessFilter
+	// ExcludeProcesses leaves out the matching processes, if set.
+	ExcludeProcesses *ProcessFilter
+	// DropFrames and KeepFrames are the drop_frames and keep_frames regular
+	// expressions of the profile. PruneFrames removes the frames at
+	// conversion time instead of leaving it to pprof.
+	DropFrames, KeepFrames string
+	PruneFrames            bool
+	// Labels are the labels of the samples, out of "pid", "tid",
+	// "process_name", "thread_name" and the thread labels "os_version",
+	// "arch", "device_model", "translated" and "crashed". Nil attaches all
+	// of them, an empty list none.
+	Labels []string
+}
+
+// Parse parses the input of the format. Gzip and zip compressed input is
+// decompressed first, like on the command line.
+func Parse(r io.Reader, format Format) (*TimeProfile, error) {
+	parser, err := makeParser(r, format)
+	if err != nil {
+		return nil, err
+	}
+	return parser.ParseProfile()
+}
+
+// makeParser returns a parser of the possibly compressed input of the
+// format.
+func makeParser(r io.Reader, format Format) (parsers.Parser, error) {
+	if format == Auto {
+		return parsers.MakeDetectingParser(func(f parsers.Format) (parsers.MakeParserFn, error) {
+			return f.Make, nil
+		})(r)
+	}
+	f, ok := parsers.LookupFormat(string(format))
+	if !ok {
+		return nil, fmt.Errorf("Invalid file format specified: %s", format)
+	}
+	if f.Archive {
+		return f.Make(r)
+	}
+	return parsers.MakeDecompressingParser(f.Make)(r)
+}
+
+// ToPprof converts a parsed input to a pprof profile. The input is left
+// unchanged, so it can be converted again with other options.
+func ToPprof(tp *TimeProfile, opts Options) (*profile.Profile, error) {
+	prof := internal.ConvertToPprof(tp, internal.ConvertOptions{
+		ExcludeProcessFrames: opts.ExcludeProcessFrames,
+		ExcludeThreadFrames:  opts.ExcludeThreadFrames,
+		IncludeIDs:           !opts.ExcludeIDs,
+		Annotations:          opts.Annotations,
+		RootFrameName:        opts.RootFrameName,
+		OmitSingleThreads:    opts.OmitSingleThreads,
+		OmitSingleProcess:    opts.OmitSingleProcess,
+		IncludeThreads:       opts.IncludeThreads,
+		ExcludeThreads:       opts.ExcludeThreads,
+		IncludeProcesses:     opts.IncludeProcesses,
+		ExcludeProcesses:     opts.ExcludeProcesses,
+		DropFrames:           opts.DropFrames,
+		KeepFrames:           opts.KeepFrames,
+		PruneFrames:          opts.PruneFrames,
+		Labels:               opts.Labels,
+	})
+	if err := prof.CheckValid(); err != nil {
+		return nil, fmt.Errorf("Invalid profile: %v", err)
+	}
+	return prof, nil
+}
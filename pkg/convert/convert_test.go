@@ -0,0 +1,153 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+)
+
+const deepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+	"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+	"5.0 s  50%\t0 s\t \t Thread 1  0x1ee7\n" +
+	"5.0 s  50%\t0 s\t \t  foo\n" +
+	"2.0 s  20%\t2.0 s\t \t   bar1\n" +
+	"3.0 s  30%\t3.0 s\t \t   bar2\n" +
+	"5.0 s  50%\t0 s\t \t Thread 2  0x7ee1\n" +
+	"5.0 s  50%\t5.0 s\t \t  spin\n" +
+	"\n"
+
+func TestParse(t *testing.T) {
+	p, err := Parse(strings.NewReader(deepCopy), Auto)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Processes) != 1 || len(p.Processes[0].Threads) != 2 {
+		t.Fatalf("Expected 1 process with 2 threads, got %v", p.Processes)
+	}
+	if _, err := Parse(strings.NewReader(deepCopy), Format("pdf")); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestParseCompressed(t *testing.T) {
+	var compressed bytes.Buffer
+	w := gzip.NewWriter(&compressed)
+	if _, err := w.Write([]byte(deepCopy)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []Format{Auto, DeepCopy} {
+		p, err := Parse(bytes.NewReader(compressed.Bytes()), format)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if len(p.Processes) != 1 || len(p.Processes[0].Threads) != 2 {
+			t.Errorf("%s: expected 1 process with 2 threads, got %v", format, p.Processes)
+		}
+	}
+}
+
+func TestToPprof(t *testing.T) {
+	p, err := Parse(strings.NewReader(deepCopy), DeepCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prof, err := ToPprof(p, Options{ExcludeThreadFrames: true, Annotations: map[uint64]string{123: "Browser"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, s := range prof.Sample {
+		total += s.Value[0]
+		for _, loc := range s.Location {
+			if name := loc.Line[0].Function.Name; strings.HasPrefix(name, "Thread") {
+				t.Errorf("Expected no thread frames, got %s", name)
+			}
+		}
+	}
+	if total != 10_000_000_000 {
+		t.Errorf("Expected a total of 10s, got %dns", total)
+	}
+	var process string
+	for _, f := range prof.Function {
+		if strings.HasPrefix(f.Name, "Main Process") {
+			process = f.Name
+		}
+	}
+	if !strings.Contains(process, "Browser") {
+		t.Errorf("Expected the process frame to be annotated, got %q", process)
+	}
+}
+
+func TestToPprofLeavesTheInputUnchanged(t *testing.T) {
+	p, err := Parse(strings.NewReader(deepCopy), DeepCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prof, err := ToPprof(p, Options{RootFrameName: "Fleet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root := prof.Sample[0].Location[len(prof.Sample[0].Location)-1]; root.Line[0].Function.Name != "Fleet" {
+		t.Errorf("Expected the root frame Fleet, got %s", root.Line[0].Function.Name)
+	}
+	prof, err = ToPprof(p, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range prof.Function {
+		if f.Name == "Fleet" {
+			t.Error("Expected no root frame from the earlier conversion")
+		}
+	}
+}
+
+func TestToPprofOmitSingleThreads(t *testing.T) {
+	p, err := Parse(strings.NewReader(deepCopy), DeepCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Processes[0].Threads = p.Processes[0].Threads[:1]
+	prof, err := ToPprof(p, Options{OmitSingleThreads: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range prof.Sample {
+		if caller := s.Location[len(s.Location)-2].Line[0].Function.Name; caller != "foo" {
+			t.Errorf("Expected foo below the process frame, got %s", caller)
+		}
+	}
+}
+
+func TestToPprofOmitSingleProcess(t *testing.T) {
+	p, err := Parse(strings.NewReader(deepCopy), DeepCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prof, err := ToPprof(p, Options{OmitSingleProcess: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range prof.Sample {
+		if outermost := s.Location[len(s.Location)-1].Line[0].Function.Name; !strings.HasPrefix(outermost, "Thread") {
+			t.Errorf("Expected the thread to be the outermost frame, got %s", outermost)
+		}
+	}
+}
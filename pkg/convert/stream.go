@@ -0,0 +1,72 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/google/instrumentsToPprof/internal/parsers/instruments"
+)
+
+// ProcessID identifies the process of a sample. Pid is 0 for inputs that
+// don't record it.
+type ProcessID struct {
+	Pid  uint64
+	Name string
+}
+
+// ThreadID identifies the thread of a sample. Tid is 0 for inputs that don't
+// record it.
+type ThreadID struct {
+	Tid  uint64
+	Name string
+}
+
+// Stack are the function names of a sample, from the outermost frame.
+type Stack []string
+
+// Weight is the weight of a sample, in the unit of its value type, e.g.
+// nanoseconds of cpu time.
+type Weight struct {
+	Value int64
+	Type  string
+	Unit  string
+}
+
+// SampleFunc is called by ParseInto for every sample. The stack is reused by
+// the next call, so copy it to keep it. Returning an error stops the parsing.
+type SampleFunc func(ProcessID, ThreadID, Stack, Weight) error
+
+// ParseInto parses a deep copy, of the format DeepCopy, Allocations or Auto,
+// and calls fn for every stack with self weight while the input is read,
+// without building the call tree, so inputs larger than memory can be
+// stored in other formats. Unlike Parse, the weight of filtered out callees
+// and the thread states are not separated, and additional sample values,
+// e.g. wakeups, are not passed. Other formats fail.
+func ParseInto(r io.Reader, format Format, fn SampleFunc) error {
+	parser, err := makeParser(r, format)
+	if err != nil {
+		return err
+	}
+	deepCopy, ok := parser.(instruments.DeepCopyParser)
+	if !ok {
+		return fmt.Errorf("ParseInto streams only deep copies, not %s input", format)
+	}
+	return deepCopy.Stream(func(s instruments.Stack) error {
+		return fn(ProcessID{Pid: s.Process.Pid, Name: s.Process.Name}, ThreadID{Tid: s.Thread.Tid, Name: s.Thread.Name},
+			Stack(s.Frames), Weight{Value: s.Weight, Type: s.ValueType.Type, Unit: s.ValueType.Unit})
+	})
+}
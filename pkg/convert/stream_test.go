@@ -0,0 +1,135 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package convert
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type streamedSample struct {
+	process ProcessID
+	thread  ThreadID
+	stack   Stack
+	weight  Weight
+}
+
+func TestParseInto(t *testing.T) {
+	var got []streamedSample
+	err := ParseInto(strings.NewReader(deepCopy), DeepCopy, func(p ProcessID, th ThreadID, s Stack, w Weight) error {
+		got = append(got, streamedSample{p, th, append(Stack(nil), s...), w})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	process := ProcessID{Pid: 123, Name: "Main Process"}
+	cpu := func(ns int64) Weight { return Weight{Value: ns, Type: "cpu", Unit: "nanoseconds"} }
+	want := []streamedSample{
+		{process, ThreadID{Tid: 0x1ee7, Name: "Thread 1"}, Stack{"foo", "bar1"}, cpu(2_000_000_000)},
+		{process, ThreadID{Tid: 0x1ee7, Name: "Thread 1"}, Stack{"foo", "bar2"}, cpu(3_000_000_000)},
+		{process, ThreadID{Tid: 0x7ee1, Name: "Thread 2"}, Stack{"spin"}, cpu(5_000_000_000)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected samples %v, got %v", want, got)
+	}
+}
+
+func TestParseIntoAllocations(t *testing.T) {
+	const allocations = "Bytes Used\tCount\t\tSymbol Name\n" +
+		"3.00 MB  100%\t30\t \tApp (123)\n" +
+		"3.00 MB  100%\t30\t \t Main Thread  0x1ee7\n" +
+		"3.00 MB  100%\t30\t \t  main\n" +
+		"1.00 MB  33.3%\t10\t \t   malloc\n"
+	var got []streamedSample
+	err := ParseInto(strings.NewReader(allocations), Allocations, func(p ProcessID, th ThreadID, s Stack, w Weight) error {
+		got = append(got, streamedSample{p, th, append(Stack(nil), s...), w})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stacks []Stack
+	var total int64
+	for _, s := range got {
+		stacks = append(stacks, s.stack)
+		total += s.weight.Value
+	}
+	// The weights include the callees, so main keeps what malloc doesn't.
+	if want := []Stack{{"main", "malloc"}, {"main"}}; !reflect.DeepEqual(stacks, want) {
+		t.Errorf("Expected stacks %v, got %v", want, stacks)
+	}
+	if total != 3<<20 || got[0].weight.Type != "alloc_space" {
+		t.Errorf("Expected 3 MB of alloc_space, got %v", got)
+	}
+}
+
+// TestParseIntoStreams checks that samples are passed before the rest of the
+// input is written.
+func TestParseIntoStreams(t *testing.T) {
+	r, w := io.Pipe()
+	first := make(chan struct{})
+	streamed := false
+	go func() {
+		io.WriteString(w, deepCopy[:strings.Index(deepCopy, " Thread 2")])
+		select {
+		case <-first:
+			streamed = true
+		case <-time.After(5 * time.Second):
+		}
+		io.WriteString(w, deepCopy[strings.Index(deepCopy, " Thread 2"):])
+		w.Close()
+	}()
+	calls := 0
+	err := ParseInto(r, DeepCopy, func(ProcessID, ThreadID, Stack, Weight) error {
+		if calls++; calls == 1 {
+			close(first)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !streamed || calls != 3 {
+		t.Errorf("Expected the first sample before the end of the input, got %d samples, streamed %v", calls, streamed)
+	}
+}
+
+func TestParseIntoStopsOnError(t *testing.T) {
+	stop := errors.New("stop")
+	calls := 0
+	err := ParseInto(strings.NewReader(deepCopy), DeepCopy, func(ProcessID, ThreadID, Stack, Weight) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Expected parsing to stop after the first error, got %v after %d calls", err, calls)
+	}
+}
+
+func TestParseIntoOtherFormats(t *testing.T) {
+	for _, format := range []Format{Format("pdf"), Speedscope} {
+		err := ParseInto(strings.NewReader(deepCopy), format, func(ProcessID, ThreadID, Stack, Weight) error {
+			return nil
+		})
+		if err == nil {
+			t.Errorf("Expected an error for %s", format)
+		}
+	}
+}
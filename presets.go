@@ -0,0 +1,85 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+)
+
+// presets are named sets of flag values for common uses, by flag name.
+var presets = map[string]map[string]string{
+	// chrome labels the components of Chromium and the time spent in V8.
+	"chrome": {
+		"component-rules":        "chromium",
+		"js-runtime":             "true",
+		"fold-js-interpreter":    "true",
+		"root-frame-labels":      "true",
+		"canonical-start-frames": "true",
+	},
+	// ios-app focuses on the code of a Swift or Objective-C app.
+	"ios-app": {
+		"normalize-swift":        "true",
+		"canonical-start-frames": "true",
+		"root-frame-labels":      "true",
+		"hide-binary":            "libsystem_*.dylib,libdyld.dylib,libdispatch.dylib,libobjc.A.dylib",
+	},
+	// minimal gives small profiles that merge well across runs.
+	"minimal": {
+		"exclude-ids":                "true",
+		"exclude-threads-from-stack": "true",
+		"canonical-start-frames":     "true",
+		"max-symbol-length":          "200",
+	},
+	// full keeps everything the input has and checks the conversion.
+	"full": {
+		"root-frame-labels": "true",
+		"debug-rows":        "true",
+		"verify":            "true",
+	},
+}
+
+// presetNames returns the sorted names of the presets.
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyPreset sets the flags of the named preset that weren't given on the
+// command line, which take precedence.
+func applyPreset(fs *flag.FlagSet, name string) error {
+	preset, ok := presets[name]
+	if !ok {
+		return unknownValueError("preset", name, presetNames())
+	}
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for flagName, value := range preset {
+		if explicit[flagName] {
+			continue
+		}
+		if err := fs.Set(flagName, value); err != nil {
+			return fmt.Errorf("Preset %s can't set --%s: %v", name, flagName, err)
+		}
+	}
+	return nil
+}
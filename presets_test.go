@@ -0,0 +1,44 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"testing"
+)
+
+func TestApplyPreset(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	excludeIds := fs.Bool("exclude-ids", false, "")
+	excludeThreads := fs.Bool("exclude-threads-from-stack", false, "")
+	fs.Bool("canonical-start-frames", false, "")
+	maxSymbolLength := fs.Int("max-symbol-length", 0, "")
+	if err := fs.Parse([]string{"--max-symbol-length=50", "--exclude-threads-from-stack=false"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyPreset(fs, "minimal"); err != nil {
+		t.Fatal(err)
+	}
+	if !*excludeIds {
+		t.Error("Expected the preset to set --exclude-ids")
+	}
+	if *maxSymbolLength != 50 || *excludeThreads {
+		t.Errorf("Expected flags given on the command line to take precedence, got %d and %v",
+			*maxSymbolLength, *excludeThreads)
+	}
+	if err := applyPreset(fs, "unknown"); err == nil {
+		t.Error("Expected an error for an unknown preset")
+	}
+}
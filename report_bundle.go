@@ -0,0 +1,148 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/instrumentsToPprof/internal/ir"
+)
+
+// reportBundle collects everything needed to reproduce a conversion, to be
+// attached to an issue.
+type reportBundle struct {
+	path   string
+	format string
+	args   []string
+	// redacted bundles contain the redacted profile as IR instead of the
+	// input, which can't be redacted. Free text naming symbols, like the
+	// warnings and the values of the arguments, is left out as well.
+	redacted  bool
+	inputName string
+	input     bytes.Buffer
+	// log receives the warnings and log messages of the conversion.
+	log     bytes.Buffer
+	ir      []byte
+	profile []byte
+}
+
+// toolVersion returns the module version of the binary, "(devel)" for builds
+// from a checkout.
+func toolVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// setRedactedProfile records the profile after redaction.
+func (b *reportBundle) setRedactedProfile(p *internal.TimeProfile) error {
+	var buf bytes.Buffer
+	if err := ir.Write(&buf, p); err != nil {
+		return err
+	}
+	b.ir = buf.Bytes()
+	return nil
+}
+
+func (b *reportBundle) summary(conversionErr error) string {
+	var s strings.Builder
+	fmt.Fprintf(&s, "Please attach this file to an issue at %s\n\n", internal.IssueURL)
+	fmt.Fprintf(&s, "Tool version: %s\n", toolVersion())
+	fmt.Fprintf(&s, "Go version: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&s, "Format: %s\n", b.format)
+	if b.redacted {
+		fmt.Fprintf(&s, "Flags: %q\n", flagNames(b.args))
+		fmt.Fprintf(&s, "Redacted: the input is replaced by the redacted profile in profile.json, "+
+			"the values of the arguments, the warnings and the error message are left out\n")
+		if conversionErr != nil {
+			fmt.Fprintf(&s, "Error: the conversion failed\n")
+		}
+		return s.String()
+	}
+	fmt.Fprintf(&s, "Arguments: %q\n", b.args)
+	fmt.Fprintf(&s, "Input: %s\n", b.inputName)
+	if conversionErr != nil {
+		fmt.Fprintf(&s, "Error: %v\n", conversionErr)
+	}
+	return s.String()
+}
+
+// flagNames returns the names of the flags in args without their values,
+// which can name the symbols of a redacted profile, e.g. --redact itself.
+func flagNames(args []string) []string {
+	names := make([]string, 0, len(args))
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			names = append(names, strings.SplitN(arg, "=", 2)[0])
+		}
+	}
+	return names
+}
+
+// write writes the bundle as a zip archive. conversionErr is the error the
+// conversion failed with, or nil.
+func (b *reportBundle) write(conversionErr error) error {
+	out, err := os.Create(b.path)
+	if err != nil {
+		return err
+	}
+	if err := b.writeTo(out, conversionErr); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// bundleFile is a file of the archive.
+type bundleFile struct {
+	name    string
+	content []byte
+}
+
+func (b *reportBundle) writeTo(w io.Writer, conversionErr error) error {
+	files := []bundleFile{{"report.txt", []byte(b.summary(conversionErr))}}
+	if !b.redacted {
+		files = append(files, bundleFile{"warnings.txt", b.log.Bytes()})
+		if b.input.Len() > 0 {
+			files = append(files, bundleFile{"input/" + b.inputName, b.input.Bytes()})
+		}
+	}
+	if b.ir != nil {
+		files = append(files, bundleFile{"profile.json", b.ir})
+	}
+	if b.profile != nil {
+		files = append(files, bundleFile{"profile.pb.gz", b.profile})
+	}
+	zw := zip.NewWriter(w)
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(f.content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
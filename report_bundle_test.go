@@ -0,0 +1,102 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func readBundle(t *testing.T, b *reportBundle, conversionErr error) map[string]string {
+	var buf bytes.Buffer
+	if err := b.writeTo(&buf, conversionErr); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func TestReportBundle(t *testing.T) {
+	b := &reportBundle{format: kSample, args: []string{"--format=sample", "sample.txt"}, inputName: "sample.txt"}
+	b.input.WriteString(selftestSample)
+	b.log.WriteString("WARNING: something\n")
+	b.profile = []byte("profile")
+	files := readBundle(t, b, nil)
+	if files["input/sample.txt"] != selftestSample {
+		t.Errorf("Expected the input in the bundle, got %q", files["input/sample.txt"])
+	}
+	if files["warnings.txt"] != "WARNING: something\n" || files["profile.pb.gz"] != "profile" {
+		t.Errorf("Unexpected bundle content: %v", files)
+	}
+	for _, expected := range []string{"Format: sample", `"--format=sample"`, "Tool version:"} {
+		if !strings.Contains(files["report.txt"], expected) {
+			t.Errorf("Expected %q in report.txt, got %s", expected, files["report.txt"])
+		}
+	}
+}
+
+func TestReportBundleRedacted(t *testing.T) {
+	b := &reportBundle{format: kSample, redacted: true, inputName: "sample.txt"}
+	b.input.WriteString(selftestSample)
+	b.ir = []byte("{}")
+	files := readBundle(t, b, errors.New("Failed to parse"))
+	if _, ok := files["input/sample.txt"]; ok {
+		t.Error("Expected no input in a redacted bundle")
+	}
+	if files["profile.json"] != "{}" {
+		t.Errorf("Expected the redacted profile, got %q", files["profile.json"])
+	}
+	if !strings.Contains(files["report.txt"], "Error: the conversion failed") {
+		t.Errorf("Expected the failure in report.txt, got %s", files["report.txt"])
+	}
+}
+
+func TestReportBundleRedactedLeavesOutSymbols(t *testing.T) {
+	const symbol = "SecretEngine::run"
+	b := &reportBundle{format: kInstrumentsDeepCopy, redacted: true, inputName: "SecretEngine.txt",
+		args: []string{"--redact=^SecretEngine::", "--pidTag=123:SecretEngine", "SecretEngine.txt"}}
+	b.input.WriteString("10.0 s  100%\t10.0 s\t \t" + symbol + "\n")
+	b.log.WriteString("WARNING: Error parsing thread '" + symbol + "'\n")
+	b.ir = []byte(`{"name": "redacted_0123456789ab"}`)
+	files := readBundle(t, b, errors.New("Error parsing "+symbol))
+	for name, content := range files {
+		if strings.Contains(name, "SecretEngine") || strings.Contains(content, "SecretEngine") {
+			t.Errorf("Expected no redacted symbol in %s, got %q", name, content)
+		}
+	}
+	if !strings.Contains(files["report.txt"], `Flags: ["--redact" "--pidTag"]`) {
+		t.Errorf("Expected the names of the flags in report.txt, got %s", files["report.txt"])
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/instrumentsToPprof/internal"
+)
+
+// selftestFixture is a small input of a format, converted by the selftest
+// command.
+type selftestFixture struct {
+	format string
+	input  func() ([]byte, error)
+}
+
+func fixture(content string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return []byte(content), nil
+	}
+}
+
+var selftestFixtures = []selftestFixture{
+	{kInstrumentsDeepCopy, fixture(selftestDeepCopy)},
+	{kAllocations, fixture(selftestAllocations)},
+	{kSample, fixture(selftestSample)},
+	{kMetricKit, fixture(selftestMetricKit)},
+	{kCrash, fixture(selftestCrash)},
+	{kSpindump, fixture(selftestSpindump)},
+	{kSysdiagnose, selftestSysdiagnose},
+	{kSpeedscope, fixture(selftestSpeedscope)},
+	{kFlameGraphSvg, fixture(selftestFlameGraphSvg)},
+	{kIR, fixture(selftestIR)},
+	{kXctrace, fixture(selftestXctrace)},
+	{kTrace, fixture(selftestXctrace)},
+	{kHeap, fixture(selftestHeap)},
+	{kVmmap, fixture(selftestVmmap)},
+	{kLeaks, fixture(selftestLeaks)},
+	{kMallocHistory, fixture(selftestMallocHistory)},
+	{kFsUsage, fixture(selftestFsUsage)},
+}
+
+// runSelftest converts the fixture of every format to a pprof profile and
+// verifies it, writing the result of each format to w. It returns whether
+// all formats passed.
+func runSelftest(w io.Writer) bool {
+	passed := true
+	for _, f := range selftestFixtures {
+		samples, err := selftestFormat(f)
+		if err != nil {
+			fmt.Fprintf(w, "FAIL %s: %v\n", f.format, err)
+			passed = false
+			continue
+		}
+		fmt.Fprintf(w, "PASS %s (%d samples)\n", f.format, samples)
+	}
+	return passed
+}
+
+// selftestFormat runs the full conversion of a fixture and returns the number
+// of samples in the resulting profile.
+func selftestFormat(f selftestFixture) (int, error) {
+	parserFn, err := parserForFormat(f.format, nil)
+	if err != nil {
+		return 0, err
+	}
+	input, err := f.input()
+	if err != nil {
+		return 0, err
+	}
+	parser, err := parserFn(bytes.NewReader(input))
+	if err != nil {
+		return 0, err
+	}
+	timeProfile, err := parser.ParseProfile()
+	if err != nil {
+		return 0, err
+	}
+	pprof := internal.ConvertToPprof(timeProfile, internal.NewConvertOptions())
+	if err := internal.VerifyPprof(timeProfile, pprof); err != nil {
+		return 0, err
+	}
+	if len(pprof.Sample) == 0 {
+		return 0, errors.New("Profile has no samples")
+	}
+	return len(pprof.Sample), nil
+}
+
+// selftestSysdiagnose wraps the spindump fixture in a sysdiagnose archive.
+func selftestSysdiagnose() ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	err := tw.WriteHeader(&tar.Header{
+		Name: "sysdiagnose_2021.03.15_15-41-58+0100/spindump.txt",
+		Mode: 0644,
+		Size: int64(len(selftestSpindump)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(tw, selftestSpindump); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+const (
+	selftestDeepCopy = "Weight\tSelf Weight\t\tSymbol Name\n" +
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n" +
+		"5.0 s  50%\t0 s\t \t Thread 1  0x1ee7\n" +
+		"5.0 s  50%\t0 s\t \t  foo\n" +
+		"2.0 s  20%\t2.0 s\t \t   bar1\n" +
+		"3.0 s  30%\t< 0.1 ms\t \t   bar2\n" +
+		"3.0 s  30%\t3.0 s\t \t    baz\n" +
+		"5.0 s  50%\t0 s\t \t Thread 2  0x7ee1\n" +
+		"5.0 s  50%\t5.0 s\t \t  spin\n" +
+		"\n"
+
+	selftestAllocations = "Bytes Used\tCount\t\tSymbol Name\n" +
+		"3.00 MB  100.0%\t300  100.0%\t \tSandwich (1234)\n" +
+		"3.00 MB  100.0%\t300  100.0%\t \t Main Thread  0x1ee7\n" +
+		"3.00 MB  100.0%\t300  100.0%\t \t  main\n" +
+		"2.00 MB  66.7%\t100  33.3%\t \t   makeSandwich\n" +
+		"\n"
+
+	selftestSample = `Analysis of sampling Sandwich (pid 1234) every 1 millisecond
+Process:         Sandwich [1234]
+
+Call graph:
+    4 Thread_1a2b   DispatchQueue_1: com.apple.main-thread  (serial)
+    + 4 start  (in libdyld.dylib) + 1  [0x7fff2037a6f1]
+    +   4 makeSandwich  (in Sandwich) + 30  [0x10a3c1f3e]
+    +     3 getBread(BreadType)  (in Sandwich) + 5  [0x10a3c2000]
+    1 Thread_1a2c
+    + 1 start_wqthread  (in libsystem_pthread.dylib) + 0  [0x7fff2035c458]
+
+Total number in stack (recursive counted multiple, when >=5):
+`
+
+	selftestMetricKit = `{"cpuExceptionDiagnostics": [{
+  "diagnosticMetaData": {"bundleIdentifier": "com.example.Sandwich"},
+  "callStackTree": {"callStackPerThread": false, "callStacks": [{
+    "threadAttributed": true,
+    "callStackRootFrames": [{
+      "binaryName": "Sandwich", "offsetIntoBinaryTextSegment": 16, "sampleCount": 10,
+      "subFrames": [{"binaryName": "Sandwich", "offsetIntoBinaryTextSegment": 32, "sampleCount": 6}]
+    }]
+  }]}
+}]}`
+
+	selftestCrash = `Process:               Sandwich [1234]
+Crashed Thread:        0  Dispatch queue: com.apple.main-thread
+
+Thread 0 Crashed:: Dispatch queue: com.apple.main-thread
+0   libsystem_kernel.dylib        	0x00007fff2032d92e __pthread_kill + 10
+1   Sandwich                      	0x000000010a3c1f3e makeSandwich + 30
+2   libdyld.dylib                 	0x00007fff20378621 start + 1
+
+Thread 1:
+0   libsystem_pthread.dylib       	0x00007fff2035c458 start_wqthread + 0
+`
+
+	selftestSpindump = `Date/Time:        2021-03-15 15:41:58.406 +0100
+Duration:         1.00s
+Steps:            100 (10ms sampling interval)
+
+Process:          Sandwich [1234]
+
+  Thread 0x1a2b    DispatchQueue "com.apple.main-thread"(1)    4 samples (1-4)    priority 46 (base 46)
+  4  start + 1 (libdyld.dylib + 87921) [0x7fff2037a6f1]
+    4  makeSandwich + 30 (Sandwich + 1234) [0x10a3c1f3e]
+      3  getBread + 5 (Sandwich + 2000) [0x10a3c2000]
+
+  Thread 0x1a2c    1 sample (1)    priority 31 (base 31)
+  1  start_wqthread + 0 (libsystem_pthread.dylib + 8) [0x7fff2035c458]
+`
+
+	selftestSpeedscope = `{
+  "name": "Sandwich",
+  "shared": {"frames": [{"name": "start"}, {"name": "makeSandwich"}, {"name": "getBread"}]},
+  "profiles": [
+    {"type": "sampled", "name": "Thread 1", "unit": "milliseconds",
+     "samples": [[0, 1], [0, 1, 2]], "weights": [1, 3]},
+    {"type": "evented", "name": "Thread 2", "unit": "milliseconds",
+     "events": [{"type": "O", "frame": 0, "at": 0}, {"type": "C", "frame": 0, "at": 5}]}
+  ]
+}`
+
+	selftestFlameGraphSvg = `<?xml version="1.0" standalone="no"?>
+<svg version="1.1" width="1200" height="70" xmlns="http://www.w3.org/2000/svg">
+<g id="frames">
+<g><title>all (10 samples, 100%)</title><rect x="10.0" y="53" width="1000.0" height="15.0" /></g>
+<g><title>makeSandwich (10 samples, 100.00%)</title><rect x="10.0" y="37" width="1000.0" height="15.0" /></g>
+<g><title>getBread (6 samples, 60.00%)</title><rect x="10.0" y="21" width="600.0" height="15.0" /></g>
+</g>
+</svg>`
+
+	selftestXctrace = `<?xml version="1.0"?>
+<trace-query-result><node><schema name="time-profile"/>
+<row><sample-time id="1">1000000</sample-time>
+<thread id="2" fmt="Main Thread 0x1a2b (Sandwich, pid: 1234)"><tid id="3">6699</tid>
+<process id="4" fmt="Sandwich (1234)"><pid id="5">1234</pid></process></thread>
+<weight id="6">1000000</weight>
+<backtrace id="7"><frame id="8" name="makeSandwich"><binary id="9" name="Sandwich"/></frame>
+<frame id="10" name="main"><binary ref="9"/></frame></backtrace></row>
+<row><sample-time id="11">2000000</sample-time><thread ref="2"/><weight ref="6"/>
+<backtrace id="12"><frame ref="10"/></backtrace></row>
+</node></trace-query-result>`
+
+	selftestHeap = `Process:         Sandwich [1234]
+
+All zones: 1000 nodes (66496 bytes)
+
+   COUNT      BYTES       AVG   CLASS_NAME                                     TYPE    BINARY
+   =====      =====       ===   ==========                                     ====    ======
+     211      16000      75.8   non-object
+     789      50496      64.0   NSString                                       ObjC    Foundation
+`
+
+	selftestVmmap = `Process:         Sandwich [1234]
+
+                                VIRTUAL RESIDENT    DIRTY  SWAPPED VOLATILE   NONVOL    EMPTY   REGION
+REGION TYPE                        SIZE     SIZE     SIZE     SIZE     SIZE     SIZE     SIZE    COUNT (non-coalesced)
+===========                     ======= ========    =====  ======= ========   ======    =====  =======
+Activity Tracing                   256K      32K      32K       0K       0K      32K       0K        1
+MALLOC_SMALL                      16.0M    1.5M     1.5M      64K       0K       0K       0K        2
+===========                     ======= ========    =====  ======= ========   ======    =====  =======
+TOTAL                             16.3M    1.5M     1.5M      64K       0K      32K       0K        3
+`
+
+	selftestLeaks = `Process:         Sandwich [1234]
+Process 1234: 2 leaks for 128 total leaked bytes.
+
+STACK OF 2 INSTANCES OF 'ROOT LEAK: <NSMutableArray>':
+2   dyld                                  0x7fff2037a6f1 start + 1
+1   Sandwich                              0x10a3c1f3e makeSandwich + 30
+0   libobjc.A.dylib                       0x7fff20300000 _objc_rootAllocWithZone + 20
+====
+    2 (128 bytes) << TOTAL >>
+`
+
+	selftestMallocHistory = `Process:         Sandwich [1234]
+
+2 calls for 128 bytes: thread_7fff8d3bb380 |start (in libdyld.dylib) | main (in Sandwich) | malloc (in libsystem_malloc.dylib)
+1 call for 64 bytes: thread_7fff8d3bb380 |start (in libdyld.dylib) | main (in Sandwich) | calloc (in libsystem_malloc.dylib)
+`
+
+	selftestFsUsage = `15:41:58.406001  open              F=4        (R_____)  /tmp/sandwich.txt   0.000100   Sandwich.1234
+15:41:58.406100  read              F=4    B=0x1000                      0.002000 W Sandwich.1234
+`
+
+	selftestIR = `{"version": 2, "processes": [{"name": "Sandwich", "pid": 1234, "threads": [
+  {"name": "Main Thread", "tid": 1, "frames": [
+    {"name": "main", "selfWeight": 1, "children": [{"name": "makeSandwich", "selfWeight": 3}]}
+  ]}
+]}]}`
+)
@@ -0,0 +1,126 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/instrumentsToPprof/internal/parsers"
+)
+
+func TestSelftest(t *testing.T) {
+	var out strings.Builder
+	if !runSelftest(&out) {
+		t.Errorf("Selftest failed:\n%s", out.String())
+	}
+}
+
+func TestFixturesAreSniffedAsTheirFormat(t *testing.T) {
+	for _, fixture := range selftestFixtures {
+		if f, _ := parsers.LookupFormat(fixture.format); f.Sniff == nil {
+			continue
+		}
+		input, err := fixture.input()
+		if err != nil {
+			t.Fatal(err)
+		}
+		decompressed, err := parsers.Decompress(bytes.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if input, err = ioutil.ReadAll(decompressed); err != nil {
+			t.Fatal(err)
+		}
+		var sniffed []string
+		for _, f := range parsers.Formats() {
+			if !f.Archive && f.Sniff != nil && f.Sniff(input) {
+				sniffed = append(sniffed, f.Name)
+			}
+		}
+		// Archives contain inputs of other formats.
+		if f, _ := parsers.LookupFormat(fixture.format); !f.Archive && (len(sniffed) != 1 || sniffed[0] != fixture.format) {
+			t.Errorf("Expected the %s fixture to be sniffed as %s only, got %v", fixture.format, fixture.format, sniffed)
+		}
+		if f, _ := parsers.Sniff(input); f.Name != fixture.format {
+			t.Errorf("Expected the %s fixture to be detected, got %s", fixture.format, f.Name)
+		}
+	}
+}
+
+func TestFixturesAreDetected(t *testing.T) {
+	for _, fixture := range selftestFixtures {
+		if f, _ := parsers.LookupFormat(fixture.format); f.Sniff == nil {
+			continue
+		}
+		if _, err := selftestFormat(selftestFixture{kAuto, fixture.input}); err != nil {
+			t.Errorf("Failed to detect and convert the %s fixture: %v", fixture.format, err)
+		}
+	}
+}
+
+func TestHeaderlessDeepCopyIsDetected(t *testing.T) {
+	for _, input := range []string{
+		"10.0 s  100%\t0 s\t \tMain Process (123)\n10.0 s  100%\t10.0 s\t \t Thread 1  0x1ee7\n",
+		"< 0.1 ms  0.0%\t< 0.1 ms\t \tMain Process (123)\n",
+	} {
+		if f, _ := parsers.Sniff([]byte(input)); f.Name != kInstrumentsDeepCopy {
+			t.Errorf("Expected %q to be detected as a deep copy, got %q", input, f.Name)
+		}
+	}
+}
+
+func TestIRNamingSharedIsDetected(t *testing.T) {
+	// A frame named like the "shared" section of speedscope files.
+	input := `{"version": 3, "processes": [{"name": "shared", "threads": [{"name": "shared", ` +
+		`"frames": [{"name": "shared", "selfWeight": 1}]}]}]}`
+	if f, _ := parsers.Sniff([]byte(input)); f.Name != "ir" {
+		t.Errorf("Expected the IR to be detected as ir, got %q", f.Name)
+	}
+}
+
+func TestFixturesMatchTheirCapabilities(t *testing.T) {
+	for _, fixture := range selftestFixtures {
+		parserFn, err := parserForFormat(fixture.format, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		input, err := fixture.input()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parser, err := parserFn(bytes.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		capabilities := parser.Capabilities()
+		p, err := parser.ParseProfile()
+		if err != nil {
+			t.Fatalf("%s: %v", fixture.format, err)
+		}
+		if p.HasTimeline() && !capabilities.Timestamps {
+			t.Errorf("%s: the profile has a timeline, but the capabilities have no timestamps", fixture.format)
+		}
+		if len(p.Processes) > 1 && !capabilities.MultiProcess {
+			t.Errorf("%s: the profile has %d processes, but the capabilities allow one", fixture.format, len(p.Processes))
+		}
+		if capabilities.Units != nil && !capabilities.HasUnit(p.GetValueType().Unit) {
+			t.Errorf("%s: the weights are in %s, which isn't in the units %v", fixture.format,
+				p.GetValueType().Unit, capabilities.Units)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/google/pprof/driver"
+	"github.com/google/pprof/profile"
+)
+
+// kDefaultServeAddress lets the web UI pick a free port when --serve is
+// given without an address.
+const kDefaultServeAddress = "localhost:0"
+
+const serveHelp = "Serves the converted profile in the pprof web UI after writing it, e.g. --serve or " +
+	"--serve=:8080, until interrupted."
+
+// serveFlag is the address of --serve, which may be given without one.
+type serveFlag string
+
+func (s *serveFlag) String() string {
+	return string(*s)
+}
+
+func (s *serveFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*s = kDefaultServeAddress
+	case "false":
+		*s = ""
+	default:
+		*s = serveFlag(value)
+	}
+	return nil
+}
+
+// IsBoolFlag allows --serve without a value.
+func (s *serveFlag) IsBoolFlag() bool {
+	return true
+}
+
+// serveProfile runs the pprof web UI on prof at addr, shown as name. The
+// profile is already symbolized by the conversion. httpServer replaces the
+// server of the UI if it isn't nil.
+func serveProfile(addr, name string, prof *profile.Profile, httpServer func(*driver.HTTPServerArgs) error) error {
+	return driver.PProf(&driver.Options{
+		Flagset: &pprofFlags{
+			set:  flag.NewFlagSet("pprof", flag.ContinueOnError),
+			args: []string{"-http=" + addr, "-symbolize=none", name},
+		},
+		Fetch:      profileFetcher{prof},
+		HTTPServer: httpServer,
+	})
+}
+
+// profileFetcher hands the converted profile to pprof for any source. It
+// returns no source URL, so pprof doesn't save a copy as it would of a
+// remote profile.
+type profileFetcher struct {
+	prof *profile.Profile
+}
+
+func (f profileFetcher) Fetch(src string, duration, timeout time.Duration) (*profile.Profile, string, error) {
+	return f.prof, "", nil
+}
+
+// pprofFlags passes fixed arguments to pprof, so it doesn't parse the
+// flags of the command line.
+type pprofFlags struct {
+	set        *flag.FlagSet
+	args       []string
+	extraUsage []string
+}
+
+func (f *pprofFlags) Bool(name string, def bool, usage string) *bool {
+	return f.set.Bool(name, def, usage)
+}
+
+func (f *pprofFlags) Int(name string, def int, usage string) *int {
+	return f.set.Int(name, def, usage)
+}
+
+func (f *pprofFlags) Float64(name string, def float64, usage string) *float64 {
+	return f.set.Float64(name, def, usage)
+}
+
+func (f *pprofFlags) String(name, def, usage string) *string {
+	return f.set.String(name, def, usage)
+}
+
+func (f *pprofFlags) StringList(name, def, usage string) *[]*string {
+	return &[]*string{f.set.String(name, def, usage)}
+}
+
+func (f *pprofFlags) ExtraUsage() string {
+	return strings.Join(f.extraUsage, "\n")
+}
+
+func (f *pprofFlags) AddExtraUsage(eu string) {
+	f.extraUsage = append(f.extraUsage, eu)
+}
+
+func (f *pprofFlags) Parse(usage func()) []string {
+	f.set.Usage = usage
+	if err := f.set.Parse(f.args); err != nil {
+		return nil
+	}
+	return f.set.Args()
+}
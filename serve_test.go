@@ -0,0 +1,70 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/pprof/driver"
+)
+
+func TestServeFlag(t *testing.T) {
+	for args, expected := range map[string]string{
+		"":                "",
+		"--serve":         kDefaultServeAddress,
+		"--serve=:8080":   ":8080",
+		"--serve=false":   "",
+		"--serve=[::1]:0": "[::1]:0",
+	} {
+		var address serveFlag
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.Var(&address, "serve", "")
+		if err := fs.Parse(strings.Fields(args)); err != nil {
+			t.Fatal(err)
+		}
+		if string(address) != expected {
+			t.Errorf("%q: address %q, want %q", args, address, expected)
+		}
+	}
+}
+
+func TestServeProfile(t *testing.T) {
+	prof := selfProfile(map[string]int64{"main": 4000, "parse": 6000})
+	var served *driver.HTTPServerArgs
+	err := serveProfile(":1234", "profile.pb.gz", prof, func(args *driver.HTTPServerArgs) error {
+		served = args
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if served.Port != 1234 {
+		t.Errorf("served on port %d, want 1234", served.Port)
+	}
+	handler, ok := served.Handlers["/top"]
+	if !ok {
+		t.Fatalf("no /top handler in %v", served.Handlers)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/top", nil))
+	body, _ := ioutil.ReadAll(recorder.Body)
+	if !strings.Contains(string(body), "parse") {
+		t.Errorf("/top lacks the functions of the profile: %s", body)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/pprof/profile"
+)
+
+// unsafeFileNameRe matches the characters replaced in the directory names of
+// the store.
+var unsafeFileNameRe = regexp.MustCompile(`[^A-Za-z0-9._ ()-]+`)
+
+// storePath returns the file of the profile of a process on the day of now,
+// <dir>/<process name>/<YYYY-MM-DD>.pb.gz.
+func storePath(dir string, processName string, now time.Time) string {
+	name := unsafeFileNameRe.ReplaceAllString(processName, "_")
+	if name == "" || name == "." || name == ".." {
+		name = "unknown"
+	}
+	return filepath.Join(dir, name, now.Format("2006-01-02")+".pb.gz")
+}
+
+// appendToStore merges the profile of every process into the store's
+// profile of that process name for the day of now, creating it if needed.
+// The processes are converted with opts like convertForDiff, without the
+// ids that change between runs, so the functions of every run line up.
+func appendToStore(dir string, p *internal.TimeProfile, now time.Time, opts internal.ConvertOptions) error {
+	byName := make(map[string][]*internal.Process)
+	names := make([]string, 0)
+	for _, proc := range p.Processes {
+		if _, ok := byName[proc.Name]; !ok {
+			names = append(names, proc.Name)
+		}
+		byName[proc.Name] = append(byName[proc.Name], proc)
+	}
+	for _, name := range names {
+		single := *p
+		single.Processes = byName[name]
+		prof := internal.ConvertToPprof(&single, storeOptions(opts, single.Processes))
+		if err := appendProfile(storePath(dir, name, now), prof); err != nil {
+			return fmt.Errorf("Failed to append the profile of %s to the store: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// storeOptions returns opts without ids and with only the annotations of the
+// processes, which are converted without the others.
+func storeOptions(opts internal.ConvertOptions, processes []*internal.Process) internal.ConvertOptions {
+	opts.IncludeIDs = false
+	annotations := make(internal.ProcessAnnotationMap)
+	for _, proc := range processes {
+		if annotation, ok := opts.Annotations[proc.Pid]; ok {
+			annotations[proc.Pid] = annotation
+		}
+	}
+	opts.Annotations = annotations
+	return opts
+}
+
+// mergeProfiles merges converted profiles, setting the period types Merge
+// requires, which the converted profiles don't set.
+func mergeProfiles(profiles ...*profile.Profile) (*profile.Profile, error) {
+	for _, p := range profiles {
+		if (p.PeriodType == nil || p.PeriodType.Type == "") && len(p.SampleType) > 0 {
+			p.PeriodType = &profile.ValueType{Type: p.SampleType[0].Type, Unit: p.SampleType[0].Unit}
+		}
+	}
+	return profile.Merge(profiles)
+}
+
+// appendProfile merges prof into the profile at path and writes the result
+// through a temporary file, so an interrupted write doesn't lose the
+// profiles appended before.
+func appendProfile(path string, prof *profile.Profile) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if existing, err := os.Open(path); err == nil {
+		stored, err := profile.Parse(existing)
+		existing.Close()
+		if err != nil {
+			return err
+		}
+		if prof, err = mergeProfiles(stored, prof); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".append-")
+	if err != nil {
+		return err
+	}
+	if err := prof.Write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
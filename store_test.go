@@ -0,0 +1,130 @@
+// Copyright 2021 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/instrumentsToPprof/internal"
+	"github.com/google/pprof/profile"
+)
+
+func storeProfile() *internal.TimeProfile {
+	thread := &internal.Thread{Name: "main", Tid: 1}
+	thread.AddStack([]string{"main", "eat"}, 10)
+	other := &internal.Thread{Name: "main", Tid: 1}
+	other.AddStack([]string{"main", "sleep"}, 5)
+	return &internal.TimeProfile{Processes: []*internal.Process{
+		{Name: "Sandwich", Pid: 1, Threads: []*internal.Thread{thread}},
+		{Name: "Sandwich Helper/GPU", Pid: 2, Threads: []*internal.Thread{other}},
+	}}
+}
+
+func TestAppendToStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Date(2021, 3, 15, 15, 41, 58, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		if err := appendToStore(dir, storeProfile(), now, internal.NewConvertOptions()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := filepath.Join(dir, "Sandwich", "2021-03-15.pb.gz")
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stored, err := profile.Parse(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, s := range stored.Sample {
+		total += s.Value[0]
+	}
+	if total != 20 {
+		t.Errorf("Expected both conversions in the stored profile, got a total of %d", total)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Sandwich Helper_GPU", "2021-03-15.pb.gz")); err != nil {
+		t.Errorf("Expected a separate profile of the helper: %v", err)
+	}
+}
+
+func TestAppendToStoreMergesRuns(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Date(2021, 3, 15, 15, 41, 58, 0, time.UTC)
+	// The pids and tids of the runs differ, and only the first is annotated.
+	opts := internal.NewConvertOptions(internal.WithAnnotations(internal.ProcessAnnotationMap{1: "canary"}))
+	for i, pid := range []uint64{1, 7} {
+		p := storeProfile()
+		p.Processes[0].Pid = pid
+		p.Processes[0].Threads[0].Tid = pid + 100
+		if err := appendToStore(dir, p, now, opts); err != nil {
+			t.Fatalf("run %d: %v", i, err)
+		}
+	}
+
+	file, err := os.Open(filepath.Join(dir, "Sandwich", "2021-03-15.pb.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	stored, err := profile.Parse(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	functions := make(map[string]int)
+	for _, f := range stored.Function {
+		functions[f.Name]++
+	}
+	for _, name := range []string{"main", "eat"} {
+		if functions[name] != 1 {
+			t.Errorf("Expected the runs to share the function %s, got %v", name, functions)
+		}
+	}
+	for name := range functions {
+		if strings.Contains(name, "pid:") || strings.Contains(name, "tid:") {
+			t.Errorf("Expected no ids in the stored function names, got %s", name)
+		}
+	}
+}
+
+func TestStoreOptions(t *testing.T) {
+	opts := internal.NewConvertOptions(internal.WithAnnotations(internal.ProcessAnnotationMap{1: "app", 2: "helper"}))
+	got := storeOptions(opts, []*internal.Process{{Name: "Sandwich", Pid: 1}})
+	if got.IncludeIDs {
+		t.Error("Expected the stored profiles to leave out the ids")
+	}
+	if len(got.Annotations) != 1 || got.Annotations[1] != "app" {
+		t.Errorf("Expected only the annotation of pid 1, got %v", got.Annotations)
+	}
+	if len(opts.Annotations) != 2 {
+		t.Errorf("Expected the options of the output to keep their annotations, got %v", opts.Annotations)
+	}
+}